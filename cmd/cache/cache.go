@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `cache` shows information about the cached repository metadata.
+package cache
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "cache",
+		Usage:       "[stats|packages|histogram|warm|compact|drop ALIAS...]",
+		Description: "Show information about the cached repository metadata.",
+		SkipRefresh: true,
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct {
+	limit int
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+	flags.IntVar(&c.limit, "limit", 20, "Show at most `count` packages for `cache packages`")
+}
+
+// Run the `cache` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) == 0 {
+		args = []string{"stats"}
+	}
+	switch args[0] {
+	case "stats":
+		return nil, c.stats(ctx, cfg, db)
+	case "packages":
+		return nil, c.packages(ctx, cfg, db)
+	case "histogram":
+		return nil, c.histogram(ctx, cfg, db)
+	case "warm":
+		return nil, db.Warm(ctx)
+	case "compact":
+		return nil, db.Compact(ctx)
+	case "drop":
+		return nil, c.drop(ctx, db, args[1:])
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", cmd.ErrUsage, args[0])
+}
+
+// stats prints the statistics for each cached repository.
+func (c *command) stats(ctx context.Context, cfg *config.Config, db *database.Database) error {
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	formatBool := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	err = output.Write(cmd.Stdout, cfg.Format, stats, []output.Column[database.RepositoryStats]{
+		{
+			Name:  "Alias",
+			Value: func(s database.RepositoryStats) string { return s.Alias },
+		},
+		{
+			Name:  "Name",
+			Value: func(s database.RepositoryStats) string { return s.Name },
+		},
+		{
+			Name:  "Release",
+			Value: func(s database.RepositoryStats) string { return s.ReleaseVer },
+		},
+		{
+			Name:  "Priority",
+			Value: func(s database.RepositoryStats) string { return strconv.Itoa(s.Priority) },
+		},
+		{
+			Name:  "GPG Check",
+			Value: func(s database.RepositoryStats) string { return formatBool(s.GPGCheck) },
+		},
+		{
+			Name:  "Keep Packages",
+			Value: func(s database.RepositoryStats) string { return formatBool(s.KeepPackages) },
+		},
+		{
+			Name:  "Packages",
+			Value: func(s database.RepositoryStats) string { return strconv.Itoa(s.Packages) },
+		},
+		{
+			Name:  "Files",
+			Value: func(s database.RepositoryStats) string { return strconv.Itoa(s.Files) },
+		},
+		{
+			Name:  "Last Checked",
+			Value: func(s database.RepositoryStats) string { return formatTime(s.LastChecked) },
+		},
+		{
+			Name:  "Last Modified",
+			Value: func(s database.RepositoryStats) string { return formatTime(s.LastModified) },
+		},
+		{
+			Name:  "Revision",
+			Value: func(s database.RepositoryStats) string { return s.Revision },
+		},
+	})
+	if err != nil || cfg.Format != config.OutputFormatHuman {
+		// The other formats include the refreshes of each repository.
+		return err
+	}
+	return writeRefreshes(stats)
+}
+
+// recentRefreshes is the number of refreshes shown by `cache stats`.
+const recentRefreshes = 10
+
+// writeRefreshes prints the most recent refreshes of the repositories, so that
+// it can be seen whether they were slowed down by the network or by importing.
+func writeRefreshes(stats []database.RepositoryStats) error {
+	var records []database.RefreshRecord
+	for _, s := range stats {
+		records = append(records, s.Refreshes...)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	slices.SortFunc(records, func(a, b database.RefreshRecord) int {
+		return b.Started.Compare(a.Started)
+	})
+	records = records[:min(len(records), recentRefreshes)]
+	formatDuration := func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	}
+	if _, err := fmt.Fprintf(cmd.Stdout, "\nRecent refreshes:\n"); err != nil {
+		return err
+	}
+	return output.Write(cmd.Stdout, config.OutputFormatHuman, records, []output.Column[database.RefreshRecord]{
+		{
+			Name:  "Started",
+			Value: func(r database.RefreshRecord) string { return r.Started.Local().Format(time.DateTime) },
+		},
+		{
+			Name:  "Alias",
+			Value: func(r database.RefreshRecord) string { return r.Alias },
+		},
+		{
+			Name:  "Release",
+			Value: func(r database.RefreshRecord) string { return r.ReleaseVer },
+		},
+		{
+			Name:  "Outcome",
+			Value: func(r database.RefreshRecord) string { return r.Outcome },
+		},
+		{
+			Name:  "Duration",
+			Value: func(r database.RefreshRecord) string { return formatDuration(r.Duration) },
+		},
+		{
+			Name:  "Download",
+			Value: func(r database.RefreshRecord) string { return formatDuration(r.Download) },
+		},
+		{
+			Name:  "Downloaded",
+			Value: func(r database.RefreshRecord) string { return output.FormatSize(r.Bytes) },
+		},
+		{
+			Name:  "Packages",
+			Value: func(r database.RefreshRecord) string { return strconv.Itoa(r.Packages) },
+		},
+		{
+			Name:  "Files",
+			Value: func(r database.RefreshRecord) string { return strconv.Itoa(r.Files) },
+		},
+		{
+			Name:  "Error",
+			Value: func(r database.RefreshRecord) string { return r.Error },
+		},
+	})
+}
+
+// drop removes the cached index of the repositories with aliases matching any
+// of the glob patterns.
+func (c *command) drop(ctx context.Context, db *database.Database, patterns []string) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("%w: no repositories to drop", cmd.ErrUsage)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: invalid pattern %q: %w", cmd.ErrUsage, pattern, err)
+		}
+	}
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	dropped := 0
+	for _, s := range stats {
+		if !slices.ContainsFunc(patterns, func(pattern string) bool {
+			matched, _ := path.Match(pattern, s.Alias)
+			return matched
+		}) {
+			continue
+		}
+		if err := db.DropRepository(ctx, s.URL, s.ReleaseVer); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", s.Alias, err)
+		}
+		slog.InfoContext(ctx, "Dropped cached repository", "repository", s.Alias, "releasever", s.ReleaseVer)
+		dropped++
+	}
+	if dropped == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// packages prints the packages with the most indexed files.
+func (c *command) packages(ctx context.Context, cfg *config.Config, db *database.Database) error {
+	if c.limit < 1 {
+		return fmt.Errorf("%w: limit must be positive", cmd.ErrUsage)
+	}
+	stats, err := db.PackageStats(ctx, c.limit)
+	if err != nil {
+		return err
+	}
+	return output.Write(cmd.Stdout, cfg.Format, stats, []output.Column[database.PackageStats]{
+		{
+			Name:  "Repository",
+			Value: func(s database.PackageStats) string { return s.Repository },
+		},
+		{
+			Name:  "Release",
+			Value: func(s database.PackageStats) string { return s.ReleaseVer },
+		},
+		{
+			Name:  "Package",
+			Value: func(s database.PackageStats) string { return s.Package },
+		},
+		{
+			Name:  "Version",
+			Value: func(s database.PackageStats) string { return s.EVR() },
+		},
+		{
+			Name:  "Arch",
+			Value: func(s database.PackageStats) string { return s.Arch },
+		},
+		{
+			Name:  "Files",
+			Value: func(s database.PackageStats) string { return strconv.Itoa(s.Files) },
+		},
+	})
+}
+
+// histogram prints, for each repository, how many packages have a given
+// number of files.
+func (c *command) histogram(ctx context.Context, cfg *config.Config, db *database.Database) error {
+	buckets, err := db.FileHistogram(ctx)
+	if err != nil {
+		return err
+	}
+	return output.Write(cmd.Stdout, cfg.Format, buckets, []output.Column[database.HistogramBucket]{
+		{
+			Name:  "Repository",
+			Value: func(b database.HistogramBucket) string { return b.Repository },
+		},
+		{
+			Name:  "Release",
+			Value: func(b database.HistogramBucket) string { return b.ReleaseVer },
+		},
+		{
+			Name: "Files per Package",
+			Value: func(b database.HistogramBucket) string {
+				if b.MinFiles == b.MaxFiles {
+					return strconv.Itoa(b.MinFiles)
+				}
+				return fmt.Sprintf("%d-%d", b.MinFiles, b.MaxFiles)
+			},
+		},
+		{
+			Name:  "Packages",
+			Value: func(b database.HistogramBucket) string { return strconv.Itoa(b.Packages) },
+		},
+		{
+			Name:  "Files",
+			Value: func(b database.HistogramBucket) string { return strconv.Itoa(b.Files) },
+		},
+	})
+}
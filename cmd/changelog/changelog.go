@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `changelog` searches package changelogs.
+package changelog
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "changelog",
+		Usage:       "pattern",
+		Description: "List the packages with changelog entries matching the pattern (requires changelogs to be enabled in the configuration).",
+		History:     true,
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	// No additional flags needed
+	return &command{}
+}
+
+type command struct {
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// Run the `changelog` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected a single pattern", cmd.ErrUsage)
+	}
+	if !cfg.Changelogs {
+		return nil, errors.New("changelogs are not indexed; set `changelogs = true` in the configuration")
+	}
+	results, err := db.SearchChangelogs(ctx, database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, database.ErrNoResults
+	}
+	return nil, output.Write(cmd.Stdout, cfg.Format, results, []output.Column[database.ChangelogResult]{
+		{
+			Name:  "Repository",
+			Value: func(r database.ChangelogResult) string { return r.Repository },
+		},
+		{
+			Name:  "Package",
+			Value: func(r database.ChangelogResult) string { return r.Package },
+		},
+		{
+			Name:  "Version",
+			Value: func(r database.ChangelogResult) string { return r.EVR() },
+		},
+		{
+			Name:  "Arch",
+			Value: func(r database.ChangelogResult) string { return r.Arch },
+		},
+		{
+			Name:  "Date",
+			Value: func(r database.ChangelogResult) string { return r.Date.Local().Format(time.DateOnly) },
+		},
+		{
+			Name:  "Author",
+			Value: func(r database.ChangelogResult) string { return r.Author },
+		},
+		{
+			Name: "Entry",
+			// Entries span several lines, which does not work in a table.
+			Value: func(r database.ChangelogResult) string { return strings.Join(strings.Fields(r.Text), " ") },
+		},
+	})
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `check` diagnoses problems with the configured repositories.
+package check
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/repository"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "check",
+		Description: "Check that the repositories can be indexed, and that the index is current.",
+		SkipRefresh: true,
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct {
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// ErrProblems is returned if any problems were found.
+var ErrProblems = errors.New("repository problems found")
+
+// Run the `check` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("%w: unexpected arguments", cmd.ErrUsage)
+	}
+	problems, err := repository.Check(ctx, cfg, db, repos)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) == 0 {
+		slog.InfoContext(ctx, "No problems found", "repositories", len(repos))
+		return nil, nil
+	}
+	err = output.Write(cmd.Stdout, cfg.Format, problems, []output.Column[repository.Problem]{
+		{
+			Name:  "Repository",
+			Value: func(p repository.Problem) string { return p.Repository },
+		},
+		{
+			Name:  "Problem",
+			Value: func(p repository.Problem) string { return p.Message },
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %d", ErrProblems, len(problems))
+}
@@ -1,25 +1,260 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 // SPDX-FileCopyrightText: SUSE LLC
 
-// Package cmd defines the interface all commands must implement
+// Package cmd defines the interface all commands must implement, as well as
+// the registry used to dispatch to them.
 package cmd
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/mook-as/zypper-filesearch/config"
 	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/fuzzy"
+	"github.com/mook-as/zypper-filesearch/output"
 	"github.com/mook-as/zypper-filesearch/zypper"
 )
 
+// ProgramName is the name of the main executable, used when dispatching by
+// subcommand.
+const ProgramName = "zypper-filesearch"
+
+// Stdout is where commands write their results; it may be redirected to a
+// file (see config.Config.OutputFile).
+var Stdout io.Writer = os.Stdout
+
+// ErrUsage is returned by commands when the arguments are invalid; the usage
+// for the command will be displayed.
+var ErrUsage = errors.New("invalid arguments")
+
 type CommandRunner interface {
 	// Add any flags this command requires.
-	AddFlags()
-	// Run the command, with the given options.
+	AddFlags(*flag.FlagSet)
+	// Run the command, with the given options and (non-flag) arguments.
 	Run(
 		context.Context,
 		*config.Config,
 		*database.Database,
 		[]*zypper.Repository,
+		[]string,
 	) ([]database.SearchResult, error)
 }
+
+// Finisher may be implemented by a CommandRunner that has something to add
+// after its results have been written.
+type Finisher interface {
+	// Finish is called with the results after they have been written to stdout;
+	// any additional output should go to w.
+	Finish(ctx context.Context, cfg *config.Config, w io.Writer, results []database.SearchResult) error
+}
+
+// RefreshSkipper may be implemented by a CommandRunner that only needs the
+// repository metadata to be refreshed for some of its options.
+type RefreshSkipper interface {
+	// SkipRefresh is called after the flags are parsed; if it returns true, the
+	// metadata is not refreshed before running the command.
+	SkipRefresh() bool
+}
+
+// Command describes a command that can be dispatched to.
+type Command struct {
+	// The name of the subcommand, e.g. `search`.
+	Name string
+	// Executable names (e.g. for use as a zypper plugin) that run this command
+	// directly without needing the subcommand name.
+	Executables []string
+	// Synopsis of the non-flag arguments.
+	Usage string
+	// Short, one-line description of the command.
+	Description string
+	// If set, repository metadata is not refreshed before running the command.
+	SkipRefresh bool
+	// If set, the arguments are recorded in the query history (if enabled).
+	History bool
+	// Create a new instance of the command.
+	New func() CommandRunner
+}
+
+var registry []*Command
+
+// Register a command; this is expected to be called from the init function of
+// the package implementing the command.
+func Register(c *Command) {
+	if Lookup(c.Name) != nil {
+		panic(fmt.Sprintf("command %q registered twice", c.Name))
+	}
+	registry = append(registry, c)
+	slices.SortFunc(registry, func(a, b *Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// Lookup a command by its subcommand name; returns nil if it does not exist.
+func Lookup(name string) *Command {
+	for _, c := range registry {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// UsageError is returned when the command to run could not be determined.
+type UsageError struct {
+	// The unknown command, if any.
+	Command string
+	// Possible commands the user may have meant.
+	Suggestions []string
+}
+
+func (e *UsageError) Error() string {
+	if e.Command == "" {
+		return "no command given"
+	}
+	message := fmt.Sprintf("unknown command %q", e.Command)
+	if len(e.Suggestions) > 0 {
+		quoted := make([]string, 0, len(e.Suggestions))
+		for _, s := range e.Suggestions {
+			quoted = append(quoted, fmt.Sprintf("%q", s))
+		}
+		message += fmt.Sprintf("; did you mean %s?", strings.Join(quoted, " or "))
+	}
+	return message
+}
+
+// Find the command to run, given the program arguments (including the program
+// name).  Returns the command, the program name to use in usage messages, and
+// the remaining arguments.  If help was requested, flag.ErrHelp is returned.
+func Find(argv []string) (*Command, string, []string, error) {
+	exe := filepath.Base(argv[0])
+	for _, c := range registry {
+		if slices.Contains(c.Executables, exe) {
+			return c, exe, argv[1:], nil
+		}
+	}
+
+	if len(argv) < 2 {
+		return nil, "", nil, &UsageError{}
+	}
+	name := argv[1]
+	if name == "help" && len(argv) > 2 {
+		// `help <command>` is equivalent to `<command> -help`.
+		name = argv[2]
+		argv = []string{argv[0], name, "-help"}
+	}
+	if c := Lookup(name); c != nil {
+		return c, ProgramName + " " + c.Name, argv[2:], nil
+	}
+	switch name {
+	case "help", "-h", "-help", "--help":
+		return nil, "", nil, flag.ErrHelp
+	}
+	if strings.HasPrefix(name, "-") {
+		return nil, "", nil, &UsageError{}
+	}
+	return nil, "", nil, &UsageError{Command: name, Suggestions: suggest(name)}
+}
+
+// PrintUsage writes the list of available commands.
+func PrintUsage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "usage: %s <command> [options] [arguments]\n\nCommands:\n", ProgramName)
+	writer := tabwriter.NewWriter(w, 3, 8, 2, ' ', 0)
+	for _, c := range registry {
+		_, _ = fmt.Fprintf(writer, "  %s\t%s\n", c.Name, c.Description)
+	}
+	_ = writer.Flush()
+	_, _ = fmt.Fprintf(w, "\nRun '%s help <command>' for details on a command.\n", ProgramName)
+}
+
+// PrintUsage writes the usage of this command, including all of its flags.
+func (c *Command) PrintUsage(prog string, flags *flag.FlagSet) {
+	w := flags.Output()
+	_, _ = fmt.Fprintf(w, "usage: %s [options] %s\n\n%s\n\nOptions:\n", prog, c.Usage, c.Description)
+	flags.PrintDefaults()
+}
+
+// Arch returns the architecture to search for: the one requested in the
+// configuration, or else the system's.
+func Arch(cfg *config.Config) (string, error) {
+	if cfg.Arch != "" {
+		return cfg.Arch, nil
+	}
+	return zypper.Arch()
+}
+
+// SearchResultColumns returns the columns used to display search results;
+// the release is included when searching several at once.  If columns were
+// selected, only those are returned; the release and alias can always be
+// selected.
+func SearchResultColumns(cfg *config.Config) ([]output.Column[database.SearchResult], error) {
+	columns := slices.Clone(output.SearchResultColumns)
+	if cfg.ShowPath != config.PathDisplayFull {
+		i := slices.IndexFunc(columns, func(c output.Column[database.SearchResult]) bool { return c.Name == "File" })
+		columns[i] = output.BasenameColumn
+		if cfg.ShowPath == config.PathDisplayDir {
+			columns[i] = output.DirColumn
+		}
+	}
+	if len(cfg.ReleaseVers) > 1 {
+		columns = slices.Concat([]output.Column[database.SearchResult]{output.ReleaseVerColumn}, columns)
+	}
+	if cfg.ShowRepoFile {
+		if cfg.RepoLabel != config.RepoLabelAlias {
+			columns = slices.Concat(columns, []output.Column[database.SearchResult]{output.AliasColumn})
+		}
+		columns = slices.Concat(columns, []output.Column[database.SearchResult]{output.RepoFileColumn})
+	}
+	if len(cfg.Columns) == 0 {
+		return columns, nil
+	}
+	for _, column := range []output.Column[database.SearchResult]{output.ReleaseVerColumn, output.AliasColumn} {
+		if !slices.ContainsFunc(columns, func(c output.Column[database.SearchResult]) bool { return c.Name == column.Name }) {
+			columns = append(columns, column)
+		}
+	}
+	return output.SelectColumns(columns, cfg.Columns)
+}
+
+// AddRepoFiles sets the file and service each result's repository is
+// configured in, from the given repositories.
+func AddRepoFiles(results []database.SearchResult, repos []*zypper.Repository) {
+	addRepoFile := RepoFileAdder(repos)
+	for i := range results {
+		addRepoFile(&results[i])
+	}
+}
+
+// RepoFileAdder returns a function that sets the file and service a result's
+// repository is configured in, from the given repositories; it is used where
+// results are handled one at a time.
+func RepoFileAdder(repos []*zypper.Repository) func(*database.SearchResult) {
+	byAlias := make(map[string]*zypper.Repository)
+	for _, repo := range repos {
+		byAlias[repo.Alias] = repo
+	}
+	return func(result *database.SearchResult) {
+		if repo, ok := byAlias[result.Alias]; ok {
+			result.RepoFile, result.Service = repo.File, repo.Service
+		}
+	}
+}
+
+// suggest returns the names of commands that are similar to the given name.
+func suggest(name string) []string {
+	var results []string
+	for _, c := range registry {
+		if strings.HasPrefix(c.Name, name) || fuzzy.Distance(name, c.Name) <= 2 {
+			results = append(results, c.Name)
+		}
+	}
+	return results
+}
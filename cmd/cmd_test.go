@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/zypper"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestFind(t *testing.T) {
+	search := &Command{Name: "search", Executables: []string{"zypper-file-search"}}
+	saved := registry
+	registry = []*Command{search}
+	defer func() { registry = saved }()
+
+	c, prog, args, err := Find([]string{"/usr/bin/zypper-file-search", "-json", "foo"})
+	assert.NilError(t, err)
+	assert.Check(t, c == search)
+	assert.Check(t, cmp.Equal(prog, "zypper-file-search"))
+	assert.Check(t, cmp.DeepEqual(args, []string{"-json", "foo"}))
+
+	c, prog, args, err = Find([]string{ProgramName, "search", "foo"})
+	assert.NilError(t, err)
+	assert.Check(t, c == search)
+	assert.Check(t, cmp.Equal(prog, ProgramName+" search"))
+	assert.Check(t, cmp.DeepEqual(args, []string{"foo"}))
+
+	_, _, _, err = Find([]string{ProgramName, "serach"})
+	var usageErr *UsageError
+	assert.Assert(t, errors.As(err, &usageErr))
+	assert.Check(t, cmp.DeepEqual(usageErr.Suggestions, []string{"search"}))
+}
+
+func TestAddRepoFiles(t *testing.T) {
+	results := []database.SearchResult{
+		{Alias: "repo-oss", Path: "/usr/bin/foo"},
+		{Alias: "SCC:Updates", Path: "/usr/bin/foo"},
+		{Alias: "removed", Path: "/usr/bin/foo"},
+	}
+	AddRepoFiles(results, []*zypper.Repository{
+		{Alias: "repo-oss", File: "/etc/zypp/repos.d/repo-oss.repo"},
+		{Alias: "SCC:Updates", File: "/etc/zypp/repos.d/SCC:Updates.repo", Service: "SCC"},
+	})
+	assert.Check(t, cmp.Equal(results[0].RepoFile, "/etc/zypp/repos.d/repo-oss.repo"))
+	assert.Check(t, cmp.Equal(results[1].Service, "SCC"))
+	assert.Check(t, cmp.Equal(results[2].RepoFile, ""))
+	assert.Check(t, cmp.Equal(output.RepoFileColumn.Value(results[1]), "/etc/zypp/repos.d/SCC:Updates.repo (service SCC)"))
+
+	names := func(cfg *config.Config) []string {
+		columns, err := SearchResultColumns(cfg)
+		assert.NilError(t, err)
+		return itertools.Map(columns, func(c output.Column[database.SearchResult]) string { return c.Name })
+	}
+	assert.Check(t, cmp.Equal(names(&config.Config{ShowRepoFile: true})[len(output.SearchResultColumns)], "Alias"))
+	assert.Check(t, cmp.Len(names(&config.Config{ShowRepoFile: true, RepoLabel: config.RepoLabelAlias}), len(output.SearchResultColumns)+1))
+}
+
+func TestSearchResultColumns(t *testing.T) {
+	columns, err := SearchResultColumns(&config.Config{
+		ShowPath: config.PathDisplayDir,
+		Columns:  []string{"package", "directory", "alias"},
+	})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(columns, output.Column[database.SearchResult].Key),
+		[]string{"package", "directory", "alias"}))
+	result := database.SearchResult{Alias: "repo-oss", Path: "/usr/bin/foo"}
+	assert.Check(t, cmp.Equal(columns[1].Value(result), "/usr/bin"))
+
+	columns, err = SearchResultColumns(&config.Config{ShowPath: config.PathDisplayBasename, Columns: []string{"file"}})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(columns[0].Value(result), "foo"))
+
+	_, err = SearchResultColumns(&config.Config{ShowPath: config.PathDisplayDir, Columns: []string{"file"}})
+	assert.ErrorContains(t, err, `unknown column "file"`)
+}
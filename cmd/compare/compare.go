@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `compare-releases` finds files that are only available in one of two
+// releases.
+package compare
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "compare-releases",
+		Usage:       "pattern",
+		Description: "List files matching the pattern that are only in one of the two releases given with -releasever.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	// No additional flags needed
+	return &command{}
+}
+
+type command struct {
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// key identifies a file independent of the package version.
+type key struct {
+	pkg  string
+	path string
+}
+
+// Run the `compare-releases` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected a single pattern", cmd.ErrUsage)
+	}
+	if len(cfg.ReleaseVers) != 2 {
+		return nil, fmt.Errorf("%w: exactly two releases must be given with -releasever", cmd.ErrUsage)
+	}
+
+	// Search each release separately, so that each file is attributed to the
+	// correct release even if the repositories share URLs.
+	found := make([]map[key]database.SearchResult, len(cfg.ReleaseVers))
+	for i, releaseVer := range cfg.ReleaseVers {
+		var releaseRepos []*zypper.Repository
+		for _, repo := range repos {
+			if repo.ReleaseVer == releaseVer {
+				releaseRepos = append(releaseRepos, repo)
+			}
+		}
+		if len(releaseRepos) == 0 {
+			return nil, fmt.Errorf("no repositories found for release %s", releaseVer)
+		}
+		filter := database.RepoFilter{Repos: releaseRepos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}
+		results, err := db.SearchFile(ctx, filter, args[0], "")
+		if err != nil {
+			return nil, err
+		}
+		found[i] = make(map[key]database.SearchResult)
+		for _, result := range results {
+			found[i][key{pkg: result.Package, path: result.Path}] = result
+		}
+	}
+
+	var results []database.SearchResult
+	for i, releaseFound := range found {
+		other := found[1-i]
+		for k, result := range releaseFound {
+			if _, ok := other[k]; !ok {
+				results = append(results, result)
+			}
+		}
+	}
+
+	slices.SortFunc(results, func(a, b database.SearchResult) int {
+		return cmp.Or(
+			strings.Compare(a.Path, b.Path),
+			strings.Compare(a.Package, b.Package),
+			strings.Compare(a.ReleaseVer, b.ReleaseVer))
+	})
+
+	if len(results) == 0 {
+		return nil, database.ErrNoResults
+	}
+	return results, nil
+}
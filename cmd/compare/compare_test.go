@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package compare
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestCompareReleases(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// Both releases use the same URL, as with $releasever in the URL before
+	// it is expanded; they must still be told apart.
+	type pkg struct {
+		name, version string
+		paths         []string
+	}
+	var repos []*zypper.Repository
+	for releaseVer, pkgs := range map[string][]pkg{
+		"15.5": {
+			{"zypper", "1.14.59", []string{"/usr/bin/zypper", "/usr/share/zypper/legacy"}},
+			{"libzypp", "17.31.0", []string{"/usr/lib64/libzypp.so.1722"}},
+		},
+		"15.6": {
+			{"zypper", "1.14.73", []string{"/usr/bin/zypper", "/usr/share/zypper/new"}},
+			{"libzypp", "17.35.0", []string{"/usr/lib64/libzypp.so.1735"}},
+		},
+	} {
+		repo := &zypper.Repository{Alias: "oss", Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss", ReleaseVer: releaseVer}
+		repos = append(repos, repo)
+		now := time.Now().UTC()
+		err := db.UpdateRepository(t.Context(), repo, now, now, database.Provenance{}, func(p func(*database.Package) (func(string, string) error, error)) error {
+			for _, pkg := range pkgs {
+				f, err := p(&database.Package{PkgId: pkg.name + "-" + pkg.version, Name: pkg.name, Version: pkg.version, Release: "1", Arch: "x86_64"})
+				if err != nil {
+					return err
+				}
+				for _, path := range pkg.paths {
+					if err := f(path, ""); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		assert.NilError(t, err)
+	}
+
+	cfg := &config.Config{ReleaseVers: []string{"15.5", "15.6"}}
+	results, err := New().Run(t.Context(), cfg, db, repos, []string{"/usr/*"})
+	assert.NilError(t, err)
+	// Files in the same package in both releases are not listed, even if the
+	// version changed; the others are in order of their paths.
+	var found []string
+	for _, result := range results {
+		found = append(found, result.ReleaseVer+" "+result.Package+" "+result.Path)
+	}
+	assert.Check(t, cmp.DeepEqual(found, []string{
+		"15.5 libzypp /usr/lib64/libzypp.so.1722",
+		"15.6 libzypp /usr/lib64/libzypp.so.1735",
+		"15.5 zypper /usr/share/zypper/legacy",
+		"15.6 zypper /usr/share/zypper/new",
+	}))
+
+	_, err = New().Run(t.Context(), cfg, db, repos, []string{"/usr/bin/*"})
+	assert.Check(t, cmp.ErrorIs(err, database.ErrNoResults))
+
+	_, err = New().Run(t.Context(), &config.Config{ReleaseVers: []string{"15.5", "16.0"}}, db, repos, []string{"/usr/*"})
+	assert.Check(t, cmp.ErrorContains(err, "no repositories found for release 16.0"))
+
+	_, err = New().Run(t.Context(), &config.Config{ReleaseVers: []string{"15.5"}}, db, repos, []string{"/usr/*"})
+	assert.Check(t, cmp.ErrorIs(err, cmd.ErrUsage))
+	_, err = New().Run(t.Context(), cfg, db, repos, nil)
+	assert.Check(t, cmp.ErrorIs(err, cmd.ErrUsage))
+}
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `check-conflicts` checks whether installing a set of packages would
+// cause file conflicts, before trying to install them.
+package conflicts
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/rpmver"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "check-conflicts",
+		Usage:       "package...",
+		Description: "Check whether the packages have files conflicting with each other or with installed packages.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct {
+	installed bool
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.installed, "installed", true, "Also check for conflicts with installed packages")
+}
+
+// conflict is a file that is provided by more than one package.
+type conflict struct {
+	XMLName    xml.Name `json:"-" xml:"conflict"`
+	Path       string   `json:"path" xml:"path,attr"`
+	Package    string   `json:"package" xml:"package,attr"`
+	Repository string   `json:"repository" xml:"repository,attr"`
+	// The other package providing the file.
+	ConflictsWith string `json:"conflictsWith" xml:"conflictsWith,attr"`
+	// Whether the other package is installed, rather than one being checked.
+	Installed bool `json:"installed" xml:"installed,attr"`
+}
+
+// ErrConflicts is returned if any conflicts were found.
+var ErrConflicts = errors.New("file conflicts found")
+
+// Run the `check-conflicts` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: expected at least one package", cmd.ErrUsage)
+	}
+
+	arch, err := cmd.Arch(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick the newest version of each package, preferring the architecture
+	// of the system, as zypper would.
+	filter := database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}
+	var files []database.SearchResult
+	chosen := make(map[string]database.SearchResult)
+	for _, term := range args {
+		var results []database.SearchResult
+		for _, arch := range []string{arch, ""} {
+			if results, err = db.ListPackage(ctx, filter, arch, term); err != nil {
+				return nil, err
+			}
+			if len(results) > 0 {
+				break
+			}
+		}
+		if len(results) == 0 {
+			return nil, fmt.Errorf("package %s not found", term)
+		}
+		for _, result := range results {
+			current, ok := chosen[result.Package]
+			if !ok || rpmver.CompareEVR(result.Epoch, result.Version, result.Release, current.Epoch, current.Version, current.Release) > 0 {
+				chosen[result.Package] = result
+			}
+		}
+		files = append(files, results...)
+	}
+
+	// Collect the owners of each file of the chosen packages.
+	owners := make(map[string][]database.SearchResult)
+	for _, result := range files {
+		if result.Alternative {
+			// update-alternatives arbitrates between these.
+			continue
+		}
+		best := chosen[result.Package]
+		if result.Repository != best.Repository || result.Arch != best.Arch || result.EVR() != best.EVR() {
+			continue
+		}
+		if !slices.ContainsFunc(owners[result.Path], func(r database.SearchResult) bool { return r.Package == result.Package }) {
+			owners[result.Path] = append(owners[result.Path], result)
+		}
+	}
+
+	var conflicts []conflict
+	for path, results := range owners {
+		for _, result := range results {
+			for _, other := range results {
+				if other.Package != result.Package {
+					conflicts = append(conflicts, conflict{
+						Path:          path,
+						Package:       result.NEVRA(),
+						Repository:    result.Repository,
+						ConflictsWith: other.NEVRA(),
+					})
+				}
+			}
+		}
+	}
+
+	if c.installed && cfg.Arch == "" {
+		paths := make([]string, 0, len(owners))
+		for path := range owners {
+			paths = append(paths, path)
+		}
+		installed, err := zypper.InstalledFiles(ctx, zypper.Options{InstallRoot: cfg.InstallRoot}, paths...)
+		if errors.Is(err, zypper.ErrUnsupported) {
+			slog.WarnContext(ctx, "Not checking for conflicts with installed packages", "error", err)
+		} else if err != nil {
+			return nil, err
+		}
+		for path, names := range installed {
+			for _, result := range owners[path] {
+				for _, name := range names {
+					// Installed packages being upgraded do not conflict.
+					if _, ok := chosen[name]; ok {
+						continue
+					}
+					conflicts = append(conflicts, conflict{
+						Path:          path,
+						Package:       result.NEVRA(),
+						Repository:    result.Repository,
+						ConflictsWith: name,
+						Installed:     true,
+					})
+				}
+			}
+		}
+	}
+
+	if len(conflicts) == 0 {
+		slog.InfoContext(ctx, "No file conflicts found", "packages", len(chosen), "files", len(owners))
+		return nil, nil
+	}
+
+	slices.SortFunc(conflicts, func(a, b conflict) int {
+		return cmp.Or(
+			strings.Compare(a.Path, b.Path),
+			strings.Compare(a.Package, b.Package),
+			strings.Compare(a.ConflictsWith, b.ConflictsWith))
+	})
+	err = output.Write(cmd.Stdout, cfg.Format, conflicts, []output.Column[conflict]{
+		{
+			Name:  "File",
+			Value: func(c conflict) string { return c.Path },
+		},
+		{
+			Name:  "Package",
+			Value: func(c conflict) string { return c.Package },
+		},
+		{
+			Name:  "Repository",
+			Value: func(c conflict) string { return c.Repository },
+		},
+		{
+			Name: "Conflicts With",
+			Value: func(c conflict) string {
+				if c.Installed {
+					return c.ConflictsWith + " (installed)"
+				}
+				return c.ConflictsWith
+			},
+			Plain: func(c conflict) string { return c.ConflictsWith },
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %d", ErrConflicts, len(conflicts))
+}
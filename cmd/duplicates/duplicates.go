@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `duplicates` lists files that are provided by more than one package.
+package duplicates
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "duplicates",
+		Usage:       "[pattern]",
+		Description: "List files (optionally matching the pattern) that are provided by more than one package.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	// No additional flags needed
+	return &command{}
+}
+
+type command struct {
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// Run the `duplicates` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	pattern := "*"
+	switch len(args) {
+	case 0:
+	case 1:
+		pattern = args[0]
+	default:
+		return nil, fmt.Errorf("%w: expected at most one pattern", cmd.ErrUsage)
+	}
+
+	filter := database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}
+	results, err := db.SearchDuplicates(ctx, filter, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, database.ErrNoResults
+	}
+	return results, nil
+}
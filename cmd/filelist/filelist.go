@@ -15,6 +15,17 @@ import (
 	"github.com/mook-as/zypper-filesearch/zypper"
 )
 
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "list",
+		Executables: []string{"zypper-file-list"},
+		Usage:       "[package...]",
+		Description: "List files contained in the given packages.",
+		History:     true,
+		New:         New,
+	})
+}
+
 func New() cmd.CommandRunner {
 	// No additional flags needed
 	return &command{}
@@ -23,23 +34,23 @@ func New() cmd.CommandRunner {
 type command struct {
 }
 
-func (c *command) AddFlags() {
+func (c *command) AddFlags(flags *flag.FlagSet) {
 }
 
 // Run the `zypper-filelist` command, including doing any argument parsing.
-func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]database.SearchResult, error) {
-	if flag.NArg() == 0 {
-		return nil, fmt.Errorf("usage: zypper file-list [pattern]")
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: expected at least one package", cmd.ErrUsage)
 	}
 
-	arch, err := zypper.Arch()
+	arch, err := cmd.Arch(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	var results []database.SearchResult
 	for _, arch := range []string{arch, ""} {
-		results, err = db.ListPackage(ctx, repos, arch, flag.Args()...)
+		results, err = db.ListPackage(ctx, database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}, arch, args...)
 		if err != nil {
 			return nil, err
 		}
@@ -49,7 +60,7 @@ func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Data
 	}
 
 	if len(results) == 0 {
-		return nil, fmt.Errorf("no results found")
+		return nil, database.ErrNoResults
 	}
 
 	return results, nil
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+)
+
+// commandDirs are the directories packages install commands into.
+var commandDirs = []string{"/usr/bin/", "/usr/sbin/", "/bin/", "/sbin/"}
+
+// commandPatterns returns the paths the command with the given name would be
+// installed at.  These are exact paths rather than glob patterns, so that the
+// lookup is quick enough for command-not-found handlers.
+func commandPatterns(name string) ([]string, error) {
+	if name == "" || strings.ContainsAny(name, "/*?[\\") {
+		return nil, fmt.Errorf("%w: invalid command name: %q", cmd.ErrUsage, name)
+	}
+	var patterns []string
+	for _, dir := range commandDirs {
+		patterns = append(patterns, dir+name)
+	}
+	return patterns, nil
+}
@@ -6,42 +6,274 @@
 package filesearch
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/mook-as/zypper-filesearch/cmd"
 	"github.com/mook-as/zypper-filesearch/config"
 	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/rpmver"
 	"github.com/mook-as/zypper-filesearch/zypper"
 )
 
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "search",
+		Executables: []string{"zypper-file-search"},
+		Usage:       "[pattern]",
+		Description: "Search for packages containing files matching a glob pattern.",
+		History:     true,
+		New:         New,
+	})
+}
+
 func New() cmd.CommandRunner {
-	// No additional flags needed
 	return &command{}
 }
 
+const (
+	// fuzzyLimit is the maximum number of results returned for fuzzy searches.
+	fuzzyLimit = 50
+	// suggestionCandidates is the number of similar files to consider when
+	// making suggestions after failing to find anything.
+	suggestionCandidates = 20
+	// maxSuggestions is the maximum number of suggestions to make.
+	maxSuggestions = 3
+)
+
+// errAmbiguous is returned when asked to install a package, but it is not clear
+// which package to install.
+var errAmbiguous = errors.New("no single package to install")
+
 type command struct {
+	fuzzy   bool
+	hash    bool
+	soname  bool
+	pkgconf bool
+	module  *moduleLanguage
+	kmod    bool
+	install bool
+	watch   bool
+	exec    string
+	// Find the package providing a command; see commandPatterns.
+	executable bool
+
+	// The aliases of the repositories with results, if only disabled
+	// repositories were searched.
+	disabledAliases []string
 }
 
-func (c *command) AddFlags() {
+func (c *command) AddFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.fuzzy, "fuzzy", false, "Find files with names similar to the given name, instead of matching a glob pattern")
+	flags.BoolVar(&c.hash, "hash", false, "Find files whose contents have the given digest (e.g. sha256), for repositories publishing filelists-ext")
+	flags.BoolVar(&c.soname, "soname", false, "Find packages providing the shared library with the given soname (e.g. libz.so.1), by file or RPM provides")
+	flags.BoolVar(&c.pkgconf, "pkgconfig", false, "Find packages providing the pkg-config module with the given name, by .pc file or RPM provides")
+	for i := range moduleLanguages {
+		language := &moduleLanguages[i]
+		usage := fmt.Sprintf("Find packages providing the %s module with the given name", language.language)
+		flags.BoolFunc(language.flag(), usage, func(string) error {
+			if c.module != nil && c.module != language {
+				return fmt.Errorf("cannot be used with -%s", c.module.flag())
+			}
+			c.module = language
+			return nil
+		})
+	}
+	flags.BoolVar(&c.kmod, "kmod", false, "Find packages providing the kernel module with the given name, for any kernel version")
+	flags.BoolVar(&c.executable, "command", false, "Find packages providing the command with the given name, without refreshing repositories first")
+	flags.BoolVar(&c.install, "install", false, "Install the package containing the file, asking which one if there are several")
+	flags.BoolVar(&c.watch, "watch", false, "Keep running, refreshing repositories periodically and printing new matches")
+	flags.StringVar(&c.exec, "exec", "", "With -watch, run the shell `command` for each new match")
 }
 
 // Run the `zypper-filesearch` command, including doing any argument parsing.
-func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]database.SearchResult, error) {
-	if flag.NArg() != 1 {
-		return nil, fmt.Errorf("usage: zypper file-search [pattern]")
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected a single pattern", cmd.ErrUsage)
+	}
+	pattern := args[0]
+	if c.install && (cfg.Arch != "" || len(cfg.ReleaseVers) > 1 || cfg.DisabledOnly) {
+		return nil, fmt.Errorf("%w: -install cannot be used with -arch, -disabled-only, or multiple -releasever", cmd.ErrUsage)
+	}
+	modes := []bool{c.fuzzy, c.hash, c.soname, c.pkgconf, c.module != nil, c.kmod, c.executable}
+	if len(itertools.Filter(modes, func(b bool) bool { return b })) > 1 {
+		return nil, fmt.Errorf("%w: only one of -fuzzy, -hash, -soname, -pkgconfig, -kmod, -command, and the module options can be used", cmd.ErrUsage)
+	}
+	if c.watch && c.install {
+		return nil, fmt.Errorf("%w: -watch and -install cannot be used together", cmd.ErrUsage)
+	}
+	if c.watch && (cfg.OutputFile != "" || cfg.Compress != config.CompressionNone) {
+		return nil, fmt.Errorf("%w: -watch cannot be used with -output or -compress", cmd.ErrUsage)
+	}
+	if c.exec != "" && !c.watch {
+		return nil, fmt.Errorf("%w: -exec requires -watch", cmd.ErrUsage)
+	}
+	if c.hash {
+		// Allow digests written as `sha256:<hex>`.
+		if _, digest, ok := strings.Cut(pattern, ":"); ok {
+			pattern = digest
+		}
+	}
+
+	filter := database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}
+	results, err := c.search(ctx, cfg, db, filter, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if c.watch {
+		return nil, c.watchResults(ctx, cfg, db, repos, filter, pattern, results)
+	}
+
+	if len(results) == 0 && !c.fuzzy && !c.hash && !c.soname && !c.pkgconf && c.module == nil && !c.kmod && !c.executable {
+		suggestions, err := c.suggest(ctx, db, filter, pattern)
+		if err != nil {
+			slog.DebugContext(ctx, "Failed to find suggestions", "error", err)
+		} else if len(suggestions) > 0 {
+			return nil, fmt.Errorf("%w for %s; did you mean %s?",
+				database.ErrNoResults, pattern, strings.Join(suggestions, " or "))
+		}
+	}
+	if len(results) == 0 {
+		return nil, database.ErrNoResults
+	}
+	if c.kmod {
+		sortByKernel(results)
 	}
-	pattern := flag.Arg(0)
 
-	arch, err := zypper.Arch()
+	if cfg.DisabledOnly {
+		for _, repo := range repos {
+			label := repoLabel(cfg.RepoLabel, repo)
+			if slices.ContainsFunc(results, func(r database.SearchResult) bool { return r.Repository == label }) {
+				c.disabledAliases = append(c.disabledAliases, repo.Alias)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// SkipRefresh avoids refreshing repositories when looking up commands, as
+// this is done by command-not-found handlers, which must be quick.
+func (c *command) SkipRefresh() bool {
+	return c.executable
+}
+
+// repoLabel returns how the repository is identified in results.
+func repoLabel(label config.RepoLabel, repo *zypper.Repository) string {
+	switch label {
+	case config.RepoLabelAlias:
+		return repo.Alias
+	case config.RepoLabelURL:
+		return repo.URL
+	}
+	return repo.Name
+}
+
+// suggest returns the paths of indexed files with names similar to the
+// pattern, preferring files in a directory matching the pattern.
+func (c *command) suggest(ctx context.Context, db *database.Database, filter database.RepoFilter, pattern string) ([]string, error) {
+	dir, name := path.Split(pattern)
+	if name == "" {
+		return nil, nil
+	}
+	candidates, err := db.FuzzySearchFile(ctx, filter, name, "", suggestionCandidates)
+	if err != nil {
+		return nil, err
+	}
+	var inDir, elsewhere []string
+	for _, candidate := range candidates {
+		if slices.Contains(inDir, candidate.Path) || slices.Contains(elsewhere, candidate.Path) {
+			continue
+		}
+		if matched, _ := path.Match(dir+"*", candidate.Path); matched || dir == "" {
+			inDir = append(inDir, candidate.Path)
+		} else {
+			elsewhere = append(elsewhere, candidate.Path)
+		}
+	}
+	suggestions := slices.Concat(inDir, elsewhere)
+	return suggestions[:min(len(suggestions), maxSuggestions)], nil
+}
+
+// Finish installs the matching package if requested; otherwise, it suggests
+// the command to do so.
+func (c *command) Finish(ctx context.Context, cfg *config.Config, w io.Writer, results []database.SearchResult) error {
+	if c.install {
+		name, err := choosePackage(os.Stdin, w, results)
+		if err != nil {
+			return err
+		}
+		opts := zypper.Options{InstallRoot: cfg.InstallRoot}
+		if len(cfg.ReleaseVers) > 0 {
+			opts.ReleaseVer = cfg.ReleaseVers[0]
+		}
+		return zypper.Install(ctx, opts, name)
+	}
+	if c.kmod && cfg.Format == config.OutputFormatHuman {
+		writeKernelSummary(w, results)
+	}
+	if len(c.disabledAliases) > 0 && cfg.Format == config.OutputFormatHuman {
+		slices.Sort(c.disabledAliases)
+		_, _ = fmt.Fprintf(w, "\nTo enable: sudo zypper modifyrepo --enable %s\n",
+			strings.Join(slices.Compact(c.disabledAliases), " "))
+		return nil
+	}
+	if cfg.InstallHint && cfg.Format == config.OutputFormatHuman && cfg.Arch == "" {
+		best := bestResult(results)
+		slog.DebugContext(ctx, "Suggesting package to install", "package", best.Package, "arch", best.Arch)
+		_, _ = fmt.Fprintf(w, "\nTo install: sudo zypper install %s\n", best.Package)
+	}
+	return nil
+}
+
+// search for the pattern, preferring results for the configured architecture.
+func (c *command) search(ctx context.Context, cfg *config.Config, db *database.Database, filter database.RepoFilter, pattern string) ([]database.SearchResult, error) {
+	arch, err := cmd.Arch(cfg)
 	if err != nil {
 		arch = ""
 	}
 
+	var patterns []string
+	if c.module != nil {
+		if patterns, err = c.modulePatterns(pattern); err != nil {
+			return nil, err
+		}
+	} else if c.kmod {
+		if patterns, err = kmodPatterns(pattern); err != nil {
+			return nil, err
+		}
+	} else if c.executable {
+		if patterns, err = commandPatterns(pattern); err != nil {
+			return nil, err
+		}
+	}
+
 	var results []database.SearchResult
 	for _, arch := range []string{arch, ""} {
-		results, err = db.SearchFile(ctx, repos, pattern, arch)
+		if c.fuzzy {
+			results, err = db.FuzzySearchFile(ctx, filter, pattern, arch, fuzzyLimit)
+		} else if c.hash {
+			results, err = db.SearchDigest(ctx, filter, pattern, arch)
+		} else if c.soname {
+			results, err = db.SearchSoname(ctx, filter, pattern, arch)
+		} else if c.pkgconf {
+			results, err = db.SearchPkgConfig(ctx, filter, pattern, arch)
+		} else if patterns != nil {
+			results, err = db.SearchFiles(ctx, filter, patterns, arch)
+		} else {
+			results, err = db.SearchFile(ctx, filter, pattern, arch)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -49,6 +281,53 @@ func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Data
 			break
 		}
 	}
-
 	return results, nil
 }
+
+// bestResult returns the result with the newest version for this architecture
+// (or noarch), if there are any.
+func bestResult(results []database.SearchResult) database.SearchResult {
+	arch, _ := zypper.Arch()
+	candidates := slices.DeleteFunc(slices.Clone(results), func(r database.SearchResult) bool {
+		return r.Arch != arch && r.Arch != "noarch"
+	})
+	if len(candidates) == 0 {
+		candidates = results
+	}
+	return slices.MaxFunc(candidates, func(a, b database.SearchResult) int {
+		return rpmver.CompareEVR(a.Epoch, a.Version, a.Release, b.Epoch, b.Version, b.Release)
+	})
+}
+
+// choosePackage returns the name of the package to install.  If the results
+// come from more than one package, the user is asked to pick one, as long as
+// input is from a terminal.
+func choosePackage(in *os.File, w io.Writer, results []database.SearchResult) (string, error) {
+	var names []string
+	for _, result := range results {
+		if !slices.Contains(names, result.Package) {
+			names = append(names, result.Package)
+		}
+	}
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	if info, err := in.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("%w: files found in multiple packages: %s",
+			errAmbiguous, strings.Join(names, ", "))
+	}
+	_, _ = fmt.Fprintln(w, "\nFiles were found in multiple packages:")
+	for i, name := range names {
+		_, _ = fmt.Fprintf(w, "  %d) %s\n", i+1, name)
+	}
+	_, _ = fmt.Fprintf(w, "Package to install [1-%d]: ", len(names))
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read selection: %w", err)
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(names) {
+		return "", fmt.Errorf("%w: invalid selection %q", errAmbiguous, strings.TrimSpace(line))
+	}
+	return names[choice-1], nil
+}
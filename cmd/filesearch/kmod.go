@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/rpmver"
+)
+
+// kmodDirs are the directories kernel modules are installed in; newer
+// releases use /usr/lib/modules.
+var kmodDirs = []string{"/lib/modules/", "/usr/lib/modules/"}
+
+// kmodName matches valid kernel module names.
+var kmodName = regexp.MustCompile(`^[\w-]+$`)
+
+// kmodPatterns returns the glob patterns for the files of the kernel module
+// with the given name, for any kernel version; as with modprobe, dashes and
+// underscores in the name are interchangeable.
+func kmodPatterns(name string) ([]string, error) {
+	if !kmodName.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid kernel module name: %q", cmd.ErrUsage, name)
+	}
+	name = strings.NewReplacer("-", "[-_]", "_", "[-_]").Replace(name)
+	var patterns []string
+	for _, dir := range kmodDirs {
+		// The module may be compressed, e.g. `.ko.zst`.
+		patterns = append(patterns, dir+"*/"+name+".ko", dir+"*/"+name+".ko.*")
+	}
+	return patterns, nil
+}
+
+// kernelVersion returns the kernel version (and flavor) the module at the
+// given path is built for, e.g. `6.4.0-150600.23.7-default`.
+func kernelVersion(path string) string {
+	for _, dir := range kmodDirs {
+		if rest, ok := strings.CutPrefix(path, dir); ok {
+			version, _, _ := strings.Cut(rest, "/")
+			return version
+		}
+	}
+	return ""
+}
+
+// sortByKernel sorts kernel module results so that they are grouped by
+// kernel version, newest first.
+func sortByKernel(results []database.SearchResult) {
+	slices.SortStableFunc(results, func(a, b database.SearchResult) int {
+		return cmp.Or(
+			rpmver.Compare(kernelVersion(b.Path), kernelVersion(a.Path)),
+			cmp.Compare(a.Package, b.Package))
+	})
+}
+
+// writeKernelSummary writes the packages providing the module for each kernel
+// version, in the order of the (sorted) results.
+func writeKernelSummary(w io.Writer, results []database.SearchResult) {
+	var versions []string
+	packages := make(map[string][]string)
+	for _, result := range results {
+		version := kernelVersion(result.Path)
+		if _, ok := packages[version]; !ok {
+			versions = append(versions, version)
+		}
+		if !slices.Contains(packages[version], result.Package) {
+			packages[version] = append(packages[version], result.Package)
+		}
+	}
+	_, _ = fmt.Fprintf(w, "\nKernel versions:\n")
+	for _, version := range versions {
+		_, _ = fmt.Fprintf(w, "  %s: %s\n", version, strings.Join(packages[version], ", "))
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestKmodPatterns(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	paths := []string{
+		"/lib/modules/6.4.0-150600.23.7-default/kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko",
+		"/usr/lib/modules/6.11.5-1-default/kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko.zst",
+		"/lib/modules/6.4.0-1-default/kernel/e1000e.ko.xz",
+		"/usr/lib/modules/6.11.5-1-default/kernel/e1000.ko.zst",
+		"/usr/lib/modules/6.11.5-1-default/kernel/e1000e.kofoo",
+		"/usr/lib/modules/6.11.5-1-default/kernel/e1000e.o",
+		"/usr/src/linux/drivers/e1000e.ko",
+		"/lib/firmware/e1000e.ko",
+		"/usr/lib/modules/6.11.5-1-default/kernel/sound/snd_hda_intel.ko.zst",
+		"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda-intel.ko",
+		"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda_intel.ko",
+		"/lib/modules/6.4.0-1-default/kernel/sound/snd.hda.intel.ko",
+	}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, database.Provenance{}, func(p func(*database.Package) (func(string, string) error, error)) error {
+		f, err := p(&database.Package{PkgId: "1", Name: "kernel-default", Arch: "x86_64"})
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := f(path, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		// Modules are found in either directory, whether compressed or not;
+		// only the whole name matches.
+		{"e1000e", []string{
+			"/lib/modules/6.4.0-1-default/kernel/e1000e.ko.xz",
+			"/lib/modules/6.4.0-150600.23.7-default/kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko",
+			"/usr/lib/modules/6.11.5-1-default/kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko.zst",
+		}},
+		{"e1000", []string{"/usr/lib/modules/6.11.5-1-default/kernel/e1000.ko.zst"}},
+		// Dashes and underscores are interchangeable, in either direction,
+		// but nothing else is.
+		{"snd-hda-intel", []string{
+			"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda-intel.ko",
+			"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda_intel.ko",
+			"/usr/lib/modules/6.11.5-1-default/kernel/sound/snd_hda_intel.ko.zst",
+		}},
+		{"snd_hda_intel", []string{
+			"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda-intel.ko",
+			"/lib/modules/6.4.0-1-default/kernel/sound/snd-hda_intel.ko",
+			"/usr/lib/modules/6.11.5-1-default/kernel/sound/snd_hda_intel.ko.zst",
+		}},
+	} {
+		patterns, err := kmodPatterns(tc.name)
+		assert.NilError(t, err)
+		results, err := db.SearchFiles(t.Context(), database.RepoFilter{Repos: []*zypper.Repository{repo}}, patterns, "")
+		assert.NilError(t, err)
+		var found []string
+		for _, result := range results {
+			found = append(found, result.Path)
+		}
+		slices.Sort(found)
+		assert.Check(t, cmp.DeepEqual(found, tc.expected), tc.name)
+	}
+
+	for _, name := range []string{"", "e1000e.ko", "../e1000e", "e1000e*", "snd hda"} {
+		_, err := kmodPatterns(name)
+		assert.Check(t, cmp.ErrorIs(err, cmd.ErrUsage), name)
+	}
+}
+
+func TestKernelVersion(t *testing.T) {
+	for _, tc := range []struct {
+		path, version string
+	}{
+		{"/lib/modules/6.4.0-150600.23.7-default/kernel/drivers/net/e1000e.ko", "6.4.0-150600.23.7-default"},
+		{"/usr/lib/modules/6.11.5-1-default/kernel/fs/btrfs/btrfs.ko.zst", "6.11.5-1-default"},
+		{"/usr/lib/modules/6.11.5-1-default", "6.11.5-1-default"},
+		{"/usr/src/linux/drivers/net/e1000e.ko", ""},
+		{"/lib/modulesx/6.4.0/e1000e.ko", ""},
+	} {
+		assert.Check(t, cmp.Equal(kernelVersion(tc.path), tc.version), tc.path)
+	}
+}
+
+func TestSortByKernel(t *testing.T) {
+	results := []database.SearchResult{
+		{Package: "kernel-default", Path: "/lib/modules/6.4.0-150600.23.7-default/kernel/e1000e.ko"},
+		{Package: "kernel-default", Path: "/usr/lib/modules/6.11.5-1-default/kernel/e1000e.ko.zst"},
+		{Package: "kernel-azure", Path: "/lib/modules/6.4.0-150600.8.5-azure/kernel/e1000e.ko"},
+		{Package: "kernel-default", Path: "/lib/modules/6.4.0-150600.23.14-default/kernel/e1000e.ko"},
+		{Package: "kernel-kvmsmall", Path: "/usr/lib/modules/6.11.5-1-default/kernel/e1000e.ko.zst"},
+	}
+	sortByKernel(results)
+	// Versions are compared as RPM versions (so 6.11 is newer than 6.4, and
+	// 23.14 than 23.7), with packages in order for the same version.
+	var order []string
+	for _, result := range results {
+		order = append(order, kernelVersion(result.Path)+" "+result.Package)
+	}
+	assert.Check(t, cmp.DeepEqual(order, []string{
+		"6.11.5-1-default kernel-default",
+		"6.11.5-1-default kernel-kvmsmall",
+		"6.4.0-150600.23.14-default kernel-default",
+		"6.4.0-150600.23.7-default kernel-default",
+		"6.4.0-150600.8.5-azure kernel-azure",
+	}))
+
+	var summary strings.Builder
+	writeKernelSummary(&summary, results)
+	assert.Check(t, cmp.Equal(summary.String(), "\nKernel versions:\n"+
+		"  6.11.5-1-default: kernel-default, kernel-kvmsmall\n"+
+		"  6.4.0-150600.23.14-default: kernel-default\n"+
+		"  6.4.0-150600.23.7-default: kernel-default\n"+
+		"  6.4.0-150600.8.5-azure: kernel-azure\n"))
+}
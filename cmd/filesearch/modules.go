@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+)
+
+// moduleLanguage describes how to find the files for a module of a language.
+type moduleLanguage struct {
+	// The name of the language, e.g. `Python`.
+	language string
+	// Valid module names.
+	name *regexp.Regexp
+	// patterns returns the glob patterns for files implementing the module.
+	patterns func(module string) []string
+}
+
+var moduleLanguages = []moduleLanguage{
+	{
+		language: "Python",
+		name:     regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)*$`),
+		patterns: func(module string) []string {
+			module = strings.ReplaceAll(module, ".", "/")
+			var patterns []string
+			for _, dir := range []string{"*/site-packages/", "*/dist-packages/", "/usr/lib*/python*/lib-dynload/"} {
+				patterns = append(patterns,
+					dir+module+"/__init__.py",
+					dir+module+".py",
+					dir+module+".so",
+					dir+module+".*.so")
+			}
+			return patterns
+		},
+	},
+	{
+		language: "Perl",
+		name:     regexp.MustCompile(`^\w+(::\w+)*$`),
+		patterns: func(module string) []string {
+			module = strings.ReplaceAll(module, "::", "/")
+			return []string{"*/perl5/*/" + module + ".pm"}
+		},
+	},
+	{
+		language: "Ruby",
+		name:     regexp.MustCompile(`^[\w.+-]+(/[\w.+-]+)*$`),
+		patterns: func(module string) []string {
+			return []string{"*/ruby/*/" + module + ".rb", "*/ruby/*/" + module + ".so"}
+		},
+	},
+}
+
+// flag returns the name of the flag selecting the language.
+func (l *moduleLanguage) flag() string {
+	return strings.ToLower(l.language) + "-module"
+}
+
+// modulePatterns returns the glob patterns for files implementing the module
+// in the selected language.
+func (c *command) modulePatterns(module string) ([]string, error) {
+	if !c.module.name.MatchString(module) {
+		return nil, fmt.Errorf("%w: invalid module name for -%s: %q", cmd.ErrUsage, c.module.flag(), module)
+	}
+	return c.module.patterns(module), nil
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/hook"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/repository"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// watchResults prints the initial results, then refreshes the repositories
+// whenever one is due to be checked and prints any new matches, until the
+// context is cancelled.
+func (c *command) watchResults(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, filter database.RepoFilter, pattern string, results []database.SearchResult) error {
+	columns, err := cmd.SearchResultColumns(cfg)
+	if err != nil {
+		return err
+	}
+	seen := make(map[database.SearchResult]bool)
+	for _, result := range results {
+		seen[result] = true
+	}
+	if len(results) > 0 {
+		if err := output.Write(cmd.Stdout, cfg.Format, results, columns); err != nil {
+			return err
+		}
+	}
+
+	interval := config.DefaultRefreshInterval
+	for i, repo := range repos {
+		if repoInterval := cfg.RefreshIntervalFor(repo.Alias); i == 0 || repoInterval < interval {
+			interval = repoInterval
+		}
+	}
+	slog.DebugContext(ctx, "Watching for new matches", "pattern", pattern, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if _, err := repository.Refresh(ctx, cfg, db, repos); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Try again next time; the cached data is still usable.
+			slog.WarnContext(ctx, "Failed to refresh repositories", "error", err)
+		}
+		results, err := c.search(ctx, cfg, db, filter, pattern)
+		if err != nil {
+			return err
+		}
+		var added []database.SearchResult
+		for _, result := range results {
+			if !seen[result] {
+				seen[result] = true
+				added = append(added, result)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		if err := output.Write(cmd.Stdout, cfg.Format, added, columns); err != nil {
+			return err
+		}
+		if command := cmp.Or(c.exec, cfg.OnNewMatch); command != "" {
+			for _, result := range added {
+				err := hook.Run(ctx, command, hook.EventNewMatch, map[string]string{
+					"repository": result.Repository,
+					"package":    result.Package,
+					"epoch":      result.Epoch,
+					"version":    result.Version,
+					"release":    result.Release,
+					"arch":       result.Arch,
+					"path":       result.Path,
+				})
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to run hook", "path", result.Path, "error", err)
+				}
+			}
+		}
+	}
+}
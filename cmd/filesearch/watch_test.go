@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package filesearch
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+// syncBuffer collects output written from another goroutine.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// waitFor calls f until it returns true, failing the test if that takes too
+// long.
+func waitFor(t *testing.T, f func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	// The index is updated while it is being watched, which the in-memory
+	// database used for testing cannot do.
+	cacheDir := t.TempDir()
+	db, err := database.New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	// The repository can't be refreshed, so the index only changes when the
+	// test updates it; failing to refresh does not stop watching.
+	repo := &zypper.Repository{Alias: "oss", Name: "oss", Type: "rpm-md", Enabled: true, URL: "unsupported://oss"}
+	setPackages := func(names ...string) {
+		now := time.Now().UTC()
+		err := db.UpdateRepository(t.Context(), repo, now, now, database.Provenance{}, func(p func(*database.Package) (func(string, string) error, error)) error {
+			for _, name := range names {
+				f, err := p(&database.Package{PkgId: name, Name: name, Version: "1.0", Release: "1", Arch: "x86_64"})
+				if err != nil {
+					return err
+				}
+				if err := f("/usr/bin/"+name, ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.NilError(t, err)
+	}
+	setPackages("foo")
+
+	var stdout syncBuffer
+	previous := cmd.Stdout
+	cmd.Stdout = &stdout
+	t.Cleanup(func() { cmd.Stdout = previous })
+
+	hookOutput := filepath.Join(t.TempDir(), "matches")
+	runner := New()
+	flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	runner.AddFlags(flags)
+	assert.NilError(t, flags.Parse([]string{"-watch", "-exec", `echo "$ZYPPER_FILESEARCH_EVENT $ZYPPER_FILESEARCH_PACKAGE $ZYPPER_FILESEARCH_PATH" >> ` + hookOutput}))
+	cfg := &config.Config{
+		CacheDir:        cacheDir,
+		Arch:            "x86_64",
+		Format:          config.OutputFormatTerse,
+		ShowPath:        config.PathDisplayFull,
+		RefreshInterval: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := runner.Run(ctx, cfg, db, []*zypper.Repository{repo}, []string{"/usr/bin/*"})
+		done <- err
+	}()
+
+	// The initial results are printed straight away.
+	waitFor(t, func() bool { return strings.Contains(stdout.String(), "/usr/bin/foo") }, "initial results")
+
+	// New matches are noticed on the next check, and run the hook once each.
+	setPackages("foo", "bar")
+	waitFor(t, func() bool {
+		_, err := os.Stat(hookOutput)
+		return err == nil
+	}, "hook")
+	// Let a few more checks pass, which must not report anything again.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.Check(t, errors.Is(<-done, context.Canceled))
+
+	matches, err := os.ReadFile(hookOutput)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(string(matches), "new-match bar /usr/bin/bar\n"))
+	// The initial results are printed first, then only the new ones.
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Assert(t, cmp.Len(lines, 2), stdout.String())
+	assert.Check(t, cmp.Contains(lines[0], "/usr/bin/foo"))
+	assert.Check(t, cmp.Contains(lines[1], "/usr/bin/bar"))
+}
+
+func TestWatchUsage(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	for _, tc := range []struct {
+		args []string
+		cfg  config.Config
+	}{
+		{args: []string{"-watch", "-install"}},
+		{args: []string{"-watch"}, cfg: config.Config{OutputFile: "results.json"}},
+		{args: []string{"-watch"}, cfg: config.Config{Compress: config.CompressionGzip}},
+		{args: []string{"-exec", "true"}},
+	} {
+		runner := New()
+		flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+		runner.AddFlags(flags)
+		assert.NilError(t, flags.Parse(tc.args))
+		_, err := runner.Run(t.Context(), &tc.cfg, db, nil, []string{"/usr/bin/*"})
+		assert.Check(t, cmp.ErrorIs(err, cmd.ErrUsage), "%v", tc.args)
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `history` lists previously run queries.
+package history
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "history",
+		Usage:       "[prefix]",
+		Description: "List recent queries (requires history to be enabled in the configuration).",
+		SkipRefresh: true,
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct {
+	command  string
+	limit    int
+	complete bool
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.command, "command", "", "Only list queries for the given `command`")
+	flags.IntVar(&c.limit, "limit", 20, "List at most `count` queries")
+	flags.BoolVar(&c.complete, "complete", false, "Only print the queries, one per line, for shell completion")
+}
+
+// Run the `history` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("%w: expected at most one prefix", cmd.ErrUsage)
+	}
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	if !cfg.History {
+		return nil, fmt.Errorf("query history is not enabled; set `history = true` in the configuration")
+	}
+	entries, err := db.History(ctx, c.command, prefix, c.limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.complete {
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(cmd.Stdout, entry.Query); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	return nil, output.Write(cmd.Stdout, cfg.Format, entries, []output.Column[database.HistoryEntry]{
+		{
+			Name:  "Time",
+			Value: func(e database.HistoryEntry) string { return e.Timestamp.Local().Format(time.DateTime) },
+		},
+		{
+			Name:  "Command",
+			Value: func(e database.HistoryEntry) string { return e.Command },
+		},
+		{
+			Name:  "Query",
+			Value: func(e database.HistoryEntry) string { return e.Query },
+		},
+	})
+}
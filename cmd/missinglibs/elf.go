@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package missinglibs
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// binary is the dynamic linking information of an ELF file.
+type binary struct {
+	// The sonames of the libraries the binary needs (DT_NEEDED).
+	needed []string
+	// Directories from DT_RPATH and DT_RUNPATH, with $ORIGIN expanded.
+	runPath []string
+	class   elf.Class
+}
+
+// readBinary reads the dynamic linking information of the ELF file.
+func readBinary(name string) (*binary, error) {
+	file, err := elf.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	result := &binary{class: file.Class}
+	if result.needed, err = file.DynString(elf.DT_NEEDED); err != nil {
+		return nil, fmt.Errorf("failed to read needed libraries of %s: %w", name, err)
+	}
+	origin, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := file.DynString(tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read library path of %s: %w", name, err)
+		}
+		for _, value := range values {
+			for _, dir := range filepath.SplitList(value) {
+				dir = strings.ReplaceAll(dir, "${ORIGIN}", origin)
+				dir = strings.ReplaceAll(dir, "$ORIGIN", origin)
+				result.runPath = append(result.runPath, dir)
+			}
+		}
+	}
+	return result, nil
+}
+
+// libraryDirs returns the directories the dynamic linker searches, in the
+// system at the given root, for binaries of the given class.
+func libraryDirs(root string, class elf.Class) []string {
+	var dirs []string
+	if value := os.Getenv("LD_LIBRARY_PATH"); value != "" && root == "" {
+		dirs = append(dirs, filepath.SplitList(value)...)
+	}
+	dirs = append(dirs, readLdSoConf(root, "/etc/ld.so.conf", 0)...)
+	if class == elf.ELFCLASS64 {
+		dirs = append(dirs, "/lib64", "/usr/lib64")
+	}
+	return append(dirs, "/lib", "/usr/lib")
+}
+
+// maxLdSoConfDepth limits nested includes in ld.so.conf.
+const maxLdSoConfDepth = 8
+
+// readLdSoConf returns the directories listed in the ld.so.conf file,
+// including any files it includes.
+func readLdSoConf(root, name string, depth int) []string {
+	file, err := os.Open(filepath.Join(root, name))
+	if err != nil || depth > maxLdSoConfDepth {
+		return nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var dirs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if pattern, ok := strings.CutPrefix(line, "include"); ok && pattern != "" && (pattern[0] == ' ' || pattern[0] == '\t') {
+			pattern = strings.TrimSpace(pattern)
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(name), pattern)
+			}
+			matches, _ := filepath.Glob(filepath.Join(root, pattern))
+			for _, match := range matches {
+				rel, err := filepath.Rel(filepath.Join(root, "/"), match)
+				if err == nil {
+					dirs = append(dirs, readLdSoConf(root, "/"+rel, depth+1)...)
+				}
+			}
+		} else if line != "" {
+			dirs = append(dirs, strings.Fields(line)...)
+		}
+	}
+	return dirs
+}
+
+// findLibrary returns whether a library with the given soname and class
+// exists in any of the directories within the root.
+func findLibrary(root, soname string, class elf.Class, dirs []string) bool {
+	for _, dir := range dirs {
+		file, err := elf.Open(filepath.Join(root, dir, soname))
+		if err != nil {
+			continue
+		}
+		matched := file.Class == class
+		_ = file.Close()
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package missinglibs
+
+import (
+	"bytes"
+	"debug/elf"
+	byteorder "encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+// writeELF writes a minimal little-endian ELF shared object of the given class,
+// with just a dynamic section holding the given entries (of string values).
+func writeELF(t *testing.T, name string, class elf.Class, dyn []elf.DynTag, values []string) {
+	t.Helper()
+	dynstr := []byte{0}
+	var offsets []uint64
+	for _, value := range values {
+		offsets = append(offsets, uint64(len(dynstr)))
+		dynstr = append(append(dynstr, value...), 0)
+	}
+	shstrtab := []byte("\x00.dynstr\x00.dynamic\x00.shstrtab\x00")
+
+	var buf bytes.Buffer
+	write := func(data any) {
+		assert.NilError(t, byteorder.Write(&buf, byteorder.LittleEndian, data))
+	}
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(class), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+	if class == elf.ELFCLASS64 {
+		headerSize, sectionSize, dynSize := 64, 64, 16
+		dynOffset := headerSize + len(dynstr)
+		shstrOffset := dynOffset + (len(dyn)+1)*dynSize
+		write(elf.Header64{
+			Ident: ident, Type: uint16(elf.ET_DYN), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+			Shoff: uint64(shstrOffset + len(shstrtab)), Ehsize: uint16(headerSize), Shentsize: uint16(sectionSize), Shnum: 4, Shstrndx: 3,
+		})
+		buf.Write(dynstr)
+		for i, tag := range dyn {
+			write(elf.Dyn64{Tag: int64(tag), Val: offsets[i]})
+		}
+		write(elf.Dyn64{Tag: int64(elf.DT_NULL)})
+		buf.Write(shstrtab)
+		write(elf.Section64{})
+		write(elf.Section64{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: uint64(headerSize), Size: uint64(len(dynstr)), Addralign: 1})
+		write(elf.Section64{Name: 9, Type: uint32(elf.SHT_DYNAMIC), Off: uint64(dynOffset), Size: uint64((len(dyn) + 1) * dynSize), Link: 1, Addralign: 8, Entsize: uint64(dynSize)})
+		write(elf.Section64{Name: 18, Type: uint32(elf.SHT_STRTAB), Off: uint64(shstrOffset), Size: uint64(len(shstrtab)), Addralign: 1})
+	} else {
+		headerSize, sectionSize, dynSize := 52, 40, 8
+		dynOffset := headerSize + len(dynstr)
+		shstrOffset := dynOffset + (len(dyn)+1)*dynSize
+		write(elf.Header32{
+			Ident: ident, Type: uint16(elf.ET_DYN), Machine: uint16(elf.EM_386), Version: uint32(elf.EV_CURRENT),
+			Shoff: uint32(shstrOffset + len(shstrtab)), Ehsize: uint16(headerSize), Shentsize: uint16(sectionSize), Shnum: 4, Shstrndx: 3,
+		})
+		buf.Write(dynstr)
+		for i, tag := range dyn {
+			write(elf.Dyn32{Tag: int32(tag), Val: uint32(offsets[i])})
+		}
+		write(elf.Dyn32{Tag: int32(elf.DT_NULL)})
+		buf.Write(shstrtab)
+		write(elf.Section32{})
+		write(elf.Section32{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: uint32(headerSize), Size: uint32(len(dynstr)), Addralign: 1})
+		write(elf.Section32{Name: 9, Type: uint32(elf.SHT_DYNAMIC), Off: uint32(dynOffset), Size: uint32((len(dyn) + 1) * dynSize), Link: 1, Addralign: 4, Entsize: uint32(dynSize)})
+		write(elf.Section32{Name: 18, Type: uint32(elf.SHT_STRTAB), Off: uint32(shstrOffset), Size: uint32(len(shstrtab)), Addralign: 1})
+	}
+	assert.NilError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+	assert.NilError(t, os.WriteFile(name, buf.Bytes(), 0o644))
+}
+
+func TestReadBinary(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "bin", "prog")
+	writeELF(t, name, elf.ELFCLASS64,
+		[]elf.DynTag{elf.DT_NEEDED, elf.DT_RPATH, elf.DT_NEEDED, elf.DT_RUNPATH},
+		[]string{"libfoo.so.1", "${ORIGIN}/rpath", "libc.so.6", "$ORIGIN/../lib:/opt/lib"})
+
+	bin, err := readBinary(name)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(bin.needed, []string{"libfoo.so.1", "libc.so.6"}))
+	// DT_RUNPATH comes first, and $ORIGIN is the directory of the binary.
+	origin := filepath.Join(dir, "bin")
+	assert.Check(t, cmp.DeepEqual(bin.runPath, []string{origin + "/../lib", "/opt/lib", origin + "/rpath"}))
+	assert.Check(t, cmp.Equal(bin.class, elf.ELFCLASS64))
+
+	// Files that are not ELF are rejected.
+	notELF := filepath.Join(dir, "script")
+	assert.NilError(t, os.WriteFile(notELF, []byte("#!/bin/sh\n"), 0o755))
+	_, err = readBinary(notELF)
+	assert.Check(t, cmp.ErrorContains(err, "failed to read "+notELF))
+}
+
+func TestReadLdSoConf(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"etc/ld.so.conf": "# The system libraries\n" +
+			"/usr/local/lib\n" +
+			"include ld.so.conf.d/*.conf\n" +
+			"/opt/lib /opt/lib2 # trailing comment\n" +
+			"includes/are/not/a/directive/without/space\n",
+		"etc/ld.so.conf.d/a.conf": "/a/lib\n",
+		"etc/ld.so.conf.d/b.conf": "include\t/etc/nested.conf\n",
+		// Include loops are cut short instead of recursing forever.
+		"etc/ld.so.conf.d/c.conf": "include /etc/ld.so.conf.d/c.conf\n",
+		"etc/ld.so.conf.d/a.txt":  "/not/included\n",
+		"etc/nested.conf":         "/nested/lib\n",
+	}
+	for name, contents := range files {
+		assert.NilError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0o755))
+		assert.NilError(t, os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644))
+	}
+
+	dirs := readLdSoConf(root, "/etc/ld.so.conf", 0)
+	assert.Check(t, cmp.DeepEqual(dirs, []string{
+		"/usr/local/lib",
+		"/a/lib",
+		"/nested/lib",
+		"/opt/lib",
+		"/opt/lib2",
+		"includes/are/not/a/directive/without/space",
+	}))
+
+	// A missing file lists nothing.
+	assert.Check(t, cmp.Len(readLdSoConf(root, "/etc/missing.conf", 0), 0))
+}
+
+func TestFindLibrary(t *testing.T) {
+	root := t.TempDir()
+	writeELF(t, filepath.Join(root, "usr/lib64/libfoo.so.1"), elf.ELFCLASS64, nil, nil)
+	writeELF(t, filepath.Join(root, "usr/lib/libfoo.so.1"), elf.ELFCLASS32, nil, nil)
+	writeELF(t, filepath.Join(root, "usr/lib/libbar.so.1"), elf.ELFCLASS32, nil, nil)
+	dirs := []string{"/usr/lib64", "/usr/lib"}
+
+	assert.Check(t, findLibrary(root, "libfoo.so.1", elf.ELFCLASS64, dirs))
+	assert.Check(t, findLibrary(root, "libfoo.so.1", elf.ELFCLASS32, dirs))
+	// A library of the wrong class does not count.
+	assert.Check(t, !findLibrary(root, "libbar.so.1", elf.ELFCLASS64, dirs))
+	assert.Check(t, findLibrary(root, "libbar.so.1", elf.ELFCLASS32, dirs))
+	assert.Check(t, !findLibrary(root, "libmissing.so.1", elf.ELFCLASS64, dirs))
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `missing-libs` finds the packages providing the shared libraries a
+// binary needs but which are not installed.
+package missinglibs
+
+import (
+	"cmp"
+	"context"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/rpmver"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "missing-libs",
+		Usage:       "binary...",
+		Description: "Find the packages providing the shared libraries the binaries need but are missing.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	// No additional flags needed
+	return &command{}
+}
+
+type command struct {
+	// The package chosen for each missing library, in order.
+	chosen []string
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// Run the `missing-libs` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: expected at least one binary", cmd.ErrUsage)
+	}
+	arch, err := cmd.Arch(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the missing libraries of all binaries, keeping their class.
+	type library struct {
+		soname string
+		class  elf.Class
+	}
+	var missing []library
+	for _, name := range args {
+		bin, err := readBinary(name)
+		if err != nil {
+			return nil, err
+		}
+		dirs := slices.Concat(bin.runPath, libraryDirs(cfg.InstallRoot, bin.class))
+		for _, soname := range bin.needed {
+			lib := library{soname: soname, class: bin.class}
+			if slices.Contains(missing, lib) {
+				continue
+			}
+			if findLibrary(cfg.InstallRoot, soname, bin.class, dirs) {
+				slog.DebugContext(ctx, "Library found", "binary", name, "library", soname)
+				continue
+			}
+			slog.DebugContext(ctx, "Library missing", "binary", name, "library", soname)
+			missing = append(missing, lib)
+		}
+	}
+	if len(missing) == 0 {
+		slog.InfoContext(ctx, "No libraries are missing", "binaries", len(args))
+		return nil, nil
+	}
+
+	filter := database.RepoFilter{Repos: repos, Patterns: cfg.RepoPatterns, Groups: cfg.Groups}
+	var results []database.SearchResult
+	var unresolved []string
+	for _, lib := range missing {
+		var candidates []database.SearchResult
+		for _, arch := range []string{arch, ""} {
+			found, err := db.SearchSoname(ctx, filter, lib.soname, arch)
+			if err != nil {
+				return nil, err
+			}
+			candidates = slices.DeleteFunc(found, func(r database.SearchResult) bool {
+				return !matchesClass(r.Path, lib.class)
+			})
+			if len(candidates) > 0 {
+				break
+			}
+		}
+		if len(candidates) == 0 {
+			unresolved = append(unresolved, lib.soname)
+			continue
+		}
+		results = append(results, candidates...)
+		best := choosePackage(candidates, c.chosen)
+		slog.DebugContext(ctx, "Suggesting package", "library", lib.soname, "package", best.Package, "version", best.EVR())
+		if !slices.Contains(c.chosen, best.Package) {
+			c.chosen = append(c.chosen, best.Package)
+		}
+	}
+	if len(unresolved) > 0 {
+		slog.WarnContext(ctx, "No package provides some missing libraries", "libraries", unresolved)
+	}
+	if len(results) == 0 {
+		return nil, database.ErrNoResults
+	}
+	return results, nil
+}
+
+// matchesClass returns whether a result for a library (either a file path or
+// an RPM provides) is for binaries of the given class.
+func matchesClass(result string, class elf.Class) bool {
+	if strings.HasPrefix(result, "/") {
+		return strings.Contains(result, "/lib64/") == (class == elf.ELFCLASS64)
+	}
+	return strings.HasSuffix(result, "(64bit)") == (class == elf.ELFCLASS64)
+}
+
+// choosePackage returns the newest result of the package to suggest among
+// the candidates providing a library.  Versions of differently named packages
+// cannot be compared, so a package that was already chosen is preferred;
+// otherwise the one with the shortest name, as variants of a library package
+// usually add a suffix to its name.
+func choosePackage(candidates []database.SearchResult, chosen []string) database.SearchResult {
+	isChosen := func(r database.SearchResult) bool { return slices.Contains(chosen, r.Package) }
+	name := slices.MinFunc(candidates, func(a, b database.SearchResult) int {
+		if isChosen(a) != isChosen(b) {
+			if isChosen(a) {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(len(a.Package), len(b.Package)), cmp.Compare(a.Package, b.Package))
+	}).Package
+	return slices.MaxFunc(slices.DeleteFunc(slices.Clone(candidates), func(r database.SearchResult) bool {
+		return r.Package != name
+	}), func(a, b database.SearchResult) int {
+		return rpmver.CompareEVR(a.Epoch, a.Version, a.Release, b.Epoch, b.Version, b.Release)
+	})
+}
+
+// Finish suggests the command to install the packages providing the missing
+// libraries.
+func (c *command) Finish(ctx context.Context, cfg *config.Config, w io.Writer, results []database.SearchResult) error {
+	if len(c.chosen) > 0 && cfg.Format == config.OutputFormatHuman {
+		_, _ = fmt.Fprintf(w, "\nTo install: sudo zypper install %s\n", strings.Join(c.chosen, " "))
+	}
+	return nil
+}
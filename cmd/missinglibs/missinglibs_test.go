@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package missinglibs
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/mook-as/zypper-filesearch/database"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestMatchesClass(t *testing.T) {
+	for _, tc := range []struct {
+		result string
+		class  elf.Class
+		want   bool
+	}{
+		{"/usr/lib64/libfoo.so.1", elf.ELFCLASS64, true},
+		{"/usr/lib64/libfoo.so.1", elf.ELFCLASS32, false},
+		{"/usr/lib/libfoo.so.1", elf.ELFCLASS32, true},
+		{"/usr/lib/libfoo.so.1", elf.ELFCLASS64, false},
+		{"/usr/lib64/foo/libfoo.so.1", elf.ELFCLASS64, true},
+		{"libfoo.so.1()(64bit)", elf.ELFCLASS64, true},
+		{"libfoo.so.1()(64bit)", elf.ELFCLASS32, false},
+		{"libfoo.so.1()", elf.ELFCLASS32, true},
+		{"libfoo.so.1", elf.ELFCLASS32, true},
+		{"libfoo.so.1", elf.ELFCLASS64, false},
+	} {
+		assert.Check(t, cmp.Equal(matchesClass(tc.result, tc.class), tc.want), "%s (%s)", tc.result, tc.class)
+	}
+}
+
+func TestChoosePackage(t *testing.T) {
+	candidates := []database.SearchResult{
+		{Package: "libfoo1-compat", Version: "9.0", Release: "1"},
+		{Package: "libfoo1", Version: "1.0", Release: "1"},
+		{Package: "libfoo1", Version: "1.2", Release: "1"},
+		{Package: "libfoo1", Version: "1.10", Release: "1"},
+	}
+	// The newer version of a different package does not win.
+	best := choosePackage(candidates, nil)
+	assert.Check(t, cmp.Equal(best.Package, "libfoo1"))
+	assert.Check(t, cmp.Equal(best.Version, "1.10"))
+
+	// A package that was already chosen is preferred.
+	best = choosePackage(candidates, []string{"libfoo1-compat"})
+	assert.Check(t, cmp.Equal(best.Package, "libfoo1-compat"))
+	assert.Check(t, cmp.Equal(best.Version, "9.0"))
+}
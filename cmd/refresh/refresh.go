@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `refresh` updates the cached repository metadata without searching.
+package refresh
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/repository"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "refresh",
+		Description: "Refresh the cached repository metadata.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct {
+	dryRun bool
+	warm   bool
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.dryRun, "dry-run", false, "Report which repositories would be refreshed, without changing anything")
+	flags.BoolVar(&c.warm, "warm", false, "After refreshing, prepare the cache so the next search is fast (see `cache warm`)")
+}
+
+// SkipRefresh avoids the refresh when only reporting what it would do.
+func (c *command) SkipRefresh() bool {
+	return c.dryRun
+}
+
+// Run the `refresh` command; the actual refresh has already been done before
+// any command is run, so there is nothing left to do unless this is a dry run
+// or the cache should be warmed.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("%w: unexpected arguments", cmd.ErrUsage)
+	}
+	if c.dryRun && c.warm {
+		return nil, fmt.Errorf("%w: -dry-run and -warm cannot be used together", cmd.ErrUsage)
+	}
+	if c.warm {
+		return nil, db.Warm(ctx)
+	}
+	if !c.dryRun {
+		return nil, nil
+	}
+	plans, err := repository.PlanRefresh(ctx, cfg, db, repos)
+	if err != nil {
+		return nil, err
+	}
+	return nil, output.Write(cmd.Stdout, cfg.Format, plans, []output.Column[repository.PlannedRefresh]{
+		{
+			Name:  "Repository",
+			Value: func(p repository.PlannedRefresh) string { return p.Repository },
+		},
+		{
+			Name: "Action",
+			Value: func(p repository.PlannedRefresh) string {
+				if p.Fetch {
+					return "fetch"
+				}
+				return "skip"
+			},
+		},
+		{
+			Name: "Download",
+			Value: func(p repository.PlannedRefresh) string {
+				if !p.Fetch {
+					return ""
+				}
+				return output.FormatSize(p.Size)
+			},
+			Plain: func(p repository.PlannedRefresh) string { return strconv.FormatInt(p.Size, 10) },
+		},
+		{
+			Name:  "Reason",
+			Value: func(p repository.PlannedRefresh) string { return p.Reason },
+		},
+	})
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `repo-contents` lists every file in the given repositories.
+package repocontents
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/output"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "repo-contents",
+		Usage:       "repository...",
+		Description: "List every indexed file in the repositories with matching alias or name.",
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	// No additional flags needed
+	return &command{}
+}
+
+// streamBatch is the number of files laid out together in human-readable
+// output.
+const streamBatch = 1000
+
+type command struct {
+}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// Run the `repo-contents` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: expected at least one repository", cmd.ErrUsage)
+	}
+	for _, pattern := range args {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q: %w", cmd.ErrUsage, pattern, err)
+		}
+	}
+
+	columns, err := cmd.SearchResultColumns(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repositories can hold far too many files to collect them all first, so
+	// they are written as they are read.
+	stream := output.NewStream(cmd.Stdout, cfg.Format, columns, streamBatch)
+	addRepoFile := cmd.RepoFileAdder(repos)
+	found := false
+	err = db.RepositoryContents(ctx, database.RepoFilter{Repos: repos, Patterns: args}, func(result database.SearchResult) error {
+		found = true
+		if cfg.ShowRepoFile {
+			addRepoFile(&result)
+		}
+		return stream.Write(result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, database.ErrNoResults
+	}
+	return nil, stream.Close()
+}
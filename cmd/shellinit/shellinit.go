@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Command `shell-init` prints the shell code to suggest packages for commands
+// that are not found.
+package shellinit
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+func init() {
+	cmd.Register(&cmd.Command{
+		Name:        "shell-init",
+		Usage:       "bash|zsh",
+		Description: "Print the shell code to suggest packages for commands that are not found.",
+		SkipRefresh: true,
+		New:         New,
+	})
+}
+
+func New() cmd.CommandRunner {
+	return &command{}
+}
+
+type command struct{}
+
+func (c *command) AddFlags(flags *flag.FlagSet) {
+}
+
+// handlers are the command-not-found handlers for each shell, with `%[1]s`
+// standing for the program name.  The handler runs in a subshell; it prints
+// whatever the search finds (including the suggested install command), and
+// then the shell's usual message.  Searching for commands skips refreshing
+// repositories, so this does not slow the shell down.
+var handlers = map[string]string{
+	"bash": `command_not_found_handle() {
+	local output
+	if command -v %[1]s >/dev/null 2>&1 &&
+		output=$(%[1]s search -command -- "$1" 2>&1); then
+		printf '%%s\n\n' "$output" >&2
+	fi
+	printf 'bash: %%s: command not found\n' "$1" >&2
+	return 127
+}
+`,
+	"zsh": `command_not_found_handler() {
+	local output
+	if (( $+commands[%[1]s] )) &&
+		output=$(%[1]s search -command -- "$1" 2>&1); then
+		printf '%%s\n\n' "$output" >&2
+	fi
+	printf 'zsh: command not found: %%s\n' "$1" >&2
+	return 127
+}
+`,
+}
+
+// Run the `shell-init` command.
+func (c *command) Run(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository, args []string) ([]database.SearchResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected a shell", cmd.ErrUsage)
+	}
+	handler, ok := handlers[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported shell %q; expected one of %s",
+			cmd.ErrUsage, args[0], strings.Join(slices.Sorted(maps.Keys(handlers)), ", "))
+	}
+	if _, err := fmt.Fprintf(cmd.Stdout, handler, cmd.ProgramName); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
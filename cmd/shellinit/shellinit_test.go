@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package shellinit
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mook-as/zypper-filesearch/cmd"
+	"github.com/mook-as/zypper-filesearch/config"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+// shellInit returns the output of the command for the given arguments.
+func shellInit(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout strings.Builder
+	previous := cmd.Stdout
+	cmd.Stdout = &stdout
+	t.Cleanup(func() { cmd.Stdout = previous })
+	_, err := New().Run(t.Context(), &config.Config{}, nil, nil, args)
+	return stdout.String(), err
+}
+
+func TestShellInit(t *testing.T) {
+	for _, tc := range []struct {
+		shell, function, message string
+	}{
+		{"bash", "command_not_found_handle", "bash: no-such-command: command not found\n"},
+		{"zsh", "command_not_found_handler", "zsh: command not found: no-such-command\n"},
+	} {
+		t.Run(tc.shell, func(t *testing.T) {
+			script, err := shellInit(t, tc.shell)
+			assert.NilError(t, err)
+			assert.Check(t, cmp.Contains(script, tc.function+"() {"))
+			assert.Check(t, cmp.Contains(script, cmd.ProgramName+" search -command -- \"$1\""))
+			assert.Check(t, !strings.Contains(script, "%!"), script)
+
+			path, err := exec.LookPath(tc.shell)
+			if err != nil {
+				t.Skipf("%s is not installed", tc.shell)
+			}
+			// Without the program on $PATH, the handler only prints the
+			// shell's usual message.
+			c := exec.CommandContext(t.Context(), path, "-c", script+"\nno-such-command")
+			c.Env = []string{"PATH=" + t.TempDir()}
+			var stderr strings.Builder
+			c.Stderr = &stderr
+			err = c.Run()
+			var exitErr *exec.ExitError
+			assert.Assert(t, errors.As(err, &exitErr), "%v: %s", err, stderr.String())
+			assert.Check(t, cmp.Equal(exitErr.ExitCode(), 127))
+			assert.Check(t, cmp.Equal(stderr.String(), tc.message))
+		})
+	}
+}
+
+func TestShellInitUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"bash", "zsh"}, {"fish"}} {
+		output, err := shellInit(t, args...)
+		assert.Check(t, cmp.ErrorIs(err, cmd.ErrUsage), "%v", args)
+		assert.Check(t, cmp.Equal(output, ""), "%v", args)
+	}
+	_, err := shellInit(t, "fish")
+	assert.Check(t, cmp.ErrorContains(err, "expected one of bash, zsh"))
+}
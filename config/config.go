@@ -7,8 +7,13 @@ package config
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/adrg/xdg"
 	"gopkg.in/ini.v1"
@@ -16,39 +21,433 @@ import (
 
 type OutputFormat string
 
+// Compression is the algorithm used to compress output.
+type Compression string
+
 const (
 	OutputFormatHuman = OutputFormat("human")
 	OutputFormatJSON  = OutputFormat("json")
 	OutputFormatXML   = OutputFormat("xml")
+	// OutputFormatNEVRA prints just the unique packages, in a form that can be
+	// passed to `zypper install`.
+	OutputFormatNEVRA = OutputFormat("nevra")
+	// OutputFormatZypperXML lists the unique packages using the same XML
+	// structure as `zypper --xmlout search --details`.
+	OutputFormatZypperXML = OutputFormat("zypper-xml")
+	// OutputFormatTerse is the human-readable output without headers, padding,
+	// or decorations, like `zypper --terse`, for use in scripts.
+	OutputFormatTerse = OutputFormat("terse")
+
+	CompressionNone = Compression("")
+	CompressionGzip = Compression("gzip")
+	CompressionZstd = Compression("zstd")
 
 	configPath = "zypper-filesearch.conf"
+
+	// DefaultRefreshInterval is how often repositories are checked for updates
+	// if not configured otherwise.
+	DefaultRefreshInterval = time.Hour
+
+	// DefaultRefreshWait is how long to wait for another process refreshing a
+	// repository if not configured otherwise.
+	DefaultRefreshWait = 10 * time.Second
+
+	// DefaultCompactThreshold is the percentage of a database file that must be
+	// unused before it is compacted, if not configured otherwise.
+	DefaultCompactThreshold = 25
+
+	// DefaultMaxConnsPerHost is the number of concurrent connections to each
+	// server if not configured otherwise; mirrors may throttle clients that
+	// open too many.
+	DefaultMaxConnsPerHost = 4
+
+	// repoSectionPrefix is the prefix for sections with per-repository settings.
+	repoSectionPrefix = "repo:"
+
+	// rootCacheDir is the cache directory used when running as root, so that
+	// it is shared between all administrators.
+	rootCacheDir = "/var/cache/zypper-filesearch"
+)
+
+type RepoLabel string
+
+const (
+	RepoLabelAlias = RepoLabel("alias")
+	RepoLabelName  = RepoLabel("name")
+	RepoLabelURL   = RepoLabel("url")
+)
+
+type LogFormat string
+
+const (
+	LogFormatText = LogFormat("text")
+	LogFormatJSON = LogFormat("json")
+)
+
+// PathDisplay selects how paths are shown in human-readable search results.
+type PathDisplay string
+
+const (
+	PathDisplayFull     = PathDisplay("path")
+	PathDisplayBasename = PathDisplay("basename")
+	PathDisplayDir      = PathDisplay("dir")
+)
+
+// IPFamily selects which IP versions are used to connect to servers.
+type IPFamily string
+
+const (
+	IPFamilyAuto = IPFamily("auto")
+	IPFamily4    = IPFamily("4")
+	IPFamily6    = IPFamily("6")
 )
 
 type Config struct {
-	Verbose    bool
-	ReleaseVer string
-	Format     OutputFormat
-	Enabled    bool
+	Verbose bool
+	// Log every SQL statement executed, with its arguments and duration.
+	VerboseSQL bool
+	// Print the query plans and timings of searches.
+	Explain bool
+	// Values of $releasever to query; if more than one is given, results are
+	// labelled with the release they came from.
+	ReleaseVers []string
+	// Query packages for this architecture instead of the system's.
+	Arch string
+	// Repositories to use in addition to those configured in zypper, as
+	// `[ALIAS=]URL`; see repository.NewAdded.
+	AddRepos []string
+	// If zypper has no repositories configured, use the standard ones of the
+	// distribution instead; see zypper.DefaultRepositories.
+	DefaultRepos bool
+	// Use the repositories configured in the system at this root directory.
+	InstallRoot string
+	Format      OutputFormat
+	// Only use enabled repositories.
+	Enabled bool
+	// Search disabled repositories too, even if Enabled is set; they are still
+	// not refreshed.
+	AllRepos bool
+	// Search only disabled repositories, refreshing them as necessary.
+	DisabledOnly bool
+	LogFormat    LogFormat
+	// Which identifier to use for repositories in results.
+	RepoLabel RepoLabel
+	// Whether to show the file (and service) each repository in the results
+	// is configured in.
+	ShowRepoFile bool
+	// The columns of human-readable and terse search results to show, in
+	// order, by lower-case name (e.g. `package`); if empty, the default ones
+	// are shown.
+	Columns []string
+	// How to show the paths in human-readable and terse search results.
+	ShowPath PathDisplay
+	// The width to fit human-readable output in; zero means the width of the
+	// terminal (if the output is one), and negative means no limit.
+	Width int
+	// The maximum width of each column of human-readable output; zero means
+	// no limit.
+	MaxColumnWidth int
+	// Whether to wrap long paths in human-readable output onto more lines,
+	// instead of cutting them short.
+	Wrap bool
+	// Glob patterns of repository aliases or names to restrict queries to.
+	RepoPatterns []string
+	// Glob patterns of RPM groups to restrict queries to.
+	Groups []string
+	// Glob patterns of repository aliases to ignore.
+	ExcludeRepos []string
+	// How often repositories are checked for updates.
+	RefreshInterval time.Duration
+	// If set, failing to refresh any repository is an error.
+	StrictRefresh bool
+	// How long to wait for another process that is refreshing a repository to
+	// finish, before using the cached data instead; zero means not to wait.
+	RefreshWait time.Duration
+	// Give up if the whole invocation takes longer than this; zero means no
+	// limit.
+	Timeout time.Duration
+	// Give up refreshing a single repository if it takes longer than this;
+	// zero means no limit.
+	RepoTimeout time.Duration
+	// Whether to record queries for later recall.
+	History bool
+	// Whether to suggest a command to install the best matching package.
+	InstallHint bool
+	// Whether to cache downloaded metadata according to HTTP cache headers.
+	HTTPCache bool
+	// Whether to use zsync to download only changed parts of metadata.
+	DeltaSync bool
+	// Repositories with (compressed) file lists larger than this many bytes are
+	// not indexed, unless Force is set; zero means no limit.
+	MaxFileListSize int64
+	// Index repositories even if they exceed MaxFileListSize.
+	Force bool
+	// Write results to this file instead of standard output, replacing it (or
+	// appending to it, if OutputAppend is set) only once complete.
+	OutputFile   string
+	OutputAppend bool
+	// Compress the results, for large exports.
+	Compress Compression
+	// Whether to index package changelogs from other.xml.
+	Changelogs bool
+	// Glob patterns of paths to index; if empty, all paths are indexed.
+	IndexInclude []string
+	// Glob patterns of paths not to index.
+	IndexExclude []string
+	// Client certificate and key files for mutual TLS.
+	ClientCert string
+	ClientKey  string
+	// The Unix domain socket of an HTTP proxy to send all requests through.
+	ProxySocket string
+	// Which IP versions to use to connect to servers.
+	IPFamily IPFamily
+	// The maximum number of concurrent connections to each server; zero means
+	// no limit.
+	MaxConnsPerHost int
+	// The directory for cached data; if empty, a default is chosen depending on
+	// whether we are running as root.  See CacheFile.
+	CacheDir string
+	// Shell commands to run when a repository was updated, and when a watched
+	// pattern has a new match; see the hook package.
+	OnRefreshSuccess string
+	OnNewMatch       string
+	// SQLite tuning; zero values leave the SQLite defaults.  See the
+	// documentation for the pragmas of the same names.
+	CacheSize   int
+	MmapSize    int64
+	TempStore   string
+	Synchronous string
+	// Trade durability for speed while importing, checking the integrity of the
+	// database if the import was cut short; useful for the initial population
+	// of the cache.
+	FastImport bool
+	// Compact database files after they were updated, if at least this
+	// percentage of them is unused; zero means never to compact them
+	// automatically.
+	CompactThreshold int
+	// Options for decompressing zstd metadata, to limit memory use on small
+	// systems: prefer lower memory use over speed, the largest window size
+	// (in bytes) to accept, and the number of goroutines to decode with.  Zero
+	// values leave the decoder defaults.
+	ZstdLowMem      bool
+	ZstdMaxWindow   int64
+	ZstdConcurrency int
+	// Per-repository overrides, keyed by lower-cased alias.
+	Repos map[string]*RepoConfig
+}
+
+// RepoConfig contains the settings that can be overridden for a single
+// repository, in a `[repo:<alias>]` section.
+type RepoConfig struct {
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+	ClientCert      string
+	ClientKey       string
+	// Connect to this Unix domain socket instead of the host in the URL.
+	Socket string
+}
+
+// Repo returns the per-repository overrides for the repository with the given
+// alias; the result is never nil.
+func (c *Config) Repo(alias string) *RepoConfig {
+	if repo, ok := c.Repos[strings.ToLower(alias)]; ok {
+		return repo
+	}
+	return &RepoConfig{}
+}
+
+// ClientCertificateFor returns the client certificate and key files to use for
+// the repository with the given alias; if no certificate is configured, both
+// are empty.  If only a certificate is set, the key is assumed to be in the
+// same file.
+func (c *Config) ClientCertificateFor(alias string) (string, string) {
+	certFile, keyFile := c.ClientCert, c.ClientKey
+	if repo := c.Repo(alias); repo.ClientCert != "" {
+		certFile, keyFile = repo.ClientCert, repo.ClientKey
+	}
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	return certFile, keyFile
+}
+
+// CacheFile returns the path of the file (or directory) with the given name in
+// the cache directory, creating the cache directory if needed.  Unless
+// configured otherwise, root uses a system-wide directory, while other users
+// use their XDG cache directory.
+func (c *Config) CacheFile(name string) (string, error) {
+	dir := c.CacheDir
+	if dir == "" {
+		if os.Geteuid() == 0 {
+			dir = rootCacheDir
+		} else {
+			dir = filepath.Join(xdg.CacheHome, "zypper-filesearch")
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// RefreshIntervalFor returns how often the repository with the given alias
+// should be checked for updates.
+func (c *Config) RefreshIntervalFor(alias string) time.Duration {
+	if interval := c.Repo(alias).RefreshInterval; interval > 0 {
+		return interval
+	}
+	if c.RefreshInterval > 0 {
+		return c.RefreshInterval
+	}
+	return DefaultRefreshInterval
+}
+
+// RepoTimeoutFor returns how long refreshing the repository with the given
+// alias may take; zero means no limit.
+func (c *Config) RepoTimeoutFor(alias string) time.Duration {
+	if timeout := c.Repo(alias).Timeout; timeout > 0 {
+		return timeout
+	}
+	return c.RepoTimeout
 }
 
 var configFromFlags struct {
-	verbose    bool
-	releaseVer string
-	json       bool
-	xml        bool
-	enabled    bool
+	verbose     bool
+	explain     bool
+	verboseSQL  bool
+	releaseVers []string
+	arch        string
+	installRoot string
+	defaultRepo bool
+	addRepos    []string
+	repos       []string
+	groups      []string
+	repoLabel   RepoLabel
+	repoFile    bool
+	columns     string
+	showPath    string
+	width       int
+	maxColWidth int
+	wrap        bool
+	format      OutputFormat
+	json        bool
+	xml         bool
+	terse       bool
+	enabled     bool
+	allRepos    bool
+	disabled    bool
+	logFormat   LogFormat
+	strict      bool
+	timeout     time.Duration
+	repoTimeout time.Duration
+	force       bool
+	fastImport  bool
+	outputFile  string
+	appendOut   bool
+	compress    Compression
+	ipFamily    IPFamily
 }
 
-func AddFlags() {
-	flag.BoolVar(&configFromFlags.verbose, "verbose", false, "Enable debug logging")
-	flag.StringVar(&configFromFlags.releaseVer, "releasever", "", "Set the value of `zypper --releasever`")
-	flag.BoolVar(&configFromFlags.json, "json", false, "Enable JSON output")
-	flag.BoolVar(&configFromFlags.xml, "xml", false, "Enable XML output")
-	flag.BoolVar(&configFromFlags.enabled, "enabled", true, "Use only enabled repositories")
+// AddFlags registers the flags common to all commands.
+func AddFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&configFromFlags.verbose, "verbose", false, "Enable debug logging")
+	flags.BoolVar(&configFromFlags.verboseSQL, "verbose-sql", false, "Log every SQL statement executed, for debugging")
+	flags.BoolVar(&configFromFlags.explain, "explain", false, "Print the query plan and timing of searches, for debugging")
+	flags.Func("releasever", "Set the value of `zypper --releasever`; may be repeated to query several releases", func(value string) error {
+		configFromFlags.releaseVers = append(configFromFlags.releaseVers, value)
+		return nil
+	})
+	flags.StringVar(&configFromFlags.arch, "arch", "", "Search for packages of the given `architecture` instead of the system's")
+	flags.StringVar(&configFromFlags.installRoot, "installroot", "", "Use the repositories configured in the system at the given `root` directory")
+	flags.BoolVar(&configFromFlags.defaultRepo, "default-repos", false, "Use the distribution's standard repositories if none are configured")
+	flags.Func("add-repo", "Also use the repository at `[ALIAS=]URL`, which may be a Debian Contents index; may be repeated", func(value string) error {
+		configFromFlags.addRepos = append(configFromFlags.addRepos, value)
+		return nil
+	})
+	flags.Func("repo", "Only query repositories with alias or name matching the glob `pattern`; may be repeated", func(value string) error {
+		if _, err := path.Match(value, ""); err != nil {
+			return err
+		}
+		configFromFlags.repos = append(configFromFlags.repos, value)
+		return nil
+	})
+	flags.Func("group", "Only query packages in an RPM group matching the glob `pattern`; may be repeated", func(value string) error {
+		if _, err := path.Match(value, ""); err != nil {
+			return err
+		}
+		configFromFlags.groups = append(configFromFlags.groups, value)
+		return nil
+	})
+	flags.Func("repo-label", "Identify repositories in results by `label`; one of alias, name, or url", func(value string) error {
+		switch label := RepoLabel(value); label {
+		case RepoLabelAlias, RepoLabelName, RepoLabelURL:
+			configFromFlags.repoLabel = label
+			return nil
+		}
+		return fmt.Errorf("unknown repository label %q", value)
+	})
+	flags.BoolVar(&configFromFlags.repoFile, "repo-file", false, "Show the file (or service) each repository in the results is configured in")
+	flags.StringVar(&configFromFlags.columns, "columns", "", "Show only the given comma-separated `columns` of search results, in that order")
+	flags.Func("show", "Show the full `path` of files in search results, or only their basename or dir", func(value string) error {
+		switch display := PathDisplay(value); display {
+		case PathDisplayFull, PathDisplayBasename, PathDisplayDir:
+			configFromFlags.showPath = value
+			return nil
+		}
+		return fmt.Errorf("unknown path display %q", value)
+	})
+	flags.IntVar(&configFromFlags.width, "width", 0, "Fit human-readable output in the given number of `columns`; by default, the terminal width")
+	flags.IntVar(&configFromFlags.maxColWidth, "max-column-width", 0, "Cut values in human-readable output short at the given `width`")
+	flags.BoolVar(&configFromFlags.wrap, "wrap", false, "Wrap long paths in human-readable output instead of cutting them short")
+	flags.Func("format", "Set the output `format`; one of human, terse, json, xml, zypper-xml, or nevra", func(value string) error {
+		switch format := OutputFormat(value); format {
+		case OutputFormatHuman, OutputFormatTerse, OutputFormatJSON, OutputFormatXML, OutputFormatZypperXML, OutputFormatNEVRA:
+			configFromFlags.format = format
+			return nil
+		}
+		return fmt.Errorf("unknown format %q", value)
+	})
+	flags.BoolVar(&configFromFlags.json, "json", false, "Enable JSON output")
+	flags.BoolVar(&configFromFlags.xml, "xml", false, "Enable XML output")
+	flags.BoolVar(&configFromFlags.terse, "terse", false, "Enable terse output for scripts: no headers, padding, or decorations")
+	flags.BoolVar(&configFromFlags.enabled, "enabled", true, "Use only enabled repositories")
+	flags.BoolVar(&configFromFlags.allRepos, "all-repos", false, "Also search disabled repositories, without refreshing them")
+	flags.BoolVar(&configFromFlags.disabled, "disabled-only", false, "Search only disabled repositories, refreshing them as needed")
+	flags.Func("log-format", "Set the log `format`; either text or json", func(value string) error {
+		switch format := LogFormat(value); format {
+		case LogFormatText, LogFormatJSON:
+			configFromFlags.logFormat = format
+			return nil
+		}
+		return fmt.Errorf("unknown log format %q", value)
+	})
+	flags.BoolVar(&configFromFlags.strict, "strict-refresh", false, "Fail if any repository could not be refreshed")
+	flags.DurationVar(&configFromFlags.timeout, "timeout", 0, "Give up after the given `duration`, including refreshing repositories")
+	flags.DurationVar(&configFromFlags.repoTimeout, "repo-timeout", 0, "Give up refreshing any one repository after the given `duration`")
+	flags.BoolVar(&configFromFlags.force, "force", false, "Index repositories even if their file lists exceed maxFileListSize")
+	flags.BoolVar(&configFromFlags.fastImport, "fast-import", false, "Import metadata faster by not syncing to disk until the import is complete")
+	flags.StringVar(&configFromFlags.outputFile, "output", "", "Write results to the given `file` instead of standard output")
+	flags.BoolVar(&configFromFlags.appendOut, "append", false, "With -output, add to the file instead of replacing it")
+	flags.Func("compress", "Compress the results with the given `algorithm`; either gzip or zstd", func(value string) error {
+		switch compression := Compression(value); compression {
+		case CompressionNone, CompressionGzip, CompressionZstd:
+			configFromFlags.compress = compression
+			return nil
+		}
+		return fmt.Errorf("unknown compression %q", value)
+	})
+	flags.Func("ip-family", "Connect to servers using the given IP `version`; one of auto, 4, or 6", func(value string) error {
+		switch family := IPFamily(value); family {
+		case IPFamilyAuto, IPFamily4, IPFamily6:
+			configFromFlags.ipFamily = family
+			return nil
+		}
+		return fmt.Errorf("unknown IP family %q", value)
+	})
 }
 
-// Read the configuration from disk
-func Read(ctx context.Context) (*Config, error) {
+// Read the configuration from disk, overriding it with any flags that were set.
+func Read(ctx context.Context, flags *flag.FlagSet) (*Config, error) {
 	var filePaths []any
 
 	// ini.LoadOptions takes the later paths as more important, but the XDG paths
@@ -71,25 +470,194 @@ func Read(ctx context.Context) (*Config, error) {
 
 	section := iniFile.Section("filesearch")
 	result := Config{
-		Verbose:    section.Key("verbose").MustBool(false),
-		ReleaseVer: section.Key("releaseVer").MustString(""),
-		Format:     OutputFormat(section.Key("format").MustString("")),
-		Enabled:    section.Key("enabled").MustBool(true),
+		Verbose:          section.Key("verbose").MustBool(false),
+		ReleaseVers:      section.Key("releaseVer").Strings(","),
+		InstallRoot:      section.Key("installRoot").MustString(""),
+		DefaultRepos:     section.Key("defaultRepos").MustBool(false),
+		Format:           OutputFormat(section.Key("format").MustString("")),
+		Enabled:          section.Key("enabled").MustBool(true),
+		LogFormat:        LogFormat(section.Key("logFormat").MustString("")),
+		RepoLabel:        RepoLabel(section.Key("repoLabel").MustString("")),
+		ShowRepoFile:     section.Key("showRepoFile").MustBool(false),
+		Columns:          section.Key("columns").Strings(","),
+		ShowPath:         PathDisplay(section.Key("show").MustString("")),
+		Width:            section.Key("width").MustInt(0),
+		MaxColumnWidth:   section.Key("maxColumnWidth").MustInt(0),
+		Wrap:             section.Key("wrap").MustBool(false),
+		ExcludeRepos:     section.Key("excludeRepos").Strings(","),
+		StrictRefresh:    section.Key("strictRefresh").MustBool(false),
+		History:          section.Key("history").MustBool(false),
+		InstallHint:      section.Key("installHint").MustBool(true),
+		HTTPCache:        section.Key("httpCache").MustBool(true),
+		DeltaSync:        section.Key("deltaSync").MustBool(true),
+		MaxFileListSize:  section.Key("maxFileListSize").MustInt64(0),
+		Changelogs:       section.Key("changelogs").MustBool(false),
+		IndexInclude:     section.Key("indexInclude").Strings(","),
+		IndexExclude:     section.Key("indexExclude").Strings(","),
+		ClientCert:       section.Key("clientCert").String(),
+		ClientKey:        section.Key("clientKey").String(),
+		ProxySocket:      section.Key("proxySocket").String(),
+		IPFamily:         IPFamily(section.Key("ipFamily").MustString(string(IPFamilyAuto))),
+		MaxConnsPerHost:  section.Key("maxConnsPerHost").MustInt(DefaultMaxConnsPerHost),
+		CacheDir:         section.Key("cacheDir").String(),
+		OnRefreshSuccess: section.Key("onRefreshSuccess").String(),
+		OnNewMatch:       section.Key("onNewMatch").String(),
+		CacheSize:        section.Key("cacheSize").MustInt(0),
+		MmapSize:         section.Key("mmapSize").MustInt64(0),
+		TempStore:        strings.ToLower(section.Key("tempStore").String()),
+		Synchronous:      strings.ToLower(section.Key("synchronous").String()),
+		FastImport:       section.Key("fastImport").MustBool(false),
+		CompactThreshold: section.Key("compactThreshold").MustInt(DefaultCompactThreshold),
+		ZstdLowMem:       section.Key("zstdLowMem").MustBool(false),
+		ZstdMaxWindow:    section.Key("zstdMaxWindow").MustInt64(0),
+		ZstdConcurrency:  section.Key("zstdConcurrency").MustInt(0),
+		Repos:            make(map[string]*RepoConfig),
+	}
+	switch result.TempStore {
+	case "", "default", "file", "memory":
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid tempStore %q", result.TempStore)
+	}
+	switch result.Synchronous {
+	case "", "off", "normal", "full", "extra":
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid synchronous %q", result.Synchronous)
+	}
+	switch result.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid logFormat %q", result.LogFormat)
+	}
+	switch result.RepoLabel {
+	case "", RepoLabelAlias, RepoLabelName, RepoLabelURL:
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid repoLabel %q", result.RepoLabel)
+	}
+	switch result.ShowPath {
+	case "", PathDisplayFull, PathDisplayBasename, PathDisplayDir:
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid show %q", result.ShowPath)
+	}
+	switch result.IPFamily {
+	case IPFamilyAuto, IPFamily4, IPFamily6:
+		// Valid values
+	default:
+		return nil, fmt.Errorf("invalid ipFamily %q", result.IPFamily)
+	}
+	if result.CompactThreshold < 0 || result.CompactThreshold > 100 {
+		return nil, fmt.Errorf("invalid compactThreshold %d: must be a percentage", result.CompactThreshold)
+	}
+	if result.ZstdMaxWindow < 0 {
+		return nil, fmt.Errorf("invalid zstdMaxWindow %d", result.ZstdMaxWindow)
+	}
+	if result.ZstdConcurrency < 0 {
+		return nil, fmt.Errorf("invalid zstdConcurrency %d", result.ZstdConcurrency)
+	}
+	if section.HasKey("refreshInterval") {
+		if result.RefreshInterval, err = section.Key("refreshInterval").Duration(); err != nil {
+			return nil, fmt.Errorf("invalid refreshInterval: %w", err)
+		}
+	}
+	result.RefreshWait = DefaultRefreshWait
+	if section.Key("refreshWait").String() != "" {
+		if result.RefreshWait, err = section.Key("refreshWait").Duration(); err != nil {
+			return nil, fmt.Errorf("invalid refreshWait: %w", err)
+		}
+	}
+	if section.Key("timeout").String() != "" {
+		if result.Timeout, err = section.Key("timeout").Duration(); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if section.Key("repoTimeout").String() != "" {
+		if result.RepoTimeout, err = section.Key("repoTimeout").Duration(); err != nil {
+			return nil, fmt.Errorf("invalid repoTimeout: %w", err)
+		}
+	}
+	for _, repoSection := range iniFile.Sections() {
+		alias, ok := strings.CutPrefix(repoSection.Name(), repoSectionPrefix)
+		if !ok {
+			continue
+		}
+		repo := &RepoConfig{
+			ClientCert: repoSection.Key("clientCert").String(),
+			ClientKey:  repoSection.Key("clientKey").String(),
+			Socket:     repoSection.Key("socket").String(),
+		}
+		if repoSection.HasKey("refreshInterval") {
+			if repo.RefreshInterval, err = repoSection.Key("refreshInterval").Duration(); err != nil {
+				return nil, fmt.Errorf("invalid refreshInterval for repository %s: %w", alias, err)
+			}
+		}
+		if repoSection.Key("timeout").String() != "" {
+			if repo.Timeout, err = repoSection.Key("timeout").Duration(); err != nil {
+				return nil, fmt.Errorf("invalid timeout for repository %s: %w", alias, err)
+			}
+		}
+		result.Repos[strings.ToLower(alias)] = repo
+	}
+	for key, patterns := range map[string][]string{
+		"excludeRepos": result.ExcludeRepos,
+		"indexInclude": result.IndexInclude,
+		"indexExclude": result.IndexExclude,
+	} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", key, pattern, err)
+			}
+		}
 	}
 	switch result.Format {
-	case OutputFormatJSON, OutputFormatXML:
+	case OutputFormatTerse, OutputFormatJSON, OutputFormatXML, OutputFormatZypperXML, OutputFormatNEVRA:
 		// Valid values
 	default:
 		// Invalid value
 		result.Format = OutputFormatHuman
 	}
 
-	flag.Visit(func(f *flag.Flag) {
+	flags.Visit(func(f *flag.Flag) {
 		switch f.Name {
 		case "verbose":
 			result.Verbose = configFromFlags.verbose
+		case "verbose-sql":
+			result.VerboseSQL = configFromFlags.verboseSQL
+		case "explain":
+			result.Explain = configFromFlags.explain
 		case "releasever":
-			result.ReleaseVer = configFromFlags.releaseVer
+			result.ReleaseVers = configFromFlags.releaseVers
+		case "arch":
+			result.Arch = configFromFlags.arch
+		case "installroot":
+			result.InstallRoot = configFromFlags.installRoot
+		case "default-repos":
+			result.DefaultRepos = configFromFlags.defaultRepo
+		case "add-repo":
+			result.AddRepos = configFromFlags.addRepos
+		case "repo":
+			result.RepoPatterns = configFromFlags.repos
+		case "group":
+			result.Groups = configFromFlags.groups
+		case "repo-label":
+			result.RepoLabel = configFromFlags.repoLabel
+		case "repo-file":
+			result.ShowRepoFile = configFromFlags.repoFile
+		case "columns":
+			result.Columns = strings.Split(configFromFlags.columns, ",")
+		case "show":
+			result.ShowPath = PathDisplay(configFromFlags.showPath)
+		case "width":
+			result.Width = configFromFlags.width
+		case "max-column-width":
+			result.MaxColumnWidth = configFromFlags.maxColWidth
+		case "wrap":
+			result.Wrap = configFromFlags.wrap
+		case "format":
+			result.Format = configFromFlags.format
 		case "json":
 			if configFromFlags.json {
 				result.Format = OutputFormatJSON
@@ -102,10 +670,53 @@ func Read(ctx context.Context) (*Config, error) {
 			} else {
 				result.Format = OutputFormatHuman
 			}
+		case "terse":
+			if configFromFlags.terse {
+				result.Format = OutputFormatTerse
+			} else {
+				result.Format = OutputFormatHuman
+			}
 		case "enabled":
 			result.Enabled = configFromFlags.enabled
+		case "all-repos":
+			result.AllRepos = configFromFlags.allRepos
+		case "disabled-only":
+			result.DisabledOnly = configFromFlags.disabled
+		case "log-format":
+			result.LogFormat = configFromFlags.logFormat
+		case "strict-refresh":
+			result.StrictRefresh = configFromFlags.strict
+		case "timeout":
+			result.Timeout = configFromFlags.timeout
+		case "repo-timeout":
+			result.RepoTimeout = configFromFlags.repoTimeout
+		case "force":
+			result.Force = configFromFlags.force
+		case "fast-import":
+			result.FastImport = configFromFlags.fastImport
+		case "output":
+			result.OutputFile = configFromFlags.outputFile
+		case "append":
+			result.OutputAppend = configFromFlags.appendOut
+		case "compress":
+			result.Compress = configFromFlags.compress
+		case "ip-family":
+			result.IPFamily = configFromFlags.ipFamily
 		}
 	})
-
+	if result.RepoLabel == "" {
+		result.RepoLabel = RepoLabelName
+	}
+	// Column names are not case-sensitive.
+	for i, column := range result.Columns {
+		result.Columns[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+	result.Columns = slices.DeleteFunc(result.Columns, func(c string) bool { return c == "" })
+	if result.ShowPath == "" {
+		result.ShowPath = PathDisplayFull
+	}
+	if result.LogFormat == "" {
+		result.LogFormat = LogFormatText
+	}
 	return &result, nil
 }
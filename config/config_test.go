@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrg/xdg"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+// readConfig reads the configuration from a file with the given contents, and
+// the given flags.
+func readConfig(t *testing.T, contents string, args ...string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, configPath), []byte(contents), 0o644))
+	t.Cleanup(xdg.Reload)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("XDG_CONFIG_DIRS", dir)
+	t.Setenv("XDG_DATA_DIRS", dir)
+	xdg.Reload()
+
+	flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	AddFlags(flags)
+	assert.NilError(t, flags.Parse(args))
+	return Read(t.Context(), flags)
+}
+
+func TestCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := readConfig(t, "[filesearch]\ncacheDir = "+dir+"\n")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.CacheDir, dir))
+	file, err := cfg.CacheFile("filesearch.db")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(file, filepath.Join(dir, "filesearch.db")))
+
+	cfg, err = readConfig(t, "[filesearch]\n")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.CacheDir, ""))
+}
+
+func TestLogFormat(t *testing.T) {
+	cfg, err := readConfig(t, "[filesearch]\n")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.LogFormat, LogFormatText))
+
+	cfg, err = readConfig(t, "[filesearch]\nlogFormat = json\n")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.LogFormat, LogFormatJSON))
+
+	// The flag overrides the configuration file.
+	cfg, err = readConfig(t, "[filesearch]\nlogFormat = json\n", "-log-format", "text")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.LogFormat, LogFormatText))
+
+	// Unknown formats are rejected rather than silently replaced.
+	_, err = readConfig(t, "[filesearch]\nlogFormat = yaml\n")
+	assert.Check(t, cmp.ErrorContains(err, `invalid logFormat "yaml"`))
+	flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	AddFlags(flags)
+	assert.Check(t, cmp.ErrorContains(flags.Parse([]string{"-log-format", "yaml"}), `unknown log format "yaml"`))
+}
+
+func TestEnumValues(t *testing.T) {
+	cfg, err := readConfig(t, "[filesearch]\n")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.RepoLabel, RepoLabelName))
+	assert.Check(t, cmp.Equal(cfg.ShowPath, PathDisplayFull))
+	assert.Check(t, cmp.Equal(cfg.IPFamily, IPFamilyAuto))
+
+	cfg, err = readConfig(t, "[filesearch]\nrepoLabel = url\nshow = basename\nipFamily = 6\n", "-repo-label", "alias")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(cfg.RepoLabel, RepoLabelAlias))
+	assert.Check(t, cmp.Equal(cfg.ShowPath, PathDisplayBasename))
+	assert.Check(t, cmp.Equal(cfg.IPFamily, IPFamily6))
+
+	// Mistakes are reported, instead of silently using the default.
+	for key, value := range map[string]string{"repoLabel": "URL", "show": "base", "ipFamily": "5"} {
+		_, err := readConfig(t, "[filesearch]\n"+key+" = "+value+"\n")
+		assert.Check(t, cmp.ErrorContains(err, "invalid "+key+` "`+value+`"`))
+	}
+	for _, args := range [][]string{{"-repo-label", "URL"}, {"-repo-label", "alias,"}, {"-show", "base"}, {"-ip-family", "5"}} {
+		flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		AddFlags(flags)
+		assert.Check(t, cmp.ErrorContains(flags.Parse(args), "unknown"), "%v", args)
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// autoVacuumIncremental is the value of the auto_vacuum pragma for databases
+// where free pages are only reclaimed by `PRAGMA incremental_vacuum`.
+const autoVacuumIncremental = 2
+
+// compact reclaims the unused pages of the database, if they make up at least
+// threshold percent of it; a threshold of zero disables this.  Deleting the
+// old packages when a repository is refreshed leaves many pages unused, so
+// this is done after refreshing.
+func compact(ctx context.Context, db *sql.DB, threshold int) error {
+	if threshold <= 0 {
+		return nil
+	}
+	var pages, free, autoVacuum int
+	for pragma, value := range map[string]*int{
+		"page_count":     &pages,
+		"freelist_count": &free,
+		"auto_vacuum":    &autoVacuum,
+	} {
+		if err := db.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(value); err != nil {
+			return fmt.Errorf("failed to get database %s: %w", pragma, err)
+		}
+	}
+	slog.DebugContext(ctx, "Database free pages", "pages", pages, "free", free)
+	if pages == 0 || free*100 < threshold*pages {
+		return nil
+	}
+	start := time.Now()
+	stmt := "PRAGMA incremental_vacuum"
+	if autoVacuum == autoVacuumIncremental {
+		// Each step of the pragma frees one page, so it must be read to
+		// the end.
+		rows, err := db.QueryContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("failed to compact database: %w", err)
+		}
+		for rows.Next() {
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("failed to compact database: %w", err)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to compact database: %w", err)
+		}
+	} else {
+		// Files without incremental auto-vacuum can only be compacted by
+		// rewriting them, which also enables it.
+		stmt = "VACUUM"
+		if err := vacuum(ctx, db); err != nil {
+			return err
+		}
+	}
+	slog.DebugContext(ctx, "Compacted database", "statement", stmt, "free", free, "duration", time.Since(start))
+	return nil
+}
+
+// vacuum rewrites the database file, switching it to incremental auto-vacuum.
+func vacuum(ctx context.Context, db *sql.DB) error {
+	// The auto_vacuum mode only takes effect if it is set on the connection
+	// doing the vacuum.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	for _, stmt := range []string{"PRAGMA auto_vacuum = INCREMENTAL", "VACUUM"} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to compact database: %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// Compact rewrites every database file, so that they take up as little space
+// as possible and are not fragmented.  Unlike the automatic compaction after
+// refreshing, this is done regardless of how much of the files is unused.
+func (d *Database) Compact(ctx context.Context) error {
+	start := time.Now()
+	repos, err := d.allRepos(ctx)
+	if err != nil {
+		return err
+	}
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		if err := vacuum(ctx, r.db); err != nil {
+			return fmt.Errorf("repository %s: %w", r.name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := vacuum(ctx, d.db); err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Database compacted", "repositories", len(repos), "duration", time.Since(start))
+	return nil
+}
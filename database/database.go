@@ -5,326 +5,1846 @@
 package database
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adrg/xdg"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/fuzzy"
 	"github.com/mook-as/zypper-filesearch/itertools"
 	"github.com/mook-as/zypper-filesearch/zypper"
 )
 
 const (
 	applicationId = int32(0x11668798)
-	userVersion   = int32(3)
+	userVersion   = int32(20)
+	// repoVersion is the version of the tables in the database file of each
+	// repository; see repoSchema.
+	repoVersion = int32(3)
+
+	// fastImportCheckpoint is the number of pages the write-ahead log may grow
+	// to before it is checkpointed, with fast imports; larger than the default,
+	// so that there are fewer (expensive) checkpoints.
+	fastImportCheckpoint = 10000
+
+	// legacyFile is where the database was kept, in the XDG cache directory,
+	// before there was a cache directory of our own.
+	legacyFile = "zypper-filesearch.db"
+
+	// chunkSize is the number of packages written per transaction when updating
+	// a repository, so the write lock is released regularly.
+	chunkSize = 1000
 )
 
-type Database struct {
-	db *sql.DB
+// connector opens SQLite connections with our custom functions registered and
+// the configured tuning applied.
+type connector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+	// Whether to log every statement; see loggingConn.
+	logStatements bool
+}
+
+func newConnector(dsn string, pragmas []string, logStatements bool) *connector {
+	return &connector{
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				if err := conn.RegisterFunc("fuzzy_score", fuzzy.Score, true); err != nil {
+					return err
+				}
+				for _, stmt := range pragmas {
+					if _, err := conn.Exec(stmt, nil); err != nil {
+						return fmt.Errorf("failed to execute pragma %q: %w", stmt, err)
+					}
+				}
+				return nil
+			},
+		},
+		dsn:           dsn,
+		logStatements: logStatements,
+	}
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil || !c.logStatements {
+		return conn, err
+	}
+	return &loggingConn{SQLiteConn: conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// tuningPragmas returns the per-connection pragmas for the performance tuning
+// in the configuration.
+func tuningPragmas(cfg *config.Config) []string {
+	var pragmas []string
+	if cfg.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d", cfg.CacheSize))
+	}
+	if cfg.MmapSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d", cfg.MmapSize))
+	}
+	if cfg.TempStore != "" {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA temp_store = %s", cfg.TempStore))
+	}
+	synchronous := cfg.Synchronous
+	if cfg.FastImport {
+		// Nothing is synced to disk until the database is closed; a database
+		// that was not closed cleanly is checked when it is next opened.
+		synchronous = "off"
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA wal_autocheckpoint = %d", fastImportCheckpoint))
+	}
+	if synchronous != "" {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA synchronous = %s", synchronous))
+	}
+	return pragmas
+}
+
+// filesSchema creates the files table and its indexes.  The table is keyed by
+// package, so that listing the files of a package (and deleting them) is
+// cheap.  The path of each file is split into its directory (with a trailing
+// slash) and base name, so that both can be looked up quickly; the indexes on
+// them include everything searches need, so that they don't have to look up
+// each row in the table.
+var filesSchema = []string{
+	`CREATE TABLE files (` +
+		`pkgid INTEGER REFERENCES packages(id) ON DELETE CASCADE, ` +
+		`dir TEXT, ` +
+		`name TEXT, ` +
+		// The hex digest of the file contents, if known (from filelists-ext).
+		`digest TEXT, ` +
+		// Whether the file is managed by update-alternatives.
+		`alternative BOOLEAN, ` +
+		// The type of the file in the file lists (such as `ghost`), if it is
+		// not a regular file.
+		`type TEXT, ` +
+		`PRIMARY KEY (pkgid, dir, name)) WITHOUT ROWID`,
+	`CREATE INDEX files_name ON files (name, dir, alternative)`,
+	`CREATE INDEX files_dir ON files (dir, name, alternative)`,
+	`CREATE INDEX files_digest ON files (digest) WHERE digest IS NOT NULL`,
+}
+
+// migrations upgrade the main database from the version they are keyed by to
+// the next one, keeping the cached data; see schema.  Until version 19, the
+// repositories were kept in the main database too.
+var migrations = map[int32][]string{
+	// Version 16 made the files table WITHOUT ROWID, with a covering index.
+	15: {
+		`DROP INDEX files_digest`,
+		`ALTER TABLE files RENAME TO files_old`,
+		`CREATE TABLE files (` +
+			`pkgid INTEGER REFERENCES packages(id) ON DELETE CASCADE, ` +
+			`file TEXT, digest TEXT, alternative BOOLEAN, ` +
+			`PRIMARY KEY (pkgid, file)) WITHOUT ROWID`,
+		`CREATE INDEX files_file ON files (file, alternative)`,
+		`CREATE INDEX files_digest ON files (digest) WHERE digest IS NOT NULL`,
+		`INSERT INTO files (pkgid, file, digest, alternative) ` +
+			`SELECT CAST(pkgid AS INTEGER), file, digest, alternative FROM files_old`,
+		`DROP TABLE files_old`,
+	},
+	// Version 17 split the path of files into the directory and base name.
+	16: {
+		`DROP INDEX files_file`,
+		`DROP INDEX files_digest`,
+		`ALTER TABLE files RENAME TO files_old`,
+		`CREATE TABLE files (` +
+			`pkgid INTEGER REFERENCES packages(id) ON DELETE CASCADE, ` +
+			`dir TEXT, name TEXT, digest TEXT, alternative BOOLEAN, ` +
+			`PRIMARY KEY (pkgid, dir, name)) WITHOUT ROWID`,
+		`CREATE INDEX files_name ON files (name, dir, alternative)`,
+		`CREATE INDEX files_dir ON files (dir, name, alternative)`,
+		`CREATE INDEX files_digest ON files (digest) WHERE digest IS NOT NULL`,
+		// Trimming every character other than a slash from the end leaves the
+		// directory.
+		`INSERT INTO files (pkgid, dir, name, digest, alternative) ` +
+			`SELECT pkgid, RTRIM(file, REPLACE(file, '/', '')) AS dir, SUBSTR(file, LENGTH(RTRIM(file, REPLACE(file, '/', ''))) + 1), ` +
+			`digest, alternative FROM files_old`,
+		`DROP TABLE files_old`,
+	},
+	// Version 18 added the refresh locks.
+	17: {
+		refreshLocksSchema,
+	},
+	// Version 19 moved each repository into its own file; this is done by
+	// splitRepositories once the database is open.
+	18: {},
+	// Version 20 added the repositories of services.
+	19: servicesSchema,
+}
+
+// repoMigrations upgrade the database file of each repository; see
+// migrations.
+var repoMigrations = map[int32][]string{
+	// Version 2 added the type of files; those imported before are taken to
+	// be regular files until the repository is refreshed.
+	1: {`ALTER TABLE files ADD COLUMN type TEXT`},
+	// Version 3 added the settings the repository was indexed with; those
+	// indexed before are taken to have used the defaults.
+	2: {`ALTER TABLE repositories ADD COLUMN indexSettings TEXT`},
+}
+
+// refreshLocksSchema creates the table recording which process is refreshing
+// each repository; see RefreshLock.
+const refreshLocksSchema = `CREATE TABLE refreshLocks (` +
+	`url TEXT, ` +
+	`releasever TEXT, ` +
+	`pid INTEGER, ` +
+	`started DATE, ` +
+	`PRIMARY KEY (url, releasever))`
+
+// splitPath splits a file path into its directory, including the trailing
+// slash, and base name, as stored in the files table.
+func splitPath(file string) (string, string) {
+	i := strings.LastIndexByte(file, '/') + 1
+	return file[:i], file[i:]
+}
+
+// fileCondition returns an SQL condition matching files against the glob
+// pattern, along with its arguments.  Where the pattern allows it, the base
+// name or directory are constrained separately so that their indexes can be
+// used.
+func fileCondition(pattern string) (string, []any) {
+	dirPattern, namePattern := splitPath(pattern)
+	if !strings.ContainsAny(namePattern, "*?[") {
+		// The base name is fixed, so the rest must match the directory.
+		return `(files.name == ? AND files.dir GLOB ?)`, []any{namePattern, dirPattern}
+	}
+	condition, args := `(files.dir || files.name) GLOB ?`, []any{pattern}
+	// Any literal leading directories must be a prefix of the directory.
+	literal := pattern
+	if i := strings.IndexAny(pattern, "*?["); i > -1 {
+		literal = pattern[:i]
+	}
+	if prefix, _ := splitPath(literal); prefix != "" {
+		condition = `(files.dir GLOB ? AND ` + condition + `)`
+		args = append([]any{escapeGlob(prefix) + "*"}, args...)
+	}
+	return condition, args
+}
+
+// ErrNoResults is returned when a query did not match anything.
+var ErrNoResults = errors.New("no results found")
+
+type Database struct {
+	// db is used for writing; it only has a single connection.
+	db *sql.DB
+	// reader is a pool of read-only connections, so that queries can run
+	// concurrently with each other and with writes.
+	reader *sql.DB
+	// The main database file and connection settings, for reopening it; the
+	// path is empty for in-memory databases.
+	path          string
+	pragmas       []string
+	logStatements bool
+	// The directory holding the database file of each repository; empty for
+	// in-memory databases, which are named with memoryName instead.
+	repoDir    string
+	memoryName string
+	// The databases of the repositories that have been opened, by key; see
+	// repoDatabase.
+	mu    sync.Mutex
+	repos map[string]*repoDatabase
+	// The column used to populate SearchResult.Repository.
+	repoLabelColumn string
+	// If set, query plans and timings of searches are written here.
+	explain io.Writer
+	// Whether repository databases are updated without syncing to disk, so
+	// they must be marked dirty until they are synced; see markDirty.
+	fastImport bool
+	// The percentage of a database file that must be unused for it to be
+	// compacted after updating it; see compact.
+	compactThreshold int
+}
+
+// New opens the on-disk database, applying any tuning from the configuration.
+func New(ctx context.Context, cfg *config.Config) (*Database, error) {
+	filePath, err := cfg.CacheFile("filesearch.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine database file path: %w", err)
+	}
+	repoDir, err := cfg.CacheFile("repos")
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine repository database directory: %w", err)
+	}
+	if err := os.MkdirAll(repoDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create repository database directory: %w", err)
+	}
+	adoptLegacyFile(ctx, filepath.Join(xdg.CacheHome, legacyFile), filePath)
+
+	d := &Database{
+		path:             filePath,
+		pragmas:          tuningPragmas(cfg),
+		logStatements:    cfg.VerboseSQL,
+		repoDir:          repoDir,
+		repos:            make(map[string]*repoDatabase),
+		repoLabelColumn:  "repositories.name",
+		fastImport:       cfg.FastImport,
+		compactThreshold: cfg.CompactThreshold,
+	}
+	err = d.open(ctx)
+	if IsCorrupt(err) {
+		// This is only a cache, so start again rather than failing forever.
+		slog.WarnContext(ctx, "Database is corrupt, recreating it", "path", filePath, "error", err)
+		if err := moveAside(filePath); err != nil {
+			return nil, err
+		}
+		err = d.open(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// adoptLegacyFile moves the database file from where older versions kept it
+// to the given path, unless there is a database there already, so that the
+// query history is kept; it is then upgraded like any older database.  If it
+// cannot be moved (for example, to another file system), it is deleted
+// instead, as nothing else would clean it up.
+func adoptLegacyFile(ctx context.Context, legacy, filePath string) {
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(legacy, filePath)
+		if err == nil {
+			// The write-ahead log must move along with the database.
+			err = os.Rename(legacy+"-wal", filePath+"-wal")
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		}
+		if err == nil {
+			slog.InfoContext(ctx, "Moved database to the cache directory", "from", legacy, "to", filePath)
+			_ = os.Remove(legacy + "-shm")
+			return
+		}
+		slog.WarnContext(ctx, "Failed to move database to the cache directory; discarding it",
+			"from", legacy, "to", filePath, "error", err)
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(legacy + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "Failed to remove old database", "path", legacy+suffix, "error", err)
+		}
+	}
+}
+
+// open the connections to the main database file, creating it if necessary.
+func (d *Database) open(ctx context.Context) error {
+	var err error
+	if d.db, d.reader, err = d.openFile(ctx, d.path, &mainSchema); err != nil {
+		return err
+	}
+	if err := d.splitRepositories(ctx); err != nil {
+		_ = d.reader.Close()
+		_ = d.db.Close()
+		return err
+	}
+	return nil
+}
+
+// openFile opens the connections to a database file, creating it with the
+// given schema if necessary.  It returns the writer (which only has a single
+// connection) and the pool of readers.
+func (d *Database) openFile(ctx context.Context, path string, s *schema) (*sql.DB, *sql.DB, error) {
+	db := sql.OpenDB(newConnector("file:"+path+"?mode=rwc&cache=shared", d.pragmas, d.logStatements))
+	db.SetMaxOpenConns(1)
+
+	if err := initialize(ctx, db, s); err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	// The file must exist (and be in WAL mode) before it can be opened read-only.
+	reader := sql.OpenDB(newConnector("file:"+path+"?mode=ro", d.pragmas, d.logStatements))
+	reader.SetMaxOpenConns(runtime.NumCPU())
+	return db, reader, nil
+}
+
+// IsCorrupt returns whether the error indicates that the database file is
+// damaged (or not a database at all).
+func IsCorrupt(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB)
+}
+
+// Rebuild replaces the damaged database files with empty ones: if searches
+// found the files of some repositories to be damaged, only those are replaced,
+// and only they must be refreshed again afterwards.  Otherwise, the main
+// database file is replaced.  The damaged files are kept next to the new
+// ones, with a `.corrupt` suffix.
+func (d *Database) Rebuild(ctx context.Context) error {
+	if d.path == "" {
+		return errors.New("cannot rebuild an in-memory database")
+	}
+	d.mu.Lock()
+	var damaged []*repoDatabase
+	for key, r := range d.repos {
+		if r.corrupt.Load() {
+			damaged = append(damaged, r)
+			delete(d.repos, key)
+		}
+	}
+	d.mu.Unlock()
+	if len(damaged) > 0 {
+		for _, r := range damaged {
+			slog.WarnContext(ctx, "Discarding damaged repository database", "repository", r.name, "path", r.path)
+			_ = r.close(ctx)
+			if err := moveAside(r.path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_ = d.reader.Close()
+	_ = d.db.Close()
+	if err := moveAside(d.path); err != nil {
+		return err
+	}
+	return d.open(ctx)
+}
+
+// moveAside renames the damaged database file so that a new one can be
+// created; its write-ahead log is discarded.
+func moveAside(path string) error {
+	if err := os.Rename(path, path+".corrupt"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to move corrupt database aside: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm", dirtySuffix} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove corrupt database journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// testingDatabases counts the in-memory databases, so that each has a unique
+// name.
+var testingDatabases atomic.Int64
+
+// Create an empty in-memory database for testing.
+func NewTesting(ctx context.Context) (*Database, error) {
+	db := sql.OpenDB(newConnector(":memory:", nil, false))
+
+	// Each connection to an in-memory database is separate, so everything must
+	// share one connection.
+	db.SetMaxOpenConns(1)
+
+	d := &Database{
+		db:              db,
+		reader:          db,
+		memoryName:      fmt.Sprintf("filesearch-%d", testingDatabases.Add(1)),
+		repos:           make(map[string]*repoDatabase),
+		repoLabelColumn: "repositories.name",
+	}
+
+	if err := initialize(ctx, db, &mainSchema); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return d, nil
+}
+
+// schema describes the tables of a kind of database file.
+type schema struct {
+	// The version of the tables, stored as the user_version of the file.
+	version int32
+	// Tables with user data are not tied to the version, and are kept when the
+	// cached data is dropped.
+	userTables []string
+	// Statements dropping the tables with cached data, and creating them.
+	drop, create []string
+	// migrations upgrade the tables from the version they are keyed by to the
+	// next one, keeping the cached data; if there is no migration for a
+	// version, the tables are dropped and created again instead.
+	migrations map[int32][]string
+}
+
+// mainSchema is the layout of the main database file, which holds everything
+// that is not specific to a single repository.
+var mainSchema = schema{
+	version: userVersion,
+	userTables: []string{
+		`CREATE TABLE IF NOT EXISTS history (` +
+			`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
+			`command TEXT, ` +
+			`query TEXT, ` +
+			`timestamp DATE)`,
+		refreshesSchema,
+	},
+	drop: slices.Concat(
+		itertools.Map(legacyTables, func(table string) string { return `DROP TABLE IF EXISTS ` + table }),
+		[]string{
+			`DROP TABLE IF EXISTS mirrors`,
+			`DROP TABLE IF EXISTS refreshLocks`,
+			`DROP TABLE IF EXISTS serviceRepositories`,
+			`DROP TABLE IF EXISTS services`,
+		}),
+	create: slices.Concat(
+		[]string{
+			// The measured latency of each mirror host; zero if it was
+			// unreachable.
+			`CREATE TABLE mirrors (` +
+				`host TEXT PRIMARY KEY, ` +
+				`latency INTEGER, ` +
+				`checked DATE)`,
+			refreshLocksSchema,
+		},
+		servicesSchema),
+	migrations: migrations,
+}
+
+// repoSchema is the layout of the database file of each repository.  It has a
+// single row in the repositories table, so that it describes itself.
+var repoSchema = schema{
+	version: repoVersion,
+	drop: []string{
+		// Drop the child tables first, so that we don't have to delete rows
+		// with foreign keys one by one.
+		`DROP TABLE IF EXISTS changelogs`,
+		`DROP TABLE IF EXISTS provides`,
+		`DROP TABLE IF EXISTS files`,
+		`DROP TABLE IF EXISTS packages`,
+		`DROP TABLE IF EXISTS repositories`,
+	},
+	create: slices.Concat([]string{
+		`CREATE TABLE repositories (` +
+			`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
+			`alias TEXT, ` +
+			`name TEXT, ` +
+			`url TEXT, ` +
+			// The explicitly requested $releasever, or empty for the default.
+			`releasever TEXT DEFAULT '', ` +
+			`type TEXT, ` +
+			`enabled BOOLEAN, ` +
+			`priority INTEGER, ` +
+			`gpgcheck BOOLEAN, ` +
+			`keeppackages BOOLEAN, ` +
+			`lastChecked DATE, ` +
+			`lastModified DATE, ` +
+			// Identify the snapshot of the metadata that was imported.
+			`revision TEXT, ` +
+			`checksum TEXT, ` +
+			// The generation of packages that is complete and should be used.
+			`generation INTEGER DEFAULT 0, ` +
+			// The settings that decided what was imported; see Provenance.
+			`indexSettings TEXT, ` +
+			`UNIQUE (url, releasever) ON CONFLICT ABORT` +
+			`)`,
+		`CREATE TABLE packages (` +
+			`repository INTEGER REFERENCES repositories(id) ON DELETE CASCADE, ` +
+			`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
+			`generation INTEGER, ` +
+			`pkgid TEXT, ` +
+			`name TEXT, ` +
+			`arch TEXT, ` +
+			`epoch TEXT, ` +
+			`version TEXT, ` +
+			`release TEXT, ` +
+			`size INTEGER, ` +
+			`installedSize INTEGER, ` +
+			`location TEXT, ` +
+			// The RPM group (category), e.g. `Development/Libraries/C and C++`.
+			`rpmGroup TEXT, ` +
+			`UNIQUE (repository, generation, name, arch, epoch, version, release))`,
+	}, filesSchema, []string{
+		// Changelog entries from other.xml, only if enabled.
+		`CREATE TABLE changelogs (` +
+			`pkgid INTEGER REFERENCES packages(id) ON DELETE CASCADE, ` +
+			`author TEXT, ` +
+			`date DATE, ` +
+			`text TEXT)`,
+		`CREATE INDEX changelogs_pkgid ON changelogs (pkgid)`,
+		// Shared libraries and pkg-config modules provided by packages, from
+		// primary.xml.
+		`CREATE TABLE provides (` +
+			`pkgid INTEGER REFERENCES packages(id) ON DELETE CASCADE, ` +
+			`name TEXT)`,
+		`CREATE INDEX provides_name ON provides (name)`,
+		`CREATE INDEX provides_pkgid ON provides (pkgid)`,
+	}),
+	migrations: repoMigrations,
+}
+
+// initialize the database with the given schema, performing migrations as
+// necessary.
+func initialize(ctx context.Context, db *sql.DB, s *schema) error {
+	var version int32
+	// These must come before anything is written to a new file, or the
+	// auto_vacuum mode can no longer be changed.
+	for _, stmt := range []string{
+		// Free pages are reclaimed after refreshing instead; see compact.
+		"PRAGMA auto_vacuum = INCREMENTAL",
+		"PRAGMA encoding = 'UTF-8'",
+		"PRAGMA foreign_keys = 1",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA recursive_triggers = 1",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute pragma %q: %w", stmt, err)
+		}
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA application_id = %d", applicationId))
+	if err != nil {
+		return fmt.Errorf("failed to set database application id: %w", err)
+	}
+
+	for _, stmt := range s.userTables {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to initialize database: %q: %w", stmt, err)
+		}
+	}
+
+	err = db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to get database version: %w", err)
+	}
+	if version == s.version {
+		// This is a valid database
+		return nil
+	}
+	if err := migrate(ctx, db, s, version); err == nil {
+		return nil
+	} else if !errors.Is(err, errNoMigration) {
+		slog.WarnContext(ctx, "Failed to migrate database, re-initializing it", "version", version, "error", err)
+	}
+	slog.DebugContext(ctx, "Re-initializing database", "stored version", version, "required version", s.version)
+
+	// The database may have incompatible data; because this is only used for
+	// a cache, we can just drop everything.
+	for _, stmt := range slices.Concat(s.drop, s.create) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to initialize database: %q: %w", stmt, err)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", s.version))
+	if err != nil {
+		return fmt.Errorf("failed to set database version: %w", err)
+	}
+	return nil
+}
+
+// errNoMigration is returned by migrate if the database cannot be upgraded in
+// place.
+var errNoMigration = errors.New("no migration available")
+
+// migrate upgrades the database from the given version to the current one of
+// the schema, keeping the cached data; this is done in a single transaction,
+// so that a failure leaves the database unchanged.
+func migrate(ctx context.Context, db *sql.DB, s *schema, version int32) error {
+	if version > s.version {
+		return errNoMigration
+	}
+	for v := version; v < s.version; v++ {
+		if _, ok := s.migrations[v]; !ok {
+			return errNoMigration
+		}
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for ; version < s.version; version++ {
+		slog.DebugContext(ctx, "Migrating database", "from", version, "to", version+1)
+		for _, stmt := range s.migrations[version] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to migrate database from version %d: %q: %w", version, stmt, err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", s.version)); err != nil {
+		return fmt.Errorf("failed to set database version: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetRepoLabel selects which identifier of a repository is reported in search
+// results.
+func (d *Database) SetRepoLabel(label config.RepoLabel) {
+	switch label {
+	case config.RepoLabelAlias:
+		d.repoLabelColumn = "repositories.alias"
+	case config.RepoLabelURL:
+		d.repoLabelColumn = "repositories.url"
+	default:
+		d.repoLabelColumn = "repositories.name"
+	}
+}
+
+// SetExplain causes the query plan and timing of each search to be written to
+// w, for debugging slow queries; nil disables this.
+func (d *Database) SetExplain(w io.Writer) {
+	d.explain = w
+}
+
+// explainQuery writes the query plan for the query.
+func (d *Database) explainQuery(ctx context.Context, conn *sql.Conn, query string, args ...any) error {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to explain query: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	_, _ = fmt.Fprintf(d.explain, "Query: %s\nPlan:\n", query)
+	// Each step is nested under its parent; the top level has parent 0.
+	depths := map[int]int{0: 0}
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return fmt.Errorf("failed to read query plan: %w", err)
+		}
+		depths[id] = depths[parent] + 1
+		_, _ = fmt.Fprintf(d.explain, "%s%s\n", strings.Repeat("  ", depths[id]), detail)
+	}
+	return rows.Err()
+}
+
+// Close the database.  The files of any repositories that were updated are
+// tidied first; see repoDatabase.close.
+func (d *Database) Close() error {
+	var errs []error
+	ctx := context.Background()
+	d.mu.Lock()
+	for _, r := range d.repos {
+		errs = append(errs, r.close(ctx))
+	}
+	d.repos = make(map[string]*repoDatabase)
+	d.mu.Unlock()
+	if d.reader != d.db {
+		errs = append(errs, d.reader.Close())
+	}
+	errs = append(errs, d.db.Close())
+	return errors.Join(errs...)
+}
+
+// Look up when the given repository was last checked, and last modified.
+func (d *Database) GetTimestamps(ctx context.Context, repo *zypper.Repository) (time.Time, time.Time, error) {
+	r, err := d.openRepo(ctx, repoKey(repo.URL, repo.ReleaseVer), false)
+	if err != nil || r == nil {
+		return time.Time{}, time.Time{}, err
+	}
+	// The timestamps are NULL if the first update of the repository was
+	// interrupted; treat that as never having been checked.
+	var lastChecked, lastModified sql.NullTime
+	err = r.reader.QueryRowContext(ctx, "SELECT lastChecked, lastModified FROM repositories WHERE url = ? AND releasever = ?", repo.URL, repo.ReleaseVer).Scan(&lastChecked, &lastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return nullTimeUTC(lastChecked), nullTimeUTC(lastModified), nil
+}
+
+// nullTimeUTC returns the time in UTC, or the zero time if it is NULL.
+func nullTimeUTC(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time.UTC()
+}
+
+// GetProvenance returns the snapshot of the metadata the given repository was
+// last imported from; it is empty if the repository was never imported.
+func (d *Database) GetProvenance(ctx context.Context, repo *zypper.Repository) (Provenance, error) {
+	r, err := d.openRepo(ctx, repoKey(repo.URL, repo.ReleaseVer), false)
+	if err != nil || r == nil {
+		return Provenance{}, err
+	}
+	var provenance Provenance
+	err = r.reader.QueryRowContext(ctx,
+		"SELECT IFNULL(revision, ''), IFNULL(checksum, ''), IFNULL(indexSettings, '') FROM repositories WHERE url = ? AND releasever = ?",
+		repo.URL, repo.ReleaseVer).Scan(&provenance.Revision, &provenance.Checksum, &provenance.Settings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Provenance{}, nil
+	}
+	if err != nil {
+		return Provenance{}, err
+	}
+	return provenance, nil
+}
+
+// Package describes a package being added to the database.
+type Package struct {
+	PkgId   string
+	Name    string
+	Arch    string
+	Epoch   string
+	Version string
+	Release string
+	// The size of the package file, and of its installed contents, in bytes;
+	// zero if unknown.
+	Size          int64
+	InstalledSize int64
+	// The location of the package file, relative to the repository.
+	Location string
+	// The RPM group of the package, if known.
+	Group string
+	// Files that are managed by update-alternatives, rather than being owned
+	// by this package alone.
+	Alternatives []string
+	// The types of the files that are not regular files (such as `ghost`),
+	// by path.
+	FileTypes map[string]string
+	// Changelog entries, if they are being indexed.
+	Changelogs []Changelog
+	// The shared libraries and pkg-config modules the package provides, e.g.
+	// `libz.so.1()(64bit)` or `pkgconfig(zlib)`.
+	Provides []string
+}
+
+// Changelog is a single entry in the changelog of a package.
+type Changelog struct {
+	Author string
+	Date   time.Time
+	Text   string
+}
+
+// Provenance identifies the snapshot of the repository metadata that was
+// imported.
+type Provenance struct {
+	// The revision from repomd.xml.
+	Revision string
+	// The checksum of the file list (or what the files were read from), as
+	// `type:value`.
+	Checksum string
+	// The settings that decided what was imported (such as which paths were
+	// indexed), so that the data can be replaced when they change; empty for
+	// the defaults.
+	Settings string
+}
+
+// Update a given repository; all updates should be done within the passed-in
+// function.  The function gets a callback which can be used to update a
+// package, which in turn returns a function that can add files (with their
+// digests, if known) to the package.
+//
+// The packages are written as a new generation, in a series of transactions
+// of chunkSize packages each; the new generation replaces the old one only
+// once everything has been written.  Until then, queries continue to see the
+// old data; if the update is interrupted, the partial generation is discarded
+// on the next update.
+func (d *Database) UpdateRepository(
+	ctx context.Context,
+	repo *zypper.Repository,
+	lastChecked, lastModified time.Time,
+	provenance Provenance,
+	cb func(pkg func(*Package) (func(file, digest string) error, error)) error,
+) error {
+	r, err := d.openRepo(ctx, repoKey(repo.URL, repo.ReleaseVer), true)
+	if err != nil {
+		return err
+	}
+	if d.fastImport {
+		if err := r.markDirty(); err != nil {
+			return err
+		}
+	}
+	repositoryId, generation, err := r.beginGeneration(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	pkgStmt, err := r.db.PrepareContext(ctx,
+		`INSERT OR REPLACE INTO packages `+
+			`(repository, generation, pkgid, name, arch, epoch, version, release, size, installedSize, location, rpmGroup) `+
+			`VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = pkgStmt.Close()
+	}()
+	fileStmt, err := r.db.PrepareContext(ctx,
+		`INSERT OR REPLACE INTO files (pkgid, dir, name, digest, alternative, type) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fileStmt.Close()
+	}()
+	changelogStmt, err := r.db.PrepareContext(ctx,
+		`INSERT INTO changelogs (pkgid, author, date, text) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = changelogStmt.Close()
+	}()
+	provideStmt, err := r.db.PrepareContext(ctx,
+		`INSERT INTO provides (pkgid, name) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = provideStmt.Close()
+	}()
+
+	var tx *sql.Tx
+	// If we return before the commit, do a rollback.  This is a no-op if we have
+	// already committed.
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	count := 0
+
+	err = cb(func(pkg *Package) (func(string, string) error, error) {
+		if tx != nil && count%chunkSize == 0 {
+			if err := tx.Commit(); err != nil {
+				return nil, fmt.Errorf("failed to commit packages: %w", err)
+			}
+			tx = nil
+		}
+		if tx == nil {
+			if tx, err = r.db.BeginTx(ctx, nil); err != nil {
+				return nil, err
+			}
+		}
+		count++
+		result, err := tx.StmtContext(ctx, pkgStmt).ExecContext(ctx, repositoryId, generation,
+			pkg.PkgId, pkg.Name, pkg.Arch, pkg.Epoch, pkg.Version, pkg.Release, pkg.Size, pkg.InstalledSize, pkg.Location, pkg.Group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update package: %w", err)
+		}
+		pkgId, err := result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get last inserted row: %w", err)
+		}
+		for _, entry := range pkg.Changelogs {
+			_, err := tx.StmtContext(ctx, changelogStmt).ExecContext(ctx, pkgId, entry.Author, entry.Date, entry.Text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add changelog: %w", err)
+			}
+		}
+		for _, name := range pkg.Provides {
+			_, err := tx.StmtContext(ctx, provideStmt).ExecContext(ctx, pkgId, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add provides: %w", err)
+			}
+		}
+		stmt := tx.StmtContext(ctx, fileStmt)
+		return func(file, digest string) error {
+			var digestValue, alternativeValue, typeValue any
+			if digest != "" {
+				digestValue = strings.ToLower(digest)
+			}
+			if slices.Contains(pkg.Alternatives, file) {
+				alternativeValue = true
+			}
+			if fileType, ok := pkg.FileTypes[file]; ok {
+				typeValue = fileType
+			}
+			dir, name := splitPath(file)
+			_, err := stmt.ExecContext(ctx, pkgId, dir, name, digestValue, alternativeValue, typeValue)
+			if err != nil {
+				return fmt.Errorf("failed to update file: %w", err)
+			}
+			return nil
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if tx == nil {
+		if tx, err = r.db.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+	}
+	// Switch over to the new generation, dropping the old one.
+	_, err = tx.ExecContext(ctx,
+		`UPDATE repositories SET generation = ?, lastChecked = ?, lastModified = ?, revision = ?, checksum = ?, indexSettings = ? WHERE id = ?`,
+		generation, lastChecked, lastModified, provenance.Revision, provenance.Checksum, provenance.Settings, repositoryId)
+	if err != nil {
+		return fmt.Errorf("failed to update repository %s: %w", repo.Name, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM packages WHERE repository = ? AND generation != ?`, repositoryId, generation)
+	if err != nil {
+		return fmt.Errorf("failed to remove old packages from repository %s: %w", repo.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error commiting update of repository %s: %w", repo.Name, err)
+	}
+	r.updated.Store(true)
+	d.mu.Lock()
+	r.name, r.releasever = repo.Name, repo.ReleaseVer
+	d.mu.Unlock()
+	return nil
+}
+
+// beginGeneration records the repository (if it is not already known), and
+// discards any packages left over from an interrupted update.  It returns the
+// id of the repository, and the generation to use for the new packages.
+func (r *repoDatabase) beginGeneration(ctx context.Context, repo *zypper.Repository) (int64, int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO repositories (alias, name, url, releasever, type, enabled, priority, gpgcheck, keeppackages) `+
+			`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) `+
+			`ON CONFLICT (url, releasever) DO UPDATE SET `+
+			`alias = excluded.alias, name = excluded.name, type = excluded.type, enabled = excluded.enabled, `+
+			`priority = excluded.priority, gpgcheck = excluded.gpgcheck, keeppackages = excluded.keeppackages`,
+		repo.Alias, repo.Name, repo.URL, repo.ReleaseVer, repo.Type, repo.Enabled, repo.Priority, repo.GPGCheck, repo.KeepPackages)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update repository %s: %w", repo.Name, err)
+	}
+	var repositoryId, generation int64
+	err = tx.QueryRowContext(ctx, `SELECT id, generation FROM repositories WHERE url = ? AND releasever = ?`, repo.URL, repo.ReleaseVer).
+		Scan(&repositoryId, &generation)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get id of repository %s: %w", repo.Name, err)
+	}
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM packages WHERE repository = ? AND generation > ?`, repositoryId, generation)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to remove incomplete update of repository %s: %w", repo.Name, err)
+	}
+	if removed, err := result.RowsAffected(); err == nil && removed > 0 {
+		slog.DebugContext(ctx, "Discarded packages from interrupted update",
+			"repository", repo.Name, "packages", removed)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error commiting update of repository %s: %w", repo.Name, err)
+	}
+	return repositoryId, generation + 1, nil
+}
+
+// RepositoryStats describes the cached data for a single repository.
+type RepositoryStats struct {
+	XMLName      xml.Name  `json:"-" xml:"repository"`
+	Alias        string    `json:"alias" xml:"alias,attr"`
+	Name         string    `json:"name" xml:"name,attr"`
+	URL          string    `json:"url" xml:"url,attr"`
+	ReleaseVer   string    `json:"releasever,omitempty" xml:"releasever,attr,omitempty"`
+	Priority     int       `json:"priority" xml:"priority,attr"`
+	GPGCheck     bool      `json:"gpgcheck" xml:"gpgcheck,attr"`
+	KeepPackages bool      `json:"keeppackages" xml:"keeppackages,attr"`
+	Packages     int       `json:"packages" xml:"packages,attr"`
+	Files        int       `json:"files" xml:"files,attr"`
+	Changelogs   int       `json:"changelogs" xml:"changelogs,attr"`
+	LastChecked  time.Time `json:"lastChecked" xml:"lastChecked,attr"`
+	LastModified time.Time `json:"lastModified" xml:"lastModified,attr"`
+	Revision     string    `json:"revision,omitempty" xml:"revision,attr,omitempty"`
+	Checksum     string    `json:"checksum,omitempty" xml:"checksum,attr,omitempty"`
+	// The most recent refreshes, newest first.
+	Refreshes []RefreshRecord `json:"refreshes,omitempty" xml:"refresh,omitempty"`
+}
+
+// Stats returns information about every repository in the database.
+func (d *Database) Stats(ctx context.Context) ([]RepositoryStats, error) {
+	repos, err := d.allRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []RepositoryStats
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		repoResults, err := r.stats(ctx)
+		results = append(results, repoResults...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		results[i].Refreshes, err = d.queryRefreshRecords(ctx, `url == ? AND releasever == ?`,
+			[]any{result.URL, result.ReleaseVer}, statsRefreshRecords)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// stats returns information about the repository in the database.
+func (r *repoDatabase) stats(ctx context.Context) ([]RepositoryStats, error) {
+	rows, err := r.reader.QueryContext(ctx,
+		`SELECT alias, name, url, releasever, IFNULL(priority, 0), IFNULL(gpgcheck, FALSE), IFNULL(keeppackages, FALSE), `+
+			`lastChecked, lastModified, IFNULL(revision, ''), IFNULL(checksum, ''), `+
+			`(SELECT COUNT(*) FROM packages WHERE packages.repository == repositories.id `+
+			`AND packages.generation == repositories.generation), `+
+			`(SELECT COUNT(*) FROM files INNER JOIN packages ON files.pkgid == packages.id `+
+			`WHERE packages.repository == repositories.id `+
+			`AND packages.generation == repositories.generation), `+
+			`(SELECT COUNT(*) FROM changelogs INNER JOIN packages ON changelogs.pkgid == packages.id `+
+			`WHERE packages.repository == repositories.id `+
+			`AND packages.generation == repositories.generation) `+
+			`FROM repositories ORDER BY name, releasever`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repository statistics: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var results []RepositoryStats
+	for rows.Next() {
+		var result RepositoryStats
+		var lastChecked, lastModified sql.NullTime
+		if err := rows.Scan(&result.Alias, &result.Name, &result.URL, &result.ReleaseVer,
+			&result.Priority, &result.GPGCheck, &result.KeepPackages, &lastChecked, &lastModified, &result.Revision, &result.Checksum, &result.Packages, &result.Files, &result.Changelogs); err != nil {
+			return nil, fmt.Errorf("failed to read repository statistics: %w", err)
+		}
+		result.LastChecked = nullTimeUTC(lastChecked)
+		result.LastModified = nullTimeUTC(lastModified)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	return results, nil
+}
+
+// PackageStats is the number of files indexed for a package.
+type PackageStats struct {
+	XMLName    xml.Name `json:"-" xml:"package"`
+	Repository string   `json:"repository" xml:"repository,attr"`
+	ReleaseVer string   `json:"releasever,omitempty" xml:"releasever,attr,omitempty"`
+	Package    string   `json:"package" xml:"package,attr"`
+	Arch       string   `json:"arch" xml:"arch,attr"`
+	Epoch      string   `json:"epoch" xml:"epoch,attr"`
+	Version    string   `json:"version" xml:"version,attr"`
+	Release    string   `json:"release" xml:"release,attr"`
+	Files      int      `json:"files" xml:"files,attr"`
+}
+
+// EVR returns the version of the package as `epoch:version-release`, omitting
+// the epoch if it is zero.
+func (s PackageStats) EVR() string {
+	return formatEVR(s.Epoch, s.Version, s.Release)
+}
+
+// PackageStats returns the packages with the most indexed files, across all
+// repositories; at most limit packages are returned.
+func (d *Database) PackageStats(ctx context.Context, limit int) ([]PackageStats, error) {
+	repos, err := d.allRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []PackageStats
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		repoResults, err := d.packageStats(ctx, r, limit)
+		results = append(results, repoResults...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The repositories are already in order, so a stable sort keeps packages
+	// with the same name sorted by repository.
+	slices.SortStableFunc(results, func(a, b PackageStats) int {
+		return cmp.Or(cmp.Compare(b.Files, a.Files), cmp.Compare(a.Package, b.Package))
+	})
+	return results[:min(limit, len(results))], nil
+}
+
+// packageStats returns the packages with the most indexed files in the
+// repository.
+func (d *Database) packageStats(ctx context.Context, r *repoDatabase, limit int) ([]PackageStats, error) {
+	rows, err := r.reader.QueryContext(ctx,
+		`SELECT `+d.repoLabelColumn+`, repositories.releasever, packages.name, packages.arch, `+
+			`packages.epoch, packages.version, packages.release, COUNT(files.name) AS count `+
+			`FROM packages `+
+			`INNER JOIN repositories ON packages.repository == repositories.id `+
+			`LEFT JOIN files ON files.pkgid == packages.id `+
+			`WHERE packages.generation == repositories.generation `+
+			`GROUP BY packages.id `+
+			`ORDER BY count DESC, packages.name, repositories.name `+
+			`LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query package statistics: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var results []PackageStats
+	for rows.Next() {
+		var result PackageStats
+		if err := rows.Scan(&result.Repository, &result.ReleaseVer, &result.Package, &result.Arch,
+			&result.Epoch, &result.Version, &result.Release, &result.Files); err != nil {
+			return nil, fmt.Errorf("failed to read package statistics: %w", err)
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	return results, nil
+}
+
+// HistogramBucket counts the packages in a repository that have between
+// MinFiles and MaxFiles (inclusive) indexed files.
+type HistogramBucket struct {
+	XMLName    xml.Name `json:"-" xml:"bucket"`
+	Repository string   `json:"repository" xml:"repository,attr"`
+	ReleaseVer string   `json:"releasever,omitempty" xml:"releasever,attr,omitempty"`
+	MinFiles   int      `json:"minFiles" xml:"minFiles,attr"`
+	MaxFiles   int      `json:"maxFiles" xml:"maxFiles,attr"`
+	Packages   int      `json:"packages" xml:"packages,attr"`
+	Files      int      `json:"files" xml:"files,attr"`
+}
+
+// FileHistogram returns, for each repository, how many packages have a given
+// number of files.  The buckets are powers of ten (0, 1-9, 10-99, and so on);
+// empty buckets are omitted.
+func (d *Database) FileHistogram(ctx context.Context) ([]HistogramBucket, error) {
+	repos, err := d.allRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []HistogramBucket
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		repoResults, err := d.fileHistogram(ctx, r)
+		results = append(results, repoResults...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// fileHistogram returns the histogram of the number of files per package in
+// the repository.
+func (d *Database) fileHistogram(ctx context.Context, r *repoDatabase) ([]HistogramBucket, error) {
+	rows, err := r.reader.QueryContext(ctx,
+		`WITH counts AS (`+
+			`SELECT packages.repository AS repository, COUNT(files.name) AS count `+
+			`FROM packages `+
+			`INNER JOIN repositories ON packages.repository == repositories.id `+
+			`LEFT JOIN files ON files.pkgid == packages.id `+
+			`WHERE packages.generation == repositories.generation `+
+			`GROUP BY packages.id) `+
+			`SELECT `+d.repoLabelColumn+`, repositories.releasever, `+
+			// The number of digits in the count, i.e. the power of ten.
+			`CASE WHEN count == 0 THEN 0 ELSE LENGTH(count) END AS bucket, `+
+			`COUNT(*), SUM(count) `+
+			`FROM counts INNER JOIN repositories ON counts.repository == repositories.id `+
+			`GROUP BY repositories.id, bucket `+
+			`ORDER BY repositories.name, repositories.releasever, bucket`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file histogram: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var results []HistogramBucket
+	for rows.Next() {
+		var result HistogramBucket
+		var digits int
+		if err := rows.Scan(&result.Repository, &result.ReleaseVer, &digits, &result.Packages, &result.Files); err != nil {
+			return nil, fmt.Errorf("failed to read file histogram: %w", err)
+		}
+		if digits > 0 {
+			result.MinFiles = 1
+			for range digits - 1 {
+				result.MinFiles *= 10
+			}
+			result.MaxFiles = result.MinFiles*10 - 1
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	return results, nil
+}
+
+// SearchResult is a file in a package.
+type SearchResult struct {
+	XMLName    xml.Name `json:"-" xml:"result"`
+	Repository string   `json:"repository" xml:"repository,attr"`
+	Package    string   `json:"package" xml:"package,attr"`
+	Arch       string   `json:"arch" xml:"arch,attr"`
+	Epoch      string   `json:"epoch" xml:"epoch,attr"`
+	Version    string   `json:"version" xml:"version,attr"`
+	Release    string   `json:"release" xml:"release,attr"`
+	// The $releasever of the repository, if one was requested explicitly.
+	ReleaseVer string `json:"releasever,omitempty" xml:"releasever,attr,omitempty"`
+	// The alias and URL of the repository, regardless of how it is labelled.
+	Alias string `json:"alias" xml:"alias,attr"`
+	URL   string `json:"url" xml:"url,attr"`
+	// The identifier of the package in the repository metadata: for rpm-md
+	// repositories, the checksum of the package file.
+	PkgId string `json:"pkgid" xml:"pkgid,attr"`
+	// The file the repository is configured in, and the service that added
+	// it; these are only set if requested, as they are not in the index.
+	RepoFile string `json:"repoFile,omitempty" xml:"repoFile,attr,omitempty"`
+	Service  string `json:"service,omitempty" xml:"service,attr,omitempty"`
+	// Sizes of the package file and its installed contents, if known.
+	Size          int64  `json:"size,omitempty" xml:"size,attr,omitempty"`
+	InstalledSize int64  `json:"installedSize,omitempty" xml:"installedSize,attr,omitempty"`
+	Location      string `json:"location,omitempty" xml:"location,attr,omitempty"`
+	// The revision of the repository metadata the result came from.
+	Revision string `json:"revision,omitempty" xml:"revision,attr,omitempty"`
+	Path     string `json:"path" xml:"path,attr"`
+	// The type of the file: `file` for regular files, `ghost` for files
+	// created by the package when installed, or `provides` if the path is the
+	// name of an RPM provides instead.
+	FileType string `json:"fileType" xml:"fileType,attr"`
+	// Whether the file is managed by update-alternatives; several packages
+	// may then provide the same path without conflicting.
+	Alternative bool `json:"alternative,omitempty" xml:"alternative,attr,omitempty"`
+}
+
+// EVR returns the version of the package as `epoch:version-release`, omitting
+// the epoch if it is zero.
+func (r SearchResult) EVR() string {
+	return formatEVR(r.Epoch, r.Version, r.Release)
 }
 
-func New(ctx context.Context) (*Database, error) {
-	filePath, err := xdg.CacheFile("zypper-filesearch.db")
-	if err != nil {
-		return nil, fmt.Errorf("failed to determine database file path: %w", err)
+// formatEVR returns the version as `epoch:version-release`, omitting the epoch
+// if it is zero.
+func formatEVR(epoch, version, release string) string {
+	if epoch != "" && epoch != "0" {
+		version = epoch + ":" + version
 	}
-
-	db, err := sql.Open("sqlite3", "file:"+filePath+"?mode=rwc&cache=shared")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+	if release != "" {
+		version += "-" + release
 	}
-	db.SetMaxOpenConns(1)
+	return version
+}
 
-	d := &Database{
-		db: db,
-	}
+// NEVRA returns the package as `name-epoch:version-release.arch`, as accepted
+// by zypper.
+func (r SearchResult) NEVRA() string {
+	return r.Package + "-" + r.EVR() + "." + r.Arch
+}
 
-	if err := d.initialize(ctx); err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
-	}
-	return d, nil
+// RepoFilter selects the repositories to query.
+type RepoFilter struct {
+	// Only repositories in this list (matched by URL) are used.
+	Repos []*zypper.Repository
+	// If not empty, a repository must also have an alias or name matching at
+	// least one of these glob patterns.
+	Patterns []string
+	// If not empty, a package must be in an RPM group matching at least one
+	// of these glob patterns.
+	Groups []string
 }
 
-// Create an empty in-memory database for testing.
-func NewTesting(ctx context.Context) (*Database, error) {
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+// buildRepoFilter returns a SQL condition (and its arguments) for the filter.
+// The condition also excludes packages from incomplete updates, so the query
+// must join the packages and repositories tables.
+func (d *Database) buildRepoFilter(filter RepoFilter) (string, []any) {
+	query := "packages.generation == repositories.generation AND (repositories.url, repositories.releasever) IN (VALUES " +
+		strings.Join(itertools.Map(filter.Repos, func(r *zypper.Repository) string { return "(?, ?)" }), ", ") + ")"
+	var args []any
+	for _, repo := range filter.Repos {
+		args = append(args, repo.URL, repo.ReleaseVer)
 	}
-
-	d := &Database{
-		db: db,
+	if len(filter.Patterns) > 0 {
+		conditions := itertools.Map(filter.Patterns, func(string) string {
+			return "repositories.alias GLOB ? OR repositories.name GLOB ?"
+		})
+		query += fmt.Sprintf(" AND (%s)", strings.Join(conditions, " OR "))
+		for _, pattern := range filter.Patterns {
+			args = append(args, pattern, pattern)
+		}
 	}
-
-	if err := d.initialize(ctx); err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	if len(filter.Groups) > 0 {
+		conditions := itertools.Map(filter.Groups, func(string) string {
+			return "packages.rpmGroup GLOB ?"
+		})
+		query += fmt.Sprintf(" AND (%s)", strings.Join(conditions, " OR "))
+		for _, group := range filter.Groups {
+			args = append(args, group)
+		}
 	}
-	return d, nil
+	return query, args
 }
 
-// initialize the database, performing migrations as necessary.
-func (d *Database) initialize(ctx context.Context) error {
-	var version int32
-	_, err := d.db.ExecContext(ctx, fmt.Sprintf("PRAGMA application_id = %d", applicationId))
-	if err != nil {
-		return fmt.Errorf("failed to set database application id: %w", err)
+// buildArchFilter returns a SQL condition (and its arguments) to append to
+// the repository filter, limiting packages to those that can be installed on
+// the given architecture; it is empty if no architecture is given.
+func buildArchFilter(arch string) (string, []any) {
+	if arch == "" {
+		return "", nil
 	}
+	return ` AND (packages.arch == 'noarch' OR ? LIKE packages.arch || '%')`, []any{arch}
+}
 
-	for _, stmt := range []string{
-		"PRAGMA auto_vacuum = 1",
-		"PRAGMA encoding = 'UTF-8'",
-		"PRAGMA foreign_keys = 1",
-		"PRAGMA journal_mode = WAL",
-		"PRAGMA recursive_triggers = 1",
-	} {
-		if _, err := d.db.ExecContext(ctx, stmt); err != nil {
-			return fmt.Errorf("failed to execute pragma %q: %w", stmt, err)
-		}
-	}
+// Search for a file: Given a file path as a glob pattern, return packages with
+// matching files.
+func (d *Database) SearchFile(ctx context.Context, filter RepoFilter, path, arch string) ([]SearchResult, error) {
+	return d.SearchFiles(ctx, filter, []string{path}, arch)
+}
 
-	err = d.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+// SearchFiles searches for files matching any of the given glob patterns.
+func (d *Database) SearchFiles(ctx context.Context, filter RepoFilter, paths []string, arch string) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to get database version: %w", err)
+		return nil, err
 	}
-	if version == userVersion {
-		// This is a valid database
-		return nil
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	var conditions []string
+	var fileArgs []any
+	for _, path := range paths {
+		condition, args := fileCondition(path)
+		conditions = append(conditions, condition)
+		fileArgs = append(fileArgs, args...)
 	}
-	slog.DebugContext(ctx, "Re-initializing database", "stored version", version, "required version", userVersion)
 
-	// The database may have incompatible data; because this is only used for
-	// a cache, we can just drop everything.
-	for _, stmt := range []string{
-		// Drop the child tables first, so that we don't have to delete rows
-		// with foreign keys one by one.
-		`DROP TABLE IF EXISTS files`,
-		`DROP TABLE IF EXISTS packages`,
-		`DROP TABLE IF EXISTS repositories`,
-		`CREATE TABLE repositories (` +
-			`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
-			`alias TEXT, ` +
-			`name TEXT, ` +
-			`url TEXT UNIQUE ON CONFLICT ABORT, ` +
-			`type TEXT, ` +
-			`enabled BOOLEAN, ` +
-			`lastChecked DATE, ` +
-			`lastModified DATE` +
-			`)`,
-		`CREATE TABLE packages (` +
-			`repository INTEGER REFERENCES repositories(id) ON DELETE CASCADE, ` +
-			`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
-			`pkgid TEXT UNIQUE, ` +
-			`name TEXT, ` +
-			`arch TEXT, ` +
-			`epoch TEXT, ` +
-			`version TEXT, ` +
-			`release TEXT, ` +
-			`UNIQUE (repository, name, arch, epoch, version, release))`,
-		`CREATE TABLE files (` +
-			`pkgid TEXT REFERENCES packages(id) ON DELETE CASCADE, ` +
-			`file TEXT,
-			PRIMARY KEY (pkgid, file))`,
-	} {
-		if _, err := d.db.ExecContext(ctx, stmt); err != nil {
-			return fmt.Errorf("failed to initialize database: %q: %w", stmt, err)
-		}
-	}
+	archQuery, archArgs := buildArchFilter(arch)
+	where := `WHERE (` + strings.Join(conditions, ` OR `) + `) AND ` + repoQuery + archQuery
+	query := func(schema string) string { return d.searchResultQuery(schema) + where }
+
+	slog.DebugContext(ctx,
+		"Searching for files",
+		"files", paths,
+		"arch", arch,
+		"repos", itertools.Map(filter.Repos, func(r *zypper.Repository) string { return r.Alias }),
+		"patterns", filter.Patterns,
+		"query", query(attachedSchema(0)))
+
+	return d.querySearchResults(ctx, repos, query, slices.Concat(fileArgs, repoArgs, archArgs)...)
+}
 
-	_, err = d.db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", userVersion))
+// SearchDigest searches for files with the given content digest (as a hex
+// string), for repositories that publish them.
+func (d *Database) SearchDigest(ctx context.Context, filter RepoFilter, digest, arch string) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to set database version: %w", err)
+		return nil, err
 	}
-	return nil
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+
+	archQuery, archArgs := buildArchFilter(arch)
+	where := `WHERE files.digest == ? AND ` + repoQuery + archQuery
+	query := func(schema string) string { return d.searchResultQuery(schema) + where }
+
+	slog.DebugContext(ctx,
+		"Searching for file digest",
+		"digest", digest,
+		"arch", arch,
+		"repos", itertools.Map(filter.Repos, func(r *zypper.Repository) string { return r.Alias }),
+		"patterns", filter.Patterns,
+		"query", query(attachedSchema(0)))
+
+	return d.querySearchResults(ctx, repos, query, slices.Concat([]any{strings.ToLower(digest)}, repoArgs, archArgs)...)
 }
 
-func (d *Database) Close() error {
-	return d.db.Close()
+// SearchSoname searches for packages providing the shared library with the
+// given soname (e.g. `libz.so.1`): either a file of that name in a library
+// directory, or an RPM provides of the soname, with or without the `()(64bit)`
+// suffix.  For provides, the path of the result is the name provided.
+func (d *Database) SearchSoname(ctx context.Context, filter RepoFilter, soname, arch string) ([]SearchResult, error) {
+	return d.searchFileOrProvides(ctx, filter, "*/lib*/"+escapeGlob(soname),
+		[]string{soname, soname + "()", soname + "()(64bit)"}, arch)
 }
 
-// Look up when the given repository was last checked, and last modified.
-func (d *Database) GetTimestamps(ctx context.Context, repo *zypper.Repository) (time.Time, time.Time, error) {
-	var lastChecked, lastModified time.Time
-	err := d.db.QueryRowContext(ctx, "SELECT lastChecked, lastModified FROM repositories WHERE url = ?", repo.URL).Scan(&lastChecked, &lastModified)
-	if errors.Is(err, sql.ErrNoRows) {
-		return time.Time{}, time.Time{}, nil
-	}
-	if err != nil {
-		return time.Time{}, time.Time{}, err
-	}
-	return lastChecked.UTC(), lastModified.UTC(), nil
+// SearchPkgConfig searches for packages providing the pkg-config module with
+// the given name: either its `.pc` file, or a `pkgconfig(name)` provides.
+func (d *Database) SearchPkgConfig(ctx context.Context, filter RepoFilter, module, arch string) ([]SearchResult, error) {
+	return d.searchFileOrProvides(ctx, filter, "/usr/*/pkgconfig/"+escapeGlob(module)+".pc",
+		[]string{"pkgconfig(" + module + ")"}, arch)
 }
 
-// Update a given repository; all updates should be done within the passed-in
-// function, as that will be used to establish a transaction.  The function
-// gets a callback which can be used to update a package, which in turn returns
-// a function that can add files to the package.
-func (d *Database) UpdateRepository(
-	ctx context.Context,
-	repo *zypper.Repository,
-	lastChecked, lastModified time.Time,
-	cb func(pkg func(pkgid, name, arch, epoch, version, release string) (func(string) error, error)) error,
-) error {
-	tx, err := d.db.BeginTx(ctx, nil)
+// searchFileOrProvides searches for files matching the glob pattern, and for
+// packages with any of the given provides; for provides, the path of the
+// result is the name provided.
+func (d *Database) searchFileOrProvides(ctx context.Context, filter RepoFilter, pattern string, provides []string, arch string) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// If we return before the commit, do a rollback.  This is a no-op if we have
-	// already committed.
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	fileQuery, fileArgs := fileCondition(pattern)
+	archQuery, archArgs := buildArchFilter(arch)
 
-	// This drops any existing data for the repository because we enable the
-	// recursive_triggers pragma, which per https://www.sqlite.org/lang_conflict.html:
-	// > When the REPLACE conflict resolution strategy deletes rows in order to
-	// > satisfy a constraint, delete triggers fire if and only if recursive
-	// > triggers are enabled.
-	result, err := tx.ExecContext(ctx,
-		`INSERT OR REPLACE INTO repositories `+
-			`(alias, name, url, type, enabled, lastChecked, lastModified) `+
-			`VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		repo.Alias, repo.Name, repo.URL, repo.Type, repo.Enabled, lastChecked, lastModified)
-	if err != nil {
-		return fmt.Errorf("failed to update repository %s: %w", repo.Name, err)
+	query := func(schema string) string {
+		return d.searchResultQuery(schema) +
+			`WHERE ` + fileQuery + ` AND ` + repoQuery + archQuery +
+			` UNION SELECT ` + d.repoLabelColumn + `, repositories.alias, repositories.url, packages.pkgid, packages.name, packages.arch, packages.epoch, packages.version, packages.release, ` +
+			`IFNULL(packages.size, 0), IFNULL(packages.installedSize, 0), IFNULL(packages.location, ''), repositories.releasever, IFNULL(repositories.revision, ''), ` +
+			`provides.name, 'provides', FALSE ` +
+			`FROM ` + schema + `.packages AS packages INNER JOIN ` + schema + `.repositories AS repositories ON packages.repository == repositories.id ` +
+			`INNER JOIN ` + schema + `.provides AS provides ON packages.id == provides.pkgid ` +
+			`WHERE provides.name IN (?` + strings.Repeat(", ?", len(provides)-1) + `) AND ` + repoQuery + archQuery
 	}
 
-	repositoryId, err := result.LastInsertId()
+	slog.DebugContext(ctx,
+		"Searching for files or provides",
+		"file", pattern,
+		"provides", provides,
+		"arch", arch,
+		"repos", itertools.Map(filter.Repos, func(r *zypper.Repository) string { return r.Alias }),
+		"patterns", filter.Patterns,
+		"query", query(attachedSchema(0)))
+
+	args := slices.Concat(fileArgs, repoArgs, archArgs, itertools.Map(provides, func(p string) any { return p }), repoArgs, archArgs)
+	return d.querySearchResults(ctx, repos, query, args...)
+}
+
+// escapeGlob returns the string as a GLOB pattern matching only itself.
+func escapeGlob(s string) string {
+	return strings.NewReplacer("[", "[[]", "*", "[*]", "?", "[?]").Replace(s)
+}
+
+// FuzzySearchFile searches for files with a base name approximately matching
+// the given name (either within a small edit distance, or containing the
+// characters of the name in order), returning up to limit results with the
+// best matches first.
+func (d *Database) FuzzySearchFile(ctx context.Context, filter RepoFilter, name, arch string, limit int) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to get last inserted id when updating repository %s: %w", repo.Name, err)
+		return nil, err
 	}
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+
+	archQuery, archArgs := buildArchFilter(arch)
+	where := `WHERE fuzzy_score(files.name, ?) >= 0 AND ` + repoQuery + archQuery
+	where += ` ORDER BY fuzzy_score(files.name, ?), files.dir, files.name LIMIT ?`
+	// Each repository has its own best matches, so the query for each must be
+	// a subquery to be combined with the others.
+	query := func(schema string) string { return `SELECT * FROM (` + d.searchResultQuery(schema) + where + `)` }
+
+	slog.DebugContext(ctx,
+		"Fuzzy searching for files",
+		"name", name,
+		"arch", arch,
+		"repos", itertools.Map(filter.Repos, func(r *zypper.Repository) string { return r.Alias }),
+		"patterns", filter.Patterns,
+		"query", query(attachedSchema(0)))
 
-	pkgStmt, err := tx.PrepareContext(ctx,
-		`INSERT OR REPLACE INTO packages (repository, pkgid, name, arch, epoch, version, release) `+
-			`VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	results, err := d.querySearchResults(ctx, repos, query, slices.Concat([]any{name}, repoArgs, archArgs, []any{name, limit})...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fileStmt, err := tx.PrepareContext(ctx,
-		`INSERT OR REPLACE INTO files (pkgid, file) VALUES (?, ?)`)
+	// Merge the best matches of each repository.
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		aDir, aName := splitPath(a.Path)
+		bDir, bName := splitPath(b.Path)
+		return cmp.Or(
+			cmp.Compare(fuzzy.Score(a.Path, name), fuzzy.Score(b.Path, name)),
+			cmp.Compare(aDir, bDir),
+			cmp.Compare(aName, bName))
+	})
+	return results[:min(limit, len(results))], nil
+}
+
+// ChangelogResult is a changelog entry that matched a search.
+type ChangelogResult struct {
+	XMLName    xml.Name  `json:"-" xml:"changelog"`
+	Repository string    `json:"repository" xml:"repository,attr"`
+	Package    string    `json:"package" xml:"package,attr"`
+	Arch       string    `json:"arch" xml:"arch,attr"`
+	Epoch      string    `json:"epoch" xml:"epoch,attr"`
+	Version    string    `json:"version" xml:"version,attr"`
+	Release    string    `json:"release" xml:"release,attr"`
+	Author     string    `json:"author" xml:"author,attr"`
+	Date       time.Time `json:"date" xml:"date,attr"`
+	Text       string    `json:"text" xml:",chardata"`
+}
+
+// EVR returns the version of the package as `epoch:version-release`, omitting
+// the epoch if it is zero.
+func (r ChangelogResult) EVR() string {
+	return formatEVR(r.Epoch, r.Version, r.Release)
+}
+
+// SearchChangelogs returns the changelog entries containing text matching the
+// glob pattern, newest first.
+func (d *Database) SearchChangelogs(ctx context.Context, filter RepoFilter, pattern string) ([]ChangelogResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	err = cb(func(pkgid, name, arch, epoch, version, release string) (func(string) error, error) {
-		result, err := pkgStmt.ExecContext(ctx, repositoryId, pkgid, name, arch, epoch, version, release)
-		if err != nil {
-			return nil, fmt.Errorf("failed to update package: %w", err)
-		}
-		pkgId, err := result.LastInsertId()
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	query := `SELECT ` + d.repoLabelColumn + `, packages.name, packages.arch, packages.epoch, packages.version, packages.release, ` +
+		`changelogs.author, changelogs.date, changelogs.text ` +
+		`FROM changelogs INNER JOIN packages ON changelogs.pkgid == packages.id ` +
+		`INNER JOIN repositories ON packages.repository == repositories.id ` +
+		`WHERE changelogs.text GLOB ? AND ` + repoQuery +
+		` ORDER BY changelogs.date DESC, packages.name`
+	var results []ChangelogResult
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		rows, err := r.reader.QueryContext(ctx, query, slices.Concat([]any{"*" + pattern + "*"}, repoArgs)...)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get last inserted row: %w", err)
+			return fmt.Errorf("failed to search changelogs: %w", err)
 		}
-		return func(file string) error {
-			_, err := fileStmt.ExecContext(ctx, pkgId, file)
-			if err != nil {
-				return fmt.Errorf("failed to update file: %w", err)
+		defer func() {
+			_ = rows.Close()
+		}()
+		for rows.Next() {
+			var result ChangelogResult
+			if err := rows.Scan(&result.Repository, &result.Package, &result.Arch, &result.Epoch, &result.Version, &result.Release,
+				&result.Author, &result.Date, &result.Text); err != nil {
+				return fmt.Errorf("failed to read changelog: %w", err)
 			}
-			return nil
-		}, nil
+			result.Date = result.Date.UTC()
+			results = append(results, result)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error reading query results: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	slices.SortStableFunc(results, func(a, b ChangelogResult) int {
+		return cmp.Or(b.Date.Compare(a.Date), cmp.Compare(a.Package, b.Package))
+	})
+	return results, nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error commiting update of repository %s: %w", repo.Name, err)
+// RepositoryContents calls fn with every file in the repositories matching
+// the filter, sorted by package.  The files are read as fn consumes them, so
+// that whole repositories need not be held in memory; an error from fn stops
+// the listing and is returned.
+func (d *Database) RepositoryContents(ctx context.Context, filter RepoFilter, fn func(SearchResult) error) error {
+	repos, err := d.filterRepos(ctx, filter)
+	if err != nil {
+		return err
+	}
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	query := func(schema string) string {
+		return d.searchResultQuery(schema) + `WHERE ` + repoQuery +
+			` ORDER BY repositories.name, packages.name, packages.arch, packages.id, files.dir, files.name`
+	}
+	// The results must stay in order, so each repository is queried on its own.
+	for _, r := range repos {
+		if err := d.eachSearchResult(ctx, []*repoDatabase{r}, query, fn, repoArgs...); err != nil {
+			return fmt.Errorf("failed to list repository contents: %w", err)
+		}
 	}
 	return nil
 }
 
-type SearchResult struct {
-	XMLName    xml.Name `json:"-" xml:"result"`
-	Repository string   `json:"repository" xml:"repository,attr"`
-	Package    string   `json:"package" xml:"package,attr"`
-	Arch       string   `json:"arch" xml:"arch,attr"`
-	Epoch      string   `json:"epoch" xml:"epoch,attr"`
-	Version    string   `json:"version" xml:"version,attr"`
-	Release    string   `json:"release" xml:"release,attr"`
-	Path       string   `json:"path" xml:"path,attr"`
-}
+// SearchDuplicates returns the files matching the glob pattern that are
+// provided by more than one distinct package (by name) in the selected
+// repositories; different versions or architectures of the same package are
+// not considered duplicates, and neither are files managed by
+// update-alternatives.
+func (d *Database) SearchDuplicates(ctx context.Context, filter RepoFilter, pattern string) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	fileQuery, fileArgs := fileCondition(pattern)
+	query := func(schema string) string {
+		return d.searchResultQuery(schema) + `WHERE ` + fileQuery + ` AND ` + repoQuery
+	}
 
-func (d *Database) buildRepoFilter(repos []*zypper.Repository) (string, []any) {
-	query := fmt.Sprintf("(%s)", strings.Join(itertools.Map(repos, func(r *zypper.Repository) string { return "?" }), ", "))
-	args := itertools.Map(repos, func(r *zypper.Repository) any { return r.URL })
-	return query, args
+	slog.DebugContext(ctx,
+		"Searching for duplicate files",
+		"file", pattern,
+		"repos", itertools.Map(filter.Repos, func(r *zypper.Repository) string { return r.Alias }),
+		"patterns", filter.Patterns,
+		"query", query(attachedSchema(0)))
+
+	candidates, err := d.querySearchResults(ctx, repos, query, slices.Concat(fileArgs, repoArgs)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for duplicate files: %w", err)
+	}
+	// The packages may be in different repositories, so they can only be
+	// compared once the results of all of them are in.
+	packages := make(map[string]map[string]bool)
+	for _, candidate := range candidates {
+		if candidate.Alternative {
+			continue
+		}
+		if packages[candidate.Path] == nil {
+			packages[candidate.Path] = make(map[string]bool)
+		}
+		packages[candidate.Path][candidate.Package] = true
+	}
+	var results []SearchResult
+	for _, candidate := range candidates {
+		if len(packages[candidate.Path]) > 1 {
+			results = append(results, candidate)
+		}
+	}
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		aDir, aName := splitPath(a.Path)
+		bDir, bName := splitPath(b.Path)
+		return cmp.Or(
+			cmp.Compare(aDir, bDir),
+			cmp.Compare(aName, bName),
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.Repository, b.Repository))
+	})
+	return results, nil
 }
 
-// Search for a file: Given a file path as a glob pattern, return packages with
-// matching files.
-func (d *Database) SearchFile(ctx context.Context, repos []*zypper.Repository, path, arch string) ([]SearchResult, error) {
-	repoQuery, repoArgs := d.buildRepoFilter(repos)
+// searchResultQuery returns the start of a query returning the columns of
+// SearchResult, up to (but not including) the WHERE clause, for the
+// repository attached as the given schema; the tables keep their names, so
+// that the rest of the query doesn't need to know about the schema.
+func (d *Database) searchResultQuery(schema string) string {
+	return `SELECT ` + d.repoLabelColumn + `, repositories.alias, repositories.url, packages.pkgid, packages.name, packages.arch, packages.epoch, packages.version, packages.release, ` +
+		`IFNULL(packages.size, 0), IFNULL(packages.installedSize, 0), IFNULL(packages.location, ''), repositories.releasever, IFNULL(repositories.revision, ''), ` +
+		`files.dir || files.name, IFNULL(files.type, 'file'), IFNULL(files.alternative, FALSE) ` +
+		`FROM ` + schema + `.packages AS packages INNER JOIN ` + schema + `.repositories AS repositories ON packages.repository == repositories.id ` +
+		`INNER JOIN ` + schema + `.files AS files ON packages.id == files.pkgid `
+}
 
-	query := `SELECT repositories.name, packages.name, packages.arch, packages.epoch, packages.version, packages.release, files.file ` +
-		`FROM packages INNER JOIN repositories ON packages.repository == repositories.id ` +
-		`INNER JOIN files ON packages.id == files.pkgid ` +
-		`WHERE files.file GLOB ? AND repositories.url IN ` + repoQuery
-	if arch != "" {
-		query += fmt.Sprintf(` AND (packages.arch == 'noarch' OR '%s' LIKE packages.arch || '%%' )`, arch)
+// querySearchResults runs a query that returns the columns of SearchResult
+// against the repositories, and returns all of the results; see
+// eachSearchResult.
+func (d *Database) querySearchResults(ctx context.Context, repos []*repoDatabase, query func(schema string) string, args ...any) ([]SearchResult, error) {
+	var results []SearchResult
+	err := d.eachSearchResult(ctx, repos, query, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
 	}
+	return results, nil
+}
 
-	slog.DebugContext(ctx,
-		"Searching for files",
-		"file", path,
-		"arch", arch,
-		"repos", itertools.Map(repos, func(r *zypper.Repository) string { return r.Alias }),
-		"query", query)
+// eachSearchResult runs a query that returns the columns of SearchResult
+// against the repositories, calling fn with each result as it is read.  The
+// databases of the repositories are attached to a single connection, and the
+// queries for each of them (as built by query, for the schema it is attached
+// as) combined into a single statement; the arguments are those for one
+// repository.  The order of the results across repositories is not defined.
+// An error from fn stops the query and is returned as is.
+func (d *Database) eachSearchResult(ctx context.Context, repos []*repoDatabase, query func(schema string) string, fn func(SearchResult) error, args ...any) error {
+	if d.explain != nil {
+		start := time.Now()
+		defer func() {
+			_, _ = fmt.Fprintf(d.explain, "Time: %s\n\n", time.Since(start))
+		}()
+	}
+	return d.federate(ctx, repos, func(conn *sql.Conn, schemas []string) error {
+		combined := strings.Join(itertools.Map(schemas, query), ` UNION ALL `)
+		combinedArgs := slices.Concat(slices.Repeat([][]any{args}, len(schemas))...)
+		if d.explain != nil {
+			if err := d.explainQuery(ctx, conn, combined, combinedArgs...); err != nil {
+				return err
+			}
+		}
+		rows, err := conn.QueryContext(ctx, combined, combinedArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to execute search query: %w", err)
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		for rows.Next() {
+			var result SearchResult
+			if err := rows.Scan(&result.Repository, &result.Alias, &result.URL, &result.PkgId, &result.Package, &result.Arch, &result.Epoch, &result.Version, &result.Release,
+				&result.Size, &result.InstalledSize, &result.Location, &result.ReleaseVer, &result.Revision, &result.Path, &result.FileType, &result.Alternative); err != nil {
+				return err
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error reading query results: %w", err)
+		}
+		return nil
+	})
+}
 
-	rows, err := d.db.QueryContext(ctx, query, slices.Concat([]any{path}, repoArgs)...)
+func (d *Database) ListPackage(ctx context.Context, filter RepoFilter, arch string, terms ...string) ([]SearchResult, error) {
+	repos, err := d.filterRepos(ctx, filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute search query: %w", err)
+		return nil, err
 	}
-	defer func() {
-		_ = rows.Close()
-	}()
+	terms = itertools.Map(terms, func(term string) string { return strings.TrimSuffix(term, "-") })
+	found := make(map[string]bool)
 	var results []SearchResult
-	for rows.Next() {
-		var result SearchResult
-		if err := rows.Scan(&result.Repository, &result.Package, &result.Arch, &result.Epoch, &result.Version, &result.Release, &result.Path); err != nil {
-			return nil, err
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		// Package ids are only unique within a repository.
+		pkgIds, err := d.findPackages(ctx, r, filter, arch, terms, found)
+		if err != nil {
+			return err
 		}
-		results = append(results, result)
+		query := func(schema string) string {
+			return d.searchResultQuery(schema) +
+				`WHERE packages.id IN ` +
+				fmt.Sprintf("(%s)", strings.Join(itertools.Map(pkgIds, func(s int) string { return "?" }), ", "))
+		}
+		repoResults, err := d.querySearchResults(ctx, []*repoDatabase{r}, query, itertools.Map(pkgIds, func(s int) any { return s })...)
+		results = append(results, repoResults...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages: %w", err)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error reading query results: %w", err)
+	for _, term := range terms {
+		if !found[term] {
+			slog.ErrorContext(ctx, "package not found", "package", term)
+		}
 	}
-
 	return results, nil
 }
 
-func (d *Database) ListPackage(ctx context.Context, repos []*zypper.Repository, arch string, terms ...string) ([]SearchResult, error) {
-	repoQuery, repoArgs := d.buildRepoFilter(repos)
+// findPackages returns the ids of the packages in the repository matching the
+// terms, each of which is `pkg`, `pkg-version` or `pkg-version-release`; the
+// terms that matched are marked in found.
+func (d *Database) findPackages(ctx context.Context, r *repoDatabase, filter RepoFilter, arch string, terms []string, found map[string]bool) ([]int, error) {
+	repoQuery, repoArgs := d.buildRepoFilter(filter)
+	archQuery, archArgs := buildArchFilter(arch)
 
 	pkgQuery := `SELECT packages.id ` +
 		`FROM packages INNER JOIN repositories ON packages.repository == repositories.id ` +
-		`WHERE repositories.url IN ` + repoQuery
-	if arch != "" {
-		pkgQuery += fmt.Sprintf(` AND (packages.arch == 'noarch' OR '%s' LIKE packages.arch || '%%' )`, arch)
-	}
+		`WHERE ` + repoQuery + archQuery
 	pkgQuery += ` AND packages.name == ?`
-	pkgStmt, err := d.db.PrepareContext(ctx, pkgQuery)
+	pkgStmt, err := r.reader.PrepareContext(ctx, pkgQuery)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %q", err)
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
+	defer func() {
+		_ = pkgStmt.Close()
+	}()
 	pkgQuery += ` AND packages.version = ?`
-	pkgVersionStmt, err := d.db.PrepareContext(ctx, pkgQuery)
+	pkgVersionStmt, err := r.reader.PrepareContext(ctx, pkgQuery)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %q", err)
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
+	defer func() {
+		_ = pkgVersionStmt.Close()
+	}()
 	pkgQuery += ` AND packages.release = ?`
-	pkgVersionReleaseStmt, err := d.db.PrepareContext(ctx, pkgQuery)
+	pkgVersionReleaseStmt, err := r.reader.PrepareContext(ctx, pkgQuery)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %q", err)
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
+	defer func() {
+		_ = pkgVersionReleaseStmt.Close()
+	}()
 	var pkgIds []int
 	for _, term := range terms {
-		term = strings.TrimSuffix(term, "-")
 		// `pkg` may be `pkg-version` or `pkg-version-build`
 		type queryInfo struct {
 			stmt *sql.Stmt
@@ -350,53 +1870,27 @@ func (d *Database) ListPackage(ctx context.Context, repos []*zypper.Repository,
 			}
 		}
 
-		found := false
 		for _, candidate := range candidates {
-			rows, err := candidate.stmt.QueryContext(ctx, slices.Concat(repoArgs, candidate.args)...)
+			rows, err := candidate.stmt.QueryContext(ctx, slices.Concat(repoArgs, archArgs, candidate.args)...)
 			if err != nil {
 				return nil, fmt.Errorf("failed to query package %v: %w", candidate.args, err)
 			}
-			defer func() {
-				_ = rows.Close()
-			}()
+			matched := false
 			for rows.Next() {
-				found = true
+				matched = true
 				var pkgId int
 				if err := rows.Scan(&pkgId); err != nil {
+					_ = rows.Close()
 					return nil, fmt.Errorf("failed to get package %v id: %w", candidate.args, err)
 				}
 				pkgIds = append(pkgIds, pkgId)
 			}
 			_ = rows.Close()
-			if found {
+			if matched {
+				found[term] = true
 				break
 			}
 		}
-		if !found {
-			slog.ErrorContext(ctx, "package not found", "package", term)
-		}
-	}
-
-	query := `SELECT repositories.name, packages.name, packages.arch, packages.epoch, packages.version, packages.release, files.file ` +
-		`FROM packages INNER JOIN repositories ON packages.repository == repositories.id ` +
-		`INNER JOIN files ON packages.id == files.pkgid ` +
-		`WHERE packages.id IN ` +
-		fmt.Sprintf("(%s)", strings.Join(itertools.Map(pkgIds, func(s int) string { return "?" }), ", "))
-	rows, err := d.db.QueryContext(ctx, query, itertools.Map(pkgIds, func(s int) any { return s })...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list packages: %w", err)
-	}
-	defer func() {
-		_ = rows.Close()
-	}()
-	var results []SearchResult
-	for rows.Next() {
-		var result SearchResult
-		if err := rows.Scan(&result.Repository, &result.Package, &result.Arch, &result.Epoch, &result.Version, &result.Release, &result.Path); err != nil {
-			return nil, fmt.Errorf("failed to read package list: %w", err)
-		}
-		results = append(results, result)
 	}
-
-	return results, nil
+	return pkgIds, nil
 }
@@ -4,11 +4,21 @@
 package database
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/adrg/xdg"
+	"github.com/mattn/go-sqlite3"
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/itertools"
 	"github.com/mook-as/zypper-filesearch/zypper"
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
@@ -23,36 +33,57 @@ func TestNew(t *testing.T) {
 	}
 	expected := []SearchResult{
 		{
-			Repository: repo.Name,
-			Package:    "pkg-name",
-			Arch:       "avr32",
-			Epoch:      "2",
-			Version:    "1.5",
-			Release:    "6",
-			Path:       "/some/path",
+			Repository:    repo.Name,
+			Package:       "pkg-name",
+			Arch:          "avr32",
+			Epoch:         "2",
+			Version:       "1.5",
+			Release:       "6",
+			URL:           repo.URL,
+			PkgId:         "pkg-id",
+			Size:          1234,
+			InstalledSize: 5678,
+			Location:      "avr32/pkg-name-1.5-6.avr32.rpm",
+			Revision:      "1234",
+			Path:          "/some/path",
+			FileType:      "file",
 		},
 	}
 
 	// Ensure we use a temporary directory for the database.
 	cacheDir := t.TempDir()
-	assert.NilError(t, os.Setenv("XDG_CACHE_HOME", cacheDir))
-	xdg.Reload()
 
 	// Create the database.
-	db, err := New(t.Context())
+	db, err := New(t.Context(), &config.Config{
+		CacheDir:    cacheDir,
+		CacheSize:   -4096,
+		TempStore:   "memory",
+		Synchronous: "off",
+	})
 	assert.NilError(t, err)
 	assert.Check(t, db != nil, "no database")
 
 	// Add some entries.
 	lastModified := time.Unix(1231006505, 0).UTC()
 	lastChecked := time.Unix(1231469665, 0).UTC()
-	err = db.UpdateRepository(t.Context(), repo, lastChecked, lastModified, func(p func(pkgid, name, arch, epoch, version, release string) (func(string) error, error)) error {
+	err = db.UpdateRepository(t.Context(), repo, lastChecked, lastModified, Provenance{Revision: "1234", Checksum: "sha256:abcd", Settings: "indexExclude=/usr/share/doc/*"}, func(p func(*Package) (func(string, string) error, error)) error {
 		for _, entry := range expected {
-			f, err := p("pkg-id", entry.Package, entry.Arch, entry.Epoch, entry.Version, entry.Release)
+			f, err := p(&Package{
+				PkgId:         entry.PkgId,
+				Name:          entry.Package,
+				Arch:          entry.Arch,
+				Epoch:         entry.Epoch,
+				Version:       entry.Version,
+				Release:       entry.Release,
+				Size:          entry.Size,
+				InstalledSize: entry.InstalledSize,
+				Location:      entry.Location,
+				Group:         "Development/Libraries/C and C++",
+			})
 			if err != nil {
 				return err
 			}
-			if err := f(entry.Path); err != nil {
+			if err := f(entry.Path, "0123ABCD"); err != nil {
 				return err
 			}
 		}
@@ -65,31 +96,1128 @@ func TestNew(t *testing.T) {
 	assert.NilError(t, err)
 	assert.Check(t, cmp.Equal(lastModified, actualModified))
 	assert.Check(t, cmp.Equal(lastChecked, actualChecked))
+	provenance, err := db.GetProvenance(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(provenance, Provenance{Revision: "1234", Checksum: "sha256:abcd", Settings: "indexExclude=/usr/share/doc/*"}))
+
+	// Check that the statistics are correct
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Check(t, cmp.Equal(stats[0].URL, repo.URL))
+	assert.Check(t, cmp.Equal(stats[0].Packages, 1))
+	assert.Check(t, cmp.Equal(stats[0].Files, 1))
+	assert.Check(t, cmp.Equal(stats[0].LastChecked, lastChecked))
+	assert.Check(t, cmp.Equal(stats[0].Revision, "1234"))
+	assert.Check(t, cmp.Equal(stats[0].Checksum, "sha256:abcd"))
+
+	// Check the per-package statistics
+	packageStats, err := db.PackageStats(t.Context(), 10)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(packageStats, 1))
+	assert.Check(t, cmp.Equal(packageStats[0].Package, "pkg-name"))
+	assert.Check(t, cmp.Equal(packageStats[0].EVR(), "2:1.5-6"))
+	assert.Check(t, cmp.Equal(packageStats[0].Files, 1))
+	histogram, err := db.FileHistogram(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(histogram, 1))
+	assert.Check(t, cmp.Equal(histogram[0].Repository, repo.Name))
+	assert.Check(t, cmp.Equal(histogram[0].MinFiles, 1))
+	assert.Check(t, cmp.Equal(histogram[0].MaxFiles, 9))
+	assert.Check(t, cmp.Equal(histogram[0].Packages, 1))
+	assert.Check(t, cmp.Equal(histogram[0].Files, 1))
 
 	// Check that we can find the file
-	results, err := db.SearchFile(t.Context(), []*zypper.Repository{repo}, "/some/path", "")
+	results, err := db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(expected, results))
+
+	// Check that fuzzy searching works
+	results, err = db.FuzzySearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "pth", "", 10)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(expected, results))
+
+	// Check that searching by digest works, ignoring case
+	results, err = db.SearchDigest(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "0123abcd", "")
 	assert.NilError(t, err)
 	assert.Check(t, cmp.DeepEqual(expected, results))
 
+	// Check that the architecture is applied, and is not part of the SQL
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "avr32")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "x86_64")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "x' OR 'a' LIKE 'a")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	// Check that repository patterns are applied
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Patterns: []string{"te*"}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Patterns: []string{"other*"}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	// Check that group patterns are applied
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Groups: []string{"System/*", "Development/*"}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Groups: []string{"System/*"}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	// Check that the repository label can be changed
+	db.SetRepoLabel(config.RepoLabelURL)
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Repository, repo.URL))
+	db.SetRepoLabel(config.RepoLabelName)
+
 	// Check that we can list files
-	results, err = db.ListPackage(t.Context(), []*zypper.Repository{repo}, "", "pkg-name")
+	results, err = db.ListPackage(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "", "pkg-name")
 	assert.NilError(t, err)
 	assert.Check(t, cmp.DeepEqual(expected, results))
 
+	// Check that query plans can be shown
+	var explain strings.Builder
+	db.SetExplain(&explain)
+	_, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "")
+	assert.NilError(t, err)
+	db.SetExplain(nil)
+	assert.Check(t, cmp.Contains(explain.String(), "Plan:\n"))
+	assert.Check(t, cmp.Contains(explain.String(), "Time: "))
+
+	// Check that we can list the repository contents
+	results = nil
+	err = db.RepositoryContents(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Patterns: []string{"test"}}, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(expected, results))
+	// Errors from the callback stop the listing.
+	errStop := errors.New("stop")
+	err = db.RepositoryContents(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}, Patterns: []string{"test"}}, func(SearchResult) error {
+		return errStop
+	})
+	assert.Check(t, cmp.ErrorIs(err, errStop))
+
 	// Check that the file can be written
 	assert.NilError(t, db.Close())
 	entries, err := os.ReadDir(cacheDir)
 	assert.NilError(t, err)
-	// It should just have the file, without WAL/journal.
-	assert.Check(t, cmp.Len(entries, 1))
+	// It should just have the main file and the repositories, without
+	// WAL/journal.
+	assert.Check(t, cmp.Len(entries, 2))
+	entries, err = os.ReadDir(filepath.Join(cacheDir, "repos"))
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(entries, func(e os.DirEntry) string { return e.Name() }),
+		[]string{repoKey(repo.URL, "") + ".db"}))
 
 	// Check that the data was persisted
-	db, err = New(t.Context())
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
 	assert.NilError(t, err)
 	assert.Assert(t, db != nil, "no database")
-	results, err = db.SearchFile(t.Context(), []*zypper.Repository{repo}, "/some/path", "")
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+
+	assert.NilError(t, db.Close())
+}
+
+func TestFastImport(t *testing.T) {
+	repo := &zypper.Repository{Name: "test", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test"}
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	cacheDir := t.TempDir()
+	db, err := New(t.Context(), &config.Config{CacheDir: cacheDir, FastImport: true})
+	assert.NilError(t, err)
+
+	var synchronous int
+	assert.NilError(t, db.db.QueryRowContext(t.Context(), "PRAGMA synchronous").Scan(&synchronous))
+	assert.Check(t, cmp.Equal(synchronous, 0))
+
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		f, err := p(&Package{PkgId: "1", Name: "pkg-name", Arch: "noarch"})
+		if err != nil {
+			return err
+		}
+		return f("/some/path", "")
+	})
+	assert.NilError(t, err)
+	path := db.repos[repoKey(repo.URL, "")].path
+	_, err = os.Stat(path + dirtySuffix)
+	assert.NilError(t, err, "database should be marked dirty while importing")
+
+	// Another process must not check the database while it is being written.
+	other, err := New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	_, err = other.openRepo(t.Context(), repoKey(repo.URL, ""), false)
+	assert.NilError(t, err)
+	assert.NilError(t, other.Close())
+	_, err = os.Stat(path + dirtySuffix)
+	assert.NilError(t, err, "marker of a database being imported should be kept")
+
+	// Closing syncs the database, so it is no longer dirty.
+	assert.NilError(t, db.Close())
+	_, err = os.Stat(path + dirtySuffix)
+	assert.Check(t, errors.Is(err, os.ErrNotExist), "dirty marker should be removed on close: %v", err)
+
+	// A marker left behind by a crash causes the database to be checked; it
+	// is intact, so it is kept.
+	assert.NilError(t, os.WriteFile(path+dirtySuffix, nil, 0o644))
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	results, err := db.SearchFile(t.Context(), filter, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	assert.NilError(t, db.Close())
+	_, err = os.Stat(path + dirtySuffix)
+	assert.Check(t, errors.Is(err, os.ErrNotExist), "dirty marker should be removed after checking: %v", err)
+
+	// A damaged database with a marker is discarded.
+	contents, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	pageSize := 4096
+	assert.Assert(t, len(contents) > pageSize*2)
+	for i := pageSize; i < len(contents); i++ {
+		contents[i] = 0xff
+	}
+	assert.NilError(t, os.WriteFile(path, contents, 0o644))
+	assert.NilError(t, os.WriteFile(path+dirtySuffix, nil, 0o644))
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	results, err = db.SearchFile(t.Context(), filter, "/some/path", "")
+	assert.Check(t, err == nil || errors.Is(err, ErrNoResults), "unexpected error: %v", err)
+	assert.Check(t, cmp.Len(results, 0))
+	assert.NilError(t, db.Close())
+	_, err = os.Stat(path + ".corrupt")
+	assert.NilError(t, err, "damaged database should be moved aside")
+}
+
+func TestMigrate(t *testing.T) {
+	repo := &zypper.Repository{Name: "test", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test"}
+	cacheDir := t.TempDir()
+	db, err := New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+
+	// Turn the database back into the version 15 layout, where everything was
+	// in the main database.
+	legacy := slices.Concat(
+		[]string{`DROP TABLE refreshLocks`, `DROP TABLE serviceRepositories`, `DROP TABLE services`},
+		itertools.Filter(repoSchema.create, func(stmt string) bool { return !strings.Contains(stmt, "files") }),
+		[]string{
+			`CREATE TABLE files (pkgid TEXT REFERENCES packages(id) ON DELETE CASCADE, file TEXT, digest TEXT, alternative BOOLEAN, PRIMARY KEY (pkgid, file))`,
+			`CREATE INDEX files_digest ON files (digest) WHERE digest IS NOT NULL`,
+			`INSERT INTO repositories (alias, name, url, releasever, type, generation) VALUES ('test', 'test', 'http://fake-host.test', '', 'rpm-md', 1)`,
+			`INSERT INTO packages (repository, generation, pkgid, name, arch, epoch, version, release) VALUES (1, 1, '1', 'pkg-name', 'noarch', '0', '1.0', '1')`,
+			`INSERT INTO files VALUES (1, '/some/path', '0123abcd', NULL)`,
+			`PRAGMA user_version = 15`,
+		})
+	for _, stmt := range legacy {
+		_, err := db.db.ExecContext(t.Context(), stmt)
+		assert.NilError(t, err, stmt)
+	}
+	assert.NilError(t, db.Close())
+
+	// Reopening the database should migrate it, moving the repository into its
+	// own file and keeping the data.
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	var version int32
+	assert.NilError(t, db.db.QueryRowContext(t.Context(), "PRAGMA user_version").Scan(&version))
+	assert.Check(t, cmp.Equal(version, userVersion))
+	var tables int
+	assert.NilError(t, db.db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM sqlite_schema WHERE name IN ('files', 'packages', 'repositories')`).Scan(&tables))
+	assert.Check(t, cmp.Equal(tables, 0))
+	_, err = os.Stat(filepath.Join(cacheDir, "repos", repoKey(repo.URL, "")+".db"))
+	assert.Check(t, err)
+	results, err := db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	results, err = db.SearchDigest(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "0123abcd", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	assert.NilError(t, db.Close())
+}
+
+func TestLegacyFile(t *testing.T) {
+	// Create a database in the old location, with some history.
+	oldCacheDir := t.TempDir()
+	db, err := New(t.Context(), &config.Config{CacheDir: oldCacheDir})
+	assert.NilError(t, err)
+	assert.NilError(t, db.AddHistory(t.Context(), "search", "/usr/bin/foo"))
+	assert.NilError(t, db.Close())
+	xdgCacheDir := t.TempDir()
+	legacy := filepath.Join(xdgCacheDir, legacyFile)
+	assert.NilError(t, os.Rename(filepath.Join(oldCacheDir, "filesearch.db"), legacy))
+
+	// Opening the database moves the old one into the cache directory.
+	t.Cleanup(xdg.Reload)
+	t.Setenv("XDG_CACHE_HOME", xdgCacheDir)
+	xdg.Reload()
+	cacheDir := t.TempDir()
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	entries, err := db.History(t.Context(), "", "", 10)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(entries, 1))
+	assert.NilError(t, db.Close())
+	_, err = os.Stat(legacy)
+	assert.Check(t, cmp.ErrorIs(err, os.ErrNotExist))
+
+	// If there already is a database, the old one is deleted.
+	assert.NilError(t, os.WriteFile(legacy, []byte("old"), 0o644))
+	adoptLegacyFile(t.Context(), legacy, filepath.Join(cacheDir, "filesearch.db"))
+	_, err = os.Stat(legacy)
+	assert.Check(t, cmp.ErrorIs(err, os.ErrNotExist))
+}
+
+func TestUpdateRepositoryInterrupted(t *testing.T) {
+	repo := &zypper.Repository{
+		Name:    "test",
+		Type:    "rpm-md",
+		Enabled: true,
+		URL:     "http://fake-host.test",
+	}
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// update writes enough packages to need several transactions, each with a
+	// file named after the given version; it fails part way if requested.
+	update := func(version string, fail bool) error {
+		now := time.Now().UTC()
+		return db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			for i := range chunkSize * 2 {
+				if fail && i == chunkSize+1 {
+					return errors.New("interrupted")
+				}
+				name := fmt.Sprintf("pkg-%d", i)
+				f, err := p(&Package{PkgId: name, Name: name, Arch: "noarch", Version: version, Release: "1"})
+				if err != nil {
+					return err
+				}
+				if err := f("/"+version+"/"+name, ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	assert.NilError(t, update("1", false))
+	assert.ErrorContains(t, update("2", true), "interrupted")
+
+	// The data from the interrupted update should not be visible.
+	results, err := db.SearchFile(t.Context(), filter, "/2/*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+	results, err = db.SearchFile(t.Context(), filter, "/1/*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, chunkSize*2))
+
+	// A later update replaces everything.
+	assert.NilError(t, update("3", false))
+	results, err = db.SearchFile(t.Context(), filter, "/*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, chunkSize*2))
+	assert.Check(t, cmp.Equal(results[0].Version, "3"))
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Check(t, cmp.Equal(stats[0].Packages, chunkSize*2))
+}
+
+func TestFirstUpdateInterrupted(t *testing.T) {
+	repo := &zypper.Repository{
+		Name:    "test",
+		Type:    "rpm-md",
+		Enabled: true,
+		URL:     "http://fake-host.test",
+	}
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	now := time.Unix(1231006505, 0).UTC()
+	update := func(fail bool) error {
+		return db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			f, err := p(&Package{PkgId: "pkg", Name: "pkg", Arch: "noarch", Version: "1", Release: "1"})
+			if err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("interrupted")
+			}
+			return f("/usr/bin/pkg", "")
+		})
+	}
+
+	assert.ErrorContains(t, update(true), "interrupted")
+
+	// A repository that was never completely imported was never checked.
+	lastChecked, lastModified, err := db.GetTimestamps(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Check(t, lastChecked.IsZero())
+	assert.Check(t, lastModified.IsZero())
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Check(t, stats[0].LastChecked.IsZero())
+	assert.Check(t, cmp.Equal(stats[0].Packages, 0))
+
+	// Retrying the update works.
+	assert.NilError(t, update(false))
+	lastChecked, _, err = db.GetTimestamps(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(lastChecked, now))
+	results, err := db.SearchFile(t.Context(), filter, "/usr/bin/pkg", "")
 	assert.NilError(t, err)
 	assert.Check(t, cmp.Len(results, 1))
+}
+
+func TestReleaseVers(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// The same URL listed for different releases is cached separately.
+	var repos []*zypper.Repository
+	for _, releaseVer := range []string{"15.6", "16.0"} {
+		repo := &zypper.Repository{
+			Name:       "test",
+			Type:       "rpm-md",
+			Enabled:    true,
+			ReleaseVer: releaseVer,
+			URL:        "http://fake-host.test",
+		}
+		repos = append(repos, repo)
+		now := time.Now().UTC()
+		err := db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			f, err := p(&Package{PkgId: releaseVer, Name: "pkg", Arch: "noarch", Version: releaseVer, Release: "1"})
+			if err != nil {
+				return err
+			}
+			return f("/usr/bin/pkg", "")
+		})
+		assert.NilError(t, err)
+	}
+
+	results, err := db.SearchFile(t.Context(), RepoFilter{Repos: repos}, "/usr/bin/pkg", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 2))
+	for _, result := range results {
+		assert.Check(t, cmp.Equal(result.ReleaseVer, result.Version))
+	}
+
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: repos[1:]}, "/usr/bin/pkg", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].ReleaseVer, "16.0"))
+
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(stats, 2))
+}
+
+func TestSearchDuplicates(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// Two repositories; the same package in both is not a duplicate, but a
+	// different package providing the same file is.
+	var repos []*zypper.Repository
+	for _, name := range []string{"oss", "update"} {
+		repo := &zypper.Repository{
+			Name:    name,
+			Type:    "rpm-md",
+			Enabled: true,
+			URL:     "http://fake-host.test/" + name,
+		}
+		repos = append(repos, repo)
+		now := time.Now().UTC()
+		err := db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			packages := map[string][]string{
+				"python3":     {"/usr/bin/python3", "/usr/bin/pip3", "/usr/lib/python3/site.py"},
+				"python311":   {"/usr/bin/python3", "/usr/bin/pip3"},
+				"unrelated-1": {"/usr/bin/unrelated"},
+			}
+			for pkgName, files := range packages {
+				if name == "oss" && pkgName == "python311" {
+					continue
+				}
+				f, err := p(&Package{
+					PkgId:        name + pkgName,
+					Name:         pkgName,
+					Arch:         "noarch",
+					Version:      "1",
+					Release:      "1",
+					Alternatives: []string{"/usr/bin/pip3"},
+				})
+				if err != nil {
+					return err
+				}
+				for _, file := range files {
+					if err := f(file, ""); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		assert.NilError(t, err)
+	}
+
+	results, err := db.SearchDuplicates(t.Context(), RepoFilter{Repos: repos}, "*")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 3))
+	for _, result := range results {
+		assert.Check(t, cmp.Equal(result.Path, "/usr/bin/python3"))
+	}
+	assert.Check(t, cmp.Equal(results[0].Package, "python3"))
+	assert.Check(t, cmp.Equal(results[2].Package, "python311"))
+
+	// The oss repository on its own has no conflicts.
+	results, err = db.SearchDuplicates(t.Context(), RepoFilter{Repos: repos[:1]}, "*")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	results, err = db.SearchDuplicates(t.Context(), RepoFilter{Repos: repos}, "/usr/lib/*")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	// Files managed by update-alternatives are annotated, and are not
+	// duplicates.
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: repos}, "/usr/bin/pip3", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 3))
+	for _, result := range results {
+		assert.Check(t, result.Alternative)
+	}
+	results, err = db.SearchFile(t.Context(), RepoFilter{Repos: repos}, "/usr/bin/python3", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 3))
+	for _, result := range results {
+		assert.Check(t, !result.Alternative)
+	}
+}
+
+func TestSearchFiles(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		files := map[string]string{
+			"python311-requests": "/usr/lib/python3.11/site-packages/requests/__init__.py",
+			"python311-six":      "/usr/lib/python3.11/site-packages/six.py",
+			"python311-docs":     "/usr/share/doc/requests/__init__.py",
+		}
+		for name, file := range files {
+			f, err := p(&Package{PkgId: name, Name: name, Arch: "noarch"})
+			if err != nil {
+				return err
+			}
+			if err := f(file, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	results, err := db.SearchFiles(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}},
+		[]string{"*/site-packages/requests/__init__.py", "*/site-packages/six.py"}, "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(results, func(r SearchResult) string { return r.Package }),
+		[]string{"python311-requests", "python311-six"}))
+}
+
+func TestSearchSoname(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
 
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		packages := []struct {
+			pkg   Package
+			files []string
+		}{
+			{Package{PkgId: "1", Name: "libz1", Arch: "x86_64", Provides: []string{"libz.so.1()(64bit)"}}, []string{"/usr/lib64/libz.so.1", "/usr/lib64/libz.so.1.3"}},
+			{Package{PkgId: "2", Name: "libz1-32bit", Arch: "x86_64", Provides: []string{"libz.so.1"}}, []string{"/usr/lib/libz.so.1"}},
+			{Package{PkgId: "3", Name: "zlib-docs", Arch: "noarch"}, []string{"/usr/share/doc/libz.so.1"}},
+			{Package{PkgId: "4", Name: "libz-ghost", Arch: "x86_64", FileTypes: map[string]string{"/usr/lib64/libz.so.1": "ghost"}}, []string{"/usr/lib64/libz.so.1"}},
+		}
+		for _, entry := range packages {
+			f, err := p(&entry.pkg)
+			if err != nil {
+				return err
+			}
+			for _, file := range entry.files {
+				if err := f(file, ""); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	results, err := db.SearchSoname(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "libz.so.1", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(results, func(r SearchResult) string { return r.Package + " " + r.Path }),
+		[]string{
+			"libz1 /usr/lib64/libz.so.1",
+			"libz1 libz.so.1()(64bit)",
+			"libz1-32bit /usr/lib/libz.so.1",
+			"libz1-32bit libz.so.1",
+			"libz-ghost /usr/lib64/libz.so.1",
+		}))
+	// The results tell files and provides apart.
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(results, func(r SearchResult) string { return r.PkgId + " " + r.FileType }),
+		[]string{"1 file", "1 provides", "2 file", "2 provides", "4 ghost"}))
+
+	results, err = db.SearchSoname(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "libz.so.*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+}
+
+func TestSearchFilePatterns(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		f, err := p(&Package{PkgId: "1", Name: "pkg", Arch: "noarch"})
+		if err != nil {
+			return err
+		}
+		for _, file := range []string{"/usr/bin/foo", "/usr/bin/foobar", "/usr/lib/foo/bar", "/usr/share/foo[1]", "/etc/foo"} {
+			if err := f(file, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	for pattern, expected := range map[string][]string{
+		"/usr/bin/foo":       {"/usr/bin/foo"},
+		"*/foo":              {"/etc/foo", "/usr/bin/foo"},
+		"/usr/*/foo":         {"/usr/bin/foo"},
+		"/usr/bin/foo*":      {"/usr/bin/foo", "/usr/bin/foobar"},
+		"/usr/*":             {"/usr/bin/foo", "/usr/bin/foobar", "/usr/lib/foo/bar", "/usr/share/foo[1]"},
+		"/usr/lib*":          {"/usr/lib/foo/bar"},
+		"/usr/lib?foo/bar":   {"/usr/lib/foo/bar"},
+		"/usr/share/foo[[]*": {"/usr/share/foo[1]"},
+		"foo":                {},
+	} {
+		results, err := db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, pattern, "")
+		assert.NilError(t, err)
+		paths := itertools.Map(results, func(r SearchResult) string { return r.Path })
+		slices.Sort(paths)
+		assert.Check(t, cmp.DeepEqual(paths, expected), pattern)
+	}
+}
+
+func TestSearchPkgConfig(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		packages := []struct {
+			pkg   Package
+			files []string
+		}{
+			{Package{PkgId: "1", Name: "zlib-devel", Arch: "x86_64", Provides: []string{"pkgconfig(zlib)"}}, []string{"/usr/lib64/pkgconfig/zlib.pc"}},
+			{Package{PkgId: "2", Name: "xorgproto-devel", Arch: "noarch"}, []string{"/usr/share/pkgconfig/xproto.pc"}},
+			{Package{PkgId: "3", Name: "zlib-docs", Arch: "noarch"}, []string{"/usr/share/doc/zlib.pc"}},
+		}
+		for _, entry := range packages {
+			f, err := p(&entry.pkg)
+			if err != nil {
+				return err
+			}
+			for _, file := range entry.files {
+				if err := f(file, ""); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	results, err := db.SearchPkgConfig(t.Context(), filter, "zlib", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(results, func(r SearchResult) string { return r.Package + " " + r.Path }),
+		[]string{
+			"zlib-devel /usr/lib64/pkgconfig/zlib.pc",
+			"zlib-devel pkgconfig(zlib)",
+		}))
+
+	results, err = db.SearchPkgConfig(t.Context(), filter, "xproto", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(results, func(r SearchResult) string { return r.Package + " " + r.Path }),
+		[]string{"xorgproto-devel /usr/share/pkgconfig/xproto.pc"}))
+}
+
+func TestFederate(t *testing.T) {
+	db, err := New(t.Context(), &config.Config{CacheDir: t.TempDir()})
+	assert.NilError(t, err)
+	defer func() {
+		assert.Check(t, db.Close())
+	}()
+
+	// There are more repositories than can be attached at once.
+	var repos []*zypper.Repository
+	now := time.Now().UTC()
+	for i := range maxAttached + 2 {
+		name := fmt.Sprintf("repo-%02d", i)
+		repo := &zypper.Repository{Name: name, Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/" + name}
+		err := db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			f, err := p(&Package{PkgId: name, Name: "pkg-" + name, Arch: "noarch"})
+			if err != nil {
+				return err
+			}
+			return f("/usr/bin/tool", "")
+		})
+		assert.NilError(t, err)
+		repos = append(repos, repo)
+	}
+
+	var explain strings.Builder
+	db.SetExplain(&explain)
+	for range 2 {
+		// The databases are detached again, so this can be repeated.
+		results, err := db.SearchFile(t.Context(), RepoFilter{Repos: repos}, "/usr/bin/tool", "")
+		assert.NilError(t, err)
+		assert.Check(t, cmp.Len(results, len(repos)))
+	}
+	db.SetExplain(nil)
+	// Each search needed one statement for each batch of repositories.
+	assert.Check(t, cmp.Equal(strings.Count(explain.String(), "Query: "), 4))
+	assert.Check(t, cmp.Contains(explain.String(), "repo9.files AS files"))
+	assert.Check(t, cmp.Contains(explain.String(), " UNION ALL "))
+
+	results, err := db.SearchDuplicates(t.Context(), RepoFilter{Repos: repos}, "/usr/bin/*")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, len(repos)))
+}
+
+func TestSearchResultNEVRA(t *testing.T) {
+	result := SearchResult{Package: "vim", Epoch: "0", Version: "9.1", Release: "1.2", Arch: "x86_64"}
+	assert.Check(t, cmp.Equal(result.NEVRA(), "vim-9.1-1.2.x86_64"))
+	result.Epoch = "2"
+	assert.Check(t, cmp.Equal(result.NEVRA(), "vim-2:9.1-1.2.x86_64"))
+}
+
+func TestCorrupt(t *testing.T) {
+	cacheDir := t.TempDir()
+	dbPath := filepath.Join(cacheDir, "filesearch.db")
+	assert.NilError(t, os.WriteFile(dbPath, bytes.Repeat([]byte("not a database"), 1024), 0o644))
+
+	// Opening a damaged file starts again, keeping the old file.
+	db, err := New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	_, err = os.Stat(dbPath + ".corrupt")
+	assert.Check(t, err)
+	_, err = db.Stats(t.Context())
+	assert.Check(t, err)
+
+	// The database can also be rebuilt while open.
+	assert.NilError(t, db.Rebuild(t.Context()))
+	_, err = db.Stats(t.Context())
+	assert.Check(t, err)
+
+	// Damage to the file of a repository only loses that repository.
+	now := time.Now().UTC()
+	var repos []*zypper.Repository
+	for _, name := range []string{"oss", "update"} {
+		repo := &zypper.Repository{Name: name, Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/" + name}
+		err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			f, err := p(&Package{PkgId: name, Name: name, Arch: "noarch"})
+			if err != nil {
+				return err
+			}
+			return f("/some/path", "")
+		})
+		assert.NilError(t, err)
+		repos = append(repos, repo)
+	}
+	assert.NilError(t, db.Close())
+	repoPath := filepath.Join(cacheDir, "repos", repoKey(repos[0].URL, "")+".db")
+	assert.NilError(t, os.WriteFile(repoPath, bytes.Repeat([]byte("not a database"), 1024), 0o644))
+	db, err = New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	results, err := db.SearchFile(t.Context(), RepoFilter{Repos: repos}, "/some/path", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(itertools.Map(results, func(r SearchResult) string { return r.Repository }), []string{"update"}))
+	_, err = os.Stat(repoPath + ".corrupt")
+	assert.Check(t, err)
+	lastChecked, _, err := db.GetTimestamps(t.Context(), repos[0])
+	assert.NilError(t, err)
+	assert.Check(t, lastChecked.IsZero())
+
+	// If a repository is found to be damaged later, only it is rebuilt.
+	db.repos[repoKey(repos[1].URL, "")].corrupt.Store(true)
+	assert.NilError(t, db.Rebuild(t.Context()))
+	lastChecked, _, err = db.GetTimestamps(t.Context(), repos[1])
+	assert.NilError(t, err)
+	assert.Check(t, lastChecked.IsZero())
+	assert.NilError(t, db.Close())
+
+	assert.Check(t, IsCorrupt(sqlite3.Error{Code: sqlite3.ErrCorrupt}))
+	assert.Check(t, !IsCorrupt(errors.New("other")))
+}
+
+func TestDropRepository(t *testing.T) {
+	cacheDir := t.TempDir()
+	db, err := New(t.Context(), &config.Config{CacheDir: cacheDir})
+	assert.NilError(t, err)
+	repo := &zypper.Repository{Name: "test", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		f, err := p(&Package{PkgId: "1", Name: "pkg-name", Arch: "noarch"})
+		if err != nil {
+			return err
+		}
+		return f("/some/path", "")
+	})
+	assert.NilError(t, err)
+
+	assert.NilError(t, db.DropRepository(t.Context(), repo.URL, ""))
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(stats, 0))
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "repos"))
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(entries, 0))
+	assert.Check(t, cmp.ErrorIs(db.DropRepository(t.Context(), repo.URL, ""), ErrNoResults))
+	assert.NilError(t, db.Close())
+}
+
+func TestVerboseSQL(t *testing.T) {
+	var logs strings.Builder
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	db, err := New(t.Context(), &config.Config{CacheDir: t.TempDir(), VerboseSQL: true})
+	assert.NilError(t, err)
+	repo := &zypper.Repository{Name: "test", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test"}
+	now := time.Now().UTC()
+	assert.NilError(t, db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(func(*Package) (func(string, string) error, error)) error {
+		return nil
+	}))
+	_, err = db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.NilError(t, db.Close())
+	assert.Check(t, cmp.Contains(logs.String(), `msg="SQL statement" query="SELECT alias, name`))
+}
+
+func TestHistory(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	for _, query := range []string{"/usr/bin/foo", "/usr/lib64/*.so", "/usr/bin/foo"} {
+		assert.NilError(t, db.AddHistory(t.Context(), "search", query))
+	}
+	assert.NilError(t, db.AddHistory(t.Context(), "list", "/usr/bin/bar"))
+
+	entries, err := db.History(t.Context(), "", "", 10)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(
+		itertools.Map(entries, func(e HistoryEntry) string { return e.Command + " " + e.Query }),
+		[]string{"list /usr/bin/bar", "search /usr/bin/foo", "search /usr/lib64/*.so"}))
+
+	entries, err = db.History(t.Context(), "search", "/usr/bin/", 10)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(entries, 1))
+	assert.Check(t, cmp.Equal(entries[0].Query, "/usr/bin/foo"))
+}
+
+func TestRefreshRecords(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", Alias: "repo-oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		_, err := p(&Package{PkgId: "1", Name: "pkg", Arch: "noarch"})
+		return err
+	})
+	assert.NilError(t, err)
+
+	for i := range maxRefreshRecords + 1 {
+		err := db.AddRefreshRecord(t.Context(), &RefreshRecord{
+			Alias:    repo.Alias,
+			Name:     repo.Name,
+			URL:      repo.URL,
+			Started:  now.Add(time.Duration(i) * time.Second),
+			Duration: 3 * time.Second,
+			Download: time.Second,
+			Bytes:    1024,
+			Packages: i,
+			Files:    10,
+			Outcome:  "updated",
+		})
+		assert.NilError(t, err)
+	}
+	records, err := db.RefreshRecords(t.Context(), 2*maxRefreshRecords)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(records, maxRefreshRecords))
+	assert.Check(t, cmp.DeepEqual(records[0], RefreshRecord{
+		Alias:    repo.Alias,
+		Name:     repo.Name,
+		URL:      repo.URL,
+		Started:  now.Add(maxRefreshRecords * time.Second),
+		Duration: 3 * time.Second,
+		Download: time.Second,
+		Bytes:    1024,
+		Packages: maxRefreshRecords,
+		Files:    10,
+		Outcome:  "updated",
+	}))
+
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Assert(t, cmp.Len(stats[0].Refreshes, statsRefreshRecords))
+	assert.Check(t, cmp.Equal(stats[0].Refreshes[0].Packages, maxRefreshRecords))
+}
+
+func TestRefreshLock(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", URL: "http://fake-host.test/oss"}
+	lock, err := db.GetRefreshLock(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Check(t, lock == nil)
+
+	assert.NilError(t, db.SetRefreshLock(t.Context(), repo, 1234))
+	lock, err = db.GetRefreshLock(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Assert(t, lock != nil)
+	assert.Check(t, cmp.Equal(lock.PID, 1234))
+
+	assert.NilError(t, db.ClearRefreshLock(t.Context(), repo))
+	lock, err = db.GetRefreshLock(t.Context(), repo)
+	assert.NilError(t, err)
+	assert.Check(t, lock == nil)
+}
+
+func TestMirrorLatencies(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	assert.NilError(t, db.SetMirrorLatency(t.Context(), "fast.example.com", 10*time.Millisecond))
+	assert.NilError(t, db.SetMirrorLatency(t.Context(), "down.example.com", 0))
+	assert.NilError(t, db.SetMirrorLatency(t.Context(), "fast.example.com", 20*time.Millisecond))
+
+	latencies, err := db.MirrorLatencies(t.Context(), []string{"fast.example.com", "down.example.com", "new.example.com"})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(latencies, 2))
+	assert.Check(t, cmp.Equal(latencies["fast.example.com"].Latency, 20*time.Millisecond))
+	assert.Check(t, cmp.Equal(latencies["down.example.com"].Latency, time.Duration(0)))
+	assert.Check(t, !latencies["down.example.com"].Checked.IsZero())
+}
+
+func TestSetMirrorLatencies(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// More hosts than fit in one statement are recorded and read in chunks.
+	var entries []MirrorLatency
+	var hosts []string
+	for i := range 2*mirrorBatch + 1 {
+		host := fmt.Sprintf("mirror%d.example.com", i)
+		entries = append(entries, MirrorLatency{Host: host, Latency: time.Duration(i)})
+		hosts = append(hosts, host)
+	}
+	assert.NilError(t, db.SetMirrorLatencies(t.Context(), entries))
+	assert.NilError(t, db.SetMirrorLatencies(t.Context(), nil))
+
+	latencies, err := db.MirrorLatencies(t.Context(), hosts)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(latencies, len(entries)))
+	for _, entry := range entries {
+		assert.Check(t, cmp.Equal(latencies[entry.Host].Latency, entry.Latency), entry.Host)
+		assert.Check(t, !latencies[entry.Host].Checked.IsZero(), entry.Host)
+	}
+}
+
+func TestServiceRepositories(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+	const url = "https://scc.example.com/access/services/1"
+
+	repos, checked, err := db.ServiceRepositories(t.Context(), "SLES", url)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(repos, 0))
+	assert.Check(t, checked.IsZero())
+
+	expected := []*zypper.Repository{
+		{Alias: "SLES:Pool", Name: "Pool", Type: "rpm-md", Enabled: true, Priority: 99, GPGCheck: true, URL: "https://updates.example.com/pool?token", Service: "SLES"},
+		{Alias: "SLES:Debug", Name: "Debug", Type: "rpm-md", Priority: 50, GPGCheck: true, URL: "https://updates.example.com/debug?token", Service: "SLES"},
+	}
+	assert.NilError(t, db.SetServiceRepositories(t.Context(), "SLES", url, expected))
+	repos, checked, err = db.ServiceRepositories(t.Context(), "SLES", url)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(repos, expected))
+	assert.Check(t, !checked.IsZero())
+
+	// A service without repositories is still recorded as listed.
+	assert.NilError(t, db.SetServiceRepositories(t.Context(), "SLES", url, nil))
+	repos, checked, err = db.ServiceRepositories(t.Context(), "SLES", url)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(repos, 0))
+	assert.Check(t, !checked.IsZero())
+
+	// A service that moved has to be listed again.
+	_, checked, err = db.ServiceRepositories(t.Context(), "SLES", url+"/moved")
+	assert.NilError(t, err)
+	assert.Check(t, checked.IsZero())
+}
+
+func TestWarm(t *testing.T) {
+	db, err := NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		f, err := p(&Package{PkgId: "1", Name: "zlib-devel", Arch: "x86_64", Provides: []string{"pkgconfig(zlib)"}})
+		if err != nil {
+			return err
+		}
+		return f("/usr/lib64/pkgconfig/zlib.pc", "")
+	})
+	assert.NilError(t, err)
+
+	assert.NilError(t, db.Warm(t.Context()))
+	var count int
+	assert.NilError(t, db.repos[repoKey(repo.URL, "")].db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM sqlite_stat1`).Scan(&count))
+	assert.Check(t, count > 0)
+}
+
+func TestCompact(t *testing.T) {
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	cfg := &config.Config{CacheDir: t.TempDir(), CompactThreshold: 10}
+	update := func(db *Database, count int) {
+		now := time.Now().UTC()
+		err := db.UpdateRepository(t.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+			for i := range count {
+				name := fmt.Sprintf("pkg%d", i)
+				f, err := p(&Package{PkgId: name, Name: name, Arch: "x86_64"})
+				if err != nil {
+					return err
+				}
+				for j := range 20 {
+					if err := f(fmt.Sprintf("/usr/share/%s/file%d", name, j), ""); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		assert.NilError(t, err)
+	}
+	pragma := func(db *Database, name string) int {
+		var value int
+		r := db.repos[repoKey(repo.URL, "")]
+		assert.NilError(t, r.db.QueryRowContext(t.Context(), "PRAGMA "+name).Scan(&value))
+		return value
+	}
+
+	db, err := New(t.Context(), cfg)
+	assert.NilError(t, err)
+	update(db, 1000)
+	update(db, 1)
+	assert.Check(t, pragma(db, "freelist_count") > 0)
 	assert.NilError(t, db.Close())
+
+	// The repository shrank, so it should have been compacted on close.
+	db, err = New(t.Context(), cfg)
+	assert.NilError(t, err)
+	_, err = db.SearchFile(t.Context(), RepoFilter{Repos: []*zypper.Repository{repo}}, "/usr/share/pkg0/file0", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(pragma(db, "auto_vacuum"), autoVacuumIncremental))
+	assert.Check(t, cmp.Equal(pragma(db, "freelist_count"), 0))
+	pages := pragma(db, "page_count")
+	assert.NilError(t, db.Compact(t.Context()))
+	assert.Check(t, pragma(db, "page_count") <= pages)
+	assert.NilError(t, db.Close())
+}
+
+// newBenchmarkDatabase returns a database with a repository of many packages,
+// each with many files.
+func newBenchmarkDatabase(b *testing.B) (*Database, *zypper.Repository) {
+	db, err := New(b.Context(), &config.Config{CacheDir: b.TempDir()})
+	assert.NilError(b, err)
+	b.Cleanup(func() {
+		_ = db.Close()
+	})
+	repo := &zypper.Repository{Name: "oss", Type: "rpm-md", Enabled: true, URL: "http://fake-host.test/oss"}
+	now := time.Now().UTC()
+	err = db.UpdateRepository(b.Context(), repo, now, now, Provenance{}, func(p func(*Package) (func(string, string) error, error)) error {
+		for i := range 2000 {
+			name := fmt.Sprintf("pkg%d", i)
+			f, err := p(&Package{PkgId: name, Name: name, Arch: "x86_64"})
+			if err != nil {
+				return err
+			}
+			for j := range 50 {
+				if err := f(fmt.Sprintf("/usr/share/%s/file%d", name, j), ""); err != nil {
+					return err
+				}
+			}
+			if err := f("/usr/bin/"+name, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NilError(b, err)
+	return db, repo
+}
+
+func BenchmarkSearchFile(b *testing.B) {
+	db, repo := newBenchmarkDatabase(b)
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	for _, pattern := range []string{"/usr/bin/pkg1234", "/usr/share/pkg12*/*", "*/file42"} {
+		b.Run(pattern, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := db.SearchFile(b.Context(), filter, pattern, ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkListPackage(b *testing.B) {
+	db, repo := newBenchmarkDatabase(b)
+	filter := RepoFilter{Repos: []*zypper.Repository{repo}}
+	for b.Loop() {
+		if _, err := db.ListPackage(b.Context(), filter, "", "pkg1234"); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
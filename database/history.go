@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// maxHistory is the number of history entries to keep.
+const maxHistory = 1000
+
+// HistoryEntry is a previously run query.
+type HistoryEntry struct {
+	XMLName   xml.Name  `json:"-" xml:"query"`
+	Command   string    `json:"command" xml:"command,attr"`
+	Query     string    `json:"query" xml:"query,attr"`
+	Timestamp time.Time `json:"timestamp" xml:"timestamp,attr"`
+}
+
+// AddHistory records a query that was run, discarding the oldest entries.
+func (d *Database) AddHistory(ctx context.Context, command, query string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO history (command, query, timestamp) VALUES (?, ?, ?)`,
+		command, query, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM history WHERE id <= (SELECT MAX(id) FROM history) - ?`, maxHistory)
+	if err != nil {
+		return fmt.Errorf("failed to prune history: %w", err)
+	}
+	return tx.Commit()
+}
+
+// History returns the most recent distinct queries, newest first.  If command
+// is not empty, only queries for that command are returned; if prefix is not
+// empty, only queries starting with it are returned.
+func (d *Database) History(ctx context.Context, command, prefix string, limit int) ([]HistoryEntry, error) {
+	filter := `SELECT MAX(id) FROM history WHERE 1`
+	var args []any
+	if command != "" {
+		filter += ` AND command == ?`
+		args = append(args, command)
+	}
+	if prefix != "" {
+		// Use substr() rather than LIKE/GLOB to avoid special characters.
+		filter += ` AND substr(query, 1, ?) == ?`
+		args = append(args, len(prefix), prefix)
+	}
+	filter += ` GROUP BY command, query`
+	query := `SELECT command, query, timestamp FROM history ` +
+		`WHERE id IN (` + filter + `) ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var results []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.Command, &entry.Query, &entry.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		entry.Timestamp = entry.Timestamp.UTC()
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	return results, nil
+}
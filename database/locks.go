@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// RefreshLock describes the process refreshing a repository.  The lock itself
+// is a file lock held by that process; this is only recorded so that other
+// processes can report who they are waiting for.
+type RefreshLock struct {
+	PID     int
+	Started time.Time
+}
+
+// SetRefreshLock records that this process is refreshing the repository.
+func (d *Database) SetRefreshLock(ctx context.Context, repo *zypper.Repository, pid int) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO refreshLocks (url, releasever, pid, started) VALUES (?, ?, ?, ?)`,
+		repo.URL, repo.ReleaseVer, pid, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record refresh lock for %s: %w", repo.Name, err)
+	}
+	return nil
+}
+
+// ClearRefreshLock records that the repository is no longer being refreshed.
+func (d *Database) ClearRefreshLock(ctx context.Context, repo *zypper.Repository) error {
+	_, err := d.db.ExecContext(ctx,
+		`DELETE FROM refreshLocks WHERE url = ? AND releasever = ?`, repo.URL, repo.ReleaseVer)
+	if err != nil {
+		return fmt.Errorf("failed to clear refresh lock for %s: %w", repo.Name, err)
+	}
+	return nil
+}
+
+// GetRefreshLock returns the recorded holder of the refresh lock of the
+// repository, or nil if none is recorded.
+func (d *Database) GetRefreshLock(ctx context.Context, repo *zypper.Repository) (*RefreshLock, error) {
+	var lock RefreshLock
+	err := d.reader.QueryRowContext(ctx,
+		`SELECT pid, started FROM refreshLocks WHERE url = ? AND releasever = ?`, repo.URL, repo.ReleaseVer).
+		Scan(&lock.PID, &lock.Started)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get refresh lock for %s: %w", repo.Name, err)
+	}
+	lock.Started = lock.Started.UTC()
+	return &lock, nil
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// loggingConn is a connection that logs every statement executed on it, along
+// with its arguments and how long it took.  For queries, the time is until the
+// first row is available, not until all rows have been read.
+type loggingConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// logStatement logs a statement that was executed.
+func logStatement(ctx context.Context, query string, args []driver.NamedValue, start time.Time, err error) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	attrs := []any{"query", query, "args", values, "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.InfoContext(ctx, "SQL statement", attrs...)
+}
+
+func (c *loggingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	logStatement(ctx, query, args, start, err)
+	return result, err
+}
+
+func (c *loggingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	logStatement(ctx, query, args, start, err)
+	return rows, err
+}
+
+func (c *loggingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &loggingStmt{SQLiteStmt: stmt.(*sqlite3.SQLiteStmt), query: query}, nil
+}
+
+// loggingStmt is a prepared statement that logs each time it is executed.
+type loggingStmt struct {
+	*sqlite3.SQLiteStmt
+	query string
+}
+
+func (s *loggingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := s.SQLiteStmt.ExecContext(ctx, args)
+	logStatement(ctx, s.query, args, start, err)
+	return result, err
+}
+
+func (s *loggingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	logStatement(ctx, s.query, args, start, err)
+	return rows, err
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/itertools"
+)
+
+// mirrorBatch is the number of hosts looked up or recorded in one statement,
+// to stay well below the number of variables SQLite allows.
+const mirrorBatch = 100
+
+// MirrorLatency is the result of the last probe of a mirror host.
+type MirrorLatency struct {
+	Host string
+	// The time taken to respond; zero if the mirror was unreachable.
+	Latency time.Duration
+	Checked time.Time
+}
+
+// MirrorLatencies returns the last known latency of each of the given hosts;
+// hosts that have never been probed are omitted.
+func (d *Database) MirrorLatencies(ctx context.Context, hosts []string) (map[string]MirrorLatency, error) {
+	results := make(map[string]MirrorLatency)
+	if len(hosts) == 0 {
+		return results, nil
+	}
+	for _, batch := range itertools.Chunk(hosts, mirrorBatch) {
+		if err := d.queryMirrorLatencies(ctx, batch, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// queryMirrorLatencies adds the last known latencies of the given hosts to
+// results.
+func (d *Database) queryMirrorLatencies(ctx context.Context, hosts []string, results map[string]MirrorLatency) error {
+	args := make([]any, 0, len(hosts))
+	for _, host := range hosts {
+		args = append(args, host)
+	}
+	query := `SELECT host, latency, checked FROM mirrors WHERE host IN (` +
+		strings.Repeat("?, ", len(hosts)-1) + `?)`
+	rows, err := d.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query mirror latencies: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var entry MirrorLatency
+		var latency int64
+		if err := rows.Scan(&entry.Host, &latency, &entry.Checked); err != nil {
+			return fmt.Errorf("failed to read mirror latency: %w", err)
+		}
+		entry.Latency = time.Duration(latency)
+		entry.Checked = entry.Checked.UTC()
+		results[entry.Host] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading query results: %w", err)
+	}
+	return nil
+}
+
+// SetMirrorLatency records the latency of a mirror host; a zero latency marks
+// it as unreachable.
+func (d *Database) SetMirrorLatency(ctx context.Context, host string, latency time.Duration) error {
+	return d.SetMirrorLatencies(ctx, []MirrorLatency{{Host: host, Latency: latency}})
+}
+
+// SetMirrorLatencies records the latencies of several mirror hosts in one
+// transaction, all checked now; the Checked field of the entries is ignored.
+func (d *Database) SetMirrorLatencies(ctx context.Context, entries []MirrorLatency) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	checked := time.Now().UTC()
+	for _, batch := range itertools.Chunk(entries, mirrorBatch) {
+		args := make([]any, 0, 3*len(batch))
+		for _, entry := range batch {
+			args = append(args, entry.Host, int64(entry.Latency), checked)
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO mirrors (host, latency, checked) VALUES `+
+				strings.Repeat("(?, ?, ?), ", len(batch)-1)+`(?, ?, ?)`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to record mirror latencies: %w", err)
+		}
+	}
+	return tx.Commit()
+}
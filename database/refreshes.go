@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// maxRefreshRecords is the number of refresh records to keep.
+const maxRefreshRecords = 500
+
+// statsRefreshRecords is the number of refresh records included in the
+// statistics of each repository.
+const statsRefreshRecords = 5
+
+// refreshesSchema records how each refresh of a repository went; like the
+// history, it is kept when the cached data is dropped.
+const refreshesSchema = `CREATE TABLE IF NOT EXISTS refreshes (` +
+	`id INTEGER PRIMARY KEY AUTOINCREMENT, ` +
+	`alias TEXT, ` +
+	`name TEXT, ` +
+	`url TEXT, ` +
+	`releasever TEXT, ` +
+	`started DATE, ` +
+	`duration INTEGER, ` +
+	`download INTEGER, ` +
+	`bytes INTEGER, ` +
+	`packages INTEGER, ` +
+	`files INTEGER, ` +
+	`outcome TEXT, ` +
+	`error TEXT)`
+
+// RefreshRecord describes one refresh of a repository.  The time spent
+// downloading is included in the duration; the remainder was spent parsing the
+// metadata and importing it.
+type RefreshRecord struct {
+	XMLName    xml.Name      `json:"-" xml:"refresh"`
+	Alias      string        `json:"alias" xml:"alias,attr"`
+	Name       string        `json:"name" xml:"name,attr"`
+	URL        string        `json:"url" xml:"url,attr"`
+	ReleaseVer string        `json:"releasever,omitempty" xml:"releasever,attr,omitempty"`
+	Started    time.Time     `json:"started" xml:"started,attr"`
+	Duration   time.Duration `json:"duration" xml:"duration,attr"`
+	Download   time.Duration `json:"download" xml:"download,attr"`
+	Bytes      int64         `json:"bytes" xml:"bytes,attr"`
+	Packages   int           `json:"packages" xml:"packages,attr"`
+	Files      int           `json:"files" xml:"files,attr"`
+	Outcome    string        `json:"outcome" xml:"outcome,attr"`
+	Error      string        `json:"error,omitempty" xml:"error,attr,omitempty"`
+}
+
+// AddRefreshRecord records a refresh of a repository, discarding the oldest
+// records.
+func (d *Database) AddRefreshRecord(ctx context.Context, record *RefreshRecord) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refreshes (`+
+			`alias, name, url, releasever, started, duration, download, bytes, packages, files, outcome, error`+
+			`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Alias, record.Name, record.URL, record.ReleaseVer, record.Started.UTC(),
+		record.Duration, record.Download, record.Bytes, record.Packages, record.Files,
+		record.Outcome, record.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record refresh of %s: %w", record.Name, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM refreshes WHERE id <= (SELECT MAX(id) FROM refreshes) - ?`, maxRefreshRecords)
+	if err != nil {
+		return fmt.Errorf("failed to prune refresh records: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RefreshRecords returns the most recent refreshes, newest first.
+func (d *Database) RefreshRecords(ctx context.Context, limit int) ([]RefreshRecord, error) {
+	return d.queryRefreshRecords(ctx, `1`, nil, limit)
+}
+
+// queryRefreshRecords returns the most recent refreshes matching the filter,
+// newest first.
+func (d *Database) queryRefreshRecords(ctx context.Context, filter string, args []any, limit int) ([]RefreshRecord, error) {
+	rows, err := d.reader.QueryContext(ctx,
+		`SELECT alias, name, url, releasever, started, duration, download, bytes, packages, files, outcome, error `+
+			`FROM refreshes WHERE `+filter+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query refresh records: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var results []RefreshRecord
+	for rows.Next() {
+		var record RefreshRecord
+		err := rows.Scan(&record.Alias, &record.Name, &record.URL, &record.ReleaseVer, &record.Started,
+			&record.Duration, &record.Download, &record.Bytes, &record.Packages, &record.Files,
+			&record.Outcome, &record.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read refresh records: %w", err)
+		}
+		record.Started = record.Started.UTC()
+		results = append(results, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	return results, nil
+}
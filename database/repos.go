@@ -0,0 +1,577 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/mook-as/zypper-filesearch/itertools"
+)
+
+// repoDatabase is the index of a single repository.  Each repository is kept
+// in its own database file, so that it can be rebuilt, dropped, or copied
+// elsewhere without touching the others; damage to one file only loses that
+// repository.
+type repoDatabase struct {
+	// The name of the file, without the extension; see repoKey.
+	key string
+	// The database file; empty for in-memory databases.
+	path string
+	// The URI to attach the database read-only; see Database.federate.
+	uri string
+	// db is used for writing, and reader for queries, as for Database.
+	db     *sql.DB
+	reader *sql.DB
+	// The name and $releasever of the repository, for ordering results; these
+	// are empty until the repository has been updated, and are protected by
+	// Database.mu.
+	name, releasever string
+	// Whether the repository was updated, so the file should be tidied on
+	// close.
+	updated atomic.Bool
+	// Whether a query found the file to be damaged; see Database.Rebuild.
+	corrupt atomic.Bool
+	// The marker that the file may not be synced to disk, which is locked for
+	// as long as this process writes to it; see markDirty.
+	dirty atomic.Pointer[os.File]
+	// The percentage of the file that must be unused for it to be compacted
+	// on close, if it was updated; see compact.
+	compactThreshold int
+}
+
+// repoKey returns the name of the database file (without the extension) for
+// the repository with the given URL and $releasever.
+func repoKey(url, releasever string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + releasever))
+	return hex.EncodeToString(sum[:])
+}
+
+// openRepo returns the database of the repository with the given key, opening
+// it if needed.  If the repository is not cached, nil is returned, unless
+// create is set, in which case an empty database is created.  A damaged file
+// is replaced with an empty one; only that repository is lost.
+func (d *Database) openRepo(ctx context.Context, key string, create bool) (*repoDatabase, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.repos[key]; ok {
+		return r, nil
+	}
+	r := &repoDatabase{key: key, compactThreshold: d.compactThreshold}
+	if d.repoDir == "" {
+		if !create {
+			return nil, nil
+		}
+		// The database is named, so that it can be attached to other
+		// connections; it lives as long as the connection below.
+		r.uri = "file:" + d.memoryName + "-" + key + "?mode=memory&cache=shared"
+		r.db = sql.OpenDB(newConnector(r.uri, nil, false))
+		r.db.SetMaxOpenConns(1)
+		r.reader = r.db
+		if err := initialize(ctx, r.db, &repoSchema); err != nil {
+			_ = r.db.Close()
+			return nil, fmt.Errorf("failed to initialize database: %w", err)
+		}
+	} else {
+		r.path = filepath.Join(d.repoDir, key+".db")
+		r.uri = "file:" + r.path + "?mode=ro"
+		if _, err := os.Stat(r.path); !create && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		var err error
+		r.db, r.reader, err = d.openFile(ctx, r.path, &repoSchema)
+		if IsCorrupt(err) {
+			slog.WarnContext(ctx, "Repository database is corrupt, recreating it", "path", r.path, "error", err)
+			if err := moveAside(r.path); err != nil {
+				return nil, err
+			}
+			r.db, r.reader, err = d.openFile(ctx, r.path, &repoSchema)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkDirty(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	err := r.reader.QueryRowContext(ctx, `SELECT name, releasever FROM repositories`).Scan(&r.name, &r.releasever)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		_ = r.close(ctx)
+		return nil, fmt.Errorf("failed to read repository database %s: %w", key, err)
+	}
+	d.repos[key] = r
+	return r, nil
+}
+
+// filterRepos returns the databases of the cached repositories selected by the
+// filter, ordered by name.  Queries must still apply the rest of the filter.
+func (d *Database) filterRepos(ctx context.Context, filter RepoFilter) ([]*repoDatabase, error) {
+	var repos []*repoDatabase
+	for _, repo := range filter.Repos {
+		r, err := d.openRepo(ctx, repoKey(repo.URL, repo.ReleaseVer), false)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && !slices.Contains(repos, r) {
+			repos = append(repos, r)
+		}
+	}
+	d.sortRepos(repos)
+	return repos, nil
+}
+
+// allRepos returns the databases of every cached repository, ordered by name.
+func (d *Database) allRepos(ctx context.Context) ([]*repoDatabase, error) {
+	if d.repoDir != "" {
+		entries, err := os.ReadDir(d.repoDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to list repository databases: %w", err)
+		}
+		for _, entry := range entries {
+			if key, ok := strings.CutSuffix(entry.Name(), ".db"); ok && entry.Type().IsRegular() {
+				if _, err := d.openRepo(ctx, key, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	d.mu.Lock()
+	repos := slices.Collect(maps.Values(d.repos))
+	d.mu.Unlock()
+	d.sortRepos(repos)
+	return repos, nil
+}
+
+// sortRepos sorts the repository databases by name and $releasever, which is
+// the order results are returned in.
+func (d *Database) sortRepos(repos []*repoDatabase) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	slices.SortFunc(repos, func(a, b *repoDatabase) int {
+		return cmp.Or(
+			cmp.Compare(a.name, b.name),
+			cmp.Compare(a.releasever, b.releasever),
+			cmp.Compare(a.key, b.key))
+	})
+}
+
+// maxAttached is the number of databases that can be attached to a single
+// connection, as SQLite is built.
+const maxAttached = 10
+
+// attachedSchema returns the name of the schema the repository at the given
+// index is attached as; see Database.federate.
+func attachedSchema(i int) string {
+	return fmt.Sprintf("repo%d", i)
+}
+
+// federate calls fn with a connection to the main database that has the
+// databases of the repositories attached, along with the schemas they are
+// attached as, so that all of them can be queried in a single statement.
+// Only a limited number of databases can be attached at once, so fn may be
+// called several times, with some of the repositories each time.
+func (d *Database) federate(ctx context.Context, repos []*repoDatabase, fn func(*sql.Conn, []string) error) error {
+	for _, batch := range itertools.Chunk(repos, maxAttached) {
+		if err := d.federateBatch(ctx, batch, fn); err != nil {
+			if IsCorrupt(err) {
+				// Find out which of the files is damaged, so that Rebuild
+				// doesn't discard the others.
+				for _, r := range batch {
+					if err := r.integrityCheck(ctx); err != nil {
+						r.corrupt.Store(true)
+					}
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// federateBatch calls fn with a connection with the databases of the
+// repositories attached, detaching them afterwards.
+func (d *Database) federateBatch(ctx context.Context, repos []*repoDatabase, fn func(*sql.Conn, []string) error) error {
+	conn, err := d.reader.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var schemas []string
+	defer func() {
+		// The connection is returned to the pool, so it must be left as it
+		// was; if that fails, it is discarded instead.
+		for _, schema := range schemas {
+			if _, err := conn.ExecContext(context.WithoutCancel(ctx), `DETACH DATABASE `+schema); err != nil {
+				_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+				return
+			}
+		}
+	}()
+	for i, r := range repos {
+		schema := attachedSchema(i)
+		if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS `+schema, r.uri); err != nil {
+			return fmt.Errorf("failed to attach database of %s: %w", r.name, err)
+		}
+		schemas = append(schemas, schema)
+	}
+	return fn(conn, schemas)
+}
+
+// eachRepo calls fn for each of the repositories in turn, stopping at the
+// first error.  Repositories whose files turn out to be damaged are noted, so
+// that Rebuild can replace them.
+func eachRepo(repos []*repoDatabase, fn func(*repoDatabase) error) error {
+	for _, r := range repos {
+		if err := fn(r); err != nil {
+			if IsCorrupt(err) {
+				r.corrupt.Store(true)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// close the repository database.  If it was updated, it is compacted if
+// needed, the write-ahead log is folded back into the database, and the query
+// planner statistics are refreshed.  A database marked dirty is synced to disk
+// as part of that, and the mark is then removed.
+func (r *repoDatabase) close(ctx context.Context) error {
+	var errs []error
+	if r.reader != r.db {
+		// Close the readers first, so they don't block the checkpoint.
+		errs = append(errs, r.reader.Close())
+	}
+	dirty := r.dirty.Load()
+	synced := false
+	if r.updated.Load() {
+		if err := compact(ctx, r.db, r.compactThreshold); err != nil {
+			slog.WarnContext(ctx, "Failed to compact database", "repository", r.name, "error", err)
+		}
+	}
+	if r.updated.Load() || dirty != nil {
+		if dirty != nil {
+			if _, err := r.db.ExecContext(ctx, "PRAGMA synchronous = FULL"); err != nil {
+				slog.WarnContext(ctx, "Failed to enable syncing database", "error", err)
+			} else {
+				synced = true
+			}
+		}
+		var busy, walPages, checkpointed int
+		err := r.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &walPages, &checkpointed)
+		if err == nil && busy != 0 {
+			err = errors.New("database is busy")
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to checkpoint database", "repository", r.name, "error", err)
+			synced = false
+		}
+		if _, err := r.db.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+			slog.WarnContext(ctx, "Failed to tidy database", "pragma", "PRAGMA optimize", "error", err)
+		}
+	}
+	errs = append(errs, r.db.Close())
+	if dirty != nil {
+		if synced {
+			// Everything is now on disk.
+			if err := os.Remove(dirty.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("failed to remove dirty marker: %w", err))
+			}
+		}
+		// Closing the file releases the lock; if the marker is still there,
+		// the database is checked the next time it is opened.
+		errs = append(errs, dirty.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// dirtySuffix is added to the path of a database file for the marker that it
+// may not have been synced to disk.
+const dirtySuffix = "-dirty"
+
+// markDirty records (on disk) that the database is about to be written
+// without syncing, so that if it is not closed cleanly, it is checked the next
+// time it is opened; see Database.checkDirty.  The marker is locked until the
+// database is closed, so that other processes can tell it apart from one left
+// behind by a crash.
+func (r *repoDatabase) markDirty() error {
+	if r.path == "" || r.dirty.Load() != nil {
+		return nil
+	}
+	var f *os.File
+	for {
+		var err error
+		f, err = os.OpenFile(r.path+dirtySuffix, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to mark database dirty: %w", err)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to lock dirty marker: %w", err)
+		}
+		// Another process may have removed the marker after checking the
+		// database, before we locked it; if so, create it again.
+		if isCurrentFile(f) {
+			break
+		}
+		_ = f.Close()
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to mark database dirty: %w", err)
+	}
+	if !r.dirty.CompareAndSwap(nil, f) {
+		_ = f.Close()
+	}
+	return nil
+}
+
+// checkDirty checks the integrity of a repository database that was being
+// imported without syncing to disk and was not closed cleanly (for example,
+// because of a crash or a power loss).  A damaged file is replaced with an
+// empty one; only that repository is lost.  Databases that another process is
+// still importing into are left alone.
+func (d *Database) checkDirty(ctx context.Context, r *repoDatabase) error {
+	f, err := os.Open(r.path + dirtySuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open dirty marker: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil
+		}
+		return fmt.Errorf("failed to lock dirty marker: %w", err)
+	}
+	if !isCurrentFile(f) {
+		// The marker was replaced by a process that started importing.
+		return nil
+	}
+	if damaged := r.integrityCheck(ctx); damaged != nil {
+		slog.WarnContext(ctx, "Database is damaged after fast import, discarding it", "path", r.path, "error", damaged)
+		_ = r.reader.Close()
+		_ = r.db.Close()
+		if err := moveAside(r.path); err != nil {
+			return err
+		}
+		if r.db, r.reader, err = d.openFile(ctx, r.path, &repoSchema); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(f.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove dirty marker: %w", err)
+	}
+	return nil
+}
+
+// isCurrentFile returns whether the open file is still the one at its path.
+func isCurrentFile(f *os.File) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(f.Name())
+	return err == nil && os.SameFile(opened, current)
+}
+
+// integrityCheck returns an error describing the problems with the database,
+// if there are any.
+func (r *repoDatabase) integrityCheck(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return fmt.Errorf("failed to check database integrity: %w", err)
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DropRepository removes the cached index of the repository with the given URL
+// and $releasever; it will be fetched again on the next refresh.
+func (d *Database) DropRepository(ctx context.Context, url, releasever string) error {
+	r, err := d.openRepo(ctx, repoKey(url, releasever), false)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return ErrNoResults
+	}
+	d.mu.Lock()
+	delete(d.repos, r.key)
+	d.mu.Unlock()
+	r.updated.Store(false)
+	if err := r.close(ctx); err != nil {
+		return fmt.Errorf("failed to close repository database: %w", err)
+	}
+	if r.path == "" {
+		return nil
+	}
+	for _, suffix := range []string{"", "-wal", "-shm", dirtySuffix} {
+		if err := os.Remove(r.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove repository database: %w", err)
+		}
+	}
+	return nil
+}
+
+// legacyTables are the tables of the repositories, children first; before
+// version 19 of the main database, they were kept in it rather than in a file
+// for each repository.
+var legacyTables = []string{"changelogs", "provides", "files", "packages", "repositories"}
+
+// splitRepositories moves the repositories from the main database file, where
+// they were kept before version 19, into their own files.  Repositories that
+// were already moved (by an earlier, interrupted, attempt) are skipped.
+func (d *Database) splitRepositories(ctx context.Context) error {
+	var legacy bool
+	err := d.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) > 0 FROM sqlite_schema WHERE type == 'table' AND name == 'repositories'`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("failed to check for repositories to move: %w", err)
+	}
+	if !legacy || d.repoDir == "" {
+		return nil
+	}
+
+	type legacyRepo struct {
+		id                    int64
+		name, url, releasever string
+	}
+	var repos []legacyRepo
+	rows, err := d.db.QueryContext(ctx, `SELECT id, name, url, releasever FROM repositories`)
+	if err != nil {
+		return fmt.Errorf("failed to list repositories to move: %w", err)
+	}
+	for rows.Next() {
+		var repo legacyRepo
+		if err := rows.Scan(&repo.id, &repo.name, &repo.url, &repo.releasever); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to read repository to move: %w", err)
+		}
+		repos = append(repos, repo)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list repositories to move: %w", err)
+	}
+
+	// The other database is attached to the connection, so everything must
+	// be done on the same one.
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	for _, repo := range repos {
+		slog.DebugContext(ctx, "Moving repository into its own database", "repository", repo.name)
+		r, err := d.openRepo(ctx, repoKey(repo.url, repo.releasever), true)
+		if err != nil {
+			return err
+		}
+		if err := copyRepository(ctx, conn, r.path, repo.id); err != nil {
+			return fmt.Errorf("failed to move repository %s: %w", repo.name, err)
+		}
+		d.mu.Lock()
+		r.name, r.releasever = repo.name, repo.releasever
+		d.mu.Unlock()
+		r.updated.Store(true)
+	}
+	for _, table := range legacyTables {
+		if _, err := conn.ExecContext(ctx, `DROP TABLE `+table); err != nil {
+			return fmt.Errorf("failed to remove moved repositories: %w", err)
+		}
+	}
+	// Most of the main database is now unused.
+	if err := compact(ctx, d.db, d.compactThreshold); err != nil {
+		slog.WarnContext(ctx, "Failed to compact database", "error", err)
+	}
+	return nil
+}
+
+// legacyRepositoryColumns are the columns of the repositories table in the
+// main database, before each repository was moved into its own file.
+const legacyRepositoryColumns = `id, alias, name, url, releasever, type, enabled, priority, gpgcheck, keeppackages, ` +
+	`lastChecked, lastModified, revision, checksum, generation`
+
+// copyRepository copies the repository with the given id, from the tables in
+// the main database, into the (empty) repository database file.
+func copyRepository(ctx context.Context, conn *sql.Conn, path string, id int64) error {
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS repo`, path); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), `DETACH DATABASE repo`)
+	}()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var copied bool
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM repo.repositories`).Scan(&copied); err != nil {
+		return err
+	}
+	if copied {
+		return nil
+	}
+	for _, stmt := range []string{
+		// The legacy table lacks the columns added since.
+		`INSERT INTO repo.repositories (` + legacyRepositoryColumns + `) ` +
+			`SELECT ` + legacyRepositoryColumns + ` FROM main.repositories WHERE id == ?`,
+		`INSERT INTO repo.packages SELECT * FROM main.packages WHERE repository == ?`,
+		`INSERT INTO repo.files (pkgid, dir, name, digest, alternative) ` +
+			`SELECT files.pkgid, files.dir, files.name, files.digest, files.alternative FROM main.files ` +
+			`INNER JOIN main.packages ON files.pkgid == packages.id WHERE packages.repository == ?`,
+		`INSERT INTO repo.changelogs SELECT changelogs.* FROM main.changelogs ` +
+			`INNER JOIN main.packages ON changelogs.pkgid == packages.id WHERE packages.repository == ?`,
+		`INSERT INTO repo.provides SELECT provides.* FROM main.provides ` +
+			`INNER JOIN main.packages ON provides.pkgid == packages.id WHERE packages.repository == ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return fmt.Errorf("%q: %w", stmt, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// servicesSchema holds the repositories last listed in the index of each
+// repository index service, so that the index does not need to be downloaded
+// on every run.  A service is keyed by its alias and URL (without
+// credentials); a service without any repositories only has a row in the
+// services table.
+var servicesSchema = []string{
+	`CREATE TABLE services (` +
+		`alias TEXT, ` +
+		`url TEXT, ` +
+		`checked DATE, ` +
+		`PRIMARY KEY (alias, url))`,
+	`CREATE TABLE serviceRepositories (` +
+		`service TEXT, ` +
+		`serviceURL TEXT, ` +
+		`alias TEXT, ` +
+		`name TEXT, ` +
+		`url TEXT, ` +
+		`enabled BOOLEAN, ` +
+		`priority INTEGER, ` +
+		`FOREIGN KEY (service, serviceURL) REFERENCES services(alias, url) ON DELETE CASCADE)`,
+}
+
+// ServiceRepositories returns the repositories last recorded for the service
+// with the given alias and URL, and when they were listed; if the service was
+// never listed, the time is zero.
+func (d *Database) ServiceRepositories(ctx context.Context, alias, url string) ([]*zypper.Repository, time.Time, error) {
+	var checked time.Time
+	err := d.reader.QueryRowContext(ctx,
+		`SELECT checked FROM services WHERE alias = ? AND url = ?`, alias, url).Scan(&checked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to query service %s: %w", alias, err)
+	}
+	rows, err := d.reader.QueryContext(ctx,
+		`SELECT alias, name, url, enabled, priority FROM serviceRepositories `+
+			`WHERE service = ? AND serviceURL = ? ORDER BY rowid`, alias, url)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to query repositories of service %s: %w", alias, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var repos []*zypper.Repository
+	for rows.Next() {
+		repo := &zypper.Repository{Type: "rpm-md", GPGCheck: true, Service: alias}
+		if err := rows.Scan(&repo.Alias, &repo.Name, &repo.URL, &repo.Enabled, &repo.Priority); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to read repository of service %s: %w", alias, err)
+		}
+		repos = append(repos, repo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error reading query results: %w", err)
+	}
+	return repos, checked.UTC(), nil
+}
+
+// SetServiceRepositories records the repositories listed in the index of the
+// service with the given alias and URL, replacing those recorded before.
+func (d *Database) SetServiceRepositories(ctx context.Context, alias, url string, repos []*zypper.Repository) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM serviceRepositories WHERE service = ? AND serviceURL = ?`, alias, url)
+	if err != nil {
+		return fmt.Errorf("failed to clear repositories of service %s: %w", alias, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO services (alias, url, checked) VALUES (?, ?, ?)`,
+		alias, url, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record service %s: %w", alias, err)
+	}
+	for _, repo := range repos {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO serviceRepositories (service, serviceURL, alias, name, url, enabled, priority) `+
+				`VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			alias, url, repo.Alias, repo.Name, repo.URL, repo.Enabled, repo.Priority)
+		if err != nil {
+			return fmt.Errorf("failed to record repository %s of service %s: %w", repo.Alias, alias, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// warmQueries read every page of the tables and indexes used by searches.
+var warmQueries = []string{
+	`SELECT SUM(LENGTH(name)) FROM files`,
+	`SELECT COUNT(*) FROM files INDEXED BY files_name`,
+	`SELECT COUNT(*) FROM files INDEXED BY files_dir`,
+	`SELECT COUNT(*) FROM files INDEXED BY files_digest WHERE digest IS NOT NULL`,
+	`SELECT SUM(LENGTH(name)) FROM packages`,
+	`SELECT SUM(LENGTH(name)) FROM provides`,
+	`SELECT COUNT(*) FROM provides INDEXED BY provides_name`,
+	`SELECT COUNT(*) FROM provides INDEXED BY provides_pkgid`,
+	`SELECT COUNT(*) FROM changelogs INDEXED BY changelogs_pkgid`,
+}
+
+// Warm prepares the database for searching: the query planner statistics are
+// gathered for each repository, and its tables and indexes are read so that they are in the
+// operating system's page cache.  This way, the first search after a refresh
+// doesn't pay for it.
+func (d *Database) Warm(ctx context.Context) error {
+	start := time.Now()
+	repos, err := d.allRepos(ctx)
+	if err != nil {
+		return err
+	}
+	err = eachRepo(repos, func(r *repoDatabase) error {
+		if _, err := r.db.ExecContext(ctx, `ANALYZE`); err != nil {
+			return fmt.Errorf("failed to analyze database of %s: %w", r.name, err)
+		}
+		for _, query := range warmQueries {
+			var ignored any
+			if err := r.reader.QueryRowContext(ctx, query).Scan(&ignored); err != nil {
+				return fmt.Errorf("failed to read database of %s: %q: %w", r.name, query, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Database warmed", "repositories", len(repos), "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Package fuzzy implements approximate string matching.
+package fuzzy
+
+import (
+	"strings"
+)
+
+// Distance calculates the Levenshtein distance between two strings.
+func Distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// IsSubsequence returns whether all the characters of needle appear in
+// haystack in order (though not necessarily consecutively).
+func IsSubsequence(needle, haystack string) bool {
+	for i := 0; i < len(haystack) && len(needle) > 0; i++ {
+		if haystack[i] == needle[0] {
+			needle = needle[1:]
+		}
+	}
+	return len(needle) == 0
+}
+
+// MaxDistance returns the maximum edit distance considered a match for a
+// string of the given length.
+func MaxDistance(length int) int {
+	switch {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Score how well the base name of the given path matches the pattern; lower is
+// better, and -1 indicates no match.  Names within a small edit distance are
+// preferred over subsequence (fzf-style) matches.
+func Score(path, pattern string) int {
+	name := path[strings.LastIndexByte(path, '/')+1:]
+	maxDistance := MaxDistance(len(pattern))
+	if diff := len(name) - len(pattern); diff >= -maxDistance && diff <= maxDistance {
+		if distance := Distance(name, pattern); distance <= maxDistance {
+			return distance
+		}
+	}
+	if IsSubsequence(pattern, name) {
+		return maxDistance + 1 + len(name) - len(pattern)
+	}
+	return -1
+}
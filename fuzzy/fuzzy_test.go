@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package fuzzy
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestScore(t *testing.T) {
+	for _, tc := range []struct {
+		path, pattern string
+		expected      int
+	}{
+		{"/usr/lib64/libcrypto.so.3", "libcrypto.so.3", 0},
+		{"/usr/lib64/libcrypto.so", "libcryto.so", 1},
+		{"/usr/bin/python3", "pyton3", 1},
+		{"/usr/lib64/libcrypto.so.3", "libcrypto", MaxDistance(9) + 1 + 5},
+		{"/usr/bin/python3", "ruby", -1},
+		{"/usr/bin/python3", "bin", -1},
+	} {
+		assert.Check(t, cmp.Equal(Score(tc.path, tc.pattern), tc.expected), "%s ~ %s", tc.path, tc.pattern)
+	}
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Package hook runs user-configured commands when something of interest
+// happens, such as a repository being updated.
+package hook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// EventRefreshSuccess is sent when a repository was updated.
+	EventRefreshSuccess = "refresh-success"
+	// EventNewMatch is sent when a watched pattern has a new match.
+	EventNewMatch = "new-match"
+
+	// envPrefix is the prefix of the environment variables describing events.
+	envPrefix = "ZYPPER_FILESEARCH_"
+)
+
+// Run the shell command for the event.  The event name is passed in the
+// environment as ZYPPER_FILESEARCH_EVENT, and each of the variables as
+// ZYPPER_FILESEARCH_<NAME>, with the name upper-cased.  Anything the command
+// prints goes to standard error, so that it is not mixed into the results.
+func Run(ctx context.Context, command, event string, vars map[string]string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), envPrefix+"EVENT="+event)
+	for name, value := range vars {
+		cmd.Env = append(cmd.Env, envPrefix+strings.ToUpper(name)+"="+value)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %s hook: %w", event, err)
+	}
+	return nil
+}
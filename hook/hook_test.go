@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package hook
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	command := `printf '%s %s %s' "$ZYPPER_FILESEARCH_EVENT" "$ZYPPER_FILESEARCH_REPOSITORY" "$ZYPPER_FILESEARCH_URL" > ` + out
+	err := Run(t.Context(), command, EventRefreshSuccess, map[string]string{
+		"repository": "repo-oss",
+		"url":        "http://fake-host.test",
+	})
+	assert.NilError(t, err)
+	contents, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(string(contents), "refresh-success repo-oss http://fake-host.test"))
+
+	// Output from hooks must not end up with the results.
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	r, w, err := os.Pipe()
+	assert.NilError(t, err)
+	os.Stdout, os.Stderr = nil, w
+	err = Run(t.Context(), "echo hello", EventNewMatch, nil)
+	os.Stdout, os.Stderr = stdout, stderr
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	contents, err = io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(string(contents), "hello\n"))
+
+	err = Run(t.Context(), "exit 3", EventNewMatch, nil)
+	assert.Check(t, cmp.ErrorContains(err, "failed to run new-match hook"))
+}
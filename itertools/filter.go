@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package itertools
+
+// Filter returns the elements of x for which f returns true, in order.
+func Filter[S ~[]E, E any](x S, f func(E) bool) S {
+	var results S
+	for _, e := range x {
+		if f(e) {
+			results = append(results, e)
+		}
+	}
+	return results
+}
+
+// Chunk splits x into consecutive slices of at most size elements; only the
+// last one may be shorter.  The chunks share the backing array of x.  Chunk
+// panics if size is less than 1.
+func Chunk[S ~[]E, E any](x S, size int) []S {
+	if size < 1 {
+		panic("itertools: chunk size must be positive")
+	}
+	results := make([]S, 0, (len(x)+size-1)/size)
+	for len(x) > size {
+		results = append(results, x[:size:size])
+		x = x[size:]
+	}
+	if len(x) > 0 {
+		results = append(results, x)
+	}
+	return results
+}
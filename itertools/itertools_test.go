@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package itertools
+
+import (
+	"bytes"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestFilter(t *testing.T) {
+	even := func(i int) bool { return i%2 == 0 }
+	assert.Check(t, cmp.DeepEqual(Filter([]int{1, 2, 3, 4, 6}, even), []int{2, 4, 6}))
+	assert.Check(t, cmp.Len(Filter([]int{1, 3}, even), 0))
+	assert.Check(t, cmp.Len(Filter([]int(nil), even), 0))
+}
+
+func TestChunk(t *testing.T) {
+	assert.Check(t, cmp.DeepEqual(Chunk([]int{1, 2, 3, 4, 5}, 2), [][]int{{1, 2}, {3, 4}, {5}}))
+	assert.Check(t, cmp.DeepEqual(Chunk([]int{1, 2}, 2), [][]int{{1, 2}}))
+	assert.Check(t, cmp.Len(Chunk([]int{}, 3), 0))
+
+	// Appending to a chunk must not overwrite the next one.
+	chunks := Chunk([]int{1, 2, 3, 4}, 2)
+	_ = append(chunks[0], 99)
+	assert.Check(t, cmp.DeepEqual(chunks[1], []int{3, 4}))
+
+	assert.Check(t, cmp.Panics(func() { Chunk([]int{1}, 0) }))
+}
+
+func FuzzChunk(f *testing.F) {
+	f.Add([]byte("hello, world"), 5)
+	f.Add([]byte{}, 1)
+	f.Add([]byte("x"), 100)
+	f.Fuzz(func(t *testing.T, data []byte, size int) {
+		if size < 1 {
+			t.Skip()
+		}
+		chunks := Chunk(data, size)
+		assert.Check(t, cmp.Equal(len(chunks), (len(data)+size-1)/size))
+		for i, chunk := range chunks {
+			assert.Check(t, len(chunk) > 0 && len(chunk) <= size, "chunk %d has length %d", i, len(chunk))
+			if i < len(chunks)-1 {
+				assert.Check(t, cmp.Len(chunk, size))
+			}
+		}
+		assert.Check(t, bytes.Equal(slices.Concat(chunks...), data))
+	})
+}
+
+func FuzzFilter(f *testing.F) {
+	f.Add([]byte("hello, world"), byte('l'))
+	f.Fuzz(func(t *testing.T, data []byte, threshold byte) {
+		below := Filter(data, func(b byte) bool { return b < threshold })
+		atLeast := Filter(data, func(b byte) bool { return b >= threshold })
+		assert.Check(t, cmp.Equal(len(below)+len(atLeast), len(data)))
+		for _, b := range below {
+			assert.Check(t, b < threshold)
+		}
+		for _, b := range atLeast {
+			assert.Check(t, b >= threshold)
+		}
+	})
+}
+
+func TestParallelMap(t *testing.T) {
+	input := make([]int, 50)
+	for i := range input {
+		input[i] = i
+	}
+	for _, limit := range []int{0, 1, 4} {
+		var running, peak atomic.Int32
+		results := ParallelMap(input, limit, func(i int) int {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				old := peak.Load()
+				if current <= old || peak.CompareAndSwap(old, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return i * 2
+		})
+		assert.Check(t, cmp.DeepEqual(results, Map(input, func(i int) int { return i * 2 })), "limit %d", limit)
+		if limit > 0 {
+			assert.Check(t, peak.Load() <= int32(limit), "limit %d exceeded: %d", limit, peak.Load())
+		}
+	}
+}
+
+func FuzzParallelMap(f *testing.F) {
+	f.Add([]byte("hello, world"), int8(3))
+	f.Add([]byte{}, int8(1))
+	f.Add([]byte("x"), int8(0))
+	f.Add([]byte("abcdefgh"), int8(-1))
+	f.Fuzz(func(t *testing.T, data []byte, limit int8) {
+		var running, peak atomic.Int32
+		results := ParallelMap(data, int(limit), func(b byte) int {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				old := peak.Load()
+				if current <= old || peak.CompareAndSwap(old, current) {
+					break
+				}
+			}
+			// Give other calls a chance to overlap with this one.
+			runtime.Gosched()
+			return int(b) + 1
+		})
+		// The results are in the order of the input, whatever the limit.
+		assert.Check(t, cmp.DeepEqual(results, Map(data, func(b byte) int { return int(b) + 1 })))
+		if limit > 0 {
+			assert.Check(t, peak.Load() <= int32(limit), "limit %d exceeded: %d", limit, peak.Load())
+		}
+		assert.Check(t, peak.Load() <= int32(len(data)))
+	})
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package itertools
+
+import "golang.org/x/sync/errgroup"
+
+// ParallelMap is like Map, but calls f concurrently, with at most limit calls
+// running at once; a limit of zero or less means no limit.  The results are
+// in the same order as the input.
+func ParallelMap[S ~[]E, E, R any](x S, limit int, f func(E) R) []R {
+	results := make([]R, len(x))
+	var group errgroup.Group
+	if limit > 0 {
+		group.SetLimit(limit)
+	}
+	for i, e := range x {
+		group.Go(func() error {
+			results[i] = f(e)
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return results
+}
@@ -5,44 +5,61 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path"
 	"slices"
 	"strings"
-	"text/tabwriter"
+	"syscall"
 
 	"github.com/mook-as/zypper-filesearch/cmd"
-	"github.com/mook-as/zypper-filesearch/cmd/filelist"
-	"github.com/mook-as/zypper-filesearch/cmd/filesearch"
+	_ "github.com/mook-as/zypper-filesearch/cmd/cache"
+	_ "github.com/mook-as/zypper-filesearch/cmd/changelog"
+	_ "github.com/mook-as/zypper-filesearch/cmd/check"
+	_ "github.com/mook-as/zypper-filesearch/cmd/compare"
+	_ "github.com/mook-as/zypper-filesearch/cmd/conflicts"
+	_ "github.com/mook-as/zypper-filesearch/cmd/duplicates"
+	_ "github.com/mook-as/zypper-filesearch/cmd/filelist"
+	_ "github.com/mook-as/zypper-filesearch/cmd/filesearch"
+	_ "github.com/mook-as/zypper-filesearch/cmd/history"
+	_ "github.com/mook-as/zypper-filesearch/cmd/missinglibs"
+	_ "github.com/mook-as/zypper-filesearch/cmd/refresh"
+	_ "github.com/mook-as/zypper-filesearch/cmd/repocontents"
+	_ "github.com/mook-as/zypper-filesearch/cmd/shellinit"
 	"github.com/mook-as/zypper-filesearch/config"
 	"github.com/mook-as/zypper-filesearch/database"
-	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/output"
 	"github.com/mook-as/zypper-filesearch/repository"
 	"github.com/mook-as/zypper-filesearch/zypper"
 )
 
 func run(ctx context.Context) error {
-	var cmd cmd.CommandRunner
-
-	exe, err := os.Executable()
-	if err != nil {
+	command, prog, args, err := cmd.Find(os.Args)
+	if errors.Is(err, flag.ErrHelp) {
+		cmd.PrintUsage(os.Stdout)
+		return nil
+	} else if err != nil {
+		var usageErr *cmd.UsageError
+		if errors.As(err, &usageErr) {
+			cmd.PrintUsage(os.Stderr)
+		}
 		return err
 	}
-	if strings.HasSuffix(exe, "zypper-file-list") {
-		cmd = filelist.New()
-	} else {
-		cmd = filesearch.New()
-	}
+	runner := command.New()
 
-	config.AddFlags()
-	cmd.AddFlags()
-	flag.Parse()
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() { command.PrintUsage(prog, flags) }
+	config.AddFlags(flags)
+	runner.AddFlags(flags)
+	// With flag.ExitOnError, parsing failures exit directly.
+	_ = flags.Parse(args)
 
-	cfg, err := config.Read(ctx)
+	cfg, err := config.Read(ctx, flags)
 	if err != nil {
 		return fmt.Errorf("failed to read configuration: %w", err)
 	}
@@ -51,122 +68,332 @@ func run(ctx context.Context) error {
 	if cfg.Verbose {
 		logOptions.Level = slog.LevelDebug
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &logOptions)))
+	switch cfg.LogFormat {
+	case config.LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &logOptions)))
+	default:
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &logOptions)))
+	}
 
-	slog.DebugContext(ctx, "Initial setup complete")
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	slog.DebugContext(ctx, "Initial setup complete", "command", command.Name)
 	// Make sure we can get the arch.
 	if _, err := zypper.Arch(); err != nil {
 		return err
 	}
+	// Check the columns before doing any work, so mistakes are caught early.
+	columns, err := cmd.SearchResultColumns(cfg)
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+	// Compressed output is binary; refuse to write it to a terminal.
+	if cfg.Compress != config.CompressionNone && cfg.OutputFile == "" && output.IsTerminal(os.Stdout) {
+		flags.Usage()
+		return fmt.Errorf("%w: refusing to write compressed output to a terminal; use -output", cmd.ErrUsage)
+	}
 
 	slog.DebugContext(ctx, "Opening database")
-	db, err := database.New(ctx)
+	db, err := database.New(ctx, cfg)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = db.Close()
 	}()
+	db.SetRepoLabel(cfg.RepoLabel)
+	if cfg.Explain {
+		db.SetExplain(os.Stderr)
+	}
 	slog.DebugContext(ctx, "Database opened")
 
-	repos, err := zypper.ListRepositories(ctx, cfg.ReleaseVer)
+	skipRefresh := command.SkipRefresh
+	if skipper, ok := runner.(cmd.RefreshSkipper); ok && skipper.SkipRefresh() {
+		skipRefresh = true
+	}
+	repos, err := listRepositories(ctx, cfg, db, !skipRefresh)
 	if err != nil {
 		return err
 	}
-	if cfg.Enabled {
+	repos = slices.DeleteFunc(repos, func(r *zypper.Repository) bool {
+		for _, pattern := range cfg.ExcludeRepos {
+			if matched, _ := path.Match(pattern, r.Alias); matched {
+				slog.DebugContext(ctx, "Excluding repository", "repository", r.Alias, "pattern", pattern)
+				return true
+			}
+		}
+		return false
+	})
+	// Disabled repositories may still be searched, but are only refreshed if
+	// the enabled filter is turned off.
+	searchRepos := slices.Clone(repos)
+	if cfg.DisabledOnly {
+		repos = slices.DeleteFunc(repos, func(r *zypper.Repository) bool {
+			return r.Enabled
+		})
+	} else if cfg.Enabled {
 		// Filter out disabled repositories
 		repos = slices.DeleteFunc(repos, func(r *zypper.Repository) bool {
 			return !r.Enabled
 		})
 	}
-	if err := repository.Refresh(ctx, db, repos); err != nil {
-		return err
+	if !cfg.AllRepos || cfg.DisabledOnly {
+		searchRepos = repos
+	}
+	var statuses []*repository.RefreshStatus
+	if !skipRefresh {
+		if statuses, err = refresh(ctx, cfg, db, repos); err != nil {
+			return err
+		}
+	}
+	// Report any failures after the results, so they are not lost.  This uses
+	// a closure, as the statuses change if the database is rebuilt.
+	defer func() {
+		reportRefreshFailures(ctx, statuses)
+	}()
+
+	var outputFile *output.File
+	if cfg.OutputFile != "" {
+		if outputFile, err = output.CreateFile(cfg.OutputFile, cfg.OutputAppend); err != nil {
+			return err
+		}
+		// If anything fails, the output is discarded rather than committed.
+		defer func() {
+			_ = outputFile.Close()
+		}()
+		cmd.Stdout = outputFile
+	}
+	var compressor io.WriteCloser
+	if cfg.Compress != config.CompressionNone {
+		if compressor, err = output.Compress(cmd.Stdout, cfg.Compress); err != nil {
+			return err
+		}
+		cmd.Stdout = compressor
+	}
+	output.TableLayout = output.Layout{Width: cfg.Width, MaxColumnWidth: cfg.MaxColumnWidth, Wrap: cfg.Wrap}
+	if cfg.Width == 0 && outputFile == nil && compressor == nil {
+		output.TableLayout.Width = output.TerminalWidth(os.Stdout)
 	}
 
-	results, err := cmd.Run(ctx, cfg, db, repos)
+	results, err := runner.Run(ctx, cfg, db, searchRepos, flags.Args())
+	if database.IsCorrupt(err) {
+		slog.WarnContext(ctx, "Database is corrupt, rebuilding it", "error", err)
+		if err := db.Rebuild(ctx); err != nil {
+			return err
+		}
+		if !skipRefresh {
+			if statuses, err = refresh(ctx, cfg, db, repos); err != nil {
+				return err
+			}
+		}
+		results, err = runner.Run(ctx, cfg, db, searchRepos, flags.Args())
+	}
+	if errors.Is(err, cmd.ErrUsage) {
+		flags.Usage()
+		return err
+	}
+	if command.History && cfg.History && flags.NArg() > 0 {
+		// Record the query even if there were no results, so that it can be
+		// retried later.
+		if err := db.AddHistory(ctx, command.Name, strings.Join(flags.Args(), " ")); err != nil {
+			slog.WarnContext(ctx, "Failed to record query history", "error", err)
+		}
+	}
 	if err != nil {
 		return err
 	}
-
-	if len(results) == 0 {
-		return fmt.Errorf("no results found")
+	if cfg.ShowRepoFile {
+		cmd.AddRepoFiles(results, searchRepos)
 	}
 
-	switch cfg.Format {
-	case config.OutputFormatJSON:
-		encoder := json.NewEncoder(os.Stdout)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(results); err != nil {
+	// If there are no results, the command has produced any output it needs to
+	// itself.
+	if len(results) > 0 {
+		if err := output.Write(cmd.Stdout, cfg.Format, results, columns); err != nil {
 			return err
 		}
-	case config.OutputFormatXML:
-		encoder := xml.NewEncoder(os.Stdout)
-		encoder.Indent("", "  ")
-		if err := encoder.Encode(results); err != nil {
-			return err
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("failed to compress output: %w", err)
 		}
-	case config.OutputFormatHuman:
-		type field struct {
-			Name  string
-			Value func(result database.SearchResult) string
-		}
-		writer := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
-		fields := []field{
-			{
-				Name:  "Repository",
-				Value: func(result database.SearchResult) string { return result.Repository },
-			},
-			{
-				Name:  "Package",
-				Value: func(result database.SearchResult) string { return result.Package },
-			},
-			{
-				Name: "Version",
-				Value: func(result database.SearchResult) string {
-					version := result.Version
-					if result.Epoch != "" && result.Epoch != "0" {
-						version = result.Epoch + ":" + version
-					}
-					if result.Release != "" {
-						version += "-" + result.Release
-					}
-					return version
-				},
-			},
-			{
-				Name:  "Arch",
-				Value: func(result database.SearchResult) string { return result.Arch },
-			},
-			{
-				Name:  "File",
-				Value: func(result database.SearchResult) string { return result.Path },
-			},
-		}
-		writeLine := func(f func(field) string) error {
-			_, err := fmt.Fprintf(writer, "%s\n", strings.Join(itertools.Map(fields, f), "\t"))
+	}
+	if outputFile != nil {
+		if err := outputFile.Commit(); err != nil {
 			return err
 		}
+	}
+	if finisher, ok := runner.(cmd.Finisher); ok && len(results) > 0 {
+		return finisher.Finish(ctx, cfg, os.Stderr, results)
+	}
+	return nil
+}
 
-		if err := writeLine(func(f field) string { return f.Name }); err != nil {
-			return err
+// listRepositories returns the repositories for each requested release, or
+// just the system's if none were requested, followed by those given with
+// -add-repo.  The repositories of services are only looked up again if they
+// are about to be refreshed; see repository.ServiceRepositories.
+func listRepositories(ctx context.Context, cfg *config.Config, db *database.Database, refresh bool) ([]*zypper.Repository, error) {
+	releaseVers := cfg.ReleaseVers
+	if len(releaseVers) == 0 {
+		releaseVers = []string{""}
+	}
+	var repos []*zypper.Repository
+	for _, releaseVer := range releaseVers {
+		opts := zypper.Options{
+			ReleaseVer:  releaseVer,
+			InstallRoot: cfg.InstallRoot,
+			Arch:        cfg.Arch,
 		}
-		if err := writeLine(func(f field) string { return "---" }); err != nil {
-			return err
+		listed, err := zypper.ListRepositories(ctx, opts)
+		// Fresh container images may not even have a repository directory.
+		if err != nil && !(cfg.DefaultRepos && errors.Is(err, os.ErrNotExist)) {
+			return nil, err
 		}
-		for _, result := range results {
-			if err := writeLine(func(f field) string { return f.Value(result) }); err != nil {
-				return err
+		discovered, err := repository.ServiceRepositories(ctx, cfg, db, opts, listed, refresh)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to list the repositories of some services", "error", err)
+		}
+		for _, repo := range discovered {
+			slog.DebugContext(ctx, "Using repository of unrefreshed service", "repository", repo.Alias, "service", repo.Service)
+		}
+		listed = append(listed, discovered...)
+		if len(listed) == 0 {
+			if listed, err = defaultRepositories(ctx, cfg, opts); err != nil {
+				return nil, err
 			}
 		}
-		if err := writer.Flush(); err != nil {
-			return err
+		for _, repo := range listed {
+			repo.ReleaseVer = releaseVer
 		}
+		repos = append(repos, listed...)
+	}
+	for _, spec := range cfg.AddRepos {
+		repo, err := repository.NewAdded(spec)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// defaultRepositories returns the standard repositories of the distribution,
+// for when none are configured; unless DefaultRepos is set, this only suggests
+// doing so.
+func defaultRepositories(ctx context.Context, cfg *config.Config, opts zypper.Options) ([]*zypper.Repository, error) {
+	if !cfg.DefaultRepos {
+		if len(cfg.AddRepos) == 0 {
+			slog.WarnContext(ctx, "No repositories are configured; use -default-repos to use the standard repositories of this distribution")
+		}
+		return nil, nil
+	}
+	repos, err := zypper.DefaultRepositories(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		slog.InfoContext(ctx, "Using default repository", "repository", repo.Alias, "url", repo.URL)
+	}
+	return repos, nil
+}
+
+// refresh updates the cached metadata of the repositories.  Failing to refresh
+// some repositories is only an error with StrictRefresh; otherwise, the
+// failures are recorded in the returned statuses.
+func refresh(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]*repository.RefreshStatus, error) {
+	statuses, err := repository.Refresh(ctx, cfg, db, repos)
+	for _, status := range statuses {
+		slog.DebugContext(ctx, "Repository refreshed",
+			"repository", status.Repo.Alias, "state", status.State)
+	}
+	if err != nil && cfg.StrictRefresh {
+		return nil, err
+	} else if ctx.Err() != nil {
+		// Interrupted; the failures are not interesting.
+		return nil, ctx.Err()
+	}
+	return statuses, nil
+}
+
+// reportRefreshFailures logs a summary of the repositories that could not be
+// refreshed, if there were any.
+func reportRefreshFailures(ctx context.Context, statuses []*repository.RefreshStatus) {
+	var failed []string
+	for _, status := range statuses {
+		if status.State == repository.RefreshFailed || status.State == repository.RefreshStale {
+			slog.WarnContext(ctx, "Failed to refresh repository",
+				"repository", status.Repo.Alias, "error", status.Err)
+			failed = append(failed, status.Repo.Alias)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	slog.WarnContext(ctx, "Some repositories could not be refreshed; results may be incomplete or stale",
+		"failed", len(failed), "total", len(statuses), "repositories", failed)
+}
+
+const (
+	// exitTimedOut is the exit code used when the timeout expires, matching
+	// timeout(1).
+	exitTimedOut = 124
+	// exitSignal is added to the number of the signal that interrupted the
+	// program to give the exit code, matching what shells report: 130 for
+	// SIGINT, and 143 for SIGTERM.
+	exitSignal = 128
+)
+
+// errSignal is the cause of the context being cancelled by a signal.
+type errSignal struct {
+	signal syscall.Signal
+}
+
+func (e *errSignal) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.signal)
+}
+
+// notifyContext returns a context that is cancelled when SIGINT or SIGTERM is
+// received, with an *errSignal as the cause, so that the signal is known.
+// Calling stop restores the default handling of the signals.
+func notifyContext(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			cancel(&errSignal{signal: sig.(syscall.Signal)})
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
 	}
-	return nil
 }
 
 func main() {
-	err := run(context.Background())
+	// Cancelling the context aborts downloads and rolls back any open
+	// transaction, leaving the database consistent.
+	ctx, stop := notifyContext(context.Background())
+	err := run(ctx)
+	var interrupted *errSignal
+	isInterrupted := errors.As(context.Cause(ctx), &interrupted)
+	stop()
+	if isInterrupted {
+		slog.Error("Interrupted", "signal", interrupted.signal)
+		os.Exit(exitSignal + int(interrupted.signal))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("Timed out", "error", err)
+		os.Exit(exitTimedOut)
+	}
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
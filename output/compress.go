@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mook-as/zypper-filesearch/config"
+)
+
+// Compress returns a writer that compresses its output to w using the given
+// algorithm; it must be closed to flush the output.
+func Compress(w io.Writer, compression config.Compression) (io.WriteCloser, error) {
+	switch compression {
+	case config.CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case config.CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File is an output file that is written atomically: the output goes to a
+// temporary file in the same directory, which replaces the destination only
+// when committed.  This ensures that readers never see partial output.
+type File struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+// CreateFile starts writing to the file at the given path.  If append is set,
+// the existing contents of the file (if any) are kept, and the new output is
+// added after them.
+func CreateFile(path string, append bool) (*File, error) {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	f := &File{File: temp, path: path}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := temp.Chmod(mode); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to set output file permissions: %w", err)
+	}
+	if append {
+		existing, err := os.Open(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to read existing output: %w", err)
+		} else if err == nil {
+			defer func() {
+				_ = existing.Close()
+			}()
+			if _, err := io.Copy(temp, existing); err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("failed to copy existing output: %w", err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Commit replaces the destination with everything written so far.
+func (f *File) Commit() error {
+	if err := f.File.Sync(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := f.File.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to replace output file: %w", err)
+	}
+	f.committed = true
+	return nil
+}
+
+// Close discards the output if it has not been committed.
+func (f *File) Close() error {
+	if f.committed {
+		return nil
+	}
+	_ = f.File.Close()
+	return os.Remove(f.File.Name())
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// The minimum width and the padding of columns in human-readable output.
+const (
+	columnMinWidth = 3
+	columnPadding  = 2
+)
+
+// wrapMinWidth is the width columns that may be wrapped are narrowed to
+// before any others.
+const wrapMinWidth = 20
+
+// Layout limits the width of human-readable output.
+type Layout struct {
+	// The width to fit the output in; zero means no limit.
+	Width int
+	// The maximum width of any one column; zero means no limit.  Column
+	// names are never cut short.
+	MaxColumnWidth int
+	// Whether to wrap long values of columns that allow it onto more lines,
+	// instead of cutting them short with an ellipsis.
+	Wrap bool
+}
+
+// TableLayout is the layout of human-readable output.
+var TableLayout Layout
+
+// TerminalWidth returns the width of the terminal the file refers to, or zero
+// if it is not a terminal.
+func TerminalWidth(f *os.File) int {
+	width, _, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// IsTerminal returns whether the file refers to a terminal.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// widths returns the width to show each column at, given the rows to show
+// (including the column names in the first row).  Columns are first limited
+// to MaxColumnWidth; then, if they do not fit in Width, the widest ones are
+// narrowed until they do, but never below the width of their name.  If
+// wrapping is enabled, columns that may be wrapped are narrowed first.
+func (l Layout) widths(rows [][]string, wrappable []bool) []int {
+	if len(rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(rows[0]))
+	minWidths := make([]int, len(rows[0]))
+	for i, name := range rows[0] {
+		minWidths[i] = utf8.RuneCountInString(name)
+	}
+	for _, row := range rows {
+		for i, value := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(value))
+		}
+	}
+	if l.MaxColumnWidth > 0 {
+		for i := range widths {
+			widths[i] = max(min(widths[i], l.MaxColumnWidth), minWidths[i])
+		}
+	}
+	if l.Width <= 0 {
+		return widths
+	}
+	// The last column is not padded.
+	total := widths[len(widths)-1]
+	for _, width := range widths[:len(widths)-1] {
+		total += max(width, columnMinWidth) + columnPadding
+	}
+	// widestAbove returns the widest column wider than its floor, if any.
+	widestAbove := func(floor func(int) int) int {
+		widest := -1
+		for i, width := range widths {
+			if width > floor(i) && (widest < 0 || width > widths[widest]) {
+				widest = i
+			}
+		}
+		return widest
+	}
+	for total > l.Width {
+		widest := -1
+		if l.Wrap {
+			widest = widestAbove(func(i int) int {
+				if !wrappable[i] {
+					return widths[i]
+				}
+				return max(minWidths[i], wrapMinWidth)
+			})
+		}
+		if widest < 0 {
+			widest = widestAbove(func(i int) int { return minWidths[i] })
+		}
+		if widest < 0 {
+			break // Nothing more can be narrowed.
+		}
+		if widest == len(widths)-1 || widths[widest] > columnMinWidth {
+			total--
+		}
+		widths[widest]--
+	}
+	return widths
+}
+
+// truncate cuts the value short to the given width, ending it with an
+// ellipsis if anything was removed.
+func truncate(value string, width int) string {
+	if utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:max(width-1, 0)]) + "…"
+}
+
+// wrap splits the value into lines of at most the given width, breaking
+// after a slash where possible so that paths are split between directories.
+func wrap(value string, width int) []string {
+	var lines []string
+	runes := []rune(value)
+	for len(runes) > width {
+		end := width
+		if i := strings.LastIndex(string(runes[:width]), "/"); i > 0 {
+			end = utf8.RuneCountInString(string(runes[:width])[:i]) + 1
+		}
+		lines = append(lines, string(runes[:end]))
+		runes = runes[end:]
+	}
+	return append(lines, string(runes))
+}
+
+// fit returns the lines to write for a row of values, so that each column is
+// at most the given width: values of columns that may be wrapped are split
+// over several lines (if wrapping is enabled), and any other long values are
+// cut short.
+func (l Layout) fit(values []string, wrappable []bool, widths []int) [][]string {
+	lines := [][]string{make([]string, len(values))}
+	for i, value := range values {
+		if utf8.RuneCountInString(value) <= widths[i] {
+			lines[0][i] = value
+			continue
+		}
+		if !l.Wrap || !wrappable[i] {
+			lines[0][i] = truncate(value, widths[i])
+			continue
+		}
+		for j, part := range wrap(value, widths[i]) {
+			if j >= len(lines) {
+				lines = append(lines, make([]string, len(values)))
+			}
+			lines[j][i] = part
+		}
+	}
+	return lines
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestLayoutWidths(t *testing.T) {
+	long := [][]string{
+		{"Name", "Path"},
+		{strings.Repeat("n", 25), strings.Repeat("p", 30)},
+	}
+	for name, tc := range map[string]struct {
+		layout    Layout
+		rows      [][]string
+		wrappable []bool
+		expected  []int
+	}{
+		"unlimited": {
+			rows:     [][]string{{"Name", "File"}, {"foo", "/usr/bin/foo"}},
+			expected: []int{4, 12},
+		},
+		"max column width": {
+			layout:   Layout{MaxColumnWidth: 5},
+			rows:     [][]string{{"Name", "File"}, {"foo-devel", "/usr/bin/foo"}},
+			expected: []int{5, 5},
+		},
+		"max column width floor": {
+			// Column names are never cut short.
+			layout:   Layout{MaxColumnWidth: 3},
+			rows:     [][]string{{"Repository", "File"}, {"r", "/usr/bin/foo"}},
+			expected: []int{10, 4},
+		},
+		"narrowed": {
+			// The widest column is narrowed first, then both in turn.
+			layout:   Layout{Width: 20},
+			rows:     [][]string{{"A", "B"}, {strings.Repeat("a", 10), strings.Repeat("b", 20)}},
+			expected: []int{9, 9},
+		},
+		"narrowed without wrapping": {
+			layout:    Layout{Width: 45},
+			rows:      long,
+			wrappable: []bool{false, true},
+			expected:  []int{21, 22},
+		},
+		"narrowed with wrapping": {
+			// Wrapped columns are narrowed (down to wrapMinWidth) before
+			// any others.
+			layout:    Layout{Width: 45, Wrap: true},
+			rows:      long,
+			wrappable: []bool{false, true},
+			expected:  []int{23, 20},
+		},
+		"too narrow": {
+			layout:   Layout{Width: 1},
+			rows:     [][]string{{"Name", "File"}, {"foo", "/usr/bin/foo"}},
+			expected: []int{4, 4},
+		},
+		"no rows": {
+			layout: Layout{Width: 80},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wrappable := tc.wrappable
+			if wrappable == nil && len(tc.rows) > 0 {
+				wrappable = make([]bool, len(tc.rows[0]))
+			}
+			assert.Check(t, cmp.DeepEqual(tc.layout.widths(tc.rows, wrappable), tc.expected))
+		})
+	}
+}
+
+func TestLayoutFit(t *testing.T) {
+	values := []string{"foo-devel", "/usr/share/doc/foo"}
+	wrappable := []bool{false, true}
+	widths := []int{5, 10}
+
+	// Without wrapping, long values are cut short.
+	assert.Check(t, cmp.DeepEqual(Layout{}.fit(values, wrappable, widths), [][]string{
+		{"foo-…", "/usr/shar…"},
+	}))
+	// With wrapping, only columns that allow it are wrapped.
+	assert.Check(t, cmp.DeepEqual(Layout{Wrap: true}.fit(values, wrappable, widths), [][]string{
+		{"foo-…", "/usr/"},
+		{"", "share/doc/"},
+		{"", "foo"},
+	}))
+	// Values that fit are left alone.
+	assert.Check(t, cmp.DeepEqual(Layout{Wrap: true}.fit([]string{"foo", "/usr"}, wrappable, widths), [][]string{
+		{"foo", "/usr"},
+	}))
+}
+
+func TestWrap(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		width    int
+		expected []string
+	}{
+		{"/usr/bin/foo", 20, []string{"/usr/bin/foo"}},
+		// Lines are broken after a slash where possible.
+		{"/usr/share/doc/foo", 10, []string{"/usr/", "share/doc/", "foo"}},
+		// Widths are counted in runes, not bytes.
+		{"/äöü/ßß/x", 5, []string{"/äöü/", "ßß/x"}},
+		// Without a slash, lines are broken at the width.
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+		// A leading slash alone is not a place to break.
+		{"/abcdef", 4, []string{"/abc", "def"}},
+	} {
+		assert.Check(t, cmp.DeepEqual(wrap(tc.value, tc.width), tc.expected), "%q (%d)", tc.value, tc.width)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		width    int
+		expected string
+	}{
+		{"hello", 5, "hello"},
+		{"hello world", 5, "hell…"},
+		{"äöüßx", 3, "äö…"},
+		{"hello", 1, "…"},
+	} {
+		assert.Check(t, cmp.Equal(truncate(tc.value, tc.width), tc.expected), "%q (%d)", tc.value, tc.width)
+	}
+}
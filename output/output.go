@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Package output handles writing results in the various supported formats.
+package output
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+)
+
+// Column describes one column of human-readable output.
+type Column[T any] struct {
+	Name  string
+	Value func(T) string
+	// If set, the value for terse output, without units or annotations.
+	Plain func(T) string
+	// Whether long values (such as paths) may be wrapped onto more lines,
+	// rather than cut short, to fit the TableLayout.
+	Wrap bool
+}
+
+// plain returns the value of the column for terse output.
+func (c Column[T]) plain(item T) string {
+	if c.Plain != nil {
+		return c.Plain(item)
+	}
+	return c.Value(item)
+}
+
+// Key returns the name used to select the column: its name in lower case,
+// with dashes instead of spaces.
+func (c Column[T]) Key() string {
+	return strings.ReplaceAll(strings.ToLower(c.Name), " ", "-")
+}
+
+// SelectColumns returns the columns with the given keys, in that order.
+func SelectColumns[T any](columns []Column[T], keys []string) ([]Column[T], error) {
+	var selected []Column[T]
+	for _, key := range keys {
+		i := slices.IndexFunc(columns, func(c Column[T]) bool { return c.Key() == key })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q; expected one of %s",
+				key, strings.Join(itertools.Map(columns, Column[T].Key), ", "))
+		}
+		selected = append(selected, columns[i])
+	}
+	return selected, nil
+}
+
+// Write the given items in the requested format; the columns are only used
+// for human-readable output.
+func Write[T any](w io.Writer, format config.OutputFormat, items []T, columns []Column[T]) error {
+	stream := NewStream(w, format, columns, 0)
+	for _, item := range items {
+		if err := stream.Write(item); err != nil {
+			return err
+		}
+	}
+	return stream.Close()
+}
+
+// Stream writes items in the requested format one at a time, so that they do
+// not all need to be held in memory.
+type Stream[T any] struct {
+	w       io.Writer
+	format  config.OutputFormat
+	columns []Column[T]
+	// The number of items laid out together in human-readable output; zero
+	// means all of them.
+	batch   int
+	count   int
+	rows    [][]string
+	started bool
+	// The width of each column of human-readable output, including padding.
+	cellWidths []int
+	seen       map[string]bool
+	xml        *xml.Encoder
+	zypper     *zypperWriter
+}
+
+// NewStream returns a stream writing items in the given format; the columns
+// are only used for human-readable output, which is laid out in batches of the
+// given number of items (or all at once, if zero).
+// The stream must be closed to finish the output.
+func NewStream[T any](w io.Writer, format config.OutputFormat, columns []Column[T], batch int) *Stream[T] {
+	s := &Stream[T]{w: w, format: format, columns: columns, batch: batch}
+	switch format {
+	case config.OutputFormatXML:
+		s.xml = xml.NewEncoder(w)
+		s.xml.Indent("", "  ")
+	case config.OutputFormatZypperXML:
+		s.zypper = newZypperWriter(w)
+	case config.OutputFormatNEVRA:
+		s.seen = make(map[string]bool)
+	}
+	return s
+}
+
+// Write the next item.
+func (s *Stream[T]) Write(item T) error {
+	s.count++
+	switch s.format {
+	case config.OutputFormatJSON:
+		// Match the indentation of encoding the items as one array.
+		data, err := json.MarshalIndent(item, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		separator := ",\n  "
+		if s.count == 1 {
+			separator = "[\n  "
+		}
+		if _, err := io.WriteString(s.w, separator); err != nil {
+			return err
+		}
+		if _, err := s.w.Write(data); err != nil {
+			return err
+		}
+	case config.OutputFormatXML:
+		if err := s.xml.Encode(item); err != nil {
+			return err
+		}
+	case config.OutputFormatZypperXML:
+		return s.zypper.write(item)
+	case config.OutputFormatNEVRA:
+		pkg, ok := any(item).(interface{ NEVRA() string })
+		if !ok {
+			return fmt.Errorf("output format %s is not supported here", s.format)
+		}
+		if nevra := pkg.NEVRA(); !s.seen[nevra] {
+			s.seen[nevra] = true
+			if _, err := fmt.Fprintln(s.w, nevra); err != nil {
+				return err
+			}
+		}
+	case config.OutputFormatTerse:
+		// Like `zypper --terse`: no headers, padding, or decorations.
+		values := itertools.Map(s.columns, func(c Column[T]) string { return c.plain(item) })
+		if _, err := fmt.Fprintln(s.w, strings.Join(values, " | ")); err != nil {
+			return err
+		}
+	case config.OutputFormatHuman:
+		s.rows = append(s.rows, itertools.Map(s.columns, func(c Column[T]) string { return c.Value(item) }))
+		if s.batch > 0 && len(s.rows) >= s.batch {
+			return s.writeTable()
+		}
+	}
+	return nil
+}
+
+// Close finishes the output; it does not close the underlying writer.
+func (s *Stream[T]) Close() error {
+	switch s.format {
+	case config.OutputFormatJSON:
+		end := "\n]\n"
+		if s.count == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(s.w, end)
+		return err
+	case config.OutputFormatZypperXML:
+		return s.zypper.close()
+	case config.OutputFormatHuman:
+		if len(s.rows) > 0 || !s.started {
+			return s.writeTable()
+		}
+	}
+	return nil
+}
+
+// writeTable writes the pending rows of human-readable output, preceded by
+// the column names if nothing has been written yet.  Columns are padded the
+// way a tabwriter would, but their widths carry over between batches, so that
+// they only ever grow.
+func (s *Stream[T]) writeTable() error {
+	names := itertools.Map(s.columns, func(c Column[T]) string { return c.Name })
+	wrappable := itertools.Map(s.columns, func(c Column[T]) bool { return c.Wrap })
+	widths := TableLayout.widths(append([][]string{names}, s.rows...), wrappable)
+	var lines [][]string
+	if !s.started {
+		s.started = true
+		lines = append(lines, names, itertools.Map(s.columns, func(c Column[T]) string { return "---" }))
+	}
+	for _, row := range s.rows {
+		lines = append(lines, TableLayout.fit(row, wrappable, widths)...)
+	}
+	s.rows = s.rows[:0]
+
+	if s.cellWidths == nil {
+		s.cellWidths = make([]int, len(s.columns))
+	}
+	for _, line := range lines {
+		for i, value := range line {
+			s.cellWidths[i] = max(s.cellWidths[i], utf8.RuneCountInString(value)+columnPadding, columnMinWidth)
+		}
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		for i, value := range line {
+			b.WriteString(value)
+			// The last column is not padded.
+			if i < len(line)-1 {
+				b.WriteString(strings.Repeat(" ", s.cellWidths[i]-utf8.RuneCountInString(value)))
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+// SearchResultColumns are the columns used to display search results.
+var SearchResultColumns = []Column[database.SearchResult]{
+	{
+		Name:  "Repository",
+		Value: func(result database.SearchResult) string { return result.Repository },
+	},
+	{
+		Name:  "Package",
+		Value: func(result database.SearchResult) string { return result.Package },
+	},
+	{
+		Name:  "Version",
+		Value: func(result database.SearchResult) string { return result.EVR() },
+	},
+	{
+		Name:  "Arch",
+		Value: func(result database.SearchResult) string { return result.Arch },
+	},
+	{
+		Name:  "Size",
+		Value: func(result database.SearchResult) string { return FormatSize(result.InstalledSize) },
+		Plain: func(result database.SearchResult) string { return strconv.FormatInt(result.InstalledSize, 10) },
+	},
+	{
+		Name: "File",
+		Value: func(result database.SearchResult) string {
+			if result.Alternative {
+				return result.Path + " (alternative)"
+			}
+			return result.Path
+		},
+		Plain: func(result database.SearchResult) string { return result.Path },
+		Wrap:  true,
+	},
+	{
+		Name:  "Location",
+		Value: func(result database.SearchResult) string { return result.Location },
+		Wrap:  true,
+	},
+}
+
+// BasenameColumn shows only the name of the file of a result, instead of its
+// full path.
+var BasenameColumn = Column[database.SearchResult]{
+	Name: "File",
+	Value: func(result database.SearchResult) string {
+		if result.Alternative {
+			return path.Base(result.Path) + " (alternative)"
+		}
+		return path.Base(result.Path)
+	},
+	Plain: func(result database.SearchResult) string { return path.Base(result.Path) },
+	Wrap:  true,
+}
+
+// DirColumn shows only the directory of the file of a result, instead of its
+// full path; it is empty for results that are provides rather than files.
+var DirColumn = Column[database.SearchResult]{
+	Name: "Directory",
+	Value: func(result database.SearchResult) string {
+		if !strings.HasPrefix(result.Path, "/") {
+			return ""
+		}
+		return path.Dir(result.Path)
+	},
+	Wrap: true,
+}
+
+// ReleaseVerColumn shows the release a result came from, for use when
+// querying multiple releases.
+var ReleaseVerColumn = Column[database.SearchResult]{
+	Name:  "Release",
+	Value: func(result database.SearchResult) string { return result.ReleaseVer },
+}
+
+// AliasColumn shows the alias of the repository a result came from.
+var AliasColumn = Column[database.SearchResult]{
+	Name:  "Alias",
+	Value: func(result database.SearchResult) string { return result.Alias },
+}
+
+// RepoFileColumn shows the file the repository of a result is configured
+// in, and the service that added it, if any.
+var RepoFileColumn = Column[database.SearchResult]{
+	Name: "Repository File",
+	Value: func(result database.SearchResult) string {
+		if result.Service != "" {
+			return fmt.Sprintf("%s (service %s)", result.RepoFile, result.Service)
+		}
+		return result.RepoFile
+	},
+	Plain: func(result database.SearchResult) string { return result.RepoFile },
+}
+
+// FormatSize returns a human-readable representation of a size in bytes; an
+// unknown (zero) size is returned as an empty string.
+func FormatSize(size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB"}
+	value, i := float64(size)/unit, 0
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
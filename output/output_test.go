@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestStream(t *testing.T) {
+	results := []database.SearchResult{
+		{Repository: "repo-oss", Package: "foo", Version: "1.0", Release: "1", Arch: "x86_64", Path: "/usr/bin/foo"},
+		{Repository: "repo-oss", Package: "foo", Version: "1.0", Release: "1", Arch: "x86_64", Path: "/usr/share/foo"},
+		{Repository: "repo-update", Package: "bar", Version: "2.0", Release: "1", Arch: "noarch", Path: "/usr/bin/bar"},
+	}
+	columns := []Column[database.SearchResult]{SearchResultColumns[1], SearchResultColumns[5]}
+
+	t.Run("json", func(t *testing.T) {
+		var b strings.Builder
+		assert.NilError(t, Write(&b, config.OutputFormatJSON, results, columns))
+		expected, err := json.MarshalIndent(results, "", "  ")
+		assert.NilError(t, err)
+		assert.Check(t, cmp.Equal(b.String(), string(expected)+"\n"))
+
+		b.Reset()
+		assert.NilError(t, Write(&b, config.OutputFormatJSON, []database.SearchResult{}, columns))
+		assert.Check(t, cmp.Equal(b.String(), "[]\n"))
+	})
+
+	t.Run("nevra", func(t *testing.T) {
+		var b strings.Builder
+		stream := NewStream(&b, config.OutputFormatNEVRA, columns, 0)
+		for _, result := range results {
+			assert.NilError(t, stream.Write(result))
+		}
+		assert.NilError(t, stream.Close())
+		assert.Check(t, cmp.Equal(b.String(), "foo-1.0-1.x86_64\nbar-2.0-1.noarch\n"))
+	})
+
+	t.Run("human batches", func(t *testing.T) {
+		var b strings.Builder
+		stream := NewStream(&b, config.OutputFormatHuman, columns, 2)
+		for _, result := range results {
+			assert.NilError(t, stream.Write(result))
+		}
+		// The first batch is written as soon as it is complete.
+		assert.Check(t, cmp.Equal(b.String(), ""+
+			"Package  File\n"+
+			"---      ---\n"+
+			"foo      /usr/bin/foo\n"+
+			"foo      /usr/share/foo\n"))
+		assert.NilError(t, stream.Close())
+		// Later batches keep the widths of the columns, without repeating
+		// their names.
+		assert.Check(t, cmp.Equal(b.String(), ""+
+			"Package  File\n"+
+			"---      ---\n"+
+			"foo      /usr/bin/foo\n"+
+			"foo      /usr/share/foo\n"+
+			"bar      /usr/bin/bar\n"))
+	})
+}
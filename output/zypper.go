@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/mook-as/zypper-filesearch/database"
+)
+
+// zypperSolvable is a package in zypper's XML output.  The installation
+// status is not known, so it is omitted.
+type zypperSolvable struct {
+	Name       string `xml:"name,attr"`
+	Kind       string `xml:"kind,attr"`
+	Edition    string `xml:"edition,attr"`
+	Arch       string `xml:"arch,attr"`
+	Repository string `xml:"repository,attr"`
+}
+
+// zypperWriter writes the packages of search results in the same structure as
+// `zypper --xmlout search --details`, listing each package once.
+type zypperWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+	seen    map[zypperSolvable]bool
+	started bool
+}
+
+func newZypperWriter(w io.Writer) *zypperWriter {
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return &zypperWriter{w: w, encoder: encoder, seen: make(map[zypperSolvable]bool)}
+}
+
+// start writes the beginning of the document, up to the list of packages.
+func (z *zypperWriter) start() error {
+	if z.started {
+		return nil
+	}
+	z.started = true
+	if _, err := io.WriteString(z.w, "<?xml version='1.0'?>\n"); err != nil {
+		return err
+	}
+	if err := z.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "stream"}}); err != nil {
+		return err
+	}
+	if err := z.encoder.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "search-result"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "0.0"}},
+	}); err != nil {
+		return err
+	}
+	return z.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "solvable-list"}})
+}
+
+// write adds the package of the search result, unless it was already written.
+func (z *zypperWriter) write(item any) error {
+	result, ok := item.(database.SearchResult)
+	if !ok {
+		return fmt.Errorf("output format zypper-xml is not supported here")
+	}
+	solvable := zypperSolvable{
+		Name:       result.Package,
+		Kind:       "package",
+		Edition:    result.EVR(),
+		Arch:       result.Arch,
+		Repository: result.Repository,
+	}
+	if z.seen[solvable] {
+		return nil
+	}
+	if err := z.start(); err != nil {
+		return err
+	}
+	z.seen[solvable] = true
+	return z.encoder.EncodeElement(solvable, xml.StartElement{Name: xml.Name{Local: "solvable"}})
+}
+
+// close writes the end of the document.
+func (z *zypperWriter) close() error {
+	if err := z.start(); err != nil {
+		return err
+	}
+	for _, name := range []string{"solvable-list", "search-result", "stream"} {
+		if err := z.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	if err := z.encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(z.w, "\n")
+	return err
+}
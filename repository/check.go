@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// maxClockSkew is how far in the future a metadata timestamp may be before it
+// is considered wrong.
+const maxClockSkew = 24 * time.Hour
+
+// Problem is an issue found with a repository by Check.
+type Problem struct {
+	XMLName    xml.Name `json:"-" xml:"problem"`
+	Repository string   `json:"repository" xml:"repository,attr"`
+	Message    string   `json:"message" xml:"message,attr"`
+}
+
+// Check the health of the given repositories without updating the database:
+// that repomd.xml can be fetched, that it lists file lists, that checksums are
+// advertised, and that timestamps are plausible and match the index.  The
+// problems are returned in the order of the repositories.
+func Check(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]Problem, error) {
+	// A fresh cached repomd.xml would be used without contacting the mirror,
+	// hiding problems with it, so don't use the cache.
+	uncached := *cfg
+	uncached.HTTPCache = false
+	clients := newHTTPClients(&uncached)
+	results := itertools.ParallelMap(repos, refreshParallelism, func(repo *zypper.Repository) []string {
+		return checkRepository(ctx, clients, db, repo)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for i, messages := range results {
+		for _, message := range messages {
+			problems = append(problems, Problem{Repository: repos[i].Alias, Message: message})
+		}
+	}
+	return problems, nil
+}
+
+// checkRepository returns the problems found with one repository.
+func checkRepository(ctx context.Context, clients *httpClients, db *database.Database, repo *zypper.Repository) []string {
+	if repo.Type != "rpm-md" && repo.Type != susetagsType && repo.Type != contentsType {
+		return []string{fmt.Sprintf("repositories of type %q cannot be indexed", repo.Type)}
+	}
+	fetcher := fetcherFor(repo.URL)
+	if fetcher == nil {
+		return []string{fmt.Sprintf("unsupported URL %s", stripCredentials(repo.URL))}
+	}
+	fetcher, err := clients.configure(ctx, repo, fetcher)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	_, lastModified, err := db.GetTimestamps(ctx, repo)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to read index: %s", err))
+	} else if lastModified.IsZero() {
+		problems = append(problems, "repository has not been indexed")
+	}
+	if repo.Type == susetagsType {
+		return append(problems, checkSusetags(ctx, db, repo, fetcher)...)
+	}
+	if repo.Type == contentsType {
+		return append(problems, checkContents(ctx, db, repo, fetcher)...)
+	}
+
+	body, err := fetcher.Fetch(ctx, repo.Name, "repomd.xml", repo.URL, "repodata", "repomd.xml")
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var repomd repomdFile
+	if err := xml.NewDecoder(body).Decode(&repomd); err != nil {
+		return append(problems, fmt.Sprintf("failed to parse repomd.xml: %s", err))
+	}
+
+	if repomd.fileListIndex() < 0 {
+		problems = append(problems, "repomd.xml does not list file lists; only the files listed in primary.xml can be indexed")
+	}
+	fileListIndex := repomd.filesIndex()
+
+	now := time.Now()
+	for _, data := range repomd.Data {
+		if data.Checksum.Value == "" {
+			problems = append(problems, fmt.Sprintf("no checksum advertised for %s", data.Type))
+		} else if newHasher(data.Checksum.Type) == nil {
+			problems = append(problems, fmt.Sprintf("unsupported checksum type %q for %s", data.Checksum.Type, data.Type))
+		}
+		timestamp := time.Unix(data.Timestamp, 0)
+		if data.Timestamp <= 0 {
+			problems = append(problems, fmt.Sprintf("no timestamp for %s", data.Type))
+		} else if timestamp.After(now.Add(maxClockSkew)) {
+			problems = append(problems, fmt.Sprintf("timestamp of %s is in the future (%s)", data.Type, timestamp.Local().Format(time.DateTime)))
+		}
+	}
+
+	if fileListIndex >= 0 && !lastModified.IsZero() {
+		timestamp := time.Unix(repomd.Data[fileListIndex].Timestamp, 0).UTC()
+		if timestamp.Before(lastModified) {
+			problems = append(problems, fmt.Sprintf("file list (%s) is older than the index (%s); the mirror may be out of date",
+				timestamp.Local().Format(time.DateTime), lastModified.Local().Format(time.DateTime)))
+		} else if timestamp.After(lastModified) {
+			problems = append(problems, fmt.Sprintf("index (%s) is older than the file list (%s); refresh to update it",
+				lastModified.Local().Format(time.DateTime), timestamp.Local().Format(time.DateTime)))
+		}
+	}
+	return problems
+}
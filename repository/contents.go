@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// contentsType is the repository type of Debian (and Ubuntu) Contents indexes:
+// a `Contents-<arch>.gz` file in an apt repository, listing each file and the
+// packages that contain it.  These are never configured in zypper; they are
+// only added with `-add-repo`.
+const contentsType = "debian-contents"
+
+// contentsPrefix is the prefix of the names of Contents indexes.
+const contentsPrefix = "Contents-"
+
+// NewAdded returns the repository given to `-add-repo`, as `[ALIAS=]URL`.  A
+// URL of a Debian Contents index (`.../dists/<suite>/<component>/Contents-<arch>.gz`)
+// is indexed as such; any other URL is taken to be an rpm-md repository.  If no
+// alias is given, one is made up from the URL.
+func NewAdded(spec string) (*zypper.Repository, error) {
+	alias, rawURL, ok := strings.Cut(spec, "=")
+	if !ok || strings.ContainsAny(alias, ":/") {
+		alias, rawURL = "", spec
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL %q: %w", rawURL, err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("invalid repository URL %q: no scheme", rawURL)
+	}
+	repo := &zypper.Repository{
+		Alias:    alias,
+		Type:     "rpm-md",
+		Enabled:  true,
+		Priority: 99,
+		URL:      rawURL,
+	}
+	trimmed := strings.Trim(u.Path, "/")
+	if strings.HasPrefix(path.Base(trimmed), contentsPrefix) {
+		repo.Type = contentsType
+		if _, rest, ok := strings.Cut(trimmed, "dists/"); ok {
+			trimmed = rest
+		}
+		// bookworm/main/Contents-amd64.gz becomes bookworm-main-amd64.
+		dir, name := path.Split(trimmed)
+		name = strings.TrimPrefix(name, contentsPrefix)
+		name = strings.TrimSuffix(name, path.Ext(name))
+		trimmed = path.Join(dir, name)
+	} else {
+		trimmed = path.Join(u.Host, trimmed)
+	}
+	if repo.Alias == "" {
+		repo.Alias = strings.ReplaceAll(trimmed, "/", "-")
+	}
+	repo.Name = repo.Alias
+	return repo, nil
+}
+
+// contentsIndex describes the Contents index of a repository.
+type contentsIndex struct {
+	// The repository to fetch files relative to: the suite directory if
+	// there is one, or else the directory of the index.
+	base *zypper.Repository
+	// The index, relative to the base; the checksum is only set if it is
+	// listed in the suite's Release file.
+	data *repomdData
+}
+
+// provenance identifies the index by its checksum from the Release file; if
+// there is none, the index cannot be told apart from a changed one.
+func (c *contentsIndex) provenance() database.Provenance {
+	if c.data.Checksum.Value == "" {
+		return database.Provenance{}
+	}
+	return database.Provenance{Checksum: c.data.Checksum.Type + ":" + c.data.Checksum.Value}
+}
+
+// readContentsIndex locates the Contents index of a repository, and looks up
+// its checksum in the Release file of its suite.  The signature of the Release
+// file is not checked.
+func readContentsIndex(ctx context.Context, fetcher Fetcher, repo *zypper.Repository) (*contentsIndex, error) {
+	base := *repo
+	href := path.Base(repo.URL)
+	if before, after, ok := strings.Cut(repo.URL, "/dists/"); ok {
+		suite, rest, _ := strings.Cut(after, "/")
+		base.URL, href = before+"/dists/"+suite, rest
+	} else {
+		base.URL = strings.TrimSuffix(repo.URL, "/"+href)
+	}
+	index := &contentsIndex{base: &base, data: &repomdData{Type: "contents", name: path.Base(href)}}
+	index.data.Location.Href = href
+	if href == "" || base.URL == repo.URL {
+		return nil, fmt.Errorf("invalid Contents index URL %s", stripCredentials(repo.URL))
+	}
+	if !strings.Contains(repo.URL, "/dists/") {
+		return index, nil
+	}
+
+	body, err := fetcher.Fetch(ctx, repo.Name, "Release", base.URL, "Release")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	// The checksums are in indented lines (`<checksum> <size> <path>`)
+	// following a `SHA256:` line.
+	inSection := false
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inSection = strings.TrimSpace(line) == "SHA256:"
+			continue
+		}
+		if fields := strings.Fields(line); inSection && len(fields) == 3 && fields[2] == href {
+			index.data.Checksum.Type = "sha256"
+			index.data.Checksum.Value = strings.ToLower(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if index.data.Checksum.Value == "" {
+		slog.DebugContext(ctx, "Contents index is not listed in Release file",
+			"repository", repo.Name, "index", href)
+	}
+	return index, nil
+}
+
+// readContents parses a Contents index: each line is a path (without the
+// leading slash), whitespace, and a comma-separated list of the packages
+// containing it, as `[<area>/]<section>/<name>`.  Older indexes start with
+// free-form text, up to a line with the column headings `FILE LOCATION`.  The
+// packages are returned in the order they are first seen; the section of each
+// is used as its group.
+func readContents(r io.Reader) ([]*fileListPackage, map[string]*primaryPackage, error) {
+	var packages []*fileListPackage
+	details := make(map[string]*primaryPackage)
+	byName := make(map[string]*fileListPackage)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "FILE" && fields[1] == "LOCATION" {
+			// Everything so far was the header.
+			packages = nil
+			clear(details)
+			clear(byName)
+			continue
+		}
+		// Paths may contain spaces, so split at the last run of whitespace.
+		i := strings.LastIndexAny(line, " \t")
+		if i < 0 {
+			continue
+		}
+		file := "/" + strings.TrimLeft(strings.TrimRight(line[:i], " \t"), "/")
+		for _, location := range strings.Split(line[i+1:], ",") {
+			slash := strings.LastIndex(location, "/")
+			if slash < 0 {
+				continue
+			}
+			name := location[slash+1:]
+			pkg, ok := byName[name]
+			if !ok {
+				pkg = &fileListPackage{PkgId: name, Name: name}
+				packages = append(packages, pkg)
+				byName[name] = pkg
+				// Drop the area (such as non-free) from the section.
+				details[name] = &primaryPackage{Group: path.Base(location[:slash])}
+			}
+			pkg.Files = append(pkg.Files, &packageFile{Path: file})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return packages, details, nil
+}
+
+// planContents returns whether the Contents index would be fetched, and the
+// reason; see planRepository.  The download size is not known.
+func planContents(ctx context.Context, db *database.Database, repo *zypper.Repository, fetcher Fetcher, lastModified time.Time, reindex bool) (bool, string, int64) {
+	index, err := readContentsIndex(ctx, fetcher, repo)
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	if lastModified.IsZero() {
+		return true, "never indexed", 0
+	}
+	if reindex {
+		return true, "indexing settings changed", 0
+	}
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	provenance := index.provenance()
+	if provenance.Checksum == "" {
+		return true, "refresh interval expired, and the index has no checksum to compare", 0
+	}
+	if previous.Checksum == provenance.Checksum {
+		return false, "refresh interval expired, but the index has not changed", 0
+	}
+	return true, "refresh interval expired, and the index changed", 0
+}
+
+// checkContents returns the problems found with a Contents index; see
+// checkRepository.
+func checkContents(ctx context.Context, db *database.Database, repo *zypper.Repository, fetcher Fetcher) []string {
+	index, err := readContentsIndex(ctx, fetcher, repo)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	provenance := index.provenance()
+	if provenance.Checksum == "" {
+		problems = append(problems, "index is not listed in a Release file; it cannot be verified")
+	}
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to read index: %s", err))
+	} else if previous.Checksum != "" && previous.Checksum != provenance.Checksum {
+		problems = append(problems, "cached data is older than the index; refresh to update it")
+	}
+	return problems
+}
+
+// updateContents updates the cached data of a Contents index; see
+// updateRepository.  The index has no timestamps, so the checksum in the
+// Release file is used to tell whether it changed.
+func updateContents(ctx context.Context, cfg *config.Config, db *database.Database, repo *zypper.Repository, fetcher Fetcher, lastChecked time.Time, unreachable func(error) error, metrics *refreshMetrics) (RefreshState, error) {
+	index, err := readContentsIndex(ctx, fetcher, repo)
+	if err != nil {
+		return RefreshFailed, unreachable(err)
+	}
+	provenance := index.provenance()
+	provenance.Settings = indexSettings(cfg, repo)
+	if provenance.Checksum != "" {
+		previous, err := db.GetProvenance(ctx, repo)
+		if err != nil {
+			return RefreshFailed, err
+		}
+		if previous == provenance {
+			slog.DebugContext(ctx, "Contents index has not changed", "repository", repo.Name)
+			return RefreshCurrent, nil
+		}
+	}
+
+	filter, err := newPathFilter(cfg)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	var packages []*fileListPackage
+	var details map[string]*primaryPackage
+	err = readMetadata(ctx, cfg, fetcher, index.base, index.data, unreachable, func(r io.Reader) (err error) {
+		packages, details, err = readContents(r)
+		return err
+	})
+	var unreachableErr *ErrRepoUnreachable
+	if err != nil && !repo.Enabled && errors.As(err, &unreachableErr) {
+		return RefreshSkipped, nil // Ignore errors from disabled repositories
+	} else if err != nil {
+		return RefreshFailed, err
+	}
+
+	err = importPackages(ctx, db, repo, lastChecked, lastChecked, provenance, filter, packages, details, nil, metrics)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	return RefreshUpdated, nil
+}
@@ -0,0 +1,344 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// Fetcher downloads repository metadata files.
+type Fetcher interface {
+	// Fetch the file at the URL formed by joining the given parts.  The name
+	// (of the repository) and kind (of file) are only used for diagnostics.
+	Fetch(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error)
+}
+
+// FetcherFunc adapts a plain function into a Fetcher.
+type FetcherFunc func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+	return f(ctx, name, kind, parts...)
+}
+
+var (
+	fetchersLock sync.RWMutex
+	fetchers     = map[string]Fetcher{
+		"http":  &HTTPFetcher{},
+		"https": &HTTPFetcher{},
+	}
+)
+
+// RegisterFetcher registers a fetcher to be used for repositories with URLs
+// using the given scheme, replacing any existing fetcher for that scheme.
+// Passing a nil fetcher removes the registration.
+func RegisterFetcher(scheme string, fetcher Fetcher) {
+	fetchersLock.Lock()
+	defer fetchersLock.Unlock()
+	scheme = strings.ToLower(scheme)
+	if fetcher == nil {
+		delete(fetchers, scheme)
+	} else {
+		fetchers[scheme] = fetcher
+	}
+}
+
+// fetcherFor returns the fetcher for the given URL, or nil if the scheme is
+// not supported.
+func fetcherFor(rawURL string) Fetcher {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	fetchersLock.RLock()
+	defer fetchersLock.RUnlock()
+	return fetchers[strings.ToLower(u.Scheme)]
+}
+
+// HTTPFetcher fetches files over HTTP(S).
+type HTTPFetcher struct {
+	// The client to use; if nil, http.DefaultClient is used.
+	Client *http.Client
+	// If set, downloaded files are cached here.
+	Cache *HTTPCache
+	// If set, these are used for HTTP basic authentication.
+	Username, Password string
+	// If set (and Cache is set), attempt to download only the changed parts of
+	// files using zsync control files published by the server.
+	DeltaSync bool
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, name, kind string, urlParts ...string) (io.ReadCloser, error) {
+	finalURL, err := url.JoinPath(stripCredentials(urlParts[0]), urlParts[1:]...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s URL: %w", kind, err)
+	}
+	if f.Cache == nil {
+		return f.fetch(ctx, name, kind, urlParts[0], finalURL, nil)
+	}
+
+	body, err := f.fetch(ctx, name, kind, urlParts[0], finalURL, f.Cache.lookup(finalURL))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Cache.setLatest(urlParts[0], kind, finalURL); err != nil {
+		slog.DebugContext(ctx, "Failed to update HTTP cache", "url", finalURL, "error", err)
+	}
+	return body, nil
+}
+
+// fetch the given URL (a file within the given repository), using the cache
+// entry (if not nil).
+func (f *HTTPFetcher) fetch(ctx context.Context, name, kind, repoURL, finalURL string, cached *httpCacheEntry) (io.ReadCloser, error) {
+	if cached != nil && time.Now().Before(cached.Expires) {
+		slog.DebugContext(ctx, "Using cached file", "kind", kind, "url", finalURL, "expires", cached.Expires.Local())
+		return f.Cache.open(cached)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if cached == nil && f.Cache != nil && f.DeltaSync && !isCompressed(finalURL) {
+		if seed := f.Cache.latest(repoURL, kind); seed != nil {
+			body, err := f.fetchDelta(ctx, client, finalURL, seed)
+			if err == nil {
+				return body, nil
+			}
+			slog.DebugContext(ctx, "Falling back to full download", "kind", kind, "url", finalURL, "error", err)
+		}
+	}
+
+	slog.DebugContext(ctx, "Fetching file", "kind", kind, "url", finalURL)
+	req, err := f.newRequest(ctx, finalURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct HTTP request: %w", err)
+	}
+	if cached != nil {
+		cached.addValidators(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from %s: %w", kind, name, err)
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		_ = resp.Body.Close()
+		slog.DebugContext(ctx, "Cached file not modified", "kind", kind, "url", finalURL)
+		return f.Cache.revalidate(cached, resp.Header)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s from %s: status code %d (%s)", kind, name, resp.StatusCode, resp.Status)
+	}
+	if resp.Body == nil {
+		return nil, fmt.Errorf("failed to fetch %s from %s: no body", kind, name)
+	}
+	resp.Body = newResumingBody(ctx, client, req, resp)
+	if f.Cache != nil {
+		return f.Cache.store(finalURL, resp)
+	}
+
+	return resp.Body, nil
+}
+
+// newRequest creates a GET request for the given URL, with the credentials of
+// the repository (if any).
+func (f *HTTPFetcher) newRequest(ctx context.Context, fileURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if f.Username != "" {
+		req.SetBasicAuth(f.Username, f.Password)
+	}
+	return req, nil
+}
+
+// compressedExts are the extensions of compressed metadata files.  A change
+// anywhere in the contents of these changes everything after it, so zsync
+// could not reuse anything from an older copy.
+var compressedExts = []string{".gz", ".zst", ".xz", ".bz2"}
+
+// isCompressed returns whether the URL is of a compressed file.
+func isCompressed(fileURL string) bool {
+	return slices.Contains(compressedExts, path.Ext(fileURL))
+}
+
+// fetchDelta downloads the given URL using zsync, with the given cache entry
+// as the seed, storing the result in the cache.
+func (f *HTTPFetcher) fetchDelta(ctx context.Context, client *http.Client, finalURL string, seed *httpCacheEntry) (io.ReadCloser, error) {
+	seedPath, _ := f.Cache.paths(seed.URL)
+	file, header, err := fetchDelta(ctx, client, f.newRequest, finalURL, seedPath, f.Cache.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// The file stays readable until closed, even after removal.
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	return f.Cache.store(finalURL, &http.Response{Header: header, Body: file})
+}
+
+// httpClients creates HTTP clients as needed for the configuration of each
+// repository, sharing them where the configuration is the same.
+type httpClients struct {
+	cfg     *config.Config
+	cache   *HTTPCache
+	lock    sync.Mutex
+	clients map[clientKey]*http.Client
+}
+
+// clientKey is the repository-specific configuration of an HTTP client.
+type clientKey struct {
+	certFile, keyFile string
+	socket            string
+}
+
+func newHTTPClients(cfg *config.Config) *httpClients {
+	c := &httpClients{cfg: cfg, clients: make(map[clientKey]*http.Client)}
+	if cfg.HTTPCache {
+		if dir, err := cfg.CacheFile("http"); err == nil {
+			c.cache = &HTTPCache{Dir: dir}
+		}
+	}
+	return c
+}
+
+// configure the fetcher for the given repository; if the fetcher does not need
+// any repository-specific configuration, it is returned unchanged.
+func (c *httpClients) configure(ctx context.Context, repo *zypper.Repository, fetcher Fetcher) (Fetcher, error) {
+	httpFetcher, ok := fetcher.(*HTTPFetcher)
+	if !ok {
+		return fetcher, nil
+	}
+	result := *httpFetcher
+	if result.Cache == nil {
+		result.Cache = c.cache
+		result.DeltaSync = c.cfg.DeltaSync
+	}
+	if name := credentialsName(repo.URL); name != "" && result.Username == "" {
+		// Repositories from SUSEConnect services reference credentials stored
+		// by zypper; these are normally only readable by root.
+		username, password, err := zypper.Credentials(zypper.Options{InstallRoot: c.cfg.InstallRoot}, name)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read repository credentials", "repository", repo.Name, "error", err)
+		} else {
+			result.Username, result.Password = username, password
+		}
+	}
+	if result.Client != nil {
+		return &result, nil
+	}
+	key := clientKey{socket: c.cfg.Repo(repo.Alias).Socket}
+	key.certFile, key.keyFile = c.cfg.ClientCertificateFor(repo.Alias)
+	if key == (clientKey{}) && c.cfg.ProxySocket == "" && c.network() == "tcp" && c.cfg.MaxConnsPerHost <= 0 {
+		// The default client is fine.
+		return &result, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	client, ok := c.clients[key]
+	if !ok {
+		transport, err := c.newTransport(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP client for %s: %w", repo.Name, err)
+		}
+		client = &http.Client{Transport: transport}
+		c.clients[key] = client
+	}
+	result.Client = client
+	return &result, nil
+}
+
+// newTransport creates an HTTP transport for the given configuration.
+func (c *httpClients) newTransport(key clientKey) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Repositories on the same mirror share the transport, and so the limit.
+	transport.MaxConnsPerHost = max(c.cfg.MaxConnsPerHost, 0)
+	if key.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(key.certFile, key.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if network := c.network(); network != "tcp" {
+		// Only connect over the requested IP version, for networks where the
+		// other one is advertised but broken.
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	switch {
+	case key.socket != "":
+		// Talk to the server on the socket directly, ignoring any proxy.
+		transport.Proxy = nil
+		transport.DialContext = dialUnix(key.socket)
+	case c.cfg.ProxySocket != "":
+		// The proxy address only needs to be valid; the connection is made
+		// to the socket instead.
+		transport.Proxy = http.ProxyURL(&url.URL{Scheme: "http", Host: "localhost"})
+		transport.DialContext = dialUnix(c.cfg.ProxySocket)
+	}
+	return transport, nil
+}
+
+// network returns the network to dial TCP connections on, restricting the IP
+// version if configured.
+func (c *httpClients) network() string {
+	switch c.cfg.IPFamily {
+	case config.IPFamily4, config.IPFamily6:
+		return "tcp" + string(c.cfg.IPFamily)
+	}
+	return "tcp"
+}
+
+// dialUnix returns a dial function that connects to the given Unix domain
+// socket, whatever address is requested.
+func dialUnix(socket string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+}
+
+// credentialsName returns the name of the zypper credentials file referenced
+// by a repository URL, if any.
+func credentialsName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("credentials")
+}
+
+// stripCredentials removes the zypper-specific credentials query parameter
+// from a repository URL, as it is not meant to be sent to the server.
+func stripCredentials(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.Query().Has("credentials") {
+		return rawURL
+	}
+	query := u.Query()
+	query.Del("credentials")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
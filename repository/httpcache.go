@@ -0,0 +1,273 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPCache stores downloaded files on disk, so that they do not need to be
+// fetched again while they are still fresh according to the Cache-Control or
+// Expires headers sent by the server.  Stale files are revalidated using the
+// ETag and Last-Modified headers.  The files may have been fetched with
+// credentials, so they are only readable by the user.
+type HTTPCache struct {
+	// The directory to store cached files in.
+	Dir string
+}
+
+// httpCacheEntry is the metadata stored alongside each cached file.
+type httpCacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// paths returns the paths to the cached body and metadata for the given URL.
+func (c *HTTPCache) paths(url string) (string, string) {
+	sum := sha256.Sum256([]byte(url))
+	base := filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+	return base, base + ".json"
+}
+
+// lookup the cache entry for the given URL; returns nil if it is not cached.
+func (c *HTTPCache) lookup(url string) *httpCacheEntry {
+	bodyPath, metaPath := c.paths(url)
+	if _, err := os.Stat(bodyPath); err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil
+	}
+	var entry httpCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	return &entry
+}
+
+// open the cached body for the given entry.  The entry is marked as used, so
+// that it is not pruned.
+func (c *HTTPCache) open(entry *httpCacheEntry) (io.ReadCloser, error) {
+	bodyPath, metaPath := c.paths(entry.URL)
+	now := time.Now()
+	for _, path := range []string{bodyPath, metaPath} {
+		_ = os.Chtimes(path, now, now)
+	}
+	return os.Open(bodyPath)
+}
+
+// addValidators adds headers to the request so the server can reply with a
+// 304 Not Modified if the cached entry is still valid.
+func (e *httpCacheEntry) addValidators(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// revalidate updates the entry after the server replied that it has not been
+// modified, and returns the cached body.
+func (c *HTTPCache) revalidate(entry *httpCacheEntry, header http.Header) (io.ReadCloser, error) {
+	if expires, ok := freshUntil(header, time.Now()); ok {
+		entry.Expires = expires
+		if err := c.writeEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	return c.open(entry)
+}
+
+// store the response body in the cache (if allowed), returning a reader for
+// the body.  The response body is consumed and closed.
+func (c *HTTPCache) store(url string, resp *http.Response) (io.ReadCloser, error) {
+	expires, ok := freshUntil(resp.Header, time.Now())
+	bodyPath, metaPath := c.paths(url)
+	if !ok {
+		_ = os.Remove(metaPath)
+		_ = os.Remove(bodyPath)
+		return resp.Body, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create HTTP cache directory: %w", err)
+	}
+	file, err := os.CreateTemp(c.Dir, "download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP cache file: %w", err)
+	}
+	defer func() {
+		// This is a no-op after the rename succeeds.
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write HTTP cache file: %w", err)
+	}
+	if err := os.Rename(file.Name(), bodyPath); err != nil {
+		return nil, fmt.Errorf("failed to write HTTP cache file: %w", err)
+	}
+
+	entry := &httpCacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Expires:      expires,
+	}
+	if err := c.writeEntry(entry); err != nil {
+		return nil, err
+	}
+	return c.open(entry)
+}
+
+// latestPath returns the path of the file recording the URL most recently
+// fetched for the given kind of file from the given repository.
+func (c *HTTPCache) latestPath(repoURL, kind string) string {
+	sum := sha256.Sum256([]byte(repoURL + "\x00" + kind))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".latest")
+}
+
+// latest returns the cache entry most recently fetched for the given kind of
+// file from the given repository, or nil if there is none.
+func (c *HTTPCache) latest(repoURL, kind string) *httpCacheEntry {
+	data, err := os.ReadFile(c.latestPath(repoURL, kind))
+	if err != nil {
+		return nil
+	}
+	return c.lookup(string(data))
+}
+
+// setLatest records the URL most recently fetched for the given kind of file
+// from the given repository; if that was previously a different URL, the
+// older file is removed from the cache.
+func (c *HTTPCache) setLatest(repoURL, kind, url string) error {
+	if previous := c.latest(repoURL, kind); previous != nil && previous.URL != url {
+		bodyPath, metaPath := c.paths(previous.URL)
+		_ = os.Remove(metaPath)
+		_ = os.Remove(bodyPath)
+	}
+	if err := os.WriteFile(c.latestPath(repoURL, kind), []byte(url), 0o600); err != nil {
+		return fmt.Errorf("failed to write HTTP cache entry: %w", err)
+	}
+	return nil
+}
+
+// writeEntry writes the metadata for a cache entry to disk.
+func (c *HTTPCache) writeEntry(entry *httpCacheEntry) error {
+	_, metaPath := c.paths(entry.URL)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize HTTP cache entry: %w", err)
+	}
+	if err := os.WriteFile(metaPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write HTTP cache entry: %w", err)
+	}
+	return nil
+}
+
+// httpCacheMaxAge is how long files may go unused before they are removed from
+// the cache; see HTTPCache.prune.
+const httpCacheMaxAge = 30 * 24 * time.Hour
+
+// prune removes the files that have not been used for longer than the given
+// duration (for example, those of repositories that are no longer configured),
+// so that the cache does not grow without bounds.
+func (c *HTTPCache) prune(maxAge time.Duration) error {
+	entries, err := os.ReadDir(c.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list HTTP cache: %w", err)
+	}
+	// Older versions made the cache readable by everyone.
+	if err := os.Chmod(c.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to restrict access to HTTP cache: %w", err)
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var errs []error
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// freshUntil calculates when a response with the given headers stops being
+// fresh.  If the response is not worth storing (because it may not be stored,
+// or it has neither freshness information nor validators), false is returned.
+func freshUntil(header http.Header, now time.Time) (time.Time, bool) {
+	var maxAge time.Duration = -1
+	var noStore, noCache bool
+	// The directives may be split over several headers; look at all of them
+	// before deciding, as no-store overrides everything else.
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store":
+				noStore = true
+			case "no-cache":
+				noCache = true
+			case "max-age":
+				if seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64); err == nil {
+					maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+	if noStore {
+		return time.Time{}, false
+	}
+	if noCache {
+		// The response may be stored, but must always be revalidated.
+		return now, hasValidators(header)
+	}
+	if maxAge >= 0 {
+		if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil {
+			maxAge -= time.Duration(age) * time.Second
+		}
+		return now.Add(maxAge), true
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			// Avoid issues with clock skew between us and the server.
+			return now.Add(expires.Sub(date)), true
+		}
+		return expires, true
+	}
+	// No freshness information; store it only if it can be revalidated.
+	return now, hasValidators(header)
+}
+
+// hasValidators returns whether a response with the given headers can be
+// revalidated with a conditional request.
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
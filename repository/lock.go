@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// lockPollInterval is how often to retry taking a refresh lock that is held by
+// another process.
+const lockPollInterval = 100 * time.Millisecond
+
+// errRefreshBusy is returned when another process is refreshing a repository,
+// and it did not finish in time.
+var errRefreshBusy = errors.New("repository is being refreshed by another process")
+
+// refreshLock is held while refreshing a repository, so that concurrent runs
+// (e.g. an interactive search and a timer) do not download the same metadata.
+type refreshLock struct {
+	file *os.File
+	db   *database.Database
+	repo *zypper.Repository
+}
+
+// lockPath returns the path of the lock file for the repository.
+func lockPath(cfg *config.Config, repo *zypper.Repository) (string, error) {
+	dir, err := cfg.CacheFile("locks")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(repo.URL + "\x00" + repo.ReleaseVer))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".lock"), nil
+}
+
+// acquireRefreshLock takes the lock for refreshing the repository.  If another
+// process holds it, wait up to the configured time for it to be released;
+// errRefreshBusy is returned if it is not.
+func acquireRefreshLock(ctx context.Context, cfg *config.Config, db *database.Database, repo *zypper.Repository) (*refreshLock, error) {
+	path, err := lockPath(cfg, repo)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file for %s: %w", repo.Name, err)
+	}
+	deadline := time.Now().Add(cfg.RefreshWait)
+	logged := false
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", repo.Name, err)
+		}
+		if !logged {
+			logged = true
+			args := []any{"repository", repo.Name, "wait", cfg.RefreshWait}
+			if holder, err := db.GetRefreshLock(ctx, repo); err == nil && holder != nil {
+				args = append(args, "pid", holder.PID, "started", holder.Started.Local())
+			}
+			slog.InfoContext(ctx, "Waiting for another process to refresh repository", args...)
+		}
+		if !time.Now().Before(deadline) {
+			_ = file.Close()
+			return nil, errRefreshBusy
+		}
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, context.Cause(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+	if err := db.SetRefreshLock(ctx, repo, os.Getpid()); err != nil {
+		// This is only informational.
+		slog.DebugContext(ctx, "Failed to record refresh lock", "repository", repo.Name, "error", err)
+	}
+	return &refreshLock{file: file, db: db, repo: repo}, nil
+}
+
+// release the lock, so that other processes may refresh the repository.
+func (l *refreshLock) release(ctx context.Context) {
+	if err := l.db.ClearRefreshLock(context.WithoutCancel(ctx), l.repo); err != nil {
+		slog.DebugContext(ctx, "Failed to clear refresh lock", "repository", l.repo.Name, "error", err)
+	}
+	// Closing the file releases the lock.
+	_ = l.file.Close()
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// refreshMetrics measures a refresh of a repository, so that it can be
+// recorded in the database; see database.RefreshRecord.
+type refreshMetrics struct {
+	started time.Time
+	// The time spent waiting for the network, and the bytes received; files
+	// served from the HTTP cache are included.
+	download time.Duration
+	bytes    int64
+	// The number of packages and files imported.
+	packages, files int
+}
+
+// record the refresh in the database.  Failing to do so is not an error, as
+// this is only informational.
+func (m *refreshMetrics) record(ctx context.Context, db *database.Database, repo *zypper.Repository, state RefreshState, err error) {
+	record := &database.RefreshRecord{
+		Alias:      repo.Alias,
+		Name:       repo.Name,
+		URL:        repo.URL,
+		ReleaseVer: repo.ReleaseVer,
+		Started:    m.started,
+		Duration:   time.Since(m.started),
+		Download:   m.download,
+		Bytes:      m.bytes,
+		Packages:   m.packages,
+		Files:      m.files,
+		Outcome:    string(state),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	// Record timeouts too.
+	if err := db.AddRefreshRecord(context.WithoutCancel(ctx), record); err != nil {
+		slog.DebugContext(ctx, "Failed to record refresh", "repository", repo.Name, "error", err)
+	}
+}
+
+// meteredFetcher measures the files downloaded by another fetcher.
+type meteredFetcher struct {
+	Fetcher
+	metrics *refreshMetrics
+}
+
+func (f *meteredFetcher) Fetch(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := f.Fetcher.Fetch(ctx, name, kind, parts...)
+	f.metrics.download += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredBody{ReadCloser: body, metrics: f.metrics}, nil
+}
+
+// meteredBody measures the reads of a downloaded file.
+type meteredBody struct {
+	io.ReadCloser
+	metrics *refreshMetrics
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.metrics.download += time.Since(start)
+	b.metrics.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// Prober is implemented by fetchers that can measure how quickly a repository
+// URL responds, so that the fastest mirror can be used.
+type Prober interface {
+	// Probe the repository at the given base URL, returning the time taken to
+	// respond.
+	Probe(ctx context.Context, baseURL string) (time.Duration, error)
+}
+
+// Probe the repository by requesting the headers of its repomd.xml.
+func (f *HTTPFetcher) Probe(ctx context.Context, baseURL string) (time.Duration, error) {
+	finalURL, err := url.JoinPath(stripCredentials(baseURL), "repodata", "repomd.xml")
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve probe URL: %w", err)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, finalURL, http.NoBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to construct HTTP request: %w", err)
+	}
+	if f.Username != "" {
+		req.SetBasicAuth(f.Username, f.Password)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe %s: %w", stripCredentials(baseURL), err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("failed to probe %s: status code %d (%s)", stripCredentials(baseURL), resp.StatusCode, resp.Status)
+	}
+	// Zero is reserved for unreachable mirrors.
+	return max(time.Since(start), time.Nanosecond), nil
+}
+
+// mirrorHost returns the key used to record the latency of a mirror.
+func mirrorHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
+
+// selectMirror returns the base URL to download the repository from: the
+// reachable URL with the lowest latency, preferring the primary URL when
+// nothing is known.  Latencies are re-measured once they are older than the
+// refresh interval of the repository.
+func selectMirror(ctx context.Context, cfg *config.Config, db *database.Database, repo *zypper.Repository, fetcher Fetcher) string {
+	prober, ok := fetcher.(Prober)
+	if !ok || len(repo.Mirrors) == 0 {
+		return repo.URL
+	}
+	candidates := append([]string{repo.URL}, repo.Mirrors...)
+	latencies, err := db.MirrorLatencies(ctx, itertools.Map(candidates, mirrorHost))
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read mirror latencies", "repository", repo.Name, "error", err)
+		return repo.URL
+	}
+
+	interval := cfg.RefreshIntervalFor(repo.Alias)
+	probed := itertools.ParallelMap(candidates, len(candidates), func(candidate string) database.MirrorLatency {
+		host := mirrorHost(candidate)
+		if entry, ok := latencies[host]; ok && entry.Checked.Add(interval).After(time.Now()) {
+			return entry
+		}
+		latency, err := prober.Probe(ctx, candidate)
+		if err != nil {
+			slog.DebugContext(ctx, "Mirror is unreachable", "repository", repo.Name, "mirror", host, "error", err)
+			latency = 0
+		}
+		return database.MirrorLatency{Host: host, Latency: latency}
+	})
+	// Record the new measurements together, rather than one write per mirror.
+	measured := itertools.Filter(probed, func(entry database.MirrorLatency) bool { return entry.Checked.IsZero() })
+	if err := db.SetMirrorLatencies(ctx, measured); err != nil {
+		slog.DebugContext(ctx, "Failed to record mirror latencies", "repository", repo.Name, "error", err)
+	}
+
+	best := -1
+	for i, entry := range probed {
+		if entry.Latency > 0 && (best < 0 || entry.Latency < probed[best].Latency) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return repo.URL
+	}
+	slog.DebugContext(ctx, "Selected mirror", "repository", repo.Name,
+		"mirror", probed[best].Host, "latency", probed[best].Latency)
+	return candidates[best]
+}
+
+// mirrorFetcher downloads files from a mirror instead of the primary URL of
+// the repository, falling back to the primary URL if the mirror fails.
+type mirrorFetcher struct {
+	Fetcher
+	db                *database.Database
+	primary, selected string
+}
+
+func (f *mirrorFetcher) Fetch(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+	if f.selected == f.primary || len(parts) == 0 || parts[0] != f.primary {
+		return f.Fetcher.Fetch(ctx, name, kind, parts...)
+	}
+	mirrorParts := append([]string{f.selected}, parts[1:]...)
+	body, err := f.Fetcher.Fetch(ctx, name, kind, mirrorParts...)
+	if err == nil || ctx.Err() != nil {
+		return body, err
+	}
+	host := mirrorHost(f.selected)
+	slog.WarnContext(ctx, "Mirror failed, using the primary URL", "repository", name, "mirror", host, "error", err)
+	if err := f.db.SetMirrorLatency(ctx, host, 0); err != nil {
+		slog.DebugContext(ctx, "Failed to record mirror latency", "mirror", host, "error", err)
+	}
+	f.selected = f.primary
+	return f.Fetcher.Fetch(ctx, name, kind, parts...)
+}
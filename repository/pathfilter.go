@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// pathFilter decides which files are indexed.
+type pathFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newPathFilter creates a filter from the indexInclude and indexExclude
+// settings; it returns nil if everything should be indexed.
+func newPathFilter(cfg *config.Config) (*pathFilter, error) {
+	if len(cfg.IndexInclude) == 0 && len(cfg.IndexExclude) == 0 {
+		return nil, nil
+	}
+	var filter pathFilter
+	var err error
+	if filter.include, err = compileGlobs(cfg.IndexInclude); err != nil {
+		return nil, fmt.Errorf("invalid indexInclude: %w", err)
+	}
+	if filter.exclude, err = compileGlobs(cfg.IndexExclude); err != nil {
+		return nil, fmt.Errorf("invalid indexExclude: %w", err)
+	}
+	return &filter, nil
+}
+
+// indexSettings describes the settings that decide what is imported from the
+// repository, so that its cached data can be replaced when they change; it is
+// empty for the defaults.  Changelogs are only read from rpm-md repositories.
+func indexSettings(cfg *config.Config, repo *zypper.Repository) string {
+	var settings []string
+	if len(cfg.IndexInclude) > 0 {
+		settings = append(settings, "indexInclude="+strings.Join(cfg.IndexInclude, ","))
+	}
+	if len(cfg.IndexExclude) > 0 {
+		settings = append(settings, "indexExclude="+strings.Join(cfg.IndexExclude, ","))
+	}
+	if cfg.Changelogs && repo.Type == "rpm-md" {
+		settings = append(settings, "changelogs")
+	}
+	return strings.Join(settings, "; ")
+}
+
+// allowed returns whether the file at the given path should be indexed.
+func (f *pathFilter) allowed(path string) bool {
+	if f == nil {
+		return true
+	}
+	if f.include != nil && !f.include.MatchString(path) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(path)
+}
+
+// compileGlobs converts glob patterns to a single regular expression matching
+// any of them.  As with the patterns used for searching (SQLite GLOB), `*`
+// also matches `/`.  Returns nil if there are no patterns.
+func compileGlobs(patterns []string) (*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	alternatives := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		var builder strings.Builder
+		for i := 0; i < len(pattern); i++ {
+			switch ch := pattern[i]; ch {
+			case '*':
+				builder.WriteString(".*")
+			case '?':
+				builder.WriteString(".")
+			case '[':
+				end := strings.IndexByte(pattern[i+1:], ']')
+				if end == 0 {
+					// A leading `]` is part of the class.
+					end = strings.IndexByte(pattern[i+2:], ']') + 1
+				}
+				if end < 1 {
+					return nil, fmt.Errorf("unterminated character class in %q", pattern)
+				}
+				class := pattern[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				builder.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+				i += end + 1
+			default:
+				builder.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		alternatives = append(alternatives, builder.String())
+	}
+	return regexp.Compile("^(?:" + strings.Join(alternatives, "|") + ")$")
+}
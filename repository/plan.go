@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/itertools"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// PlannedRefresh describes what refreshing a repository would do.
+type PlannedRefresh struct {
+	XMLName    xml.Name `json:"-" xml:"repository"`
+	Repository string   `json:"repository" xml:"alias,attr"`
+	// Whether the metadata would be downloaded and imported.
+	Fetch  bool   `json:"fetch" xml:"fetch,attr"`
+	Reason string `json:"reason" xml:"reason,attr"`
+	// The estimated number of bytes to download, if known.
+	Size int64 `json:"size" xml:"size,attr"`
+}
+
+// PlanRefresh reports which of the repositories Refresh would update, and
+// why, without writing to the database or the cache.  Only repomd.xml is
+// downloaded, for repositories that are due to be checked.
+func PlanRefresh(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]PlannedRefresh, error) {
+	// The HTTP cache would be written to, so don't use it.
+	uncached := *cfg
+	uncached.HTTPCache = false
+	clients := newHTTPClients(&uncached)
+	plans := itertools.ParallelMap(repos, refreshParallelism, func(repo *zypper.Repository) PlannedRefresh {
+		plan := PlannedRefresh{Repository: repo.Alias}
+		plan.Fetch, plan.Reason, plan.Size = planRepository(ctx, cfg, clients, db, repo)
+		return plan
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// planRepository returns whether the repository would be fetched, the reason,
+// and the estimated download size.
+func planRepository(ctx context.Context, cfg *config.Config, clients *httpClients, db *database.Database, repo *zypper.Repository) (bool, string, int64) {
+	if repo.Type != "rpm-md" && repo.Type != susetagsType && repo.Type != contentsType {
+		return false, fmt.Sprintf("unsupported repository type %q", repo.Type), 0
+	}
+	fetcher := fetcherFor(repo.URL)
+	if fetcher == nil {
+		return false, "unsupported URL scheme", 0
+	}
+	lastUpdated, lastModified, err := db.GetTimestamps(ctx, repo)
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	reindex, err := settingsChanged(ctx, db, repo, indexSettings(cfg, repo))
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	reindex = reindex && !lastModified.IsZero()
+	if next := lastUpdated.Add(cfg.RefreshIntervalFor(repo.Alias)); next.After(time.Now()) && !reindex {
+		return false, fmt.Sprintf("checked recently; next check after %s", next.Local().Format(time.DateTime)), 0
+	}
+
+	fetcher, err = clients.configure(ctx, repo, fetcher)
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	if repo.Type == susetagsType {
+		return planSusetags(ctx, db, repo, fetcher, lastModified, reindex)
+	}
+	if repo.Type == contentsType {
+		return planContents(ctx, db, repo, fetcher, lastModified, reindex)
+	}
+	body, err := fetcher.Fetch(ctx, repo.Name, "repomd.xml", repo.URL, "repodata", "repomd.xml")
+	if err != nil {
+		if !repo.Enabled {
+			return false, "disabled and unreachable", 0
+		}
+		return false, err.Error(), 0
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var repomd repomdFile
+	if err := xml.NewDecoder(body).Decode(&repomd); err != nil {
+		return false, fmt.Sprintf("failed to parse repomd.xml: %s", err), 0
+	}
+	fileListIndex := repomd.filesIndex()
+	if fileListIndex < 0 {
+		return false, "no file lists", 0
+	}
+	fileList := repomd.Data[fileListIndex]
+	timestamp := time.Unix(fileList.Timestamp, 0).UTC()
+	if timestamp.Equal(lastModified) && !reindex {
+		return false, "refresh interval expired, but the file list has not changed", 0
+	}
+	if cfg.MaxFileListSize > 0 && fileList.Size > cfg.MaxFileListSize && !cfg.Force {
+		return false, "file list is larger than maxFileListSize", fileList.Size
+	}
+
+	// Sum the sizes of the files that would be downloaded.
+	size := fileList.Size
+	types := []string{"primary"}
+	if cfg.Changelogs {
+		types = append(types, "other")
+	}
+	for _, data := range repomd.Data {
+		// Without file lists, primary.xml is counted as the file list.
+		if slices.Contains(types, data.Type) && data.Type != fileList.Type {
+			size += data.Size
+		}
+	}
+	if lastModified.IsZero() {
+		return true, "never indexed", size
+	}
+	if reindex {
+		return true, "indexing settings changed", size
+	}
+	return true, fmt.Sprintf("refresh interval expired, and the file list changed at %s", timestamp.Local().Format(time.DateTime)), size
+}
@@ -5,16 +5,20 @@
 package repository
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
+	"maps"
+	"os"
 	"path"
 	"path/filepath"
 	"slices"
@@ -22,170 +26,639 @@ import (
 	"time"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/mook-as/zypper-filesearch/config"
 	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/hook"
+	"github.com/mook-as/zypper-filesearch/itertools"
 	"github.com/mook-as/zypper-filesearch/zypper"
-	"golang.org/x/sync/errgroup"
 )
 
-type fetchType func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error)
+var (
+	// ErrChecksumMismatch is returned when downloaded metadata does not match
+	// the checksum advertised in repomd.xml.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrStaleCache is returned when a repository could not be refreshed, but
+	// older data for it is still available in the database.
+	ErrStaleCache = errors.New("cached data is stale")
+	// ErrRepoTimeout is returned when refreshing a single repository took
+	// longer than its configured timeout.
+	ErrRepoTimeout = errors.New("refresh timed out")
+)
+
+// ErrRepoUnreachable is returned when the metadata for a repository could not
+// be fetched.
+type ErrRepoUnreachable struct {
+	Repo *zypper.Repository
+	Err  error
+}
+
+func (e *ErrRepoUnreachable) Error() string {
+	return fmt.Sprintf("repository %s is unreachable: %s", e.Repo.Name, e.Err)
+}
+
+func (e *ErrRepoUnreachable) Unwrap() error {
+	return e.Err
+}
+
+// RefreshState describes the outcome of refreshing a single repository.
+type RefreshState string
+
+const (
+	// The repository was not refreshed, e.g. because it is of an unsupported
+	// type, or it is disabled and could not be reached.
+	RefreshSkipped = RefreshState("skipped")
+	// The cached data was already up to date.
+	RefreshCurrent = RefreshState("current")
+	// The cached data was updated.
+	RefreshUpdated = RefreshState("updated")
+	// The repository could not be refreshed, but older cached data for it is
+	// still used.
+	RefreshStale = RefreshState("stale")
+	// The repository could not be refreshed.
+	RefreshFailed = RefreshState("failed")
+)
+
+// RefreshStatus is the result of refreshing a single repository.
+type RefreshStatus struct {
+	Repo  *zypper.Repository
+	State RefreshState
+	// The error, if State is RefreshStale or RefreshFailed.
+	Err error
+}
+
+// repomdFile is the index of the metadata files of a repository.
+type repomdFile struct {
+	Revision string       `xml:"revision"`
+	Data     []repomdData `xml:"data"`
+}
+
+// fileListIndex returns the index of the file lists in Data, or -1 if there
+// are none.  The extended file lists, which include file digests, are
+// preferred.
+func (r *repomdFile) fileListIndex() int {
+	index := slices.IndexFunc(r.Data, func(d repomdData) bool {
+		return d.Type == "filelists-ext"
+	})
+	if index < 0 {
+		index = slices.IndexFunc(r.Data, func(d repomdData) bool {
+			return d.Type == "filelists"
+		})
+	}
+	return index
+}
+
+// filesIndex returns the index in Data of the metadata to read the files of
+// the packages from: the file lists, or if there are none, primary.xml, which
+// only lists some files (such as those in `bin` directories and `/etc`).  It
+// returns -1 if there is neither.
+func (r *repomdFile) filesIndex() int {
+	if index := r.fileListIndex(); index >= 0 {
+		return index
+	}
+	return slices.IndexFunc(r.Data, func(d repomdData) bool {
+		return d.Type == "primary"
+	})
+}
+
+// repomdData is an entry in repomd.xml, describing one metadata file.
+type repomdData struct {
+	Type     string `xml:"type,attr"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Timestamp int64 `xml:"timestamp"`
+	Size      int64 `xml:"size"`
+	// The name of the file, for diagnostics; if empty, it is `<type>.xml`.
+	// This is only set for other repository formats; see susetags.go.
+	name string
+}
+
+// fileName returns the name of the metadata file, for diagnostics.
+func (d *repomdData) fileName() string {
+	if d.name != "" {
+		return d.name
+	}
+	return d.Type + ".xml"
+}
+
+// packageVersion is the version of a package, in primary.xml and the file
+// lists.
+type packageVersion struct {
+	Epoch   string `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+}
+
+// packageFile is a file of a package, in the file lists (or primary.xml).
+type packageFile struct {
+	Type string `xml:"type,attr"`
+	// Only present in filelists-ext.
+	Hash string `xml:"hash,attr"`
+	Path string `xml:",chardata"`
+}
+
+// fileListPackage is a package in the file lists.
+type fileListPackage struct {
+	PkgId   string         `xml:"pkgid,attr"`
+	Name    string         `xml:"name,attr"`
+	Arch    string         `xml:"arch,attr"`
+	Version packageVersion `xml:"version"`
+	Files   []*packageFile `xml:"file"`
+}
+
+// primaryPackage is the information about a package from primary.xml that is
+// not in the file lists.
+type primaryPackage struct {
+	Name     string         `xml:"name"`
+	Arch     string         `xml:"arch"`
+	Version  packageVersion `xml:"version"`
+	Checksum string         `xml:"checksum"`
+	Size     struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Group    string `xml:"format>group"`
+	Provides []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"format>provides>entry"`
+	// The subset of the files of the package listed in primary.xml; only kept
+	// if there are no file lists.
+	Files []*packageFile `xml:"format>file"`
+	// The provides that are indexed; see isIndexedProvides.
+	indexed []string
+}
+
+// isIndexedProvides returns whether the RPM provides is kept in the index:
+// shared libraries and pkg-config modules.
+func isIndexedProvides(name string) bool {
+	return isSoname(name) || (strings.HasPrefix(name, "pkgconfig(") && strings.HasSuffix(name, ")"))
+}
+
+// isSoname returns whether the RPM provides names a shared library, such as
+// `libz.so.1()(64bit)`; symbol versions such as `libc.so.6(GLIBC_2.34)` are
+// not included.
+func isSoname(name string) bool {
+	name = strings.TrimSuffix(name, "(64bit)")
+	name = strings.TrimSuffix(name, "()")
+	if strings.ContainsAny(name, "()/ ") {
+		return false
+	}
+	return strings.HasSuffix(name, ".so") || strings.Contains(name, ".so.")
+}
+
+// newHasher returns a hash for the given repomd.xml checksum type, or nil if
+// the type is not supported.
+func newHasher(checksumType string) hash.Hash {
+	switch checksumType {
+	case "sha", "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// readMetadata downloads one of the metadata files listed in repomd.xml and
+// decodes it.  The file is first saved to a temporary file in the cache
+// directory, and only decoded once its checksum has been verified, so that a
+// damaged download is never imported.
+func readMetadata(ctx context.Context, cfg *config.Config, fetcher Fetcher, repo *zypper.Repository, data *repomdData, unreachable func(error) error, decode func(io.Reader) error) error {
+	name := data.fileName()
+	body, err := fetcher.Fetch(ctx, repo.Name, name, repo.URL, data.Location.Href)
+	if err != nil {
+		return unreachable(err)
+	}
+	defer func() {
+		_ = body.Close()
+	}()
 
-func fetchHttp(ctx context.Context, name, kind string, urlParts ...string) (io.ReadCloser, error) {
-	finalURL, err := url.JoinPath(urlParts[0], urlParts[1:]...)
+	dir, err := cfg.CacheFile("")
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve %s URL: %w", kind, err)
+		return err
 	}
-	slog.DebugContext(ctx, "Fetching file", "kind", kind, "url", finalURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, finalURL, http.NoBody)
+	temp, err := os.CreateTemp(dir, "download-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct HTTP request: %w", err)
+		return fmt.Errorf("failed to create temporary file for %s: %w", name, err)
+	}
+	defer func() {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+	}()
+
+	hasher := newHasher(data.Checksum.Type)
+	if hasher == nil {
+		slog.DebugContext(ctx, "Not verifying metadata with unknown checksum type",
+			"repository", repo.Name, "type", data.Type, "checksum", data.Checksum.Type)
+	}
+	writer := io.Writer(temp)
+	if hasher != nil {
+		writer = io.MultiWriter(temp, hasher)
+	}
+	if _, err := io.Copy(writer, body); err != nil {
+		return unreachable(err)
+	}
+	if hasher != nil {
+		sum := fmt.Sprintf("%02x", hasher.Sum(nil))
+		if sum != data.Checksum.Value {
+			return fmt.Errorf("%s for %s: %w: expected %s, got %s",
+				data.Type, repo.Name, ErrChecksumMismatch, data.Checksum.Value, sum)
+		}
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read downloaded %s: %w", name, err)
+	}
+
+	var reader io.Reader = bufio.NewReader(temp)
+	switch path.Ext(data.Location.Href) {
+	case ".gz":
+		reader, err = gzip.NewReader(reader)
+	case ".zst":
+		var decoder *zstd.Decoder
+		if decoder, err = newZstdReader(cfg, reader); err == nil {
+			defer decoder.Close()
+			reader = decoder
+		}
 	}
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch %s from %s: %w", kind, name, err)
+		return fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+
+	if err := decode(reader); err != nil {
+		return fmt.Errorf("failed to parse %s from %s: %w", name, repo.Name, err)
+	}
+	return nil
+}
+
+// newZstdReader returns a zstd decoder with the configured memory limits.
+func newZstdReader(cfg *config.Config, r io.Reader) (*zstd.Decoder, error) {
+	var options []zstd.DOption
+	if cfg.ZstdLowMem {
+		options = append(options, zstd.WithDecoderLowmem(true))
 	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to fetch %s from %s: status code %d (%s)", kind, name, resp.StatusCode, resp.Status)
+	if cfg.ZstdMaxWindow > 0 {
+		options = append(options, zstd.WithDecoderMaxWindow(uint64(cfg.ZstdMaxWindow)))
 	}
-	if resp.Body == nil {
-		return nil, fmt.Errorf("failed to fetch %s from %s: no body", kind, name)
+	if cfg.ZstdConcurrency > 0 {
+		options = append(options, zstd.WithDecoderConcurrency(cfg.ZstdConcurrency))
 	}
+	return zstd.NewReader(r, options...)
+}
 
-	return resp.Body, nil
+// readPrimary reads the package details from primary.xml, keyed by pkgid.  The
+// files listed are only kept if keepFiles is set.
+func readPrimary(ctx context.Context, cfg *config.Config, fetcher Fetcher, repo *zypper.Repository, data *repomdData, keepFiles bool, unreachable func(error) error) (map[string]*primaryPackage, error) {
+	packages := make(map[string]*primaryPackage)
+	err := readMetadata(ctx, cfg, fetcher, repo, data, unreachable, func(r io.Reader) error {
+		// primary.xml can be large, so decode one package at a time.
+		decoder := xml.NewDecoder(r)
+		for {
+			token, err := decoder.Token()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if start, ok := token.(xml.StartElement); ok && start.Name.Local == "package" {
+				var pkg primaryPackage
+				if err := decoder.DecodeElement(&pkg, &start); err != nil {
+					return err
+				}
+				for _, provides := range pkg.Provides {
+					if isIndexedProvides(provides.Name) {
+						pkg.indexed = append(pkg.indexed, provides.Name)
+					}
+				}
+				// Most provides are not needed, so don't keep them around.
+				pkg.Provides = nil
+				if !keepFiles {
+					pkg.Files = nil
+				}
+				packages[pkg.Checksum] = &pkg
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages, nil
 }
 
-func updateRepository(ctx context.Context, db *database.Database, repo *zypper.Repository, fetch fetchType) error {
-	if repo.Type != "rpm-md" {
+// otherPackage is the changelog of a package from other.xml.
+type otherPackage struct {
+	PkgId     string `xml:"pkgid,attr"`
+	Changelog []struct {
+		Author string `xml:"author,attr"`
+		Date   int64  `xml:"date,attr"`
+		Text   string `xml:",chardata"`
+	} `xml:"changelog"`
+}
+
+// readChangelogs reads the changelogs from other.xml, keyed by pkgid.
+func readChangelogs(ctx context.Context, cfg *config.Config, fetcher Fetcher, repo *zypper.Repository, data *repomdData, unreachable func(error) error) (map[string][]database.Changelog, error) {
+	changelogs := make(map[string][]database.Changelog)
+	err := readMetadata(ctx, cfg, fetcher, repo, data, unreachable, func(r io.Reader) error {
+		decoder := xml.NewDecoder(r)
+		for {
+			token, err := decoder.Token()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if start, ok := token.(xml.StartElement); ok && start.Name.Local == "package" {
+				var pkg otherPackage
+				if err := decoder.DecodeElement(&pkg, &start); err != nil {
+					return err
+				}
+				for _, entry := range pkg.Changelog {
+					changelogs[pkg.PkgId] = append(changelogs[pkg.PkgId], database.Changelog{
+						Author: entry.Author,
+						Date:   time.Unix(entry.Date, 0).UTC(),
+						Text:   entry.Text,
+					})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changelogs, nil
+}
+
+// settingsChanged returns whether the repository was indexed with settings
+// other than the given ones (see indexSettings), in which case its cached
+// data must be replaced even if the repository itself has not changed.
+func settingsChanged(ctx context.Context, db *database.Database, repo *zypper.Repository, settings string) (bool, error) {
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		return false, err
+	}
+	return previous.Settings != settings, nil
+}
+
+func updateRepository(ctx context.Context, cfg *config.Config, db *database.Database, repo *zypper.Repository, fetcher Fetcher) (state RefreshState, err error) {
+	if repo.Type != "rpm-md" && repo.Type != susetagsType && repo.Type != contentsType {
 		slog.WarnContext(ctx,
 			"Skipping repository of unknown type",
 			"repository", repo.Name, "type", repo.Type)
-		return nil
+		return RefreshSkipped, nil
 	}
 	lastUpdated, lastModified, err := db.GetTimestamps(ctx, repo)
 	if err != nil {
-		return err
+		return RefreshFailed, err
+	}
+	settings := indexSettings(cfg, repo)
+	reindex, err := settingsChanged(ctx, db, repo, settings)
+	if err != nil {
+		return RefreshFailed, err
 	}
-	if lastUpdated.Add(time.Hour).After(time.Now()) {
+	if !reindex && lastUpdated.Add(cfg.RefreshIntervalFor(repo.Alias)).After(time.Now()) {
 		slog.DebugContext(ctx,
 			"Repository does not require update",
 			"repository", repo.Name, "last update", lastUpdated.Local())
-		return nil
+		return RefreshCurrent, nil
+	}
+	lock, err := acquireRefreshLock(ctx, cfg, db, repo)
+	if errors.Is(err, errRefreshBusy) {
+		slog.WarnContext(ctx, "Repository is still being refreshed by another process; using cached data",
+			"repository", repo.Name)
+		return RefreshSkipped, nil
+	} else if err != nil {
+		return RefreshFailed, err
+	}
+	defer lock.release(ctx)
+	// Another process may have refreshed the repository while we waited.
+	if lastUpdated, lastModified, err = db.GetTimestamps(ctx, repo); err != nil {
+		return RefreshFailed, err
+	}
+	if reindex, err = settingsChanged(ctx, db, repo, settings); err != nil {
+		return RefreshFailed, err
+	}
+	if !reindex && lastUpdated.Add(cfg.RefreshIntervalFor(repo.Alias)).After(time.Now()) {
+		slog.DebugContext(ctx,
+			"Repository was updated by another process",
+			"repository", repo.Name, "last update", lastUpdated.Local())
+		return RefreshCurrent, nil
+	}
+	if reindex && !lastModified.IsZero() {
+		slog.InfoContext(ctx, "Indexing settings changed; indexing repository again", "repository", repo.Name)
 	}
 	slog.DebugContext(ctx, "Updating repository",
 		"repository", repo.Name, "url", repo.URL, "last update", lastUpdated.Local())
+	metrics := &refreshMetrics{started: time.Now()}
+	defer func() {
+		metrics.record(ctx, db, repo, state, err)
+	}()
+	if mirror := selectMirror(ctx, cfg, db, repo, fetcher); mirror != repo.URL {
+		fetcher = &mirrorFetcher{Fetcher: fetcher, db: db, primary: repo.URL, selected: mirror}
+	}
+	fetcher = &meteredFetcher{Fetcher: fetcher, metrics: metrics}
 	updateStartTime := time.Now().UTC()
 
-	mdBody, err := fetch(ctx, repo.Name, "repomd.xml", repo.URL, "repodata", "repomd.xml")
+	// unreachable wraps fetch errors so callers can tell them apart.
+	unreachable := func(err error) error {
+		err = &ErrRepoUnreachable{Repo: repo, Err: err}
+		if !lastModified.IsZero() {
+			return fmt.Errorf("%w: %w", ErrStaleCache, err)
+		}
+		return err
+	}
+
+	if repo.Type == susetagsType {
+		return updateSusetags(ctx, cfg, db, repo, fetcher, updateStartTime, unreachable, metrics)
+	}
+	if repo.Type == contentsType {
+		return updateContents(ctx, cfg, db, repo, fetcher, updateStartTime, unreachable, metrics)
+	}
+
+	mdBody, err := fetcher.Fetch(ctx, repo.Name, "repomd.xml", repo.URL, "repodata", "repomd.xml")
 	if err != nil {
 		if !repo.Enabled {
-			return nil // Ignore errors from disabled repositories
+			return RefreshSkipped, nil // Ignore errors from disabled repositories
 		}
-		return err
+		return RefreshFailed, unreachable(err)
 	}
 	defer func() {
 		_ = mdBody.Close()
 	}()
-	type repomdData struct {
-		Type     string `xml:"type,attr"`
-		Checksum struct {
-			Type  string `xml:"type,attr"`
-			Value string `xml:",chardata"`
-		} `xml:"checksum"`
-		Location struct {
-			Href string `xml:"href,attr"`
-		} `xml:"location"`
-		Timestamp int64 `xml:"timestamp"`
-		Size      int   `xml:"size"`
-	}
-	var repomd struct {
-		Data []repomdData `xml:"data"`
-	}
+	var repomd repomdFile
 	if err := xml.NewDecoder(mdBody).Decode(&repomd); err != nil {
-		return fmt.Errorf("failed to parse repomd.xml from %s: %w", repo.Name, err)
+		return RefreshFailed, fmt.Errorf("failed to parse repomd.xml from %s: %w", repo.Name, err)
 	}
 	_ = mdBody.Close()
 
-	fileListIndex := slices.IndexFunc(repomd.Data, func(d repomdData) bool {
-		return d.Type == "filelists"
-	})
+	fileListIndex := repomd.filesIndex()
 	if fileListIndex < 0 {
-		return fmt.Errorf("repository %s does not have file lists", repo.Name)
+		return RefreshFailed, fmt.Errorf("repository %s does not have file lists", repo.Name)
+	}
+	fileList := &repomd.Data[fileListIndex]
+	fromPrimary := fileList.Type == "primary"
+	if fromPrimary {
+		slog.WarnContext(ctx, "Repository does not have file lists; only the files listed in primary.xml are indexed",
+			"repository", repo.Name)
 	}
 	timestamp := time.Unix(repomd.Data[fileListIndex].Timestamp, 0).UTC()
-	if timestamp.Equal(lastModified) {
+	if timestamp.Equal(lastModified) && !reindex {
 		slog.DebugContext(ctx, "File list has not changed",
 			"repository", repo.Name, "last update", lastModified.Local())
-		return nil
+		return RefreshCurrent, nil
+	}
+	if size := repomd.Data[fileListIndex].Size; cfg.MaxFileListSize > 0 && size > cfg.MaxFileListSize && !cfg.Force {
+		slog.WarnContext(ctx, "Skipping repository with file list larger than maxFileListSize; use -force to index it anyway",
+			"repository", repo.Name, "size", size, "maxFileListSize", cfg.MaxFileListSize)
+		return RefreshSkipped, nil
 	}
 
-	fileListBody, err := fetch(ctx,
-		repo.Name, "filelists.xml", repo.URL, repomd.Data[fileListIndex].Location.Href)
+	filter, err := newPathFilter(cfg)
 	if err != nil {
-		if !repo.Enabled {
-			return nil // Ignore errors from disabled repositories
-		}
-		return err
+		return RefreshFailed, err
 	}
-	defer func() {
-		_ = fileListBody.Close()
-	}()
 
-	var hasher hash.Hash
-	switch repomd.Data[fileListIndex].Checksum.Type {
-	case "sha512":
-		hasher = sha512.New()
-	}
-	fileListReader := fileListBody.(io.Reader)
-	if hasher != nil {
-		fileListReader = io.TeeReader(fileListBody, hasher)
+	// Package sizes and locations are nice to have; don't fail without them.
+	var primary map[string]*primaryPackage
+	if primaryIndex := slices.IndexFunc(repomd.Data, func(d repomdData) bool {
+		return d.Type == "primary"
+	}); primaryIndex >= 0 {
+		primary, err = readPrimary(ctx, cfg, fetcher, repo, &repomd.Data[primaryIndex], fromPrimary, unreachable)
+		if err != nil && fromPrimary {
+			return RefreshFailed, err
+		} else if err != nil {
+			slog.WarnContext(ctx, "Failed to read package details", "repository", repo.Name, "error", err)
+		}
 	}
 
-	switch path.Ext(repomd.Data[fileListIndex].Location.Href) {
-	case ".gz":
-		fileListReader, err = gzip.NewReader(fileListReader)
-	case ".zst":
-		fileListReader, err = zstd.NewReader(fileListReader)
-	}
-	if err != nil {
-		return fmt.Errorf("failed to decompress filelists.xml: %w", err)
+	var changelogs map[string][]database.Changelog
+	if cfg.Changelogs {
+		if otherIndex := slices.IndexFunc(repomd.Data, func(d repomdData) bool {
+			return d.Type == "other"
+		}); otherIndex >= 0 {
+			changelogs, err = readChangelogs(ctx, cfg, fetcher, repo, &repomd.Data[otherIndex], unreachable)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to read changelogs", "repository", repo.Name, "error", err)
+			}
+		}
 	}
 
 	var data struct {
-		Package []*struct {
-			PkgId   string `xml:"pkgid,attr"`
-			Name    string `xml:"name,attr"`
-			Arch    string `xml:"arch,attr"`
-			Version struct {
-				Epoch   string `xml:"epoch,attr"`
-				Version string `xml:"ver,attr"`
-				Release string `xml:"rel,attr"`
-			} `xml:"version"`
-			Files []*struct {
-				Type string `xml:"type,attr"`
-				Path string `xml:",chardata"`
-			} `xml:"file"`
-		} `xml:"package"`
-	}
-
-	if err := xml.NewDecoder(fileListReader).Decode(&data); err != nil {
-		return fmt.Errorf("failed to parse filelists.xml from %s: %w", repo.Name, err)
+		Package []*fileListPackage `xml:"package"`
 	}
-
-	if hasher != nil {
-		sum := fmt.Sprintf("%02x", hasher.Sum(nil))
-		if sum != repomd.Data[fileListIndex].Checksum.Value {
-			slog.WarnContext(ctx, "File list has incorrect checksum",
-				"repository", repo.Name,
-				"expected", repomd.Data[fileListIndex].Checksum.Value,
-				"actual", sum)
+	readFileList := func() error {
+		data.Package = nil
+		if fromPrimary {
+			// The packages were already read.
+			for _, pkgId := range slices.Sorted(maps.Keys(primary)) {
+				pkg := primary[pkgId]
+				data.Package = append(data.Package, &fileListPackage{
+					PkgId:   pkgId,
+					Name:    pkg.Name,
+					Arch:    pkg.Arch,
+					Version: pkg.Version,
+					Files:   pkg.Files,
+				})
+			}
+			return nil
 		}
+		return readMetadata(ctx, cfg, fetcher, repo, fileList, unreachable, func(r io.Reader) error {
+			return xml.NewDecoder(r).Decode(&data)
+		})
+	}
+	err = readFileList()
+	if err != nil && fileList.Type == "filelists-ext" && ctx.Err() == nil {
+		// Mirrors may not have the extended file lists yet; the plain ones
+		// only lack the digests.
+		if plainIndex := slices.IndexFunc(repomd.Data, func(d repomdData) bool {
+			return d.Type == "filelists"
+		}); plainIndex >= 0 {
+			slog.WarnContext(ctx, "Failed to read extended file lists, using plain file lists",
+				"repository", repo.Name, "error", err)
+			fileList = &repomd.Data[plainIndex]
+			err = readFileList()
+		}
+	}
+	var unreachableErr *ErrRepoUnreachable
+	if err != nil && !repo.Enabled && errors.As(err, &unreachableErr) {
+		return RefreshSkipped, nil // Ignore errors from disabled repositories
+	} else if err != nil {
+		return RefreshFailed, err
 	}
 
-	err = db.UpdateRepository(ctx, repo, updateStartTime, timestamp, func(addPkg func(pkgid, name, arch, epoch, version, release string) (func(string) error, error)) error {
-		for _, pkg := range data.Package {
-			addFile, err := addPkg(pkg.PkgId, pkg.Name, pkg.Arch, pkg.Version.Epoch, pkg.Version.Version, pkg.Version.Release)
+	provenance := database.Provenance{
+		Revision: repomd.Revision,
+		Checksum: fileList.Checksum.Type + ":" + fileList.Checksum.Value,
+		Settings: settings,
+	}
+	err = importPackages(ctx, db, repo, updateStartTime, timestamp, provenance, filter, data.Package, primary, changelogs, metrics)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	return RefreshUpdated, nil
+}
+
+// importPackages writes the packages to the database as the new contents of
+// the repository.  The details from primary.xml and the changelogs are looked
+// up by pkgid, and may be missing.
+func importPackages(
+	ctx context.Context,
+	db *database.Database,
+	repo *zypper.Repository,
+	lastChecked, lastModified time.Time,
+	provenance database.Provenance,
+	filter *pathFilter,
+	packages []*fileListPackage,
+	primary map[string]*primaryPackage,
+	changelogs map[string][]database.Changelog,
+	metrics *refreshMetrics,
+) error {
+	return db.UpdateRepository(ctx, repo, lastChecked, lastModified, provenance, func(addPkg func(*database.Package) (func(string, string) error, error)) error {
+		for _, pkg := range packages {
+			info := &database.Package{
+				PkgId:   pkg.PkgId,
+				Name:    pkg.Name,
+				Arch:    pkg.Arch,
+				Epoch:   pkg.Version.Epoch,
+				Version: pkg.Version.Version,
+				Release: pkg.Version.Release,
+			}
+			if details, ok := primary[pkg.PkgId]; ok {
+				info.Size = details.Size.Package
+				info.InstalledSize = details.Size.Installed
+				info.Location = details.Location.Href
+				info.Group = details.Group
+				info.Provides = details.indexed
+			}
+			info.Changelogs = changelogs[pkg.PkgId]
+			alternativeNames := make(map[string]bool)
+			for _, file := range pkg.Files {
+				if dir, name := path.Split(file.Path); dir == "/etc/alternatives/" {
+					alternativeNames[name] = true
+				}
+			}
+			for _, file := range pkg.Files {
+				if isAlternative(file.Path, file.Type, alternativeNames) {
+					info.Alternatives = append(info.Alternatives, file.Path)
+				}
+				if file.Type != "" && file.Type != "dir" {
+					if info.FileTypes == nil {
+						info.FileTypes = make(map[string]string)
+					}
+					info.FileTypes[file.Path] = file.Type
+				}
+			}
+			addFile, err := addPkg(info)
 			if err != nil {
 				return err
 			}
+			metrics.packages++
 			for _, file := range pkg.Files {
 				if file.Type == "dir" {
 					continue
@@ -193,30 +666,107 @@ func updateRepository(ctx context.Context, db *database.Database, repo *zypper.R
 				if !filepath.IsAbs(file.Path) {
 					continue
 				}
-				if err := addFile(file.Path); err != nil {
+				if !filter.allowed(file.Path) {
+					continue
+				}
+				if err := addFile(file.Path, file.Hash); err != nil {
 					return err
 				}
+				metrics.files++
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		return err
+}
+
+// alternativeDirs are the directories containing update-alternatives links.
+var alternativeDirs = []string{"/etc/alternatives/", "/usr/lib/alternatives/"}
+
+// isAlternative returns whether a file is managed by update-alternatives:
+// either it is in one of the alternatives directories, or it is a ghost file
+// with the same name as one of the package's links in /etc/alternatives.
+func isAlternative(filePath, fileType string, alternativeNames map[string]bool) bool {
+	for _, dir := range alternativeDirs {
+		if strings.HasPrefix(filePath, dir) {
+			return true
+		}
 	}
-	return nil
+	return fileType == "ghost" && alternativeNames[path.Base(filePath)]
 }
 
-func Refresh(ctx context.Context, db *database.Database, repos []*zypper.Repository) error {
-	wg, ctx := errgroup.WithContext(ctx)
-	for _, repo := range repos {
-		wg.Go(func() error {
-			if !strings.HasPrefix(repo.URL, "http://") && !strings.HasPrefix(repo.URL, "https://") {
-				slog.WarnContext(ctx, "Skipping non-HTTP repository",
-					"repository", repo.Name, "url", repo.URL)
-				return nil
+// refreshParallelism is the maximum number of repositories refreshed at once.
+const refreshParallelism = 8
+
+// Refresh the cached metadata for the given repositories, as necessary.  A
+// failure to refresh one repository does not stop the others from being
+// refreshed; the status of each repository is returned in the same order as
+// the input, and the returned error joins all individual failures.
+func Refresh(ctx context.Context, cfg *config.Config, db *database.Database, repos []*zypper.Repository) ([]*RefreshStatus, error) {
+	clients := newHTTPClients(cfg)
+	statuses := itertools.ParallelMap(repos, refreshParallelism, func(repo *zypper.Repository) *RefreshStatus {
+		status := &RefreshStatus{Repo: repo}
+		// Each repository gets its own context, so that one timing out does not
+		// affect the others; cancelling ctx still stops all of them.
+		var repoCtx context.Context
+		var cancel context.CancelFunc
+		timeout := cfg.RepoTimeoutFor(repo.Alias)
+		if timeout > 0 {
+			repoCtx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrRepoTimeout, timeout))
+		} else {
+			repoCtx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+		fetcher := fetcherFor(repo.URL)
+		if fetcher == nil {
+			slog.WarnContext(ctx, "Skipping repository with unsupported URL scheme",
+				"repository", repo.Name, "url", repo.URL)
+			status.State = RefreshSkipped
+			return status
+		}
+		fetcher, err := clients.configure(repoCtx, repo, fetcher)
+		if err != nil {
+			status.State, status.Err = RefreshFailed, err
+			return status
+		}
+		status.State, status.Err = updateRepository(repoCtx, cfg, db, repo, fetcher)
+		if status.Err != nil && ctx.Err() == nil && repoCtx.Err() != nil {
+			// Only this repository timed out; don't report it as the context
+			// deadline, which would look like the whole run timed out.  The
+			// refresh was rolled back, so any previous generation of the
+			// data is still there to use, however far the refresh got.
+			err := fmt.Errorf("repository %s: %w", repo.Name, context.Cause(repoCtx))
+			if _, lastModified, tsErr := db.GetTimestamps(ctx, repo); tsErr == nil && !lastModified.IsZero() {
+				err = fmt.Errorf("%w: %w", ErrStaleCache, err)
 			}
-			return updateRepository(ctx, db, repo, fetchHttp)
-		})
+			status.State, status.Err = RefreshFailed, err
+		}
+		if errors.Is(status.Err, ErrStaleCache) {
+			status.State = RefreshStale
+		}
+		if status.State == RefreshUpdated && cfg.OnRefreshSuccess != "" {
+			err := hook.Run(ctx, cfg.OnRefreshSuccess, hook.EventRefreshSuccess, map[string]string{
+				"repository": repo.Alias,
+				"name":       repo.Name,
+				"url":        repo.URL,
+			})
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to run hook", "repository", repo.Alias, "error", err)
+			}
+		}
+		return status
+	})
+
+	if clients.cache != nil {
+		if err := clients.cache.prune(httpCacheMaxAge); err != nil {
+			slog.DebugContext(ctx, "Failed to prune HTTP cache", "error", err)
+		}
+	}
+
+	var errs []error
+	for _, status := range statuses {
+		if status.Err != nil {
+			errs = append(errs, status.Err)
+		}
 	}
-	return wg.Wait()
+	return statuses, errors.Join(errs...)
 }
@@ -4,16 +4,33 @@
 package repository
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
 	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/mook-as/zypper-filesearch/config"
 	"github.com/mook-as/zypper-filesearch/database"
 	"github.com/mook-as/zypper-filesearch/zypper"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // zsync uses MD4 block checksums.
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
 )
@@ -42,25 +59,1253 @@ func TestRefresh(t *testing.T) {
 	}
 
 	// Check that we have no results before the refresh
-	results, err := db.SearchFile(t.Context(), repos, "*/zypper-filesearch/LICENSE*", "x86_64_v999")
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*/zypper-filesearch/LICENSE*", "x86_64_v999")
 	assert.NilError(t, err, "failed to search for files")
 	assert.Check(t, cmp.Len(results, 0))
 
-	err = Refresh(t.Context(), db, repos)
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
 	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+	// Check that the refresh was recorded
+	records, err := db.RefreshRecords(t.Context(), 10)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(records, 1))
+	assert.Check(t, cmp.Equal(records[0].Outcome, string(RefreshUpdated)))
+	assert.Check(t, records[0].Bytes > 0)
+	assert.Check(t, records[0].Download <= records[0].Duration)
+	assert.Check(t, records[0].Packages > 0)
+	assert.Check(t, records[0].Files > 0)
 
 	// Check that we found results after the refresh
-	results, err = db.SearchFile(t.Context(), repos, "*/zypper-filesearch/LICENSE*", "x86_64_v999")
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*/zypper-filesearch/LICENSE*", "x86_64_v999")
 	assert.NilError(t, err, "failed to search for files")
 	assert.Assert(t, cmp.DeepEqual(results, []database.SearchResult{
 		{
-			Repository: "test",
-			Package:    "zypper-filesearch",
-			Arch:       "x86_64",
-			Epoch:      "0",
-			Version:    "0.20251202T1523520800.235d9b57f3d8fbc2bc1856a34a088ba831bbae86",
-			Release:    "lp160.10.1",
-			Path:       "/usr/share/licenses/zypper-filesearch/LICENSE.txt",
+			Repository:    "test",
+			Package:       "zypper-filesearch",
+			Arch:          "x86_64",
+			Epoch:         "0",
+			Version:       "0.20251202T1523520800.235d9b57f3d8fbc2bc1856a34a088ba831bbae86",
+			Release:       "lp160.10.1",
+			URL:           server.URL,
+			PkgId:         "a8c52388771b0c249b611fbc6f32a1b94c1daeb234101dc2b2a406594cc9e57f93b0f66bf6ba5815e6db507daba03d0d64487126243a22d7ba16bb6f6bb3cb73",
+			Size:          2416236,
+			InstalledSize: 6011533,
+			Location:      "x86_64/zypper-filesearch-0.20251202T1523520800.235d9b57f3d8fbc2bc1856a34a088ba831bbae86-lp160.10.1.x86_64.rpm",
+			Revision:      "1764689000",
+			Path:          "/usr/share/licenses/zypper-filesearch/LICENSE.txt",
+			FileType:      "file",
 		},
 	}))
+
+	// Check that the package group was imported
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos, Groups: []string{"System/*"}}, "*/zypper-filesearch/LICENSE*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos, Groups: []string{"Development/*"}}, "*/zypper-filesearch/LICENSE*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+
+	// Check that shared libraries provided were imported
+	results, err = db.SearchSoname(t.Context(), database.RepoFilter{Repos: repos}, "libfilesearch.so.1", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Path, "libfilesearch.so.1()(64bit)"))
+
+	// Check that pkg-config modules provided were imported
+	results, err = db.SearchPkgConfig(t.Context(), database.RepoFilter{Repos: repos}, "filesearch", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Path, "pkgconfig(filesearch)"))
+}
+
+func TestRefreshChangelogs(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repos := []*zypper.Repository{
+		{
+			Name:    "test",
+			Type:    "rpm-md",
+			Enabled: true,
+			URL:     server.URL,
+		},
+	}
+	cfg := &config.Config{CacheDir: t.TempDir()}
+	_, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	stats, err := db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Check(t, cmp.Equal(stats[0].Changelogs, 0))
+
+	// Enabling changelogs indexes the repository again, even though it has
+	// not changed.
+	cfg.Changelogs = true
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	stats, err = db.Stats(t.Context())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(stats, 1))
+	assert.Check(t, cmp.Equal(stats[0].Changelogs, 2))
+
+	results, err := db.SearchChangelogs(t.Context(), database.RepoFilter{Repos: repos}, "CVE-2025-*")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Package, "zypper-filesearch"))
+	assert.Check(t, cmp.Equal(results[0].Author, "Packager <packager@example.test>"))
+	assert.Check(t, cmp.Equal(results[0].Date, time.Unix(1764633600, 0).UTC()))
+}
+
+func TestRefreshUnreachable(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	repos := []*zypper.Repository{
+		{
+			Name:    "test",
+			Type:    "rpm-md",
+			Enabled: true,
+			URL:     server.URL,
+		},
+	}
+
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshFailed))
+	var unreachable *ErrRepoUnreachable
+	assert.Assert(t, errors.As(err, &unreachable), "unexpected error %v", err)
+	assert.Check(t, cmp.Equal(unreachable.Repo, repos[0]))
+	assert.Check(t, !errors.Is(err, ErrStaleCache))
+}
+
+func TestRefreshRepoTimeout(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow/") {
+			// Never respond until the client gives up.
+			<-r.Context().Done()
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repos := []*zypper.Repository{
+		{Alias: "slow", Name: "slow", Type: "rpm-md", Enabled: true, URL: server.URL + "/slow"},
+		{Alias: "fast", Name: "fast", Type: "rpm-md", Enabled: true, URL: server.URL},
+	}
+	cfg := &config.Config{CacheDir: t.TempDir(), RepoTimeout: 100 * time.Millisecond}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.Check(t, cmp.ErrorIs(err, ErrRepoTimeout))
+	assert.Check(t, !errors.Is(err, context.DeadlineExceeded))
+	assert.Assert(t, cmp.Len(statuses, 2))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshFailed))
+	assert.Check(t, cmp.Equal(statuses[1].State, RefreshUpdated))
+}
+
+func TestRefreshRepoTimeoutStale(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	repomd, err := fs.ReadFile(subFS, "repodata/repomd.xml")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	var updated atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !updated.Load():
+			files.ServeHTTP(w, r)
+		case r.URL.Path == "/repodata/repomd.xml":
+			_, _ = w.Write(bytes.ReplaceAll(repomd, []byte("1764717985"), []byte("1764717986")))
+		case r.URL.Path == "/repodata/filelists.uncompressed.xml":
+			// Time out in the middle of the refresh, after repomd.xml.
+			<-r.Context().Done()
+		default:
+			files.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repos := []*zypper.Repository{{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL}}
+	cfg := &config.Config{CacheDir: t.TempDir(), RefreshInterval: time.Nanosecond, RepoTimeout: 100 * time.Millisecond}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+	// The data from the first refresh is still used.
+	updated.Store(true)
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.Check(t, cmp.ErrorIs(err, ErrRepoTimeout))
+	assert.Check(t, cmp.ErrorIs(err, ErrStaleCache))
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshStale))
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*", "")
+	assert.NilError(t, err)
+	assert.Check(t, len(results) > 0)
+}
+
+func TestRefreshLock(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repos := []*zypper.Repository{{Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL}}
+	cfg := &config.Config{CacheDir: t.TempDir()}
+
+	// Pretend another process is refreshing the repository.
+	lock, err := acquireRefreshLock(t.Context(), cfg, db, repos[0])
+	assert.NilError(t, err)
+	holder, err := db.GetRefreshLock(t.Context(), repos[0])
+	assert.NilError(t, err)
+	assert.Assert(t, holder != nil)
+	assert.Check(t, cmp.Equal(holder.PID, os.Getpid()))
+
+	// Without waiting, the cached data is used.
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshSkipped))
+
+	// When the other process finishes in time, the refresh goes ahead.
+	cfg.RefreshWait = 10 * time.Second
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lock.release(t.Context())
+	}()
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	holder, err = db.GetRefreshLock(t.Context(), repos[0])
+	assert.NilError(t, err)
+	assert.Check(t, holder == nil)
+}
+
+func TestRefreshResume(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	fileList, err := fs.ReadFile(subFS, "repodata/filelists.uncompressed.xml")
+	assert.NilError(t, err)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repodata/filelists.uncompressed.xml" {
+			files.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"filelists"`)
+		if r.Header.Get("Range") == "" {
+			// Drop the connection half way through the first download.
+			w.Header().Set("Content-Length", fmt.Sprint(len(fileList)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(fileList[:len(fileList)/2])
+			w.(http.Flusher).Flush()
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "filelists.uncompressed.xml", time.Time{}, bytes.NewReader(fileList))
+	}))
+	defer server.Close()
+
+	repos := []*zypper.Repository{
+		{Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL},
+	}
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(statuses, 1))
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, cmp.DeepEqual(ranges, []string{fmt.Sprintf("bytes=%d-", len(fileList)/2)}))
+}
+
+func TestRefreshUnixSocket(t *testing.T) {
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+
+	// serve starts a server on a Unix domain socket, recording the hosts of
+	// the requests it receives.
+	serve := func() (string, *[]string) {
+		socket := path.Join(t.TempDir(), "http.sock")
+		listener, err := net.Listen("unix", socket)
+		assert.NilError(t, err)
+		var hosts []string
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.URL.Host // Only set for proxy requests.
+			if host == "" {
+				host = r.Host
+			}
+			hosts = append(hosts, host)
+			files.ServeHTTP(w, r)
+		}))
+		server.Listener = listener
+		server.Start()
+		t.Cleanup(server.Close)
+		return socket, &hosts
+	}
+
+	repos := []*zypper.Repository{
+		{Alias: "mirror", Name: "mirror", Type: "rpm-md", Enabled: true, URL: "http://mirror.invalid"},
+	}
+
+	// A repository with its own socket.
+	socket, hosts := serve()
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	cfg := &config.Config{
+		CacheDir: t.TempDir(),
+		Repos:    map[string]*config.RepoConfig{"mirror": {Socket: socket}},
+	}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, len(*hosts) > 0)
+
+	// A proxy on a socket; it sees the original host.
+	socket, hosts = serve()
+	db, err = database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	cfg = &config.Config{CacheDir: t.TempDir(), ProxySocket: socket}
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Assert(t, len(*hosts) > 0)
+	assert.Check(t, cmp.Equal((*hosts)[0], "mirror.invalid"))
+}
+
+func TestRefreshIPFamily(t *testing.T) {
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	// The test server only listens on IPv4.
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	for family, expected := range map[config.IPFamily]RefreshState{
+		config.IPFamilyAuto: RefreshUpdated,
+		config.IPFamily4:    RefreshUpdated,
+		config.IPFamily6:    RefreshFailed,
+	} {
+		db, err := database.NewTesting(t.Context())
+		assert.NilError(t, err)
+		repos := []*zypper.Repository{
+			{Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL},
+		}
+		statuses, _ := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir(), IPFamily: family}, db, repos)
+		assert.Assert(t, cmp.Len(statuses, 1))
+		assert.Check(t, cmp.Equal(statuses[0].State, expected), "family %s: %v", family, statuses[0].Err)
+	}
+}
+
+func TestRefreshMaxConnsPerHost(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	var running, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if current <= old || peak.CompareAndSwap(old, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		// Each repository has a different prefix, /repoN/.
+		_, rest, _ := strings.Cut(r.URL.Path[1:], "/")
+		r.URL.Path = "/" + rest
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	var repos []*zypper.Repository
+	for i := range 4 {
+		name := fmt.Sprintf("repo%d", i)
+		repos = append(repos, &zypper.Repository{Alias: name, Name: name, Type: "rpm-md", Enabled: true, URL: server.URL + "/" + name})
+	}
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir(), MaxConnsPerHost: 1}, db, repos)
+	assert.NilError(t, err)
+	for _, status := range statuses {
+		assert.Check(t, cmp.Equal(status.State, RefreshUpdated))
+	}
+	assert.Check(t, cmp.Equal(peak.Load(), int32(1)))
+}
+
+func TestRefreshMirrors(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	var slowDownloads, fastDownloads, brokenDownloads atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			time.Sleep(100 * time.Millisecond)
+		} else {
+			slowDownloads.Add(1)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			fastDownloads.Add(1)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer fast.Close()
+	// This mirror answers probes quickly, but fails to serve any files.
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			brokenDownloads.Add(1)
+			http.NotFound(w, r)
+		}
+	}))
+	defer broken.Close()
+
+	repos := []*zypper.Repository{
+		{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: slow.URL, Mirrors: []string{fast.URL}},
+	}
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, cmp.Equal(slowDownloads.Load(), int32(0)))
+	assert.Check(t, fastDownloads.Load() > 0)
+
+	latencies, err := db.MirrorLatencies(t.Context(), []string{mirrorHost(slow.URL), mirrorHost(fast.URL)})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(latencies, 2))
+	assert.Check(t, latencies[mirrorHost(fast.URL)].Latency < latencies[mirrorHost(slow.URL)].Latency)
+
+	// A mirror that fails is marked unreachable, and the primary URL is used.
+	db, err = database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	repos[0].Mirrors = []string{broken.URL}
+	statuses, err = Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, cmp.Equal(brokenDownloads.Load(), int32(1)))
+	assert.Check(t, slowDownloads.Load() > 0)
+	latencies, err = db.MirrorLatencies(t.Context(), []string{mirrorHost(broken.URL)})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(latencies[mirrorHost(broken.URL)].Latency, time.Duration(0)))
+}
+
+func TestCheck(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	repos := []*zypper.Repository{
+		{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL},
+		{Alias: "missing", Name: "missing", Type: "rpm-md", Enabled: true, URL: missing.URL},
+		{Alias: "plaindir", Name: "plaindir", Type: "plaindir", Enabled: true, URL: server.URL},
+	}
+	cfg := &config.Config{CacheDir: t.TempDir()}
+	_, err = Refresh(t.Context(), cfg, db, repos[:1])
+	assert.NilError(t, err)
+
+	problems, err := Check(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Repository+": "+problem.Message)
+	}
+	assert.Check(t, cmp.DeepEqual(messages, []string{
+		"test: no checksum advertised for unrelated",
+		"test: no timestamp for unrelated",
+		"test: no checksum advertised for primary",
+		"missing: repository has not been indexed",
+		"missing: failed to fetch repomd.xml from missing: status code 404 (404 Not Found)",
+		`plaindir: repositories of type "plaindir" cannot be indexed`,
+	}))
+}
+
+func TestCheckUncached(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	files := http.FileServer(http.FS(subFS))
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	repos := []*zypper.Repository{{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL}}
+	cfg := &config.Config{CacheDir: t.TempDir(), HTTPCache: true}
+	_, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+
+	// The cached repomd.xml is still fresh, but the mirror must be checked.
+	down.Store(true)
+	problems, err := Check(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Assert(t, len(problems) > 0)
+	assert.Check(t, cmp.Contains(problems[len(problems)-1].Message, "status code 503"))
+}
+
+func TestServiceRepositories(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Check(t, cmp.Equal(r.URL.RawQuery, ""), "credentials sent to the server")
+		if r.URL.Path == "/empty/repo/repoindex.xml" {
+			_, _ = io.WriteString(w, `<repoindex ttl="86400"/>`)
+			return
+		}
+		if r.URL.Path != "/access/repo/repoindex.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `<repoindex ttl="86400">`+
+			`<repo url="https://updates.test/Products/SLES/product?token" alias="SLES-Pool" name="SLES-Pool" enabled="true"/>`+
+			`<repo url="https://updates.test/Products/SLES/product_debug?token" alias="SLES-Debug-Pool" enabled="false"/>`+
+			`<repo path="/Updates/SLES" alias="SLES-Updates" priority="50" enabled="1"/>`+
+			`</repoindex>`)
+	}))
+	defer server.Close()
+
+	t.Setenv("ZYPP_CONF", "")
+	root := t.TempDir()
+	servicesDir := filepath.Join(root, "etc/zypp/services.d")
+	credentialsDir := filepath.Join(root, "etc/zypp/credentials.d")
+	assert.NilError(t, os.MkdirAll(servicesDir, 0o755))
+	assert.NilError(t, os.MkdirAll(credentialsDir, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(servicesDir, "SLES.service"), []byte(
+		"[SLES]\nname=SLES\nenabled=1\ntype=ris\nurl="+server.URL+"/access?credentials=SCC\n"+
+			"[refreshed]\nenabled=1\ntype=ris\nurl="+server.URL+"/refreshed\n"+
+			"[disabled]\nenabled=0\ntype=ris\nurl="+server.URL+"/disabled\n"+
+			"[empty]\nenabled=1\ntype=ris\nurl="+server.URL+"/empty?credentials=SCC\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(credentialsDir, "SCC"), []byte("username=user\npassword=secret\n"), 0o600))
+	previous := zypper.Default
+	zypper.Default = &zypper.Files{Root: root}
+	t.Cleanup(func() { zypper.Default = previous })
+
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+	listed := []*zypper.Repository{{Alias: "refreshed:repo", Service: "refreshed", URL: server.URL + "/repo"}}
+	cfg := &config.Config{CacheDir: t.TempDir(), InstallRoot: root}
+	expected := []*zypper.Repository{
+		{Alias: "SLES:SLES-Pool", Name: "SLES-Pool", Type: "rpm-md", Enabled: true, Priority: zypper.DefaultPriority, GPGCheck: true, URL: "https://updates.test/Products/SLES/product?token", Service: "SLES"},
+		{Alias: "SLES:SLES-Debug-Pool", Name: "SLES-Debug-Pool", Type: "rpm-md", Priority: zypper.DefaultPriority, GPGCheck: true, URL: "https://updates.test/Products/SLES/product_debug?token", Service: "SLES"},
+		{Alias: "SLES:SLES-Updates", Name: "SLES-Updates", Type: "rpm-md", Enabled: true, Priority: 50, GPGCheck: true, URL: server.URL + "/access/Updates/SLES", Service: "SLES"},
+	}
+
+	// Without refreshing, nothing is downloaded, and nothing is known yet.
+	repos, err := ServiceRepositories(t.Context(), cfg, db, zypper.Options{InstallRoot: root}, listed, false)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(repos, 0))
+	assert.Check(t, cmp.Equal(requests, 0))
+
+	repos, err = ServiceRepositories(t.Context(), cfg, db, zypper.Options{InstallRoot: root}, listed, true)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(repos, expected))
+	assert.Check(t, cmp.Equal(requests, 2))
+
+	// The recorded lists are used until the refresh interval has passed, even
+	// for the service without any repositories, and without refreshing.
+	for _, refresh := range []bool{true, false} {
+		repos, err = ServiceRepositories(t.Context(), cfg, db, zypper.Options{InstallRoot: root}, listed, refresh)
+		assert.NilError(t, err)
+		assert.Check(t, cmp.DeepEqual(repos, expected), "refresh %v", refresh)
+		assert.Check(t, cmp.Equal(requests, 2), "refresh %v", refresh)
+	}
+
+	// Once it has passed, the indexes are downloaded again.
+	cfg.RefreshInterval = time.Nanosecond
+	repos, err = ServiceRepositories(t.Context(), cfg, db, zypper.Options{InstallRoot: root}, listed, true)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(repos, expected))
+	assert.Check(t, cmp.Equal(requests, 4))
+
+	// If that fails, the recorded list is still used.
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	repos, err = ServiceRepositories(t.Context(), cfg, db, zypper.Options{InstallRoot: root}, listed, true)
+	assert.Check(t, cmp.ErrorContains(err, "service SLES"))
+	assert.Check(t, cmp.DeepEqual(repos, expected))
+}
+
+func TestPlanRefresh(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repos := []*zypper.Repository{
+		{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL},
+	}
+	cfg := &config.Config{CacheDir: t.TempDir(), HTTPCache: true}
+	plans, err := PlanRefresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(plans, 1))
+	assert.Check(t, plans[0].Fetch)
+	assert.Check(t, cmp.Equal(plans[0].Reason, "never indexed"))
+	assert.Check(t, cmp.Equal(plans[0].Size, int64(631)))
+
+	// Nothing was written.
+	lastUpdated, _, err := db.GetTimestamps(t.Context(), repos[0])
+	assert.NilError(t, err)
+	assert.Check(t, lastUpdated.IsZero())
+	entries, err := os.ReadDir(cfg.CacheDir)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(entries, 0))
+
+	_, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	plans, err = PlanRefresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, !plans[0].Fetch)
+	assert.Check(t, cmp.Contains(plans[0].Reason, "checked recently"))
+
+	cfg.RefreshInterval = time.Nanosecond
+	plans, err = PlanRefresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, !plans[0].Fetch)
+	assert.Check(t, cmp.Equal(plans[0].Reason, "refresh interval expired, but the file list has not changed"))
+}
+
+func TestRegisterFetcher(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	RegisterFetcher("test", FetcherFunc(func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+		return subFS.Open(path.Join(parts[1:]...))
+	}))
+	defer RegisterFetcher("test", nil)
+
+	repos := []*zypper.Repository{
+		{
+			Name:    "test",
+			Type:    "rpm-md",
+			Enabled: true,
+			URL:     "test://repository",
+		},
+	}
+
+	_, err = Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.NilError(t, err)
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*/zypper-filesearch/LICENSE*", "x86_64")
+	assert.NilError(t, err, "failed to search for files")
+	assert.Check(t, cmp.Len(results, 1))
+}
+
+func TestRefreshChecksumMismatch(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// Serve a file list that is still valid XML, but does not match the
+	// checksum in repomd.xml.
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	RegisterFetcher("test", FetcherFunc(func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+		contents, err := fs.ReadFile(subFS, path.Join(parts[1:]...))
+		if err != nil {
+			return nil, err
+		}
+		if kind == "filelists.xml" {
+			contents = append(contents, "<!-- tampered -->"...)
+		}
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}))
+	defer RegisterFetcher("test", nil)
+
+	repos := []*zypper.Repository{
+		{
+			Name:    "test",
+			Type:    "rpm-md",
+			Enabled: true,
+			URL:     "test://repository",
+		},
+	}
+
+	_, err = Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.Check(t, cmp.ErrorIs(err, ErrChecksumMismatch))
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 0))
+}
+
+func TestHTTPCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.URL.Query().Get("etag") != "none" {
+			w.Header().Set("ETag", `"v1"`)
+		}
+		for _, value := range r.URL.Query()["cache-control"] {
+			w.Header().Add("Cache-Control", value)
+		}
+		_, _ = io.WriteString(w, "contents")
+	}))
+	defer server.Close()
+
+	cache := &HTTPCache{Dir: filepath.Join(t.TempDir(), "http")}
+	fetcher := &HTTPFetcher{Cache: cache}
+	fetch := func(url string) string {
+		body, err := fetcher.Fetch(t.Context(), "test", "file", url)
+		assert.NilError(t, err)
+		defer func() {
+			_ = body.Close()
+		}()
+		data, err := io.ReadAll(body)
+		assert.NilError(t, err)
+		return string(data)
+	}
+
+	// A fresh response is not requested again.
+	url := server.URL + "/fresh?cache-control=max-age=3600"
+	assert.Check(t, cmp.Equal(fetch(url), "contents"))
+	assert.Check(t, cmp.Equal(fetch(url), "contents"))
+	assert.Check(t, cmp.Equal(requests, 1))
+
+	// A response that must be revalidated uses the cached copy on 304.
+	requests = 0
+	url = server.URL + "/revalidate?cache-control=no-cache"
+	assert.Check(t, cmp.Equal(fetch(url), "contents"))
+	assert.Check(t, cmp.Equal(fetch(url), "contents"))
+	assert.Check(t, cmp.Equal(requests, 2))
+
+	// A response that may not be stored is not, even if it could be
+	// revalidated, whether the directives are in one header or several.
+	for _, query := range []string{"cache-control=no-cache,%20no-store", "cache-control=no-cache&cache-control=no-store"} {
+		url = server.URL + "/no-store?" + query
+		assert.Check(t, cmp.Equal(fetch(url), "contents"))
+		assert.Check(t, cache.lookup(url) == nil, query)
+	}
+
+	// A response that can be neither reused nor revalidated is not stored.
+	url = server.URL + "/uncacheable?etag=none"
+	assert.Check(t, cmp.Equal(fetch(url), "contents"))
+	assert.Check(t, cache.lookup(url) == nil)
+
+	// The cached files may contain data fetched with credentials.
+	info, err := os.Stat(cache.Dir)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(info.Mode().Perm(), os.FileMode(0o700)))
+	entries, err := os.ReadDir(cache.Dir)
+	assert.NilError(t, err)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		assert.NilError(t, err)
+		assert.Check(t, cmp.Equal(info.Mode().Perm(), os.FileMode(0o600)), entry.Name())
+	}
+
+	// Files that have not been used for a while are pruned.
+	url = server.URL + "/fresh?cache-control=max-age=3600"
+	bodyPath, metaPath := cache.paths(url)
+	old := time.Now().Add(-2 * httpCacheMaxAge)
+	assert.NilError(t, os.Chtimes(bodyPath, old, old))
+	assert.NilError(t, os.Chtimes(metaPath, old, old))
+	assert.NilError(t, cache.prune(httpCacheMaxAge))
+	assert.Check(t, cache.lookup(url) == nil)
+	assert.Check(t, cache.lookup(server.URL+"/revalidate?cache-control=no-cache") != nil)
+}
+
+func TestDeltaSync(t *testing.T) {
+	const blockSize = 16
+	oldData := []byte(strings.Repeat("0123456789abcdef", 64))
+	newData := slices.Concat(oldData[:300], []byte("changed!"), oldData[300:900], []byte("tail"))
+
+	// zsyncControl builds a zsync control file for the data.
+	zsyncControl := func(data []byte) []byte {
+		var control bytes.Buffer
+		sum := sha1.Sum(data)
+		fmt.Fprintf(&control, "zsync: 0.6.2\nBlocksize: %d\nLength: %d\nHash-Lengths: 1,4,16\nSHA-1: %x\n\n",
+			blockSize, len(data), sum)
+		for start := 0; start < len(data); start += blockSize {
+			block := make([]byte, blockSize)
+			copy(block, data[start:])
+			var a, b uint16
+			for i, ch := range block {
+				a += uint16(ch)
+				b += uint16(blockSize-i) * uint16(ch)
+			}
+			_ = binary.Write(&control, binary.BigEndian, [2]uint16{a, b})
+			hasher := md4.New()
+			_, _ = hasher.Write(block)
+			control.Write(hasher.Sum(nil))
+		}
+		return control.Bytes()
+	}
+
+	var rangeRequests, headRequests, zsyncRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".zsync") {
+			zsyncRequests++
+			// The headers of the control file must not be used for the file.
+			w.Header().Set("ETag", `"control"`)
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		switch r.URL.Path {
+		case "/repodata/old.xml":
+			w.Header().Set("ETag", `"old"`)
+			_, _ = w.Write(oldData)
+		case "/repodata/new.xml.zsync", "/repodata/same.xml.zsync":
+			_, _ = w.Write(zsyncControl(newData))
+		case "/repodata/old.xml.gz", "/repodata/new.xml.gz":
+			w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+			_, _ = w.Write(oldData)
+		case "/repodata/new.xml", "/repodata/same.xml":
+			if r.Method == http.MethodHead {
+				headRequests++
+			} else {
+				assert.Check(t, r.Header.Get("Range") != "", "full download requested")
+				rangeRequests++
+			}
+			w.Header().Set("ETag", `"`+path.Base(r.URL.Path)+`"`)
+			http.ServeContent(w, r, "new.xml", time.Time{}, bytes.NewReader(newData))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := &HTTPFetcher{Cache: &HTTPCache{Dir: t.TempDir()}, DeltaSync: true, Username: "user", Password: "secret"}
+	for _, file := range []string{"repodata/old.xml", "repodata/new.xml", "repodata/same.xml", "repodata/old.xml.gz", "repodata/new.xml.gz"} {
+		body, err := fetcher.Fetch(t.Context(), "test", "filelists.xml", server.URL, file)
+		assert.NilError(t, err)
+		data, err := io.ReadAll(body)
+		assert.NilError(t, err)
+		assert.NilError(t, body.Close())
+		if file == "repodata/new.xml" || file == "repodata/same.xml" {
+			assert.Check(t, bytes.Equal(data, newData), "unexpected contents %q", data)
+			// The result is cached with the headers of the file itself, not
+			// those of the control file.
+			entry := fetcher.Cache.lookup(server.URL + "/" + file)
+			assert.Assert(t, entry != nil, file)
+			assert.Check(t, cmp.Equal(entry.ETag, `"`+path.Base(file)+`"`))
+			assert.Check(t, !entry.Expires.After(time.Now()), "%s is fresh until %s", file, entry.Expires)
+		}
+	}
+	assert.Check(t, cmp.Equal(rangeRequests, 2))
+	// If every block could be reused, the headers of the file are requested
+	// separately.
+	assert.Check(t, cmp.Equal(headRequests, 1))
+	// zsync can't help with compressed files, so it isn't tried for them.
+	assert.Check(t, cmp.Equal(zsyncRequests, 2))
+}
+
+func TestPathFilter(t *testing.T) {
+	filter, err := newPathFilter(&config.Config{
+		IndexInclude: []string{"/usr/bin/*", "/usr/lib*/*.so*"},
+		IndexExclude: []string{"/usr/lib*/debug/*", "/usr/bin/[!a-z]*"},
+	})
+	assert.NilError(t, err)
+	for path, expected := range map[string]bool{
+		"/usr/bin/zypper":                true,
+		"/usr/bin/nested/dir":            true,
+		"/usr/bin/Upper":                 false,
+		"/usr/lib64/libzypp.so.1735":     true,
+		"/usr/lib64/debug/libzypp.so":    false,
+		"/usr/share/doc/zypper/README":   false,
+		"/usr/lib/systemd/system.so.txt": true,
+	} {
+		assert.Check(t, cmp.Equal(filter.allowed(path), expected), path)
+	}
+
+	filter, err = newPathFilter(&config.Config{})
+	assert.NilError(t, err)
+	assert.Check(t, filter.allowed("/anything"))
+}
+
+func TestRefreshIndexSettingsChanged(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repos := []*zypper.Repository{{Alias: "test", Name: "test", Type: "rpm-md", Enabled: true, URL: server.URL}}
+	cfg := &config.Config{CacheDir: t.TempDir()}
+	docs := func() int {
+		results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "/usr/share/doc/*", "")
+		assert.NilError(t, err)
+		return len(results)
+	}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, docs() > 0)
+
+	// Changing the filters indexes the repository again, even though it was
+	// checked recently and has not changed.
+	cfg.IndexExclude = []string{"/usr/share/doc/*"}
+	plans, err := PlanRefresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, plans[0].Fetch)
+	assert.Check(t, cmp.Equal(plans[0].Reason, "indexing settings changed"))
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, cmp.Equal(docs(), 0))
+
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshCurrent))
+
+	// Going back to the defaults does too.
+	cfg.IndexExclude = nil
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+	assert.Check(t, docs() > 0)
+}
+
+func TestIsSoname(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"libz.so.1()(64bit)":            true,
+		"libz.so.1":                     true,
+		"libfoo.so()":                   true,
+		"libc.so.6(GLIBC_2.34)(64bit)":  false,
+		"zlib":                          false,
+		"/usr/lib64/libz.so.1":          false,
+		"perl(Foo::Bar.so.1)":           false,
+		"libsomething.soname()(64bit)":  false,
+		"ld-linux-x86-64.so.2()(64bit)": true,
+		"rtld(GNU_HASH)":                false,
+	} {
+		assert.Check(t, cmp.Equal(isSoname(name), expected), name)
+	}
+}
+
+func TestIsIndexedProvides(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"libz.so.1()(64bit)": true,
+		"pkgconfig(zlib)":    true,
+		"pkgconfig":          false,
+		"perl(Foo::Bar)":     false,
+		"zlib-devel":         false,
+	} {
+		assert.Check(t, cmp.Equal(isIndexedProvides(name), expected), name)
+	}
+}
+
+func TestIsAlternative(t *testing.T) {
+	names := map[string]bool{"python3": true}
+	for _, tc := range []struct {
+		path     string
+		fileType string
+		expected bool
+	}{
+		{"/etc/alternatives/python3", "ghost", true},
+		{"/usr/lib/alternatives/python3", "", true},
+		{"/usr/bin/python3", "ghost", true},
+		{"/usr/bin/python3", "", false},
+		{"/usr/bin/python3.11", "ghost", false},
+	} {
+		assert.Check(t, cmp.Equal(isAlternative(tc.path, tc.fileType, names), tc.expected), "%s (%s)", tc.path, tc.fileType)
+	}
+}
+
+func TestZstdReader(t *testing.T) {
+	// Use a window larger than the data, so that the decoder needs it all.
+	data := bytes.Repeat([]byte("zypper-filesearch "), 64<<10)
+	encoder, err := zstd.NewWriter(nil, zstd.WithWindowSize(zstd.MaxWindowSize>>10), zstd.WithSingleSegment(false))
+	assert.NilError(t, err)
+	compressed := encoder.EncodeAll(data, nil)
+
+	for name, tc := range map[string]struct {
+		cfg *config.Config
+		err bool
+	}{
+		"defaults":     {cfg: &config.Config{}},
+		"low memory":   {cfg: &config.Config{ZstdLowMem: true, ZstdConcurrency: 1}},
+		"small window": {cfg: &config.Config{ZstdMaxWindow: 1 << 10}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			decoder, err := newZstdReader(tc.cfg, bytes.NewReader(compressed))
+			assert.NilError(t, err)
+			defer decoder.Close()
+			result, err := io.ReadAll(decoder)
+			if tc.err {
+				assert.Check(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Check(t, bytes.Equal(result, data))
+		})
+	}
+}
+
+func TestRefreshFileListsExt(t *testing.T) {
+	const digest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	extended := `<?xml version="1.0" encoding="UTF-8"?>
+<filelists-ext xmlns="http://linux.duke.edu/metadata/filelists-ext" packages="1">
+<package pkgid="1" name="ext-package" arch="noarch">
+  <version epoch="0" ver="1.0" rel="1"/>
+  <checksum type="sha256"/>
+  <file type="dir" hash="">/usr/share/ext-package</file>
+  <file hash="` + digest + `">/usr/share/ext-package/data</file>
+</package>
+</filelists-ext>
+`
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	for name, serveExtended := range map[string]bool{
+		"extended": true,
+		"fallback": false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := database.NewTesting(t.Context())
+			assert.NilError(t, err)
+			RegisterFetcher("test", FetcherFunc(func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+				if kind == "filelists-ext.xml" {
+					if !serveExtended {
+						return nil, fs.ErrNotExist
+					}
+					return io.NopCloser(strings.NewReader(extended)), nil
+				}
+				contents, err := fs.ReadFile(subFS, path.Join(parts[1:]...))
+				if err != nil {
+					return nil, err
+				}
+				if kind == "repomd.xml" {
+					contents = bytes.Replace(contents, []byte("</repomd>"), []byte(`  <data type="filelists-ext">
+    <location href="repodata/filelists-ext.xml"/>
+    <timestamp>1764717985</timestamp>
+  </data>
+</repomd>`), 1)
+				}
+				return io.NopCloser(bytes.NewReader(contents)), nil
+			}))
+			defer RegisterFetcher("test", nil)
+
+			repos := []*zypper.Repository{{Name: "test", Type: "rpm-md", Enabled: true, URL: "test://repository"}}
+			statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+			assert.NilError(t, err)
+			assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+			// The digests are only known from the extended file lists.
+			results, err := db.SearchDigest(t.Context(), database.RepoFilter{Repos: repos}, digest, "")
+			assert.NilError(t, err)
+			if serveExtended {
+				assert.Assert(t, cmp.Len(results, 1))
+				assert.Check(t, cmp.Equal(results[0].Path, "/usr/share/ext-package/data"))
+			} else {
+				assert.Check(t, cmp.Len(results, 0))
+			}
+			results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*/LICENSE.txt", "")
+			assert.NilError(t, err)
+			assert.Check(t, cmp.Equal(len(results) > 0, !serveExtended))
+		})
+	}
+}
+
+func TestRefreshPrimaryFiles(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	// Serve a repository without file lists, where primary.xml lists some of
+	// the files.
+	subFS, err := fs.Sub(testdata, "testdata")
+	assert.NilError(t, err)
+	RegisterFetcher("test", FetcherFunc(func(ctx context.Context, name, kind string, parts ...string) (io.ReadCloser, error) {
+		contents, err := fs.ReadFile(subFS, path.Join(parts[1:]...))
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "repomd.xml":
+			contents = bytes.Replace(contents, []byte(`type="filelists"`), []byte(`type="unrelated"`), 1)
+		case "primary.xml":
+			contents = bytes.Replace(contents, []byte("</format>"),
+				[]byte("<file>/usr/bin/zypper-filesearch</file><file type=\"dir\">/etc/zypper-filesearch</file></format>"), 1)
+		}
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}))
+	defer RegisterFetcher("test", nil)
+
+	repos := []*zypper.Repository{{Name: "test", Type: "rpm-md", Enabled: true, URL: "test://repository"}}
+	statuses, err := Refresh(t.Context(), &config.Config{CacheDir: t.TempDir()}, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "*", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Package, "zypper-filesearch"))
+	assert.Check(t, cmp.Equal(results[0].Arch, "x86_64"))
+	assert.Check(t, cmp.Equal(results[0].Release, "lp160.10.1"))
+	assert.Check(t, cmp.Equal(results[0].Path, "/usr/bin/zypper-filesearch"))
+}
+
+func TestRefreshSusetags(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata/susetags")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repos := []*zypper.Repository{{Name: "media", Type: "yast2", Enabled: true, URL: server.URL}}
+	cfg := &config.Config{CacheDir: t.TempDir(), RefreshInterval: time.Nanosecond}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "/usr/bin/*", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 2))
+	slices.SortFunc(results, func(a, b database.SearchResult) int { return strings.Compare(a.Package, b.Package) })
+	assert.Check(t, cmp.Equal(results[0].Package, "vendor-tool"))
+	assert.Check(t, cmp.Equal(results[0].EVR(), "2:1.0-3"))
+	assert.Check(t, cmp.Equal(results[0].Location, "suse/noarch-extra/vendor-tool-1.0-3.noarch.rpm"))
+	assert.Check(t, cmp.Equal(results[1].Package, "zypper-filesearch"))
+	assert.Check(t, cmp.Equal(results[1].InstalledSize, int64(6011533)))
+	assert.Check(t, cmp.Equal(results[1].Location, "suse/x86_64/zypper-filesearch-0.20251202-lp160.10.1.x86_64.rpm"))
+
+	// Directories are not indexed.
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "/usr/share/licenses/*", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Path, "/usr/share/licenses/zypper-filesearch/LICENSE.txt"))
+
+	results, err = db.SearchSoname(t.Context(), database.RepoFilter{Repos: repos}, "libfilesearch.so.1", "")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(results, 1))
+
+	// The checksums in content have not changed, so nothing is imported.
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshCurrent))
+
+	problems, err := Check(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(problems, 0))
+}
+
+func TestNewAdded(t *testing.T) {
+	for _, tt := range []struct {
+		spec, alias, repoType string
+	}{
+		{"http://deb.example.test/debian/dists/bookworm/main/Contents-amd64.gz", "bookworm-main-amd64", contentsType},
+		{"deb=http://deb.example.test/debian/dists/bookworm/main/Contents-amd64.gz", "deb", contentsType},
+		{"http://rpm.example.test/repo/oss/", "rpm.example.test-repo-oss", "rpm-md"},
+		{"http://rpm.example.test/repo?key=value", "rpm.example.test-repo", "rpm-md"},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			repo, err := NewAdded(tt.spec)
+			assert.NilError(t, err)
+			assert.Check(t, cmp.Equal(repo.Alias, tt.alias))
+			assert.Check(t, cmp.Equal(repo.Type, tt.repoType))
+			assert.Check(t, repo.Enabled)
+		})
+	}
+	_, err := NewAdded("alias=not-a-url")
+	assert.Check(t, cmp.ErrorContains(err, "no scheme"))
+}
+
+func TestRefreshContents(t *testing.T) {
+	db, err := database.NewTesting(t.Context())
+	assert.NilError(t, err)
+
+	subFS, err := fs.Sub(testdata, "testdata/debian")
+	assert.NilError(t, err)
+	server := httptest.NewServer(http.FileServer(http.FS(subFS)))
+	defer server.Close()
+
+	repo, err := NewAdded(server.URL + "/dists/bookworm/main/Contents-amd64")
+	assert.NilError(t, err)
+	repos := []*zypper.Repository{repo}
+	cfg := &config.Config{CacheDir: t.TempDir(), RefreshInterval: time.Nanosecond}
+	statuses, err := Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshUpdated))
+
+	// Files may belong to several packages.
+	results, err := db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "/usr/share/doc/shared/README", "x86_64")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 2))
+	slices.SortFunc(results, func(a, b database.SearchResult) int { return strings.Compare(a.Package, b.Package) })
+	assert.Check(t, cmp.Equal(results[0].Package, "bash"))
+	assert.Check(t, cmp.Equal(results[1].Package, "rpm"))
+
+	// Sections are used as groups, without the area.
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos, Groups: []string{"utils"}}, "/usr/bin/*", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Package, "unrar"))
+
+	results, err = db.SearchFile(t.Context(), database.RepoFilter{Repos: repos}, "/usr/share/fonts/*", "")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(results, 1))
+	assert.Check(t, cmp.Equal(results[0].Path, "/usr/share/fonts/My Font.ttf"))
+
+	// The checksum in the Release file has not changed, so nothing is imported.
+	statuses, err = Refresh(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(statuses[0].State, RefreshCurrent))
+
+	problems, err := Check(t.Context(), cfg, db, repos)
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(problems, 0))
 }
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxResumeAttempts is the number of times an interrupted download is resumed
+// before giving up.
+const maxResumeAttempts = 3
+
+// resumingBody is a response body that, if reading fails part way through,
+// requests the rest of the file with a Range header and continues from where
+// it left off; the reader sees one uninterrupted stream, so the checksum of
+// the whole file can still be verified.
+type resumingBody struct {
+	ctx    context.Context
+	client *http.Client
+	req    *http.Request
+	body   io.ReadCloser
+	// The ETag or Last-Modified header of the original response, used with
+	// If-Range so that a changed file is not spliced onto the old one.
+	validator string
+	// The number of bytes read so far.
+	offset   int64
+	attempts int
+}
+
+// newResumingBody wraps the body of the response to the request so that it is
+// resumed on errors, if the response allows it.
+func newResumingBody(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) io.ReadCloser {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// Weak validators cannot be used with If-Range.
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" || resp.Uncompressed || resp.StatusCode != http.StatusOK {
+		// Offsets would not match the file on the server.
+		return resp.Body
+	}
+	return &resumingBody{
+		ctx:       ctx,
+		client:    client,
+		req:       req,
+		body:      resp.Body,
+		validator: validator,
+	}
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if err == nil || errors.Is(err, io.EOF) || b.ctx.Err() != nil || b.attempts >= maxResumeAttempts {
+		return n, err
+	}
+	if resumeErr := b.resume(); resumeErr != nil {
+		slog.DebugContext(b.ctx, "Failed to resume download",
+			"url", b.req.URL.String(), "offset", b.offset, "error", resumeErr)
+		b.attempts = maxResumeAttempts
+		return n, err
+	}
+	slog.DebugContext(b.ctx, "Resumed interrupted download",
+		"url", b.req.URL.String(), "offset", b.offset, "attempt", b.attempts, "error", err)
+	return n, nil
+}
+
+// resume requests the rest of the file, replacing the body.
+func (b *resumingBody) resume() error {
+	b.attempts++
+	_ = b.body.Close()
+	req := b.req.Clone(b.ctx)
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	req.Header.Set("If-Range", b.validator)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// Either ranges are not supported, or the file has changed.
+		_ = resp.Body.Close()
+		return fmt.Errorf("unexpected status code %d (%s)", resp.StatusCode, resp.Status)
+	}
+	var start int64
+	contentRange := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil || start != b.offset {
+		_ = resp.Body.Close()
+		return fmt.Errorf("unexpected content range %q", contentRange)
+	}
+	b.body = resp.Body
+	return nil
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// repoIndex is the repoindex.xml of a repository index service (RIS).
+type repoIndex struct {
+	Repos []struct {
+		Alias    string `xml:"alias,attr"`
+		Name     string `xml:"name,attr"`
+		URL      string `xml:"url,attr"`
+		Path     string `xml:"path,attr"`
+		Enabled  string `xml:"enabled,attr"`
+		Priority int    `xml:"priority,attr"`
+	} `xml:"repo"`
+}
+
+// ServiceRepositories returns the repositories of the enabled repository index
+// services (such as those SUSEConnect adds for each registered product and
+// extension) that are missing from the listed repositories, for example,
+// because the services were never refreshed.  The index of each service is
+// downloaded with its credentials; the URLs of the repositories in it carry
+// their own access tokens.  The repositories listed in each index are
+// recorded in the database, and the index is only downloaded again when
+// refreshing, once the recorded list is older than the refresh interval of
+// the service; otherwise (including for commands that do not refresh), the
+// recorded list is used as it is.  A failure for one service does not stop
+// the others from being read; the returned error joins all individual
+// failures.
+func ServiceRepositories(ctx context.Context, cfg *config.Config, db *database.Database, opts zypper.Options, listed []*zypper.Repository, refresh bool) ([]*zypper.Repository, error) {
+	services, err := zypper.ListServices(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var clients *httpClients
+	var repos []*zypper.Repository
+	var errs []error
+	for _, service := range services {
+		if !service.Enabled || !strings.EqualFold(service.Type, "ris") ||
+			slices.ContainsFunc(listed, func(r *zypper.Repository) bool { return r.Service == service.Alias }) {
+			continue
+		}
+		serviceURL := stripCredentials(service.URL)
+		recorded, checked, err := db.ServiceRepositories(ctx, service.Alias, serviceURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", service.Alias, err))
+			continue
+		}
+		if !refresh || checked.Add(cfg.RefreshIntervalFor(service.Alias)).After(time.Now()) {
+			repos = append(repos, recorded...)
+			continue
+		}
+		if clients == nil {
+			clients = newHTTPClients(cfg)
+		}
+		serviceRepos, err := fetchServiceRepositories(ctx, clients, service)
+		if err != nil {
+			// Keep using the repositories listed before, if any.
+			errs = append(errs, fmt.Errorf("service %s: %w", service.Alias, err))
+			repos = append(repos, recorded...)
+			continue
+		}
+		if err := db.SetServiceRepositories(ctx, service.Alias, serviceURL, serviceRepos); err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", service.Alias, err))
+		}
+		repos = append(repos, serviceRepos...)
+	}
+	return repos, errors.Join(errs...)
+}
+
+// fetchServiceRepositories downloads the index of the service, and returns the
+// repositories in it, named the way zypper would add them.
+func fetchServiceRepositories(ctx context.Context, clients *httpClients, service *zypper.Service) ([]*zypper.Repository, error) {
+	fetcher := fetcherFor(service.URL)
+	if fetcher == nil {
+		return nil, fmt.Errorf("unsupported URL %s", stripCredentials(service.URL))
+	}
+	fetcher, err := clients.configure(ctx, &zypper.Repository{Alias: service.Alias, Name: service.Name, URL: service.URL}, fetcher)
+	if err != nil {
+		return nil, err
+	}
+	body, err := fetcher.Fetch(ctx, service.Name, "repoindex.xml", service.URL, "repo", "repoindex.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var index repoIndex
+	if err := xml.NewDecoder(body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse repoindex.xml: %w", err)
+	}
+
+	var repos []*zypper.Repository
+	for _, entry := range index.Repos {
+		repoURL := entry.URL
+		if repoURL == "" && entry.Path != "" {
+			// The repository is relative to the service.
+			if repoURL, err = url.JoinPath(stripCredentials(service.URL), entry.Path); err != nil {
+				return nil, fmt.Errorf("invalid path %q of repository %s: %w", entry.Path, entry.Alias, err)
+			}
+		}
+		if entry.Alias == "" || repoURL == "" {
+			continue
+		}
+		repos = append(repos, &zypper.Repository{
+			Alias:    service.Alias + ":" + entry.Alias,
+			Name:     cmp.Or(entry.Name, entry.Alias),
+			Type:     "rpm-md",
+			Enabled:  entry.Enabled == "1" || strings.EqualFold(entry.Enabled, "true"),
+			Priority: cmp.Or(entry.Priority, zypper.DefaultPriority),
+			GPGCheck: true,
+			URL:      repoURL,
+			Service:  service.Alias,
+		})
+	}
+	return repos, nil
+}
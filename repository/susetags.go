@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mook-as/zypper-filesearch/config"
+	"github.com/mook-as/zypper-filesearch/database"
+	"github.com/mook-as/zypper-filesearch/zypper"
+)
+
+// susetagsType is the repository type of susetags repositories, the format
+// used by older installation media: a `content` file listing the metadata
+// files, a `packages` file describing the packages, and a `packages.FL` file
+// listing their files.
+const susetagsType = "yast2"
+
+// The directories of the metadata and of the packages, if `content` does not
+// say otherwise.
+const (
+	susetagsDescrDir = "suse/setup/descr"
+	susetagsDataDir  = "suse"
+)
+
+// susetagsContent is the index of a susetags repository, from its `content`
+// file.
+type susetagsContent struct {
+	descrDir, dataDir string
+	// The metadata files in descrDir, keyed by name.
+	meta map[string]*repomdData
+}
+
+// metadata returns the first of the named metadata files that is listed, or
+// nil if there are none.
+func (c *susetagsContent) metadata(names ...string) *repomdData {
+	for _, name := range names {
+		if data, ok := c.meta[name]; ok {
+			return data
+		}
+	}
+	return nil
+}
+
+// files returns the metadata files to read: the packages (nil if they are not
+// listed), and the file list (nil if there is none).
+func (c *susetagsContent) files() (packages, fileList *repomdData) {
+	return c.metadata("packages.zst", "packages.gz", "packages"),
+		c.metadata("packages.FL.zst", "packages.FL.gz", "packages.FL")
+}
+
+// provenance identifies the metadata by the checksum of the file list, or of
+// the packages if there is no file list; there are no timestamps to use.
+func (c *susetagsContent) provenance() database.Provenance {
+	packages, fileList := c.files()
+	source := cmp.Or(fileList, packages)
+	if source == nil {
+		return database.Provenance{}
+	}
+	return database.Provenance{Checksum: source.Checksum.Type + ":" + source.Checksum.Value}
+}
+
+// readSusetagsContent downloads and parses the `content` file of a susetags
+// repository.
+func readSusetagsContent(ctx context.Context, fetcher Fetcher, repo *zypper.Repository, unreachable func(error) error) (*susetagsContent, error) {
+	body, err := fetcher.Fetch(ctx, repo.Name, "content", repo.URL, "content")
+	if err != nil {
+		return nil, unreachable(err)
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	content := &susetagsContent{
+		descrDir: susetagsDescrDir,
+		dataDir:  susetagsDataDir,
+		meta:     make(map[string]*repomdData),
+	}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "DESCRDIR":
+			content.descrDir = fields[1]
+		case "DATADIR":
+			content.dataDir = fields[1]
+		case "META":
+			// META <checksum type> <checksum> <name>
+			if len(fields) != 4 {
+				continue
+			}
+			data := &repomdData{Type: "susetags", name: fields[3]}
+			data.Checksum.Type = strings.ToLower(fields[1])
+			data.Checksum.Value = strings.ToLower(fields[2])
+			content.meta[fields[3]] = data
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, unreachable(err)
+	}
+	for name, data := range content.meta {
+		data.Location.Href = path.Join(content.descrDir, name)
+	}
+	return content, nil
+}
+
+// readSusetags calls fn with the tag and value of each entry in a susetags
+// metadata file: once for single-line entries (`=Tag: value`), and once for
+// each line of multi-line entries (`+Tag:` ... `-Tag:`).
+func readSusetags(r io.Reader, fn func(tag, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	block := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if block != "" {
+			if line == "-"+block+":" {
+				block = ""
+			} else if err := fn(block, line); err != nil {
+				return err
+			}
+			continue
+		}
+		// Tags are always three characters; anything else is a comment.
+		if len(line) < 5 || line[4] != ':' {
+			continue
+		}
+		switch line[0] {
+		case '=':
+			if err := fn(line[1:4], strings.TrimSpace(line[5:])); err != nil {
+				return err
+			}
+		case '+':
+			block = line[1:4]
+		}
+	}
+	return scanner.Err()
+}
+
+// susetagsPackages collects the packages of a susetags repository from the
+// packages file and the file list, in the form they would have in rpm-md
+// metadata.  Packages have no pkgid, so their `=Pkg:` line is used instead.
+type susetagsPackages struct {
+	dataDir  string
+	packages []*fileListPackage
+	details  map[string]*primaryPackage
+	byId     map[string]*fileListPackage
+	// The package the entries being read belong to.
+	current *fileListPackage
+}
+
+// add an entry read from a metadata file.
+func (p *susetagsPackages) add(tag, value string) error {
+	if tag == "Pkg" {
+		// =Pkg: <name> <version> <release> <arch>
+		if p.current = p.byId[value]; p.current != nil {
+			return nil
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return fmt.Errorf("invalid package %q", value)
+		}
+		pkg := &fileListPackage{PkgId: value, Name: fields[0], Arch: fields[3]}
+		pkg.Version.Epoch, pkg.Version.Version = "0", fields[1]
+		if epoch, version, ok := strings.Cut(fields[1], ":"); ok {
+			pkg.Version.Epoch, pkg.Version.Version = epoch, version
+		}
+		pkg.Version.Release = fields[2]
+		p.packages = append(p.packages, pkg)
+		p.details[value] = &primaryPackage{}
+		p.byId[value] = pkg
+		p.current = pkg
+		return nil
+	}
+	if p.current == nil {
+		return nil
+	}
+	details := p.details[p.current.PkgId]
+	switch tag {
+	case "Siz":
+		// =Siz: <package size> <installed size>
+		if fields := strings.Fields(value); len(fields) == 2 {
+			details.Size.Package, _ = strconv.ParseInt(fields[0], 10, 64)
+			details.Size.Installed, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	case "Loc":
+		// =Loc: <medium> <file name> [<directory>]; the directory defaults
+		// to the architecture.
+		if fields := strings.Fields(value); len(fields) >= 2 {
+			dir := p.current.Arch
+			if len(fields) > 2 {
+				dir = fields[2]
+			}
+			details.Location.Href = path.Join(p.dataDir, dir, fields[1])
+		}
+	case "Grp":
+		details.Group = value
+	case "Prv":
+		if name, _, _ := strings.Cut(value, " "); isIndexedProvides(name) {
+			details.indexed = append(details.indexed, name)
+		}
+	case "Fls":
+		// Directories have a trailing slash.
+		file := &packageFile{Path: value}
+		if trimmed, ok := strings.CutSuffix(value, "/"); ok && trimmed != "" {
+			file.Type, file.Path = "dir", trimmed
+		}
+		p.current.Files = append(p.current.Files, file)
+	}
+	return nil
+}
+
+// read the packages (or their files) from a metadata file.
+func (p *susetagsPackages) read(ctx context.Context, cfg *config.Config, fetcher Fetcher, repo *zypper.Repository, data *repomdData, unreachable func(error) error) error {
+	return readMetadata(ctx, cfg, fetcher, repo, data, unreachable, func(r io.Reader) error {
+		p.current = nil
+		return readSusetags(r, p.add)
+	})
+}
+
+// planSusetags returns whether the susetags repository would be fetched, and
+// the reason; see planRepository.  The download size is not known.
+func planSusetags(ctx context.Context, db *database.Database, repo *zypper.Repository, fetcher Fetcher, lastModified time.Time, reindex bool) (bool, string, int64) {
+	content, err := readSusetagsContent(ctx, fetcher, repo, func(err error) error { return err })
+	if err != nil {
+		if !repo.Enabled {
+			return false, "disabled and unreachable", 0
+		}
+		return false, err.Error(), 0
+	}
+	if packages, _ := content.files(); packages == nil {
+		return false, "no packages", 0
+	}
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		return false, err.Error(), 0
+	}
+	if previous.Checksum == content.provenance().Checksum && !reindex {
+		return false, "refresh interval expired, but the file list has not changed", 0
+	}
+	if lastModified.IsZero() {
+		return true, "never indexed", 0
+	}
+	if reindex {
+		return true, "indexing settings changed", 0
+	}
+	return true, "refresh interval expired, and the file list changed", 0
+}
+
+// checkSusetags returns the problems found with the metadata of a susetags
+// repository; see checkRepository.
+func checkSusetags(ctx context.Context, db *database.Database, repo *zypper.Repository, fetcher Fetcher) []string {
+	content, err := readSusetagsContent(ctx, fetcher, repo, func(err error) error { return err })
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	packages, fileList := content.files()
+	if packages == nil {
+		problems = append(problems, "content does not list packages")
+	}
+	if fileList == nil {
+		problems = append(problems, "content does not list file lists; only the files listed with the packages can be indexed")
+	}
+	for _, name := range slices.Sorted(maps.Keys(content.meta)) {
+		if data := content.meta[name]; newHasher(data.Checksum.Type) == nil {
+			problems = append(problems, fmt.Sprintf("unsupported checksum type %q for %s", data.Checksum.Type, name))
+		}
+	}
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to read index: %s", err))
+	} else if previous.Checksum != "" && previous.Checksum != content.provenance().Checksum {
+		problems = append(problems, "index is older than the file list; refresh to update it")
+	}
+	return problems
+}
+
+// updateSusetags updates the cached index of a susetags repository; see
+// updateRepository.  There are no timestamps, so the checksum of the file list
+// in `content` is used to tell whether it changed.
+func updateSusetags(ctx context.Context, cfg *config.Config, db *database.Database, repo *zypper.Repository, fetcher Fetcher, lastChecked time.Time, unreachable func(error) error, metrics *refreshMetrics) (RefreshState, error) {
+	var unreachableErr *ErrRepoUnreachable
+	content, err := readSusetagsContent(ctx, fetcher, repo, unreachable)
+	if err != nil && !repo.Enabled && errors.As(err, &unreachableErr) {
+		return RefreshSkipped, nil // Ignore errors from disabled repositories
+	} else if err != nil {
+		return RefreshFailed, err
+	}
+	packagesData, fileListData := content.files()
+	if packagesData == nil {
+		return RefreshFailed, fmt.Errorf("repository %s does not list packages", repo.Name)
+	}
+	if fileListData == nil {
+		slog.WarnContext(ctx, "Repository does not have file lists; only the files listed with the packages are indexed",
+			"repository", repo.Name)
+	}
+	provenance := content.provenance()
+	provenance.Settings = indexSettings(cfg, repo)
+	previous, err := db.GetProvenance(ctx, repo)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	if previous == provenance {
+		slog.DebugContext(ctx, "File list has not changed", "repository", repo.Name)
+		return RefreshCurrent, nil
+	}
+
+	filter, err := newPathFilter(cfg)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	packages := &susetagsPackages{
+		dataDir: content.dataDir,
+		details: make(map[string]*primaryPackage),
+		byId:    make(map[string]*fileListPackage),
+	}
+	err = packages.read(ctx, cfg, fetcher, repo, packagesData, unreachable)
+	if err == nil && fileListData != nil {
+		err = packages.read(ctx, cfg, fetcher, repo, fileListData, unreachable)
+	}
+	if err != nil && !repo.Enabled && errors.As(err, &unreachableErr) {
+		return RefreshSkipped, nil // Ignore errors from disabled repositories
+	} else if err != nil {
+		return RefreshFailed, err
+	}
+
+	err = importPackages(ctx, db, repo, lastChecked, lastChecked, provenance, filter, packages.packages, packages.details, nil, metrics)
+	if err != nil {
+		return RefreshFailed, err
+	}
+	return RefreshUpdated, nil
+}
@@ -0,0 +1,354 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // zsync uses MD4 block checksums.
+)
+
+// errZsyncUnsupported is returned for zsync control files we can't use.
+var errZsyncUnsupported = errors.New("unsupported zsync control file")
+
+// zsyncControl is a parsed zsync control file; see http://zsync.moria.org.uk/
+type zsyncControl struct {
+	blockSize     int
+	length        int64
+	rsumBytes     int
+	checksumBytes int
+	sha1          string
+	blocks        []zsyncBlock
+}
+
+type zsyncBlock struct {
+	rsum     uint32
+	checksum []byte
+}
+
+// parseZsync reads a zsync control file.
+func parseZsync(r io.Reader) (*zsyncControl, error) {
+	reader := bufio.NewReader(r)
+	var control zsyncControl
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zsync header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("invalid zsync header line %q", line)
+		}
+		switch key {
+		case "Blocksize":
+			control.blockSize, err = strconv.Atoi(value)
+		case "Length":
+			control.length, err = strconv.ParseInt(value, 10, 64)
+		case "Hash-Lengths":
+			parts := strings.Split(value, ",")
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("invalid zsync hash lengths %q", value)
+			}
+			if control.rsumBytes, err = strconv.Atoi(parts[1]); err == nil {
+				control.checksumBytes, err = strconv.Atoi(parts[2])
+			}
+		case "SHA-1":
+			control.sha1 = strings.ToLower(value)
+		case "Z-Map2", "Z-URL":
+			// Compressed mode requires recompressing the file; not supported.
+			return nil, fmt.Errorf("%w: compressed mode", errZsyncUnsupported)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync header %s: %w", key, err)
+		}
+	}
+	if control.blockSize <= 0 || control.length < 0 || control.sha1 == "" ||
+		control.rsumBytes < 1 || control.rsumBytes > 4 ||
+		control.checksumBytes < 1 || control.checksumBytes > md4.Size {
+		return nil, fmt.Errorf("%w: missing or invalid headers", errZsyncUnsupported)
+	}
+
+	count := (control.length + int64(control.blockSize) - 1) / int64(control.blockSize)
+	buf := make([]byte, control.rsumBytes+control.checksumBytes)
+	control.blocks = make([]zsyncBlock, 0, count)
+	for range count {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, fmt.Errorf("failed to read zsync block checksums: %w", err)
+		}
+		var rsum [4]byte
+		copy(rsum[4-control.rsumBytes:], buf[:control.rsumBytes])
+		control.blocks = append(control.blocks, zsyncBlock{
+			rsum:     binary.BigEndian.Uint32(rsum[:]),
+			checksum: bytes.Clone(buf[control.rsumBytes:]),
+		})
+	}
+	return &control, nil
+}
+
+// rsumMask returns the mask to apply to rolling checksums before comparing them
+// to the (possibly truncated) values in the control file.
+func (c *zsyncControl) rsumMask() uint32 {
+	if c.rsumBytes == 4 {
+		return 0xffffffff
+	}
+	return 1<<(8*c.rsumBytes) - 1
+}
+
+// checksum calculates the (truncated) strong checksum for a block.
+func (c *zsyncControl) checksum(block []byte) []byte {
+	hasher := md4.New()
+	_, _ = hasher.Write(block)
+	if len(block) < c.blockSize {
+		_, _ = hasher.Write(make([]byte, c.blockSize-len(block)))
+	}
+	return hasher.Sum(nil)[:c.checksumBytes]
+}
+
+// match the blocks of the control file against the seed, which is read once
+// from start to end; returns, for each block, the offset in the seed where it
+// was found, or -1.
+func (c *zsyncControl) match(seed io.Reader) ([]int64, error) {
+	offsets := make([]int64, len(c.blocks))
+	candidates := make(map[uint32][]int)
+	mask := c.rsumMask()
+	for i, block := range c.blocks {
+		offsets[i] = -1
+		candidates[block.rsum&mask] = append(candidates[block.rsum&mask], i)
+	}
+
+	// The current block of the seed is kept in window, which wraps around at
+	// first; block is a contiguous copy, made to calculate the checksum.
+	reader := bufio.NewReader(seed)
+	window := make([]byte, c.blockSize)
+	if _, err := io.ReadFull(reader, window); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return offsets, nil
+		}
+		return nil, err
+	}
+	block := make([]byte, c.blockSize)
+	first := 0
+	var pos int64
+
+	var a, b uint16
+	for i, ch := range window {
+		a += uint16(ch)
+		b += uint16(c.blockSize-i) * uint16(ch)
+	}
+	// After a match, skip is the number of bytes to roll forward before
+	// looking for the next one, so that matches don't overlap.
+	skip := 0
+	for {
+		if skip == 0 {
+			if indices, ok := candidates[(uint32(a)<<16|uint32(b))&mask]; ok {
+				var sum []byte
+				for _, i := range indices {
+					if offsets[i] >= 0 {
+						continue
+					}
+					if sum == nil {
+						n := copy(block, window[first:])
+						copy(block[n:], window[:first])
+						sum = c.checksum(block)
+					}
+					if bytes.Equal(sum, c.blocks[i].checksum) {
+						offsets[i] = pos
+						skip = c.blockSize
+					}
+				}
+			}
+		}
+		// Roll the checksum forward by one byte.
+		in, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		out := window[first]
+		window[first] = in
+		first = (first + 1) % c.blockSize
+		pos++
+		a += uint16(in) - uint16(out)
+		b += a - uint16(c.blockSize)*uint16(out)
+		if skip > 0 {
+			skip--
+		}
+	}
+	return offsets, nil
+}
+
+// newRequestFunc creates a GET request for the given URL, with any credentials
+// needed.
+type newRequestFunc func(ctx context.Context, fileURL string) (*http.Request, error)
+
+// fetchDelta downloads the file at the given URL using its zsync control file,
+// reusing blocks from the seed file and fetching only the rest with range
+// requests.  The result is written to a temporary file in the given directory,
+// which the caller must remove.  The headers of the file itself (not those of
+// the control file) are also returned, for use with caching; see fileHeader.
+func fetchDelta(ctx context.Context, client *http.Client, newRequest newRequestFunc, fileURL, seedPath, dir string) (*os.File, http.Header, error) {
+	req, err := newRequest(ctx, fileURL+".zsync")
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: status code %d", errZsyncUnsupported, resp.StatusCode)
+	}
+	control, err := parseZsync(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seed, err := os.Open(seedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = seed.Close()
+	}()
+	offsets, err := control.match(seed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read zsync seed: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, "zsync-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if err := file.Truncate(control.length); err != nil {
+		return nil, nil, err
+	}
+
+	reused := 0
+	var header http.Header
+	buf := make([]byte, control.blockSize)
+	missingStart := -1
+	for i := 0; i <= len(offsets); i++ {
+		if i < len(offsets) && offsets[i] < 0 {
+			if missingStart < 0 {
+				missingStart = i
+			}
+			continue
+		}
+		if missingStart >= 0 {
+			start := int64(missingStart) * int64(control.blockSize)
+			end := min(int64(i)*int64(control.blockSize), control.length)
+			if header, err = fetchRange(ctx, client, newRequest, fileURL, file, start, end); err != nil {
+				return nil, nil, err
+			}
+			missingStart = -1
+		}
+		if i < len(offsets) {
+			start := int64(i) * int64(control.blockSize)
+			size := min(int64(control.blockSize), control.length-start)
+			if _, err := seed.ReadAt(buf[:size], offsets[i]); err != nil {
+				return nil, nil, fmt.Errorf("failed to read zsync seed: %w", err)
+			}
+			if _, err := file.WriteAt(buf[:size], start); err != nil {
+				return nil, nil, err
+			}
+			reused++
+		}
+	}
+	slog.DebugContext(ctx, "Downloaded file using zsync",
+		"url", fileURL, "blocks", len(offsets), "reused", reused)
+
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, control.length)); err != nil {
+		return nil, nil, err
+	}
+	if sum := fmt.Sprintf("%02x", hasher.Sum(nil)); sum != control.sha1 {
+		return nil, nil, fmt.Errorf("zsync result for %s: %w", fileURL, ErrChecksumMismatch)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		// Every block was reused, so nothing was requested from the file.
+		header = fileHeader(ctx, client, newRequest, fileURL)
+	}
+	success = true
+	return file, header, nil
+}
+
+// fileHeader returns the headers of the file at the given URL, as given in
+// response to a HEAD request.  If that fails, no headers are returned, so the
+// result is not cached with validators that may be wrong.
+func fileHeader(ctx context.Context, client *http.Client, newRequest newRequestFunc, fileURL string) http.Header {
+	req, err := newRequest(ctx, fileURL)
+	if err != nil {
+		return http.Header{}
+	}
+	req.Method = http.MethodHead
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.DebugContext(ctx, "Failed to get headers of file", "url", fileURL, "error", err)
+		return http.Header{}
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.DebugContext(ctx, "Failed to get headers of file", "url", fileURL, "status", resp.StatusCode)
+		return http.Header{}
+	}
+	return resp.Header
+}
+
+// fetchRange downloads the given byte range [start, end) of the URL, writing
+// it into the file at the same offset, and returns the headers of the response.
+func fetchRange(ctx context.Context, client *http.Client, newRequest newRequestFunc, fileURL string, file *os.File, start, end int64) (http.Header, error) {
+	req, err := newRequest(ctx, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("%w: range request returned status code %d", errZsyncUnsupported, resp.StatusCode)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(file, start), io.LimitReader(resp.Body, end-start))
+	if err != nil {
+		return nil, err
+	}
+	if n != end-start {
+		return nil, fmt.Errorf("short range response: got %d of %d bytes", n, end-start)
+	}
+	return resp.Header, nil
+}
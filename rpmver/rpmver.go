@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+// Package rpmver compares RPM versions the same way rpm does.
+package rpmver
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Compare two version (or release) strings, following rpmvercmp(); returns -1
+// if a is older than b, 1 if a is newer, and 0 if they are equal.
+func Compare(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for {
+		a = strings.TrimLeftFunc(a, func(r rune) bool {
+			return r < 0x80 && !isDigit(byte(r)) && !isAlpha(byte(r)) && r != '~' && r != '^'
+		})
+		b = strings.TrimLeftFunc(b, func(r rune) bool {
+			return r < 0x80 && !isDigit(byte(r)) && !isAlpha(byte(r)) && r != '~' && r != '^'
+		})
+
+		// A tilde sorts before everything, even the end of the string.
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// A caret sorts after the end of the string, but before anything else.
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		// Grab the next segment of the same type from both strings.
+		isNum := isDigit(a[0])
+		class := isAlpha
+		if isNum {
+			class = isDigit
+		}
+		segment := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && class(s[i]) {
+				i++
+			}
+			return s[:i], s[i:]
+		}
+		var segA, segB string
+		segA, a = segment(a)
+		segB, b = segment(b)
+		if segB == "" {
+			// Segments of different types; numeric ones are newer.
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if result := cmp.Compare(len(segA), len(segB)); result != 0 {
+				return result
+			}
+		}
+		if result := strings.Compare(segA, segB); result != 0 {
+			return result
+		}
+	}
+	if a == "" && b == "" {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	return 1
+}
+
+// CompareEVR compares two sets of epoch, version, and release; an empty epoch
+// is treated as zero.
+func CompareEVR(epochA, versionA, releaseA, epochB, versionB, releaseB string) int {
+	parseEpoch := func(epoch string) int {
+		value, _ := strconv.Atoi(epoch)
+		return value
+	}
+	if result := cmp.Compare(parseEpoch(epochA), parseEpoch(epochB)); result != 0 {
+		return result
+	}
+	if result := Compare(versionA, versionB); result != 0 {
+		return result
+	}
+	return Compare(releaseA, releaseB)
+}
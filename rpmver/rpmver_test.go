@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package rpmver
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestCompare(t *testing.T) {
+	// Cases taken from rpm's own test suite.
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"1.0aa", "1.0a", 1},
+		{"10a2", "10b2", -1},
+		{"6.0.rc1", "6.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"001", "1", 0},
+		{"a+", "a_", 0},
+	} {
+		assert.Check(t, cmp.Equal(Compare(tc.a, tc.b), tc.expected), "%s <=> %s", tc.a, tc.b)
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	assert.Check(t, cmp.Equal(CompareEVR("", "1.0", "1", "0", "1.0", "1"), 0))
+	assert.Check(t, cmp.Equal(CompareEVR("1", "1.0", "1", "", "2.0", "1"), 1))
+	assert.Check(t, cmp.Equal(CompareEVR("", "1.0", "1.1", "", "1.0", "2"), -1))
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package zypper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrNoDefaultRepositories is returned by DefaultRepositories for systems it
+// does not know the standard repositories of.
+var ErrNoDefaultRepositories = fmt.Errorf("%w: no default repositories for this distribution", ErrUnsupported)
+
+// defaultRepository is one of the standard repositories of a distribution; the
+// URL may use repository variables.
+type defaultRepository struct {
+	alias, name, url string
+}
+
+// defaultRepositories returns the standard repositories of the distribution
+// with the given os-release ID and version (or release), if it is known.
+func defaultRepositories(id, version, arch string) []defaultRepository {
+	const base = "https://download.opensuse.org"
+	switch id {
+	case "opensuse-tumbleweed", "opensuse-microos":
+		if arch != "x86_64" && arch != "i586" {
+			return []defaultRepository{
+				{"repo-oss", "Main Repository", base + "/ports/$basearch/tumbleweed/repo/oss/"},
+			}
+		}
+		return []defaultRepository{
+			{"repo-oss", "Main Repository", base + "/tumbleweed/repo/oss/"},
+			{"repo-update", "Main Update Repository", base + "/update/tumbleweed/"},
+		}
+	case "opensuse-slowroll":
+		return []defaultRepository{
+			{"repo-oss", "Main Repository", base + "/slowroll/repo/oss/"},
+		}
+	case "opensuse-leap":
+		if major, _, _ := strings.Cut(version, "."); major == "15" {
+			return []defaultRepository{
+				{"repo-oss", "Main Repository", base + "/distribution/leap/$releasever/repo/oss/"},
+				{"repo-update", "Main Update Repository", base + "/update/leap/$releasever/oss/"},
+				{"repo-sle-update", "Update repository with updates from SUSE Linux Enterprise 15", base + "/update/leap/$releasever/sle/"},
+			}
+		}
+		// Since 16.0, updates are published in the main repository.
+		return []defaultRepository{
+			{"repo-oss", "Main Repository", base + "/distribution/leap/$releasever/repo/oss/$basearch/"},
+		}
+	}
+	return nil
+}
+
+// DefaultRepositories returns the standard repositories of the distribution
+// installed in the root given in the options, as identified by its
+// os-release file, for systems that have none configured (such as fresh
+// container images).  Only openSUSE distributions are known; for others,
+// ErrNoDefaultRepositories is returned.
+func DefaultRepositories(opts Options) ([]*Repository, error) {
+	f := &Files{Root: opts.InstallRoot}
+	vars, err := f.variables(opts)
+	if err != nil {
+		return nil, err
+	}
+	id := f.osRelease("ID")
+	defaults := defaultRepositories(id, vars["releasever"], vars["basearch"])
+	if len(defaults) == 0 {
+		return nil, fmt.Errorf("%w (%q)", ErrNoDefaultRepositories, id)
+	}
+	var repos []*Repository
+	for _, repo := range defaults {
+		repos = append(repos, &Repository{
+			Alias:    repo.alias,
+			Name:     repo.name,
+			Type:     "rpm-md",
+			Enabled:  true,
+			Priority: DefaultPriority,
+			GPGCheck: true,
+			URL:      expand(repo.url, vars),
+		})
+	}
+	return repos, nil
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package zypper
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ErrUnsupported is returned by backends for operations they cannot perform.
+var ErrUnsupported = errors.New("operation not supported")
+
+// Files is a Backend that reads the zypper configuration files directly,
+// without running zypper; this works even if zypper is missing or locked, but
+// packages cannot be installed.  The locations of the files are read from
+// zypp.conf (see loadZyppConf).
+type Files struct {
+	// The root directory of the system; if empty, `/` is used.
+	Root string
+}
+
+// path returns the path of the given absolute path within the root.
+func (f *Files) path(name string) string {
+	return filepath.Join(cmp.Or(f.Root, "/"), name)
+}
+
+// goArches maps Go architectures to RPM architectures.
+var goArches = map[string]string{
+	"386":     "i586",
+	"amd64":   "x86_64",
+	"arm":     "armv7hl",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+func (f *Files) Arch() (string, error) {
+	if arch, ok := goArches[runtime.GOARCH]; ok {
+		return arch, nil
+	}
+	return "", fmt.Errorf("%w: unknown architecture %s", ErrUnsupported, runtime.GOARCH)
+}
+
+// fileSection is a section of an ini file, along with the path of the file.
+type fileSection struct {
+	*ini.Section
+	file string
+}
+
+// loadSections reads all sections of the ini files in the given directory
+// with the given extension.
+func (f *Files) loadSections(dir, ext string) ([]fileSection, error) {
+	entries, err := os.ReadDir(f.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var sections []fileSection
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ext {
+			continue
+		}
+		name := filepath.Join(f.path(dir), entry.Name())
+		file, err := ini.LoadSources(ini.LoadOptions{Loose: true, AllowPythonMultilineValues: true}, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		for _, section := range file.Sections() {
+			if section.Name() != ini.DefaultSection {
+				sections = append(sections, fileSection{Section: section, file: name})
+			}
+		}
+	}
+	return sections, nil
+}
+
+// repoFiles returns the path of the file configuring each repository, by
+// alias; repositories that cannot be read are omitted.
+func (f *Files) repoFiles() map[string]string {
+	files := make(map[string]string)
+	sections, _ := f.loadSections(loadZyppConf(f.Root).reposDir, ".repo")
+	for _, section := range sections {
+		files[section.Name()] = section.file
+	}
+	return files
+}
+
+func (f *Files) ListServices(ctx context.Context, opts Options) ([]*Service, error) {
+	sections, err := f.loadSections(loadZyppConf(f.Root).servicesDir, ".service")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+	vars, err := f.variables(opts)
+	if err != nil {
+		return nil, err
+	}
+	var services []*Service
+	for _, section := range sections {
+		services = append(services, &Service{
+			Alias:   section.Name(),
+			Name:    cmp.Or(section.Key("name").String(), section.Name()),
+			Type:    section.Key("type").String(),
+			Enabled: section.Key("enabled").MustBool(true),
+			URL:     expand(section.Key("url").String(), vars),
+		})
+	}
+	return services, nil
+}
+
+// ListRepositories reads the repository files, including those generated by
+// services; repositories belonging to disabled services are disabled.
+func (f *Files) ListRepositories(ctx context.Context, opts Options) ([]*Repository, error) {
+	sections, err := f.loadSections(loadZyppConf(f.Root).reposDir, ".repo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	services, err := f.ListServices(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := f.variables(opts)
+	if err != nil {
+		return nil, err
+	}
+	var repos []*Repository
+	for _, section := range sections {
+		var urls []string
+		for _, url := range strings.Split(section.Key("baseurl").String(), "\n") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, expand(url, vars))
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		var mirrors []string
+		if len(urls) > 1 {
+			mirrors = urls[1:]
+		}
+		enabled := section.Key("enabled").MustBool(true)
+		if alias := section.Key("service").String(); alias != "" {
+			index := slices.IndexFunc(services, func(s *Service) bool { return s.Alias == alias })
+			if index >= 0 && !services[index].Enabled {
+				enabled = false
+			}
+		}
+		repos = append(repos, &Repository{
+			Alias:        section.Name(),
+			Name:         cmp.Or(section.Key("name").String(), section.Name()),
+			Type:         cmp.Or(section.Key("type").String(), "rpm-md"),
+			Enabled:      enabled,
+			Priority:     section.Key("priority").MustInt(DefaultPriority),
+			GPGCheck:     section.Key("gpgcheck").MustBool(true),
+			KeepPackages: section.Key("keeppackages").MustBool(false),
+			URL:          urls[0],
+			Mirrors:      mirrors,
+			Service:      section.Key("service").String(),
+			File:         section.file,
+		})
+	}
+	return repos, nil
+}
+
+func (f *Files) Install(ctx context.Context, opts Options, packages ...string) error {
+	return fmt.Errorf("%w: installing packages requires zypper", ErrUnsupported)
+}
+
+func (f *Files) InstalledFiles(ctx context.Context, opts Options, paths ...string) (map[string][]string, error) {
+	return nil, fmt.Errorf("%w: listing installed files requires rpm", ErrUnsupported)
+}
+
+func (f *Files) Credentials(opts Options, name string) (string, string, error) {
+	return readCredentials(f.path(loadZyppConf(f.Root).credentialsDir), name)
+}
+
+// variables returns the values of the repository variables, as documented in
+// zypper(8).
+func (f *Files) variables(opts Options) (map[string]string, error) {
+	arch := opts.Arch
+	if arch == "" {
+		var err error
+		if arch, err = f.Arch(); err != nil {
+			return nil, err
+		}
+	}
+	vars := map[string]string{
+		"arch":       arch,
+		"basearch":   arch,
+		"releasever": opts.ReleaseVer,
+	}
+	if vars["releasever"] == "" {
+		vars["releasever"] = f.osRelease("VERSION_ID")
+	}
+	major, minor, _ := strings.Cut(vars["releasever"], ".")
+	vars["releasever_major"] = major
+	vars["releasever_minor"] = minor
+
+	// Custom variables override the built-in ones.
+	dir := f.path(loadZyppConf(f.Root).varsDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read repository variables: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read repository variable %s: %w", entry.Name(), err)
+		}
+		value, _, _ := strings.Cut(string(data), "\n")
+		vars[entry.Name()] = strings.TrimSpace(value)
+	}
+	return vars, nil
+}
+
+// osRelease returns the value of the given key in os-release, or an empty
+// string.
+func (f *Files) osRelease(key string) string {
+	file, err := os.Open(f.path("/etc/os-release"))
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), key+"="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
+
+// variablePattern matches `$name` and `${name}`.
+var variablePattern = regexp.MustCompile(`\$(?:([A-Za-z0-9_]+)|\{([A-Za-z0-9_]+)\})`)
+
+// expand the known variables in the string; unknown ones are left unchanged.
+func expand(s string, vars map[string]string) string {
+	return variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := strings.Trim(match, "${}")
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return match
+	})
+}
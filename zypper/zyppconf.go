@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+// SPDX-FileCopyrightText: SUSE LLC
+
+package zypper
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/ini.v1"
+)
+
+// zyppConf holds the locations configured in zypp.conf.  All paths are
+// relative to the root of the system being operated on.
+type zyppConf struct {
+	reposDir       string
+	servicesDir    string
+	varsDir        string
+	credentialsDir string
+}
+
+// loadZyppConf reads zypp.conf for the system at the given root, falling back
+// to the libzypp defaults for anything not set.  As with libzypp, the file
+// can be relocated with the ZYPP_CONF environment variable.
+func loadZyppConf(root string) zyppConf {
+	confPath := os.Getenv("ZYPP_CONF")
+	if confPath == "" {
+		confPath = filepath.Join(cmp.Or(root, "/"), "etc/zypp/zypp.conf")
+	}
+	section := ini.Empty().Section("main")
+	if file, err := ini.LoadSources(ini.LoadOptions{Loose: true}, confPath); err == nil {
+		section = file.Section("main")
+	}
+	configDir := section.Key("configdir").MustString("/etc/zypp")
+	return zyppConf{
+		reposDir:       section.Key("reposdir").MustString(filepath.Join(configDir, "repos.d")),
+		servicesDir:    section.Key("servicesdir").MustString(filepath.Join(configDir, "services.d")),
+		varsDir:        section.Key("varsdir").MustString(filepath.Join(configDir, "vars.d")),
+		credentialsDir: section.Key("credentials.global.dir").MustString(filepath.Join(configDir, "credentials.d")),
+	}
+}
@@ -6,11 +6,18 @@
 package zypper
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -20,30 +27,138 @@ type Repository struct {
 	Name    string `xml:"name,attr"`
 	Type    string `xml:"type,attr"`
 	Enabled bool   `xml:"enabled,attr"`
-	URL     string `xml:"url"`
+	// The value of $releasever the repository was listed with, if one was
+	// given explicitly.
+	ReleaseVer string `xml:"-"`
+	// Lower numbers are preferred; zypper uses 99 by default.
+	Priority int `xml:"priority,attr"`
+	// Whether package signatures are checked.
+	GPGCheck bool `xml:"gpgcheck,attr"`
+	// Whether downloaded packages are kept in the local cache.
+	KeepPackages bool   `xml:"keeppackages,attr"`
+	URL          string `xml:"url"`
+	// Any further base URLs, which serve the same content as URL.
+	Mirrors []string `xml:"-"`
+	// The alias of the service that added the repository, if any.
+	Service string `xml:"service,attr"`
+	// The path of the file the repository is configured in, if known.
+	File string `xml:"-"`
 }
 
-var arch = sync.OnceValues(func() (string, error) {
-	var buf bytes.Buffer
-	cmd := exec.Command("zypper", "system-architecture")
-	cmd.Stdout = &buf
-	if err := cmd.Run(); err != nil {
-		return "", err
+// DefaultPriority is the priority of repositories that do not set one.
+const DefaultPriority = 99
+
+// Options control how zypper is invoked.
+type Options struct {
+	// Override the value of $releasever.
+	ReleaseVer string
+	// Operate on the system installed in the given directory.
+	InstallRoot string
+	// Expand repository URLs for this architecture instead of the system's.
+	// zypper cannot do this, so the repository files are read directly.
+	Arch string
+}
+
+// args returns the global zypper arguments for the options.
+func (o Options) args() []string {
+	var args []string
+	if o.ReleaseVer != "" {
+		args = append(args, "--releasever", o.ReleaseVer)
 	}
-	return strings.TrimSpace(buf.String()), nil
-})
+	if o.InstallRoot != "" {
+		args = append(args, "--installroot", o.InstallRoot)
+	}
+	return args
+}
 
-// List the repositories that are enabled on the system.
-func ListRepositories(ctx context.Context, releaseVer string) ([]*Repository, error) {
-	var buf bytes.Buffer
-	args := []string{"--xmlout", "repos"}
-	if releaseVer != "" {
-		args = append([]string{"--releasever", releaseVer}, args...)
+// Service is a repository index service, such as those added by SUSEConnect.
+type Service struct {
+	Alias   string `xml:"alias,attr"`
+	Name    string `xml:"name,attr"`
+	Type    string `xml:"type,attr"`
+	Enabled bool   `xml:"enabled,attr"`
+	URL     string `xml:"url,attr"`
+}
+
+// Backend is the interface to the package manager.  The functions in this
+// package use Default; embedders and tests may replace it.
+type Backend interface {
+	// Arch returns the architecture of the system.
+	Arch() (string, error)
+	// ListRepositories returns all configured repositories.
+	ListRepositories(ctx context.Context, opts Options) ([]*Repository, error)
+	// ListServices returns all configured services.
+	ListServices(ctx context.Context, opts Options) ([]*Service, error)
+	// Install the given packages, interacting with the user as necessary.
+	Install(ctx context.Context, opts Options, packages ...string) error
+	// InstalledFiles returns the names of the installed packages owning each
+	// of the given paths; paths not owned by any package are omitted.
+	InstalledFiles(ctx context.Context, opts Options, paths ...string) (map[string][]string, error)
+	// Credentials returns the user name and password in the credentials file
+	// with the given name.
+	Credentials(opts Options, name string) (string, string, error)
+}
+
+// Default is the backend used by the functions in this package.
+var Default Backend = &Exec{}
+
+// Exec is a Backend that runs the zypper executable.  If zypper cannot be run
+// (for example, because it is locked), the configuration files are read
+// directly instead, as by Files.
+type Exec struct {
+	// The zypper executable; if empty, `zypper` is found in $PATH.
+	Command string
+	// The rpm executable; if empty, `rpm` is found in $PATH.
+	RPMCommand string
+	// The directory containing credentials files; if empty, the directory
+	// configured in zypp.conf is used.
+	CredentialsDir string
+
+	archOnce sync.Once
+	arch     string
+	archErr  error
+}
+
+func (e *Exec) command(ctx context.Context, args ...string) *exec.Cmd {
+	command := e.Command
+	if command == "" {
+		command = "zypper"
 	}
-	cmd := exec.CommandContext(ctx, "zypper", args...)
+	return exec.CommandContext(ctx, command, args...)
+}
+
+func (e *Exec) Arch() (string, error) {
+	e.archOnce.Do(func() {
+		var buf bytes.Buffer
+		cmd := e.command(context.Background(), "system-architecture")
+		cmd.Stdout = &buf
+		if e.archErr = cmd.Run(); e.archErr == nil {
+			e.arch = strings.TrimSpace(buf.String())
+		} else if arch, err := (&Files{}).Arch(); err == nil {
+			slog.Debug("Failed to get architecture from zypper, using build architecture",
+				"error", e.archErr, "arch", arch)
+			e.arch, e.archErr = arch, nil
+		}
+	})
+	return e.arch, e.archErr
+}
+
+func (e *Exec) ListRepositories(ctx context.Context, opts Options) ([]*Repository, error) {
+	if opts.Arch != "" {
+		return (&Files{Root: opts.InstallRoot}).ListRepositories(ctx, opts)
+	}
+	var buf bytes.Buffer
+	args := append(opts.args(), "--xmlout", "repos")
+	cmd := e.command(ctx, args...)
 	cmd.Stdout = &buf
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to get repositories: %w", err)
+		// zypper may be missing or locked; try reading the configuration.
+		repos, fallbackErr := (&Files{Root: opts.InstallRoot}).ListRepositories(ctx, opts)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("failed to get repositories: %w", errors.Join(err, fallbackErr))
+		}
+		slog.DebugContext(ctx, "Failed to run zypper, read repository files instead", "error", err)
+		return repos, nil
 	}
 
 	var data struct {
@@ -52,17 +167,171 @@ func ListRepositories(ctx context.Context, releaseVer string) ([]*Repository, er
 	if err := xml.Unmarshal(buf.Bytes(), &data); err != nil {
 		return nil, fmt.Errorf("failed to parse repositories: %w", err)
 	}
+	// Repositories with several base URLs list all of them.
+	var urls struct {
+		Repos []struct {
+			URLs []string `xml:"url"`
+		} `xml:"repo-list>repo"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &urls); err != nil {
+		return nil, fmt.Errorf("failed to parse repositories: %w", err)
+	}
 
-	for _, repo := range data.Repos {
+	// zypper does not say which file each repository came from.
+	files := (&Files{Root: opts.InstallRoot}).repoFiles()
+	for i, repo := range data.Repos {
+		repo.File = files[repo.Alias]
+		if repoURLs := urls.Repos[i].URLs; len(repoURLs) > 1 {
+			repo.URL, repo.Mirrors = repoURLs[0], repoURLs[1:]
+		}
 		if repo.Type == "" {
 			// Assume rpm-md if no type given
 			repo.Type = "rpm-md"
 		}
+		if repo.Priority == 0 {
+			repo.Priority = DefaultPriority
+		}
 	}
 
 	return data.Repos, nil
 }
 
+func (e *Exec) ListServices(ctx context.Context, opts Options) ([]*Service, error) {
+	var buf bytes.Buffer
+	args := append(opts.args(), "--xmlout", "services")
+	cmd := e.command(ctx, args...)
+	cmd.Stdout = &buf
+	if err := cmd.Run(); err != nil {
+		services, fallbackErr := (&Files{Root: opts.InstallRoot}).ListServices(ctx, opts)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("failed to get services: %w", errors.Join(err, fallbackErr))
+		}
+		slog.DebugContext(ctx, "Failed to run zypper, read service files instead", "error", err)
+		return services, nil
+	}
+
+	var data struct {
+		Services []*Service `xml:"service-list>service"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse services: %w", err)
+	}
+	return data.Services, nil
+}
+
+func (e *Exec) Install(ctx context.Context, opts Options, packages ...string) error {
+	args := append(opts.args(), "install")
+	args = append(args, packages...)
+	cmd := e.command(ctx, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to install %s: %w", strings.Join(packages, ", "), err)
+	}
+	return nil
+}
+
+func (e *Exec) InstalledFiles(ctx context.Context, opts Options, paths ...string) (map[string][]string, error) {
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+	args := []string{"--query", "--all", "--queryformat", "[%{FILENAMES}\t%{NAME}\n]"}
+	if opts.InstallRoot != "" {
+		args = append([]string{"--root", opts.InstallRoot}, args...)
+	}
+	cmd := exec.CommandContext(ctx, cmp.Or(e.RPMCommand, "rpm"), args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to list installed files: %w", err)
+	}
+	owners := make(map[string][]string)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		path, name, ok := strings.Cut(scanner.Text(), "\t")
+		if ok && wanted[path] && !slices.Contains(owners[path], name) {
+			owners[path] = append(owners[path], name)
+		}
+	}
+	scanErr := scanner.Err()
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to list installed files: %w", err)
+	}
+	if scanErr != nil {
+		return nil, fmt.Errorf("failed to read installed files: %w", scanErr)
+	}
+	return owners, nil
+}
+
+// List the repositories that are configured on the system.
+func ListRepositories(ctx context.Context, opts Options) ([]*Repository, error) {
+	return Default.ListRepositories(ctx, opts)
+}
+
+// List the services that are configured on the system.
+func ListServices(ctx context.Context, opts Options) ([]*Service, error) {
+	return Default.ListServices(ctx, opts)
+}
+
+// Install the given packages, letting zypper interact with the user.
+func Install(ctx context.Context, opts Options, packages ...string) error {
+	return Default.Install(ctx, opts, packages...)
+}
+
+// InstalledFiles returns the names of the installed packages owning each of
+// the given paths.
+func InstalledFiles(ctx context.Context, opts Options, paths ...string) (map[string][]string, error) {
+	return Default.InstalledFiles(ctx, opts, paths...)
+}
+
 func Arch() (string, error) {
-	return arch()
+	return Default.Arch()
+}
+
+// Credentials reads the zypper credentials file with the given name, as
+// referenced by the `credentials` query parameter of a repository URL.
+func Credentials(opts Options, name string) (string, string, error) {
+	return Default.Credentials(opts, name)
+}
+
+func (e *Exec) Credentials(opts Options, name string) (string, string, error) {
+	if e.CredentialsDir != "" {
+		return readCredentials(e.CredentialsDir, name)
+	}
+	// Credentials are stored in the directory configured in zypp.conf, such as
+	// the ones created by SUSEConnect.
+	return (&Files{Root: opts.InstallRoot}).Credentials(opts, name)
+}
+
+// readCredentials reads the credentials file with the given name in the
+// directory.
+func readCredentials(dir, name string) (string, string, error) {
+	if name == "" || strings.ContainsRune(name, '/') {
+		return "", "", fmt.Errorf("invalid credentials name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credentials %s: %w", name, err)
+	}
+	var username, password string
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "username":
+			username = strings.TrimSpace(value)
+		case "password":
+			password = strings.TrimSpace(value)
+		}
+	}
+	if username == "" {
+		return "", "", fmt.Errorf("credentials %s do not contain a user name", name)
+	}
+	return username, password, nil
 }
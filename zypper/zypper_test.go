@@ -4,12 +4,180 @@
 package zypper
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
 )
 
 func TestListRepositories(t *testing.T) {
-	_, err := ListRepositories(t.Context(), "")
+	_, err := ListRepositories(t.Context(), Options{})
 	assert.NilError(t, err)
 }
+
+func TestExec(t *testing.T) {
+	// Use a fake zypper that prints canned output for each command.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for arg; do last="$arg"; done
+case "$last" in
+system-architecture) echo x86_64 ;;
+repos) echo '<stream><repo-list><repo alias="oss" name="Main" enabled="1" priority="90" gpgcheck="1" keeppackages="0"><url>http://example.test/oss</url><url>http://mirror.test/oss</url></repo></repo-list></stream>' ;;
+services) echo '<stream><service-list><service alias="scc" name="SCC" type="ris" enabled="1" url="https://example.test/scc"/></service-list></stream>' ;;
+*) exit 1 ;;
+esac
+`
+	command := filepath.Join(dir, "zypper")
+	assert.NilError(t, os.WriteFile(command, []byte(script), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "SCC"), []byte("username=user\npassword=secret\n"), 0o600))
+	backend := &Exec{Command: command, CredentialsDir: dir}
+
+	arch, err := backend.Arch()
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(arch, "x86_64"))
+
+	// The file each repository came from is found by reading the files.
+	root := t.TempDir()
+	reposDir := filepath.Join(root, "etc/zypp/repos.d")
+	assert.NilError(t, os.MkdirAll(reposDir, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(reposDir, "main.repo"), []byte("[oss]\nbaseurl=http://example.test/oss\n"), 0o644))
+	repos, err := backend.ListRepositories(t.Context(), Options{ReleaseVer: "16.0", InstallRoot: root})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(repos, []*Repository{
+		{Alias: "oss", Name: "Main", Type: "rpm-md", Enabled: true, Priority: 90, GPGCheck: true, URL: "http://example.test/oss", Mirrors: []string{"http://mirror.test/oss"}, File: filepath.Join(reposDir, "main.repo")},
+	}))
+
+	services, err := backend.ListServices(t.Context(), Options{})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(services, []*Service{
+		{Alias: "scc", Name: "SCC", Type: "ris", Enabled: true, URL: "https://example.test/scc"},
+	}))
+
+	username, password, err := backend.Credentials(Options{}, "SCC")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(username, "user"))
+	assert.Check(t, cmp.Equal(password, "secret"))
+
+	assert.Check(t, backend.Install(t.Context(), Options{}, "unknown") != nil)
+}
+
+func TestExecCredentialsInstallRoot(t *testing.T) {
+	t.Setenv("ZYPP_CONF", "")
+	root := t.TempDir()
+	dir := filepath.Join(root, "etc/zypp/credentials.d")
+	assert.NilError(t, os.MkdirAll(dir, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "SCC"), []byte("username=image\npassword=token\n"), 0o600))
+
+	username, password, err := (&Exec{}).Credentials(Options{InstallRoot: root}, "SCC")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(username, "image"))
+	assert.Check(t, cmp.Equal(password, "token"))
+}
+
+func TestExecInstalledFiles(t *testing.T) {
+	// Use a fake rpm that lists a few installed files.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+printf '/usr/bin/python3\tpython311\n/usr/bin/python3\tpython313\n/usr/bin/ls\tcoreutils\n'
+`
+	command := filepath.Join(dir, "rpm")
+	assert.NilError(t, os.WriteFile(command, []byte(script), 0o755))
+	backend := &Exec{RPMCommand: command}
+
+	owners, err := backend.InstalledFiles(t.Context(), Options{}, "/usr/bin/python3", "/usr/bin/missing")
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(owners, map[string][]string{
+		"/usr/bin/python3": {"python311", "python313"},
+	}))
+}
+
+func TestFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"etc/os-release":         "NAME=\"openSUSE Leap\"\nVERSION_ID=\"16.0\"\n",
+		"etc/zypp/vars.d/custom": "value\n",
+		"etc/zypp/repos.d/oss.repo": "[repo-oss]\nname=Main\nenabled=1\n" +
+			"baseurl=http://example.test/distribution/leap/$releasever/repo/${custom}\n",
+		"etc/zypp/repos.d/debug.repo":     "[repo-debug]\nenabled=0\npriority=120\ngpgcheck=0\nkeeppackages=1\nbaseurl=http://example.test/debug/$basearch/\n  http://mirror.test/debug/$basearch/\n",
+		"etc/zypp/repos.d/scc.repo":       "[SCC:Updates]\nservice=SCC\nbaseurl=https://example.test/updates\n",
+		"etc/zypp/services.d/SCC.service": "[SCC]\nname=SCC\nenabled=0\ntype=ris\nurl=https://example.test/scc\n",
+	}
+	for name, contents := range files {
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		assert.NilError(t, os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644))
+	}
+	backend := &Files{Root: root}
+
+	_, err := backend.InstalledFiles(t.Context(), Options{}, "/usr/bin/ls")
+	assert.Check(t, cmp.ErrorIs(err, ErrUnsupported))
+
+	repos, err := backend.ListRepositories(t.Context(), Options{Arch: "x86_64"})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(repos, []*Repository{
+		{Alias: "repo-debug", Name: "repo-debug", Type: "rpm-md", Priority: 120, KeepPackages: true, URL: "http://example.test/debug/x86_64/", Mirrors: []string{"http://mirror.test/debug/x86_64/"}, File: filepath.Join(root, "etc/zypp/repos.d/debug.repo")},
+		{Alias: "repo-oss", Name: "Main", Type: "rpm-md", Enabled: true, Priority: 99, GPGCheck: true, URL: "http://example.test/distribution/leap/16.0/repo/value", File: filepath.Join(root, "etc/zypp/repos.d/oss.repo")},
+		{Alias: "SCC:Updates", Name: "SCC:Updates", Type: "rpm-md", Priority: 99, GPGCheck: true, URL: "https://example.test/updates", Service: "SCC", File: filepath.Join(root, "etc/zypp/repos.d/scc.repo")},
+	}))
+
+	repos, err = backend.ListRepositories(t.Context(), Options{ReleaseVer: "15.6", Arch: "aarch64"})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(repos[0].URL, "http://example.test/debug/aarch64/"))
+	assert.Check(t, cmp.Equal(repos[1].URL, "http://example.test/distribution/leap/15.6/repo/value"))
+
+	services, err := backend.ListServices(t.Context(), Options{})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.DeepEqual(services, []*Service{
+		{Alias: "SCC", Name: "SCC", Type: "ris", URL: "https://example.test/scc"},
+	}))
+
+	assert.Check(t, cmp.ErrorIs(backend.Install(t.Context(), Options{}, "pkg"), ErrUnsupported))
+}
+
+func TestDefaultRepositories(t *testing.T) {
+	writeOSRelease := func(t *testing.T, contents string) string {
+		root := t.TempDir()
+		assert.NilError(t, os.MkdirAll(filepath.Join(root, "etc"), 0o755))
+		assert.NilError(t, os.WriteFile(filepath.Join(root, "etc/os-release"), []byte(contents), 0o644))
+		return root
+	}
+
+	root := writeOSRelease(t, "ID=\"opensuse-leap\"\nVERSION_ID=\"15.6\"\n")
+	repos, err := DefaultRepositories(Options{InstallRoot: root, Arch: "x86_64"})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(repos, 3))
+	assert.Check(t, cmp.DeepEqual(repos[0], &Repository{
+		Alias: "repo-oss", Name: "Main Repository", Type: "rpm-md", Enabled: true, Priority: DefaultPriority, GPGCheck: true,
+		URL: "https://download.opensuse.org/distribution/leap/15.6/repo/oss/",
+	}))
+
+	repos, err = DefaultRepositories(Options{InstallRoot: root, Arch: "aarch64", ReleaseVer: "16.0"})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(repos, 1))
+	assert.Check(t, cmp.Equal(repos[0].URL, "https://download.opensuse.org/distribution/leap/16.0/repo/oss/aarch64/"))
+
+	root = writeOSRelease(t, "ID=\"opensuse-tumbleweed\"\nVERSION_ID=\"20251201\"\n")
+	repos, err = DefaultRepositories(Options{InstallRoot: root, Arch: "x86_64"})
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Len(repos, 2))
+
+	root = writeOSRelease(t, "ID=\"sles\"\nVERSION_ID=\"16.0\"\n")
+	_, err = DefaultRepositories(Options{InstallRoot: root, Arch: "x86_64"})
+	assert.Check(t, cmp.ErrorIs(err, ErrNoDefaultRepositories))
+}
+
+func TestZyppConf(t *testing.T) {
+	root := t.TempDir()
+	confPath := filepath.Join(t.TempDir(), "zypp.conf")
+	assert.NilError(t, os.WriteFile(confPath, []byte("[main]\nreposdir = /custom/repos\n"), 0o644))
+	t.Setenv("ZYPP_CONF", confPath)
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "custom/repos"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "custom/repos/test.repo"),
+		[]byte("[test]\nbaseurl=http://example.test/\n"), 0o644))
+
+	repos, err := (&Files{Root: root}).ListRepositories(t.Context(), Options{})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(repos, 1))
+	assert.Check(t, cmp.Equal(repos[0].Alias, "test"))
+}